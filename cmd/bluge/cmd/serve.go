@@ -0,0 +1,318 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+
+	"github.com/spf13/cobra"
+)
+
+var serveAddr string
+
+var serveTimeout time.Duration
+
+var serveMaxBodyBytes int64
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [path]",
+	Short: "serves the bluge index over HTTP",
+	Long: `The serve command will open the Bluge index, creating it if necessary,
+and serve it over HTTP with the following endpoints:
+
+  POST   /_batch      update and delete documents in a single batch
+  POST   /_search     search the index
+  GET    /_stats      report the index statistics
+  GET    /docs/{id}   fetch a document
+  PUT    /docs/{id}   index or replace a document
+  DELETE /docs/{id}   delete a document`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return fmt.Errorf("must specify path to index")
+		}
+
+		writer, err := bluge.OpenWriter(bluge.DefaultConfig(args[0]))
+		if err != nil {
+			return fmt.Errorf("error opening index: %v", err)
+		}
+
+		srv := &http.Server{
+			Addr:              serveAddr,
+			Handler:           newServer(writer, serveMaxBodyBytes),
+			ReadHeaderTimeout: serveTimeout,
+			ReadTimeout:       serveTimeout,
+			WriteTimeout:      serveTimeout,
+		}
+		go func() {
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			<-interrupt
+			_ = srv.Shutdown(context.Background())
+		}()
+
+		fmt.Printf("serving %s on %s\n", args[0], serveAddr)
+		err = srv.ListenAndServe()
+		if err != http.ErrServerClosed {
+			_ = writer.Close()
+			return err
+		}
+		return writer.Close()
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "localhost:8094", "address to listen on")
+	serveCmd.Flags().DurationVar(&serveTimeout, "timeout", 30*time.Second,
+		"maximum duration for reading a request and writing its response")
+	serveCmd.Flags().Int64Var(&serveMaxBodyBytes, "max-body-bytes", 10<<20,
+		"maximum size of a request body, larger requests are rejected")
+	RootCmd.AddCommand(serveCmd)
+}
+
+type server struct {
+	writer       *bluge.Writer
+	maxBodyBytes int64
+}
+
+func newServer(writer *bluge.Writer, maxBodyBytes int64) http.Handler {
+	s := &server{
+		writer:       writer,
+		maxBodyBytes: maxBodyBytes,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_batch", s.handleBatch)
+	mux.HandleFunc("/_search", s.handleSearch)
+	mux.HandleFunc("/_stats", s.handleStats)
+	mux.HandleFunc("/docs/", s.handleDocument)
+	return mux
+}
+
+type batchRequest struct {
+	Update []json.RawMessage `json:"update"`
+	Delete []string          `json:"delete"`
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var req batchRequest
+	if status, err := s.decodeBody(w, r, &req); err != nil {
+		writeError(w, status, fmt.Errorf("error parsing batch: %v", err))
+		return
+	}
+
+	batch := bluge.NewBatch()
+	for i, source := range req.Update {
+		doc, err := parseDocument("", source)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("error parsing document %d: %v", i, err))
+			return
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	for _, id := range req.Delete {
+		batch.Delete(bluge.Identifier(id))
+	}
+	if err := s.writer.Batch(batch); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{
+		"updated": len(req.Update),
+		"deleted": len(req.Delete),
+	})
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var req searchRequest
+	if status, err := s.decodeBody(w, r, &req); err != nil {
+		writeError(w, status, fmt.Errorf("error parsing search: %v", err))
+		return
+	}
+	topN, err := req.topNSearch()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	reader, err := s.writer.Reader()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	dmi, err := reader.Search(r.Context(), topN)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	rv, err := req.response(dmi)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rv)
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.writer.Stats())
+}
+
+func (s *server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/docs/")
+	if id == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("must specify document id"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getDocument(w, r, id)
+	case http.MethodPut:
+		var source json.RawMessage
+		if status, err := s.decodeBody(w, r, &source); err != nil {
+			writeError(w, status, fmt.Errorf("error parsing document: %v", err))
+			return
+		}
+		doc, err := parseDocument(id, source)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("error parsing document: %v", err))
+			return
+		}
+		if err = s.writer.Update(doc.ID(), doc); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+	case http.MethodDelete:
+		if err := s.writer.Delete(bluge.Identifier(id)); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *server) getDocument(w http.ResponseWriter, r *http.Request, id string) {
+	reader, err := s.writer.Reader()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	q := bluge.NewTermQuery(id).SetField("_id")
+	dmi, err := reader.Search(r.Context(), bluge.NewTopNSearch(1, q))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	match, err := dmi.Next()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if match == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("document %s not found", id))
+		return
+	}
+	docID, source, _, err := loadStoredFields(match)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     docID,
+		"source": source,
+	})
+}
+
+// decodeBody decodes the JSON request body into v, returning the status
+// to respond with when it cannot, the body is read only up to the
+// maximum size, so that a large request cannot exhaust the memory
+func (s *server) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) (int, error) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	if err != nil {
+		if int64(len(data)) >= s.maxBodyBytes {
+			return http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body larger than %d bytes", s.maxBodyBytes)
+		}
+		return http.StatusBadRequest, err
+	}
+	return http.StatusBadRequest, json.Unmarshal(data, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusRequestTimeout
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// loadStoredFields returns the identifier, source
+// and stored text values of the match
+func loadStoredFields(match *search.DocumentMatch) (id string, source json.RawMessage,
+	values map[string][]byte, err error) {
+	values = make(map[string][]byte)
+	err = match.VisitStoredFields(func(field string, value []byte) bool {
+		switch field {
+		case "_id":
+			id = string(value)
+		case sourceField:
+			source = append(json.RawMessage(nil), value...)
+		default:
+			if _, ok := values[field]; !ok {
+				values[field] = append([]byte(nil), value...)
+			}
+		}
+		return true
+	})
+	return id, source, values, err
+}
@@ -0,0 +1,545 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/blugelabs/bluge"
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
+	"github.com/blugelabs/bluge/search/highlight"
+)
+
+// sourceField stores the original JSON of each document
+const sourceField = "_source"
+
+// parseDocument builds a document from its JSON source.  Strings are
+// indexed as text, numbers as numeric values and booleans as keywords.
+// Arrays index each of their values, and objects index their fields
+// with dotted names, unless the object has the form
+// {"type": "keyword"|"text"|"date"|"geo", "value": ...}, which indexes
+// the value with the requested type, dates are RFC 3339 strings and
+// geo points are {"lon": ..., "lat": ...} objects.
+// The identifier is taken from the "_id" field when id is empty.
+func parseDocument(id string, source json.RawMessage) (*bluge.Document, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return nil, err
+	}
+	if sourceID, ok := fields["_id"]; ok {
+		delete(fields, "_id")
+		if id == "" {
+			id, _ = sourceID.(string)
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("document must have a string _id")
+	}
+
+	doc := bluge.NewDocument(id)
+	for name, value := range fields {
+		if err := addField(doc, name, value); err != nil {
+			return nil, err
+		}
+	}
+	doc.AddField(bluge.NewStoredOnlyField(sourceField, source))
+	doc.AddField(bluge.NewCompositeFieldExcluding("_all", []string{"_id"}))
+	return doc, nil
+}
+
+func addField(doc *bluge.Document, name string, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+	case string:
+		doc.AddField(bluge.NewTextField(name, value).StoreValue().HighlightMatches())
+	case float64:
+		doc.AddField(bluge.NewNumericField(name, value).Aggregatable())
+	case bool:
+		doc.AddField(bluge.NewKeywordField(name, strconv.FormatBool(value)).Aggregatable())
+	case []interface{}:
+		for _, v := range value {
+			if err := addField(doc, name, v); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if typ, ok := value["type"].(string); ok && len(value) == 2 {
+			if v, ok := value["value"]; ok {
+				return addTypedField(doc, name, typ, v)
+			}
+		}
+		for k, v := range value {
+			if err := addField(doc, name+"."+k, v); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("field %s has unsupported value %v", name, value)
+	}
+	return nil
+}
+
+func addTypedField(doc *bluge.Document, name, typ string, value interface{}) error {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if err := addTypedField(doc, name, typ, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch typ {
+	case "keyword":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("field %s keyword value must be a string", name)
+		}
+		doc.AddField(bluge.NewKeywordField(name, str).StoreValue().Aggregatable())
+	case "text":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("field %s text value must be a string", name)
+		}
+		doc.AddField(bluge.NewTextField(name, str).StoreValue().HighlightMatches())
+	case "date":
+		str, _ := value.(string)
+		dt, err := time.Parse(time.RFC3339, str)
+		if err != nil {
+			return fmt.Errorf("field %s date value must be an RFC 3339 string: %v", name, err)
+		}
+		doc.AddField(bluge.NewDateTimeField(name, dt).Aggregatable())
+	case "geo":
+		point, _ := value.(map[string]interface{})
+		lon, lonOk := point["lon"].(float64)
+		lat, latOk := point["lat"].(float64)
+		if !lonOk || !latOk {
+			return fmt.Errorf("field %s geo value must have numeric lon and lat", name)
+		}
+		doc.AddField(bluge.NewGeoPointField(name, lon, lat).Aggregatable())
+	default:
+		return fmt.Errorf("field %s has unknown type %s", name, typ)
+	}
+	return nil
+}
+
+type searchRequest struct {
+	Query        json.RawMessage            `json:"query"`
+	Size         *int                       `json:"size"`
+	From         int                        `json:"from"`
+	Sort         []string                   `json:"sort"`
+	SearchAfter  [][]byte                   `json:"search_after"`
+	SearchBefore [][]byte                   `json:"search_before"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+	Highlight    *highlightRequest          `json:"highlight"`
+	Explain      bool                       `json:"explain"`
+}
+
+type highlightRequest struct {
+	Fields    []string `json:"fields"`
+	Style     string   `json:"style"`
+	Fragments int      `json:"fragments"`
+}
+
+const defaultSearchSize = 10
+
+func (r *searchRequest) topNSearch() (*bluge.TopNSearch, error) {
+	q, err := parseQuery(r.Query)
+	if err != nil {
+		return nil, err
+	}
+	size := defaultSearchSize
+	if r.Size != nil {
+		size = *r.Size
+	}
+
+	rv := bluge.NewTopNSearch(size, q).SetFrom(r.From).WithStandardAggregations()
+	if len(r.Sort) > 0 {
+		rv.SortBy(r.Sort)
+	}
+	if r.SearchAfter != nil {
+		rv.After(r.SearchAfter)
+	}
+	if r.SearchBefore != nil {
+		rv.Before(r.SearchBefore)
+	}
+	if r.Explain {
+		rv.ExplainScores()
+	}
+	if r.Highlight != nil {
+		rv.IncludeLocations()
+	}
+	for name, data := range r.Aggregations {
+		if name == "count" || name == "max_score" || name == "duration" {
+			return nil, fmt.Errorf("aggregation name %s is reserved", name)
+		}
+		agg, err := parseAggregation(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing aggregation %s: %v", name, err)
+		}
+		rv.AddAggregation(name, agg)
+	}
+	return rv, nil
+}
+
+type searchResponse struct {
+	Total             uint64                 `json:"total"`
+	TotalIsLowerBound bool                   `json:"total_is_lower_bound,omitempty"`
+	MaxScore          float64                `json:"max_score"`
+	Took              string                 `json:"took"`
+	Hits              []*searchHit           `json:"hits"`
+	Aggregations      map[string]interface{} `json:"aggregations,omitempty"`
+}
+
+type searchHit struct {
+	ID          string              `json:"id"`
+	Score       float64             `json:"score"`
+	Sort        [][]byte            `json:"sort,omitempty"`
+	Source      json.RawMessage     `json:"source,omitempty"`
+	Highlight   map[string][]string `json:"highlight,omitempty"`
+	Explanation *search.Explanation `json:"explanation,omitempty"`
+
+	values map[string][]byte
+}
+
+func (h *searchHit) load(match *search.DocumentMatch) (err error) {
+	h.ID, h.Source, h.values, err = loadStoredFields(match)
+	h.Score = match.Score
+	h.Sort = match.SortValue
+	h.Explanation = match.Explanation
+	return err
+}
+
+func (r *searchRequest) response(dmi search.DocumentMatchIterator) (*searchResponse, error) {
+	var highlighter *highlight.SimpleHighlighter
+	fragments := 1
+	if r.Highlight != nil {
+		switch r.Highlight.Style {
+		case "", "html":
+			highlighter = highlight.NewHTMLHighlighter()
+		case "ansi":
+			highlighter = highlight.NewANSIHighlighter()
+		default:
+			return nil, fmt.Errorf("unknown highlight style %s", r.Highlight.Style)
+		}
+		if r.Highlight.Fragments > 0 {
+			fragments = r.Highlight.Fragments
+		}
+	}
+
+	rv := &searchResponse{
+		Hits: []*searchHit{},
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		hit := &searchHit{}
+		if err = hit.load(next); err != nil {
+			return nil, err
+		}
+		if highlighter != nil {
+			for _, field := range r.Highlight.Fields {
+				value, ok := hit.values[field]
+				if !ok {
+					continue
+				}
+				if frags := highlighter.BestFragments(next.Locations[field], value, fragments); len(frags) > 0 {
+					if hit.Highlight == nil {
+						hit.Highlight = make(map[string][]string)
+					}
+					hit.Highlight[field] = frags
+				}
+			}
+		}
+		rv.Hits = append(rv.Hits, hit)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := dmi.Aggregations()
+	rv.Total = bucket.Count()
+	rv.MaxScore = bucket.Metric("max_score")
+	rv.Took = bucket.Duration().String()
+	if pri, ok := dmi.(search.PartialResultsIterator); ok {
+		rv.TotalIsLowerBound = pri.CountIsLowerBound()
+	}
+	for name := range r.Aggregations {
+		if rv.Aggregations == nil {
+			rv.Aggregations = make(map[string]interface{})
+		}
+		rv.Aggregations[name] = search.CalculatorJSON(bucket.Aggregation(name))
+	}
+	return rv, nil
+}
+
+// parseQuery builds a query from its JSON form, an object with a single
+// key naming the kind of query, whose value holds its parameters,
+// for example {"match": {"field": "name", "match": "bluge"}}.
+// An empty query matches all documents.
+func parseQuery(data json.RawMessage) (bluge.Query, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return bluge.NewMatchAllQuery(), nil
+	}
+	var tagged map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tagged); err != nil {
+		return nil, fmt.Errorf("error parsing query: %v", err)
+	}
+	if len(tagged) != 1 {
+		return nil, fmt.Errorf("query must have exactly one kind, got %d", len(tagged))
+	}
+	for kind, body := range tagged {
+		q, err := parseQueryKind(kind, body)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s query: %v", kind, err)
+		}
+		return q, nil
+	}
+	return nil, nil
+}
+
+type queryParams struct {
+	Field       string            `json:"field"`
+	Boost       *float64          `json:"boost"`
+	Term        string            `json:"term"`
+	Match       string            `json:"match"`
+	MatchPhrase string            `json:"match_phrase"`
+	Operator    string            `json:"operator"`
+	Fuzziness   int               `json:"fuzziness"`
+	Prefix      string            `json:"prefix"`
+	PrefixLen   int               `json:"prefix_length"`
+	Slop        int               `json:"slop"`
+	Wildcard    string            `json:"wildcard"`
+	Regexp      string            `json:"regexp"`
+	Min         json.RawMessage   `json:"min"`
+	Max         json.RawMessage   `json:"max"`
+	MinInc      *bool             `json:"inclusive_min"`
+	MaxInc      *bool             `json:"inclusive_max"`
+	Must        []json.RawMessage `json:"must"`
+	Should      []json.RawMessage `json:"should"`
+	MustNot     []json.RawMessage `json:"must_not"`
+	MinShould   int               `json:"min_should"`
+}
+
+// inclusive returns whether the range endpoints are included,
+// by default the minimum is and the maximum is not
+func (p *queryParams) inclusive() (minInclusive, maxInclusive bool) {
+	minInclusive = true
+	if p.MinInc != nil {
+		minInclusive = *p.MinInc
+	}
+	if p.MaxInc != nil {
+		maxInclusive = *p.MaxInc
+	}
+	return minInclusive, maxInclusive
+}
+
+func (p *queryParams) boost() float64 {
+	if p.Boost != nil {
+		return *p.Boost
+	}
+	return 1
+}
+
+func parseQueryKind(kind string, body json.RawMessage) (bluge.Query, error) {
+	var p queryParams
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "match_all":
+		return bluge.NewMatchAllQuery().SetBoost(p.boost()), nil
+	case "match_none":
+		return bluge.NewMatchNoneQuery(), nil
+	case "term":
+		return bluge.NewTermQuery(p.Term).SetField(p.Field).SetBoost(p.boost()), nil
+	case "match":
+		q := bluge.NewMatchQuery(p.Match).SetField(p.Field).SetBoost(p.boost()).
+			SetFuzziness(p.Fuzziness).SetPrefix(p.PrefixLen)
+		switch p.Operator {
+		case "", "or":
+		case "and":
+			q.SetOperator(bluge.MatchQueryOperatorAnd)
+		default:
+			return nil, fmt.Errorf("unknown operator %s", p.Operator)
+		}
+		return q, nil
+	case "match_phrase":
+		return bluge.NewMatchPhraseQuery(p.MatchPhrase).SetField(p.Field).SetBoost(p.boost()).SetSlop(p.Slop), nil
+	case "prefix":
+		return bluge.NewPrefixQuery(p.Prefix).SetField(p.Field).SetBoost(p.boost()), nil
+	case "wildcard":
+		return bluge.NewWildcardQuery(p.Wildcard).SetField(p.Field).SetBoost(p.boost()), nil
+	case "regexp":
+		return bluge.NewRegexpQuery(p.Regexp).SetField(p.Field).SetBoost(p.boost()), nil
+	case "fuzzy":
+		return bluge.NewFuzzyQuery(p.Term).SetField(p.Field).SetBoost(p.boost()).
+			SetFuzziness(p.Fuzziness).SetPrefix(p.PrefixLen), nil
+	case "numeric_range":
+		return parseNumericRange(&p)
+	case "term_range":
+		var min, max string
+		if err := unmarshalOptional(p.Min, &min); err != nil {
+			return nil, err
+		}
+		if err := unmarshalOptional(p.Max, &max); err != nil {
+			return nil, err
+		}
+		minInclusive, maxInclusive := p.inclusive()
+		return bluge.NewTermRangeInclusiveQuery(min, max, minInclusive, maxInclusive).
+			SetField(p.Field).SetBoost(p.boost()), nil
+	case "date_range":
+		var start, end time.Time
+		if err := unmarshalOptional(p.Min, &start); err != nil {
+			return nil, err
+		}
+		if err := unmarshalOptional(p.Max, &end); err != nil {
+			return nil, err
+		}
+		minInclusive, maxInclusive := p.inclusive()
+		return bluge.NewDateRangeInclusiveQuery(start, end, minInclusive, maxInclusive).
+			SetField(p.Field).SetBoost(p.boost()), nil
+	case "bool":
+		return parseBooleanQuery(&p)
+	}
+	return nil, fmt.Errorf("unknown query kind")
+}
+
+func unmarshalOptional(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func parseNumericRange(p *queryParams) (bluge.Query, error) {
+	min, max := bluge.MinNumeric, bluge.MaxNumeric
+	if err := unmarshalOptional(p.Min, &min); err != nil {
+		return nil, err
+	}
+	if err := unmarshalOptional(p.Max, &max); err != nil {
+		return nil, err
+	}
+	minInclusive, maxInclusive := p.inclusive()
+	return bluge.NewNumericRangeInclusiveQuery(min, max, minInclusive, maxInclusive).
+		SetField(p.Field).SetBoost(p.boost()), nil
+}
+
+func parseBooleanQuery(p *queryParams) (bluge.Query, error) {
+	rv := bluge.NewBooleanQuery().SetMinShould(p.MinShould).SetBoost(p.boost())
+	clauses := []struct {
+		queries []json.RawMessage
+		add     func(m ...bluge.Query) *bluge.BooleanQuery
+	}{
+		{p.Must, rv.AddMust},
+		{p.Should, rv.AddShould},
+		{p.MustNot, rv.AddMustNot},
+	}
+	for _, clause := range clauses {
+		for _, data := range clause.queries {
+			q, err := parseQuery(data)
+			if err != nil {
+				return nil, err
+			}
+			clause.add(q)
+		}
+	}
+	return rv, nil
+}
+
+type aggregationParams struct {
+	Field        string                     `json:"field"`
+	Size         int                        `json:"size"`
+	Ranges       []rangeParams              `json:"ranges"`
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
+}
+
+type rangeParams struct {
+	Name string   `json:"name"`
+	From *float64 `json:"from"`
+	To   *float64 `json:"to"`
+}
+
+const defaultTermsSize = 10
+
+// parseAggregation builds an aggregation from its JSON form, an object
+// with a single key naming the kind of aggregation, whose value holds
+// its parameters, for example {"terms": {"field": "tags", "size": 5}}.
+// Bucket aggregations may hold sub-aggregations in "aggregations".
+func parseAggregation(data json.RawMessage) (search.Aggregation, error) {
+	var tagged map[string]aggregationParams
+	if err := json.Unmarshal(data, &tagged); err != nil {
+		return nil, err
+	}
+	if len(tagged) != 1 {
+		return nil, fmt.Errorf("aggregation must have exactly one kind, got %d", len(tagged))
+	}
+	for kind, p := range tagged {
+		source := search.Field(p.Field)
+		switch kind {
+		case "sum":
+			return aggregations.Sum(source), nil
+		case "min":
+			return aggregations.Min(source), nil
+		case "max":
+			return aggregations.Max(source), nil
+		case "avg":
+			return aggregations.Avg(source), nil
+		case "cardinality":
+			return aggregations.Cardinality(source), nil
+		case "terms":
+			size := p.Size
+			if size <= 0 {
+				size = defaultTermsSize
+			}
+			rv := aggregations.NewTermsAggregation(source, size)
+			return rv, addSubAggregations(p.Aggregations, rv.AddAggregation)
+		case "range":
+			rv := aggregations.Ranges(source)
+			for _, r := range p.Ranges {
+				low, high := bluge.MinNumeric, bluge.MaxNumeric
+				if r.From != nil {
+					low = *r.From
+				}
+				if r.To != nil {
+					high = *r.To
+				}
+				rv.AddRange(aggregations.NamedRange(r.Name, low, high))
+			}
+			return rv, addSubAggregations(p.Aggregations, func(name string, agg search.Aggregation) {
+				rv.AddAggregation(name, agg)
+			})
+		}
+		return nil, fmt.Errorf("unknown aggregation kind %s", kind)
+	}
+	return nil, nil
+}
+
+func addSubAggregations(subs map[string]json.RawMessage, add func(name string, agg search.Aggregation)) error {
+	for name, data := range subs {
+		if name == "count" {
+			return fmt.Errorf("aggregation name %s is reserved", name)
+		}
+		agg, err := parseAggregation(data)
+		if err != nil {
+			return fmt.Errorf("error parsing aggregation %s: %v", name, err)
+		}
+		add(name, agg)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestServe(t *testing.T) {
+	writer, err := bluge.OpenWriter(bluge.InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = writer.Close()
+	}()
+	srv := httptest.NewServer(newServer(writer, 1<<10))
+	defer srv.Close()
+
+	do := func(method, path, body string, expectedStatus int, rv interface{}) {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d", method, path, expectedStatus, resp.StatusCode)
+		}
+		if rv != nil {
+			if err = json.NewDecoder(resp.Body).Decode(rv); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	do(http.MethodPost, "/_batch", `{"update": [
+		{"_id": "a", "name": "bluge search library", "price": 10, "color": {"type": "keyword", "value": "red"}},
+		{"_id": "b", "name": "another search engine", "price": 25, "color": {"type": "keyword", "value": "blue"}},
+		{"_id": "c", "name": "nothing here", "price": 5, "color": {"type": "keyword", "value": "red"}}
+	]}`, http.StatusOK, nil)
+	do(http.MethodPut, "/docs/d", `{"name": "search again", "price": 1, "color": {"type": "keyword", "value": "red"}}`, http.StatusOK, nil)
+	do(http.MethodDelete, "/docs/c", "", http.StatusOK, nil)
+
+	var doc struct {
+		ID     string                 `json:"id"`
+		Source map[string]interface{} `json:"source"`
+	}
+	do(http.MethodGet, "/docs/d", "", http.StatusOK, &doc)
+	if doc.ID != "d" || doc.Source["name"] != "search again" {
+		t.Errorf("unexpected document %v", doc)
+	}
+	do(http.MethodGet, "/docs/c", "", http.StatusNotFound, nil)
+
+	var res searchResponse
+	do(http.MethodPost, "/_search", `{
+		"query": {"match": {"field": "name", "match": "search"}},
+		"size": 2,
+		"sort": ["-price"],
+		"aggregations": {
+			"colors": {"terms": {"field": "color", "aggregations": {"total": {"sum": {"field": "price"}}}}},
+			"sum": {"sum": {"field": "price"}}
+		},
+		"highlight": {"fields": ["name"]}
+	}`, http.StatusOK, &res)
+	if res.Total != 3 {
+		t.Errorf("expected 3 matches, got %d", res.Total)
+	}
+	var ids []string
+	for _, hit := range res.Hits {
+		ids = append(ids, hit.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"b", "a"}) {
+		t.Errorf("expected hits [b a], got %v", ids)
+	}
+	if len(res.Hits) > 0 && !reflect.DeepEqual(res.Hits[0].Highlight["name"],
+		[]string{"another <mark>search</mark> engine"}) {
+		t.Errorf("unexpected highlight %v", res.Hits[0].Highlight)
+	}
+	aggs, _ := json.Marshal(res.Aggregations)
+	var actualAggs, expectedAggs interface{}
+	_ = json.Unmarshal(aggs, &actualAggs)
+	_ = json.Unmarshal([]byte(`{
+		"colors": {"buckets": [
+			{"key": "red", "count": 2, "aggregations": {"total": {"value": 11}}},
+			{"key": "blue", "count": 1, "aggregations": {"total": {"value": 25}}}
+		], "other": 0},
+		"sum": {"value": 36}
+	}`), &expectedAggs)
+	if !reflect.DeepEqual(expectedAggs, actualAggs) {
+		t.Errorf("expected aggregations %v, got %v", expectedAggs, actualAggs)
+	}
+
+	// page on from the last hit
+	after, _ := json.Marshal(res.Hits[len(res.Hits)-1].Sort)
+	res = searchResponse{}
+	do(http.MethodPost, "/_search", `{
+		"query": {"bool": {"must": [{"match": {"match": "search"}}], "must_not": [{"term": {"field": "_id", "term": "b"}}]}},
+		"sort": ["-price"],
+		"search_after": `+string(after)+`
+	}`, http.StatusOK, &res)
+	if len(res.Hits) != 1 || res.Hits[0].ID != "d" {
+		t.Errorf("expected only hit d, got %v", res.Hits)
+	}
+
+	var stats struct {
+		TotUpdates uint64
+		TotDeletes uint64
+	}
+	do(http.MethodGet, "/_stats", "", http.StatusOK, &stats)
+	if stats.TotUpdates != 4 {
+		t.Errorf("expected 4 updates, got %d", stats.TotUpdates)
+	}
+
+	do(http.MethodPost, "/_search", `{"query": {"unknown": {}}}`, http.StatusBadRequest, nil)
+	do(http.MethodPost, "/_search", `{"aggregations": {"count": {"sum": {"field": "price"}}}}`, http.StatusBadRequest, nil)
+	do(http.MethodGet, "/_batch", "", http.StatusMethodNotAllowed, nil)
+
+	large := `{"name": "` + strings.Repeat("large ", 200) + `"}`
+	do(http.MethodPut, "/docs/e", large, http.StatusRequestEntityTooLarge, nil)
+	do(http.MethodPost, "/_batch", `{"update": [`+large+`]}`, http.StatusRequestEntityTooLarge, nil)
+	do(http.MethodPost, "/_search", `{"query": {"match": {"name": "`+strings.Repeat("large ", 200)+`"}}}`,
+		http.StatusRequestEntityTooLarge, nil)
+}
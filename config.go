@@ -15,10 +15,12 @@
 package bluge
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 
 	"github.com/blugelabs/bluge/index"
+	"github.com/blugelabs/bluge/index/hnsw"
 
 	"github.com/blugelabs/bluge/search"
 	"github.com/blugelabs/bluge/search/similarity"
@@ -38,6 +40,13 @@ type Config struct {
 
 	SearchStartFunc func(size uint64) error
 	SearchEndFunc   func(size uint64)
+
+	SearchSlices   int
+	SearchExecutor func(func())
+
+	// searchContext is set on the copy of the config
+	// used to build the searcher for a single search
+	searchContext context.Context
 }
 
 // WithVirtualField allows you to describe a field that
@@ -75,6 +84,53 @@ func (config Config) DisableOptimizeDisjunctionUnadorned() Config {
 	return config
 }
 
+// WithQueryCache enables caching, per segment, of the documents
+// matched by filter queries, using at most maxBytes of memory
+func (config Config) WithQueryCache(maxBytes uint64) Config {
+	config.indexConfig = config.indexConfig.WithQueryCache(maxBytes)
+	return config
+}
+
+// WithIndexSort orders the documents within each segment flushed
+// from a batch by the document values of the fields in the sort order.
+// The sort is applied only when a segment is flushed, segments merged
+// from sorted segments are not sorted.
+// Top N searches sorted by the same order may then skip the rest of
+// each segment once its matches rank too low, when the total number of
+// matches need not be exact, see TopNSearch.TrackTotalHitsUpTo.
+// Sort orders using anything other than field values, such as the
+// score, cannot be used to sort the index and are ignored.
+func (config Config) WithIndexSort(order search.SortOrder) Config {
+	config.indexConfig = config.indexConfig.WithIndexSort(order)
+	return config
+}
+
+// WithDenseVectorField indexes the vectors of the field, which
+// must all have dims dimensions, to find the nearest neighbors of
+// a vector by the similarity, see NewDenseVectorField and
+// NewKNNQuery.  The vectors of each segment are linked in a graph
+// when the segment is built, which is persisted with the segment and
+// loaded when opening the index, the graphs of merged segments are
+// merged, adding the vectors of the others to the largest graph.
+func (config Config) WithDenseVectorField(name string, dims int, similarity hnsw.Similarity) Config {
+	config.indexConfig = config.indexConfig.WithVectorField(name, index.VectorField{
+		Dims:       dims,
+		Similarity: similarity,
+	})
+	return config
+}
+
+// WithConcurrentSearch partitions the segments of the index into at
+// most slices slices, which are searched concurrently, each by a
+// function passed to executor, and the results are then merged.
+// A nil executor runs each function in its own goroutine.
+// Searches are only partitioned when their collector supports it.
+func (config Config) WithConcurrentSearch(slices int, executor func(func())) Config {
+	config.SearchSlices = slices
+	config.SearchExecutor = executor
+	return config
+}
+
 func (config Config) WithSearchStartFunc(f func(size uint64) error) Config {
 	config.SearchStartFunc = f
 	return config
@@ -106,13 +162,46 @@ func defaultConfig(indexConfig index.Config) Config {
 	allDocsFields := NewKeywordField("", "")
 	_ = allDocsFields.Analyze(0)
 	indexConfig = indexConfig.WithVirtualField(allDocsFields)
-	indexConfig = indexConfig.WithNormCalc(func(field string, length int) float32 {
-		if pfs, ok := rv.PerFieldSimilarity[field]; ok {
-			return pfs.ComputeNorm(length)
-		}
-		return rv.DefaultSimilarity.ComputeNorm(length)
-	})
-	rv.indexConfig = indexConfig
+	rv.indexConfig = indexConfig.WithNormCalc(rv.normCalc())
 
 	return rv
 }
+
+// WithDefaultSimilarity scores the fields without a similarity
+// of their own with the similarity (default: BM25)
+func (config Config) WithDefaultSimilarity(similarity search.Similarity) Config {
+	config.DefaultSimilarity = similarity
+	config.indexConfig = config.indexConfig.WithNormCalc(config.normCalc())
+	return config
+}
+
+// WithSimilarity scores the field with the similarity, such as
+// similarity.NewLMDirichletSimilarity().  The similarity also
+// computes the norms of the field when documents are indexed,
+// the similarities of package similarity all store the length of
+// the field, so that the similarity of a field may be changed
+// without indexing its documents again.
+func (config Config) WithSimilarity(field string, similarity search.Similarity) Config {
+	perFieldSimilarity := make(map[string]search.Similarity, len(config.PerFieldSimilarity)+1)
+	for k, v := range config.PerFieldSimilarity {
+		perFieldSimilarity[k] = v
+	}
+	perFieldSimilarity[field] = similarity
+	config.PerFieldSimilarity = perFieldSimilarity
+	config.indexConfig = config.indexConfig.WithNormCalc(config.normCalc())
+	return config
+}
+
+// SimilarityForField returns the similarity scoring the field
+func (config Config) SimilarityForField(field string) search.Similarity {
+	if pfs, ok := config.PerFieldSimilarity[field]; ok {
+		return pfs
+	}
+	return config.DefaultSimilarity
+}
+
+func (config Config) normCalc() func(field string, length int) float32 {
+	return func(field string, length int) float32 {
+		return config.SimilarityForField(field).ComputeNorm(length)
+	}
+}
@@ -0,0 +1,154 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluge
+
+import (
+	"math"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// FeatureSet names the features of matches which ranking models
+// are trained on and evaluated over, the same set is used to log
+// the features of matches for training, and to rescore them
+type FeatureSet struct {
+	names   []string
+	queries []Query
+	sources []search.NumericValuesSource
+}
+
+func NewFeatureSet() *FeatureSet {
+	return &FeatureSet{}
+}
+
+// AddQueryFeature adds a feature whose value is the score of the
+// query, or 0 for matches the query does not match
+func (f *FeatureSet) AddQueryFeature(name string, q Query) *FeatureSet {
+	f.names = append(f.names, name)
+	f.queries = append(f.queries, q)
+	f.sources = append(f.sources, nil)
+	return f
+}
+
+// AddValueFeature adds a feature whose value is the first value
+// of the source, or NaN for matches without values
+func (f *FeatureSet) AddValueFeature(name string, src search.NumericValuesSource) *FeatureSet {
+	f.names = append(f.names, name)
+	f.queries = append(f.queries, nil)
+	f.sources = append(f.sources, src)
+	return f
+}
+
+// Names returns the names of the features, in the order of
+// the values in the Features of each match
+func (f *FeatureSet) Names() []string {
+	return f.names
+}
+
+// extract sets the Features of the matches of one reader
+func (f *FeatureSet) extract(reader search.Reader, config Config,
+	matches []*search.DocumentMatch) error {
+	sorted := sortedByNumber(matches)
+	for _, match := range sorted {
+		match.Features = make([]float64, len(f.names))
+	}
+	options := searchOptionsFromConfig(config, SearchOptions{})
+	for i := range f.names {
+		if f.queries[i] != nil {
+			err := scoreMatches(f.queries[i], reader, options, sorted,
+				func(match, dm *search.DocumentMatch) {
+					match.Features[i] = dm.Score
+				})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := f.extractValues(i, reader, sorted)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FeatureSet) extractValues(i int, reader search.Reader, matches []*search.DocumentMatch) error {
+	src := f.sources[i]
+	fields := src.Fields()
+	ctx := search.NewSearchContext(1, 0)
+	for _, match := range matches {
+		dm := &search.DocumentMatch{
+			Number: match.Number,
+		}
+		dm.SetReader(reader)
+		err := dm.LoadDocumentValues(ctx, fields)
+		if err != nil {
+			return err
+		}
+		match.Features[i] = math.NaN()
+		if values := src.Numbers(dm); len(values) > 0 {
+			match.Features[i] = values[0]
+		}
+	}
+	return nil
+}
+
+func sortedByNumber(matches []*search.DocumentMatch) []*search.DocumentMatch {
+	rv := make([]*search.DocumentMatch, len(matches))
+	copy(rv, matches)
+	sort.Slice(rv, func(i, j int) bool {
+		return rv[i].Number < rv[j].Number
+	})
+	return rv
+}
+
+// scoreMatches advances a searcher of the query to each of the
+// matches, which are sorted by number, and calls found with
+// each match the query matches and the match of the query
+func scoreMatches(q Query, reader search.Reader, options search.SearcherOptions,
+	sorted []*search.DocumentMatch, found func(match, dm *search.DocumentMatch)) (err error) {
+	searcher, err := q.Searcher(reader, options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := searcher.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx := search.NewSearchContext(searcher.DocumentMatchPoolSize(), 0)
+	var dm *search.DocumentMatch
+	for _, match := range sorted {
+		if dm == nil || dm.Number < match.Number {
+			if dm != nil {
+				ctx.DocumentMatchPool.Put(dm)
+			}
+			dm, err = searcher.Advance(ctx, match.Number)
+			if err != nil {
+				return err
+			}
+			if dm == nil {
+				// the query matches none of the following matches
+				return nil
+			}
+		}
+		if dm.Number == match.Number {
+			found(match, dm)
+		}
+	}
+	return nil
+}
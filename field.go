@@ -22,6 +22,7 @@ import (
 	"github.com/blugelabs/bluge/analysis/analyzer"
 
 	"github.com/blugelabs/bluge/analysis"
+	"github.com/blugelabs/bluge/index/hnsw"
 	"github.com/blugelabs/bluge/numeric"
 	"github.com/blugelabs/bluge/numeric/geo"
 )
@@ -344,6 +345,24 @@ func DecodeGeoLonLat(value []byte) (lon, lat float64, err error) {
 	return geo.MortonUnhashLon(uint64(i64)), geo.MortonUnhashLat(uint64(i64)), nil
 }
 
+// NewDenseVectorField stores a vector, which is indexed
+// for nearest neighbor search when the field is configured
+// with Config.WithDenseVectorField, all the vectors of the
+// field must then have the number of dimensions configured
+func NewDenseVectorField(name string, vector []float32) *TermField {
+	value := hnsw.EncodeVector(vector)
+	return &TermField{
+		FieldOptions:      Store,
+		name:              name,
+		value:             value,
+		numPlainTextBytes: len(value),
+	}
+}
+
+func DecodeDenseVector(value []byte) ([]float32, error) {
+	return hnsw.DecodeVector(value)
+}
+
 const defaultCompositeIndexingOptions = Index
 
 type CompositeField struct {
@@ -0,0 +1,361 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/collector"
+	"github.com/blugelabs/bluge/search/searcher"
+	"github.com/blugelabs/bluge/search/similarity"
+)
+
+type FusionMethod int
+
+const (
+	// Each match scores the sum, over the searches which found it,
+	// of the weight of the search divided by the rank constant plus
+	// the rank of the match, this is the default.
+	FusionReciprocalRank FusionMethod = iota
+	// Each match scores the sum, over the searches which found it,
+	// of the weight of the search multiplied by the score of the
+	// match, normalized to range from 0 to 1 among its matches.
+	FusionNormalizedScores
+)
+
+// DefaultRankConstant dampens the advantage of the top ranks of
+// each search, in reciprocal rank fusion
+const DefaultRankConstant = 60
+
+// RankFusionSearch combines the ranked matches of several searches
+// of the same readers, such as a lexical query and a kNN query, whose
+// scores cannot be compared, into a single ranked list.  Each search
+// contributes its top matches, the size of each search is thus the
+// window of matches it ranks.
+type RankFusionSearch struct {
+	searches     []SearchRequest
+	weights      []float64
+	n            int
+	from         int
+	method       FusionMethod
+	rankConstant float64
+	explain      bool
+
+	aggregations     search.Aggregations
+	aggregationsFrom int
+}
+
+// NewRankFusionSearch creates a search which will return the first
+// N matches of the fused matches of its searches
+func NewRankFusionSearch(n int) *RankFusionSearch {
+	return &RankFusionSearch{
+		n:                n,
+		rankConstant:     DefaultRankConstant,
+		aggregations:     make(search.Aggregations),
+		aggregationsFrom: -1,
+	}
+}
+
+// AddSearch adds a search whose matches are fused with weight 1
+func (s *RankFusionSearch) AddSearch(req SearchRequest) *RankFusionSearch {
+	return s.AddWeightedSearch(req, 1)
+}
+
+// AddWeightedSearch adds a search whose
+// matches are fused with the weight
+func (s *RankFusionSearch) AddWeightedSearch(req SearchRequest, weight float64) *RankFusionSearch {
+	s.searches = append(s.searches, req)
+	s.weights = append(s.weights, weight)
+	return s
+}
+
+// Size returns the number of matches this search request will return
+func (s *RankFusionSearch) Size() int {
+	return s.n
+}
+
+// SetFrom sets the number of fused matches to skip
+func (s *RankFusionSearch) SetFrom(from int) *RankFusionSearch {
+	s.from = from
+	return s
+}
+
+// From returns the number of fused matches that will be skipped
+func (s *RankFusionSearch) From() int {
+	return s.from
+}
+
+// SetMethod sets the way the matches of the searches
+// are fused (default: FusionReciprocalRank)
+func (s *RankFusionSearch) SetMethod(method FusionMethod) *RankFusionSearch {
+	s.method = method
+	return s
+}
+
+// SetRankConstant sets the constant added to ranks in reciprocal
+// rank fusion (default: DefaultRankConstant), lower values favor
+// the top matches of each search
+func (s *RankFusionSearch) SetRankConstant(k float64) *RankFusionSearch {
+	s.rankConstant = k
+	return s
+}
+
+// ExplainScores explains how the fused scores were computed,
+// the searches must explain their own scores for their
+// explanations to be included
+func (s *RankFusionSearch) ExplainScores() *RankFusionSearch {
+	s.explain = true
+	return s
+}
+
+// AggregationsFrom returns the aggregations of the search at index,
+// in the order the searches were added, instead of computing the
+// aggregations of this request over the matches of all the searches
+func (s *RankFusionSearch) AggregationsFrom(index int) *RankFusionSearch {
+	s.aggregationsFrom = index
+	return s
+}
+
+// AddAggregation adds an aggregation computed over the union of
+// the matches of the searches, not only their top matches
+func (s *RankFusionSearch) AddAggregation(name string, aggregation search.Aggregation) {
+	s.aggregations.Add(name, aggregation)
+}
+
+func (s *RankFusionSearch) Aggregations() search.Aggregations {
+	return s.aggregations
+}
+
+// Collector collects every match of the union of the searches
+func (s *RankFusionSearch) Collector() search.Collector {
+	return collector.NewAllCollector()
+}
+
+// Searcher matches the union of the matches of the searches
+func (s *RankFusionSearch) Searcher(i search.Reader, config Config) (search.Searcher, error) {
+	var searchers []search.Searcher
+	for _, req := range s.searches {
+		sr, err := req.Searcher(i, config)
+		if err != nil {
+			for _, opened := range searchers {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		searchers = append(searchers, sr)
+	}
+	return searcher.NewDisjunctionSearcher(i, searchers, 0, similarity.NewCompositeSumScorer(),
+		searchOptionsFromConfig(config, SearchOptions{}))
+}
+
+// fusingRequest is implemented by requests which
+// combine the results of other searches
+type fusingRequest interface {
+	fuse(searchFunc func(SearchRequest) (search.DocumentMatchIterator, error)) (search.DocumentMatchIterator, error)
+}
+
+type fusionKey struct {
+	source int
+	number uint64
+}
+
+// fuse runs each search using searchFunc, and returns the fused
+// matches requested, matches are the same document when they were
+// found by the same reader with the same number
+func (s *RankFusionSearch) fuse(
+	searchFunc func(SearchRequest) (search.DocumentMatchIterator, error)) (search.DocumentMatchIterator, error) {
+	if s.aggregationsFrom >= len(s.searches) {
+		return nil, fmt.Errorf("aggregations from search %d, of %d searches",
+			s.aggregationsFrom, len(s.searches))
+	}
+
+	var matches []*search.DocumentMatch
+	fused := make(map[fusionKey]*search.DocumentMatch)
+	scores := make(map[*search.DocumentMatch]float64)
+	explanations := make(map[*search.DocumentMatch][]*search.Explanation)
+	var bucket *search.Bucket
+	for i, req := range s.searches {
+		dmi, err := searchFunc(req)
+		if err != nil {
+			return nil, err
+		}
+		var ranked []*search.DocumentMatch
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			ranked = append(ranked, next)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i == s.aggregationsFrom {
+			bucket = dmi.Aggregations()
+		}
+
+		contributions := s.contributions(i, ranked)
+		for rank, match := range ranked {
+			key := fusionKey{source: match.SourceIndex, number: match.Number}
+			first, ok := fused[key]
+			if !ok {
+				first = match
+				fused[key] = first
+				matches = append(matches, first)
+			}
+			scores[first] += contributions[rank].Value
+			if s.explain {
+				explanations[first] = append(explanations[first], contributions[rank])
+			}
+		}
+	}
+
+	if bucket == nil {
+		var err error
+		bucket, err = s.unionAggregations(searchFunc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, match := range matches {
+		match.Score = scores[match]
+		match.SortValue = nil
+		match.Explanation = nil
+		if s.explain {
+			match.Explanation = search.NewExplanation(match.Score,
+				"sum of the fused scores of the searches", explanations[match]...)
+		}
+	}
+	// ties keep the order in which the matches were first found
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	from := s.from
+	if from > len(matches) {
+		from = len(matches)
+	}
+	matches = matches[from:]
+	if len(matches) > s.n {
+		matches = matches[:s.n]
+	}
+	for i, match := range matches {
+		match.HitNumber = i + 1
+	}
+
+	return &fusedIterator{
+		matches: matches,
+		bucket:  bucket,
+	}, nil
+}
+
+// contributions returns the part of the fused score
+// of each of the ranked matches of search i
+func (s *RankFusionSearch) contributions(i int, ranked []*search.DocumentMatch) []*search.Explanation {
+	weight := s.weights[i]
+	rv := make([]*search.Explanation, len(ranked))
+	if s.method == FusionNormalizedScores {
+		var min, max float64
+		for rank, match := range ranked {
+			if rank == 0 || match.Score < min {
+				min = match.Score
+			}
+			if rank == 0 || match.Score > max {
+				max = match.Score
+			}
+		}
+		for rank, match := range ranked {
+			normalized := 1.0
+			if max > min {
+				normalized = (match.Score - min) / (max - min)
+			}
+			rv[rank] = search.NewExplanation(weight*normalized,
+				fmt.Sprintf("product of weight %f and normalized score %f of search %d", weight, normalized, i),
+				match.Explanation)
+		}
+		return rv
+	}
+	for rank := range ranked {
+		rv[rank] = search.NewExplanation(weight/(s.rankConstant+float64(rank+1)),
+			fmt.Sprintf("weight %f divided by rank constant %f plus rank %d of search %d",
+				weight, s.rankConstant, rank+1, i))
+	}
+	return rv
+}
+
+// unionAggregations computes the aggregations of the request over
+// the union of the matches of the searches, when there are any
+func (s *RankFusionSearch) unionAggregations(
+	searchFunc func(SearchRequest) (search.DocumentMatchIterator, error)) (*search.Bucket, error) {
+	if len(s.aggregations) == 0 || len(s.searches) == 0 {
+		rv := search.NewBucket("", s.aggregations)
+		rv.Finish()
+		return rv, nil
+	}
+	dmi, err := searchFunc(&unionSearch{fusion: s})
+	if err != nil {
+		return nil, err
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dmi.Aggregations(), nil
+}
+
+// unionSearch searches every match of the searches of the fusion,
+// without fusing them, to compute the aggregations of the fusion
+type unionSearch struct {
+	fusion *RankFusionSearch
+}
+
+func (u *unionSearch) Collector() search.Collector {
+	return u.fusion.Collector()
+}
+
+func (u *unionSearch) Searcher(i search.Reader, config Config) (search.Searcher, error) {
+	return u.fusion.Searcher(i, config)
+}
+
+func (u *unionSearch) AddAggregation(name string, aggregation search.Aggregation) {
+	u.fusion.AddAggregation(name, aggregation)
+}
+
+func (u *unionSearch) Aggregations() search.Aggregations {
+	return u.fusion.Aggregations()
+}
+
+// fusedIterator iterates the fused matches
+type fusedIterator struct {
+	matches []*search.DocumentMatch
+	index   int
+	bucket  *search.Bucket
+}
+
+func (i *fusedIterator) Next() (*search.DocumentMatch, error) {
+	if i.index < len(i.matches) {
+		rv := i.matches[i.index]
+		i.index++
+		return rv, nil
+	}
+	return nil, nil
+}
+
+func (i *fusedIterator) Aggregations() *search.Bucket {
+	return i.bucket
+}
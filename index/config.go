@@ -74,6 +74,22 @@ type Config struct {
 
 	ValidateSnapshotCRC bool
 
+	// QueryCacheMaxBytes is the maximum size of the per-segment
+	// filter cache, the cache is disabled when zero
+	QueryCacheMaxBytes uint64
+
+	// QueryCacheMinFrequency is the number of times a filter must be
+	// used, among the most recent uses, before its results are cached
+	QueryCacheMinFrequency int
+
+	// IndexSort, when set, orders the documents within each
+	// segment built from a batch, merged segments are not sorted
+	IndexSort DocumentSort
+
+	// VectorFields describes the fields of dense vectors,
+	// which are indexed for nearest neighbor search
+	VectorFields map[string]VectorField
+
 	virtualFields map[string][]segment.Field
 }
 
@@ -125,6 +141,16 @@ func (config Config) DisableOptimizeDisjunctionUnadorned() Config {
 	return config
 }
 
+func (config Config) WithQueryCache(maxBytes uint64) Config {
+	config.QueryCacheMaxBytes = maxBytes
+	return config
+}
+
+func (config Config) WithIndexSort(sort DocumentSort) Config {
+	config.IndexSort = sort
+	return config
+}
+
 func (config Config) WithUnsafeBatches() Config {
 	config.UnsafeBatch = true
 	return config
@@ -208,6 +234,8 @@ func defaultConfig() Config {
 
 		ValidateSnapshotCRC: true,
 
+		QueryCacheMinFrequency: 2,
+
 		supportedSegmentPlugins: map[string]map[uint32]*SegmentPlugin{},
 	}
 
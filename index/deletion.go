@@ -14,6 +14,8 @@
 
 package index
 
+import "os"
+
 type DeletionPolicy interface {
 	Commit(snapshot *Snapshot)
 	Cleanup(Directory) error
@@ -85,8 +87,12 @@ OUTER:
 			}
 		}
 
-		// file is no longer needed by anyone
-		err := dir.Remove(ItemKindSegment, segmentID)
+		// file is no longer needed by anyone, nor are its vectors
+		err := dir.Remove(ItemKindVectors, segmentID)
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		err = dir.Remove(ItemKindSegment, segmentID)
 		if err != nil {
 			// unable to remove, we'll try again next time
 			continue
@@ -24,6 +24,9 @@ import (
 const (
 	ItemKindSnapshot = ".snp"
 	ItemKindSegment  = ".seg"
+	// ItemKindVectors holds the graphs of the vectors
+	// of the segment of the same id
+	ItemKindVectors = ".vec"
 )
 
 // WriterTo is like io.WriterTo only it can be canceled
@@ -0,0 +1,175 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hnsw
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const graphFormatVersion = 1
+
+// WriteTo encodes the graph, to be decoded by ReadGraph
+func (g *Graph) WriteTo(w io.Writer) (int64, error) {
+	gw := &graphWriter{
+		w:      bufio.NewWriter(w),
+		intBuf: make([]byte, binary.MaxVarintLen64),
+	}
+	gw.uvarint(graphFormatVersion)
+	gw.uvarint(uint64(g.similarity))
+	gw.uvarint(uint64(g.m))
+	gw.uvarint(uint64(g.efConstruction))
+	gw.uvarint(uint64(g.entry + 1))
+	gw.uvarint(uint64(g.maxLevel))
+	gw.uvarint(uint64(len(g.ids)))
+	gw.uvarint(uint64(g.Dims()))
+	for node, id := range g.ids {
+		gw.uvarint(id)
+		for _, val := range g.vectors[node] {
+			gw.float32(val)
+		}
+		gw.uvarint(uint64(len(g.friends[node])))
+		for _, friends := range g.friends[node] {
+			gw.uvarint(uint64(len(friends)))
+			for _, friend := range friends {
+				gw.uvarint(uint64(friend))
+			}
+		}
+	}
+	if gw.err != nil {
+		return gw.n, gw.err
+	}
+	return gw.n, gw.w.Flush()
+}
+
+// graphWriter writes the values of a graph,
+// until it fails to write one of them
+type graphWriter struct {
+	w      *bufio.Writer
+	intBuf []byte
+	n      int64
+	err    error
+}
+
+func (w *graphWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var sz int
+	sz, w.err = w.w.Write(b)
+	w.n += int64(sz)
+}
+
+func (w *graphWriter) uvarint(val uint64) {
+	n := binary.PutUvarint(w.intBuf, val)
+	w.write(w.intBuf[:n])
+}
+
+func (w *graphWriter) float32(val float32) {
+	binary.LittleEndian.PutUint32(w.intBuf, math.Float32bits(val))
+	w.write(w.intBuf[:4])
+}
+
+// ReadGraph decodes a graph encoded by WriteTo, the reader
+// is left at the end of the graph, so that the values which
+// follow it may be read
+func ReadGraph(r *bufio.Reader) (*Graph, error) {
+	gr := &graphReader{
+		r:      r,
+		intBuf: make([]byte, 4),
+	}
+	version := gr.uvarint()
+	if gr.err == nil && version != graphFormatVersion {
+		return nil, fmt.Errorf("unsupported graph format version %d", version)
+	}
+	rv := NewGraph(Similarity(gr.uvarint()), int(gr.uvarint()), int(gr.uvarint()))
+	rv.entry = int(gr.uvarint()) - 1
+	rv.maxLevel = int(gr.uvarint())
+	count := gr.uvarint()
+	dims := gr.uvarint()
+	if gr.err != nil {
+		return nil, fmt.Errorf("error reading graph: %w", gr.err)
+	}
+	if rv.entry >= int(count) || (rv.entry < 0 && count > 0) {
+		return nil, fmt.Errorf("graph entry point %d is not one of its %d vectors", rv.entry, count)
+	}
+
+	rv.ids = make([]uint64, 0, count)
+	rv.vectors = make([][]float32, 0, count)
+	rv.friends = make([][][]uint32, 0, count)
+	for node := uint64(0); node < count && gr.err == nil; node++ {
+		rv.ids = append(rv.ids, gr.uvarint())
+		vector := make([]float32, dims)
+		for i := range vector {
+			vector[i] = gr.float32()
+		}
+		rv.vectors = append(rv.vectors, vector)
+		numLevels := gr.uvarint()
+		if numLevels > uint64(rv.maxLevel)+1 && gr.err == nil {
+			gr.err = fmt.Errorf("vector on %d layers, graph has %d", numLevels, rv.maxLevel+1)
+		}
+		levels := make([][]uint32, numLevels)
+		for level := range levels {
+			numFriends := gr.uvarint()
+			if numFriends > count && gr.err == nil {
+				gr.err = fmt.Errorf("vector has %d neighbors, graph has %d vectors", numFriends, count)
+			}
+			if gr.err != nil {
+				break
+			}
+			friends := make([]uint32, numFriends)
+			for i := range friends {
+				friends[i] = uint32(gr.uvarint())
+				if uint64(friends[i]) >= count && gr.err == nil {
+					gr.err = fmt.Errorf("neighbor %d is not one of the %d vectors", friends[i], count)
+				}
+			}
+			levels[level] = friends
+		}
+		rv.friends = append(rv.friends, levels)
+	}
+	if gr.err != nil {
+		return nil, fmt.Errorf("error reading graph: %w", gr.err)
+	}
+	return rv, nil
+}
+
+// graphReader reads the values of a graph,
+// until it fails to read one of them
+type graphReader struct {
+	r      *bufio.Reader
+	intBuf []byte
+	err    error
+}
+
+func (r *graphReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var rv uint64
+	rv, r.err = binary.ReadUvarint(r.r)
+	return rv
+}
+
+func (r *graphReader) float32() float32 {
+	if r.err != nil {
+		return 0
+	}
+	_, r.err = io.ReadFull(r.r, r.intBuf)
+	return math.Float32frombits(binary.LittleEndian.Uint32(r.intBuf))
+}
@@ -0,0 +1,422 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hnsw implements hierarchical navigable small world graphs,
+// which find the approximate nearest neighbors of a vector, as
+// described by Malkov and Yashunin in "Efficient and robust
+// approximate nearest neighbor search using Hierarchical
+// Navigable Small World graphs"
+package hnsw
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+const (
+	DefaultM              = 16
+	DefaultEFConstruction = 100
+)
+
+// Neighbor is a vector found by a search, and its score
+type Neighbor struct {
+	ID    uint64
+	Score float64
+}
+
+// Graph links each vector to its nearest neighbors, on a number of
+// layers, each of which holds a fraction of the vectors of the layer
+// below, searches descend the layers from the sparsest to the densest
+type Graph struct {
+	similarity     Similarity
+	m              int
+	efConstruction int
+	levelMult      float64
+	rand           *rand.Rand
+
+	ids     []uint64
+	vectors [][]float32
+	// friends holds the neighbors of each node on each of its layers
+	friends  [][][]uint32
+	entry    int
+	maxLevel int
+}
+
+// NewGraph builds an empty graph, linking each vector to m neighbors
+// on each layer, and 2m on the lowest, chosen among efConstruction
+// candidates, zero values use DefaultM and DefaultEFConstruction
+func NewGraph(similarity Similarity, m, efConstruction int) *Graph {
+	if m <= 1 {
+		m = DefaultM
+	}
+	if efConstruction <= 0 {
+		efConstruction = DefaultEFConstruction
+	}
+	return &Graph{
+		similarity:     similarity,
+		m:              m,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(m)),
+		// graphs of the same vectors are always the same
+		rand:  rand.New(rand.NewSource(1)),
+		entry: -1,
+	}
+}
+
+// Len returns the number of vectors in the graph
+func (g *Graph) Len() int {
+	return len(g.ids)
+}
+
+// Dims returns the number of dimensions of the
+// vectors in the graph, or 0 for an empty graph
+func (g *Graph) Dims() int {
+	if len(g.vectors) == 0 {
+		return 0
+	}
+	return len(g.vectors[0])
+}
+
+// Similarity returns the similarity comparing the vectors of the graph
+func (g *Graph) Similarity() Similarity {
+	return g.similarity
+}
+
+// Each calls f with each vector of the graph, in the order they were
+// added, the vectors of graphs using cosine similarity are normalized
+func (g *Graph) Each(f func(id uint64, vector []float32)) {
+	for node, id := range g.ids {
+		f(id, g.vectors[node])
+	}
+}
+
+// Remap returns a copy of the graph, identifying each vector by the id
+// remap returns for it, so that the vectors of a graph may be added to
+// a copy of another rather than to an empty graph, which is faster
+func (g *Graph) Remap(remap func(id uint64) uint64) *Graph {
+	rv := NewGraph(g.similarity, g.m, g.efConstruction)
+	rv.entry = g.entry
+	rv.maxLevel = g.maxLevel
+	rv.ids = make([]uint64, len(g.ids))
+	for node, id := range g.ids {
+		rv.ids[node] = remap(id)
+	}
+	// the vectors are never modified, but the neighbors are
+	// when adding vectors, so only they are copied
+	rv.vectors = append([][]float32(nil), g.vectors...)
+	rv.friends = make([][][]uint32, len(g.friends))
+	for node, levels := range g.friends {
+		rv.friends[node] = make([][]uint32, len(levels))
+		for level, friends := range levels {
+			rv.friends[node][level] = append([]uint32(nil), friends...)
+		}
+	}
+	return rv
+}
+
+// Size returns the approximate memory used by the graph, in bytes
+func (g *Graph) Size() int {
+	rv := len(g.ids) * 8
+	for i := range g.vectors {
+		rv += len(g.vectors[i]) * 4
+		for _, friends := range g.friends[i] {
+			rv += len(friends) * 4
+		}
+	}
+	return rv
+}
+
+// prepare copies the vector, normalizing it for cosine similarity,
+// which is then the dot product of the normalized vectors
+func (g *Graph) prepare(vector []float32) []float32 {
+	rv := make([]float32, len(vector))
+	copy(rv, vector)
+	if g.similarity == Cosine {
+		if n := norm(rv); n > 0 {
+			for i := range rv {
+				rv[i] = float32(float64(rv[i]) / n)
+			}
+		}
+	}
+	return rv
+}
+
+func (g *Graph) score(a, b []float32) float64 {
+	if g.similarity == Cosine {
+		return (1 + dot(a, b)) / 2
+	}
+	return g.similarity.Score(a, b)
+}
+
+// Add inserts the vector identified by id
+func (g *Graph) Add(id uint64, vector []float32) {
+	vector = g.prepare(vector)
+	node := uint32(len(g.ids))
+	level := int(-math.Log(1-g.rand.Float64()) * g.levelMult)
+	g.ids = append(g.ids, id)
+	g.vectors = append(g.vectors, vector)
+	g.friends = append(g.friends, make([][]uint32, level+1))
+	if g.entry < 0 {
+		g.entry = int(node)
+		g.maxLevel = level
+		return
+	}
+
+	entryPoints := []uint32{uint32(g.entry)}
+	for l := g.maxLevel; l > level; l-- {
+		nearest := g.searchLayer(vector, entryPoints, 1, l, nil)
+		entryPoints = []uint32{nearest[0].node}
+	}
+	for l := minInt(level, g.maxLevel); l >= 0; l-- {
+		candidates := g.searchLayer(vector, entryPoints, g.efConstruction, l, nil)
+		g.friends[node][l] = g.selectNeighbors(candidates, g.maxFriends(l))
+		for _, friend := range g.friends[node][l] {
+			g.link(friend, node, l)
+		}
+		entryPoints = entryPoints[:0]
+		for _, candidate := range candidates {
+			entryPoints = append(entryPoints, candidate.node)
+		}
+	}
+	if level > g.maxLevel {
+		g.entry = int(node)
+		g.maxLevel = level
+	}
+}
+
+func (g *Graph) maxFriends(level int) int {
+	if level == 0 {
+		return 2 * g.m
+	}
+	return g.m
+}
+
+// link adds the new node to the neighbors of node,
+// pruning them again when there are too many
+func (g *Graph) link(node, newNode uint32, level int) {
+	friends := append(g.friends[node][level], newNode)
+	if len(friends) > g.maxFriends(level) {
+		candidates := make([]scored, len(friends))
+		for i, friend := range friends {
+			candidates[i] = scored{
+				node:  friend,
+				score: g.score(g.vectors[node], g.vectors[friend]),
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].score > candidates[j].score
+		})
+		friends = g.selectNeighbors(candidates, g.maxFriends(level))
+	}
+	g.friends[node][level] = friends
+}
+
+// selectNeighbors chooses among candidates, sorted from the nearest,
+// those nearer to the node than to any neighbor already chosen, so
+// that the neighbors lead in different directions, and then the
+// nearest of the others, up to max neighbors
+func (g *Graph) selectNeighbors(candidates []scored, max int) []uint32 {
+	rv := make([]uint32, 0, max)
+	var pruned []uint32
+	for _, candidate := range candidates {
+		if len(rv) == max {
+			break
+		}
+		diverse := true
+		for _, chosen := range rv {
+			if g.score(g.vectors[candidate.node], g.vectors[chosen]) > candidate.score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			rv = append(rv, candidate.node)
+		} else {
+			pruned = append(pruned, candidate.node)
+		}
+	}
+	for _, node := range pruned {
+		if len(rv) == max {
+			break
+		}
+		rv = append(rv, node)
+	}
+	return rv
+}
+
+// Search returns the k vectors most similar to the vector, best
+// first, among the ef nearest candidates, ef should be at least k,
+// greater values find the nearest neighbors more reliably.  When
+// accept is not nil, only the vectors it accepts are returned.
+func (g *Graph) Search(vector []float32, k, ef int, accept func(id uint64) bool) []Neighbor {
+	if g.entry < 0 || k <= 0 {
+		return nil
+	}
+	if ef < k {
+		ef = k
+	}
+	vector = g.prepare(vector)
+	entryPoints := []uint32{uint32(g.entry)}
+	for l := g.maxLevel; l > 0; l-- {
+		nearest := g.searchLayer(vector, entryPoints, 1, l, nil)
+		entryPoints = []uint32{nearest[0].node}
+	}
+	found := g.searchLayer(vector, entryPoints, ef, 0, accept)
+	if len(found) > k {
+		found = found[:k]
+	}
+	rv := make([]Neighbor, len(found))
+	for i, f := range found {
+		rv[i] = Neighbor{
+			ID:    g.ids[f.node],
+			Score: f.score,
+		}
+	}
+	return rv
+}
+
+// ExactSearch returns the k vectors most similar to the vector, best
+// first, comparing it to every vector accepted, which is faster than
+// searching the graph when accept only accepts a few vectors
+func (g *Graph) ExactSearch(vector []float32, k int, accept func(id uint64) bool) []Neighbor {
+	if k <= 0 {
+		return nil
+	}
+	vector = g.prepare(vector)
+	results := &scoredHeap{}
+	for node, id := range g.ids {
+		if accept != nil && !accept(id) {
+			continue
+		}
+		heap.Push(results, scored{
+			node:  uint32(node),
+			score: g.score(vector, g.vectors[node]),
+		})
+		if results.Len() > k {
+			heap.Pop(results)
+		}
+	}
+	rv := make([]Neighbor, results.Len())
+	for i := len(rv) - 1; i >= 0; i-- {
+		s := heap.Pop(results).(scored)
+		rv[i] = Neighbor{
+			ID:    g.ids[s.node],
+			Score: s.score,
+		}
+	}
+	return rv
+}
+
+// searchLayer returns the ef nearest nodes accepted on the layer,
+// best first, exploring from the entry points through the neighbors
+// of the nearest nodes found, until none of them are nearer
+func (g *Graph) searchLayer(vector []float32, entryPoints []uint32, ef, level int,
+	accept func(id uint64) bool) []scored {
+	visited := bitset.New(uint(len(g.ids)))
+	candidates := &scoredHeap{max: true}
+	results := &scoredHeap{}
+	for _, node := range entryPoints {
+		visited.Set(uint(node))
+		s := scored{
+			node:  node,
+			score: g.score(vector, g.vectors[node]),
+		}
+		heap.Push(candidates, s)
+		if accept == nil || accept(g.ids[node]) {
+			heap.Push(results, s)
+			if results.Len() > ef {
+				heap.Pop(results)
+			}
+		}
+	}
+
+	for candidates.Len() > 0 {
+		candidate := heap.Pop(candidates).(scored)
+		if results.Len() >= ef && candidate.score < results.items[0].score {
+			break
+		}
+		for _, friend := range g.friends[candidate.node][level] {
+			if visited.Test(uint(friend)) {
+				continue
+			}
+			visited.Set(uint(friend))
+			s := scored{
+				node:  friend,
+				score: g.score(vector, g.vectors[friend]),
+			}
+			if results.Len() < ef || s.score > results.items[0].score {
+				heap.Push(candidates, s)
+				if accept == nil || accept(g.ids[friend]) {
+					heap.Push(results, s)
+					if results.Len() > ef {
+						heap.Pop(results)
+					}
+				}
+			}
+		}
+	}
+
+	rv := make([]scored, results.Len())
+	for i := len(rv) - 1; i >= 0; i-- {
+		rv[i] = heap.Pop(results).(scored)
+	}
+	return rv
+}
+
+type scored struct {
+	node  uint32
+	score float64
+}
+
+// scoredHeap keeps the least score on top, or the greatest when max
+type scoredHeap struct {
+	items []scored
+	max   bool
+}
+
+func (h *scoredHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *scoredHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].score > h.items[j].score
+	}
+	return h.items[i].score < h.items[j].score
+}
+
+func (h *scoredHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *scoredHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(scored))
+}
+
+func (h *scoredHeap) Pop() interface{} {
+	rv := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return rv
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
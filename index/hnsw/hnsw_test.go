@@ -0,0 +1,227 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hnsw
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func randomVectors(r *rand.Rand, n, dims int) [][]float32 {
+	rv := make([][]float32, n)
+	for i := range rv {
+		rv[i] = make([]float32, dims)
+		for j := range rv[i] {
+			rv[i][j] = float32(r.NormFloat64())
+		}
+	}
+	return rv
+}
+
+func TestGraphRecall(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	vectors := randomVectors(r, 2000, 16)
+	queries := randomVectors(r, 50, 16)
+	for _, similarity := range []Similarity{Cosine, DotProduct, L2} {
+		g := NewGraph(similarity, 0, 0)
+		for i, vector := range vectors {
+			g.Add(uint64(i), vector)
+		}
+		if g.Len() != len(vectors) {
+			t.Fatalf("expected %d vectors, got %d", len(vectors), g.Len())
+		}
+
+		var found, expected int
+		for _, query := range queries {
+			exact := g.ExactSearch(query, 10, nil)
+			approximate := g.Search(query, 10, 50, nil)
+			if len(approximate) != 10 {
+				t.Fatalf("expected 10 neighbors, got %d", len(approximate))
+			}
+			for i := 1; i < len(approximate); i++ {
+				if approximate[i].Score > approximate[i-1].Score {
+					t.Errorf("expected neighbors best first, got %v", approximate)
+				}
+			}
+			ids := make(map[uint64]struct{})
+			for _, n := range exact {
+				ids[n.ID] = struct{}{}
+			}
+			for _, n := range approximate {
+				if _, ok := ids[n.ID]; ok {
+					found++
+				}
+				if want := similarity.Score(query, vectors[n.ID]); math.Abs(n.Score-want) > 1e-6 {
+					t.Errorf("expected %s score %f for %d, got %f", similarity, want, n.ID, n.Score)
+				}
+			}
+			expected += len(exact)
+		}
+		if recall := float64(found) / float64(expected); recall < 0.9 {
+			t.Errorf("expected %s recall of at least 0.9, got %f", similarity, recall)
+		}
+	}
+}
+
+func TestGraphSearchAccept(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	vectors := randomVectors(r, 500, 8)
+	g := NewGraph(L2, 8, 50)
+	for i, vector := range vectors {
+		g.Add(uint64(i), vector)
+	}
+	even := func(id uint64) bool {
+		return id%2 == 0
+	}
+	for _, query := range randomVectors(r, 10, 8) {
+		neighbors := g.Search(query, 5, 100, even)
+		if len(neighbors) != 5 {
+			t.Fatalf("expected 5 neighbors, got %d", len(neighbors))
+		}
+		for _, n := range neighbors {
+			if !even(n.ID) {
+				t.Errorf("expected only accepted neighbors, got %d", n.ID)
+			}
+		}
+		exact := g.ExactSearch(query, 5, even)
+		if exact[0].ID != neighbors[0].ID {
+			t.Errorf("expected the nearest accepted neighbor %d, got %d", exact[0].ID, neighbors[0].ID)
+		}
+	}
+
+	none := g.Search(vectors[0], 5, 10, func(uint64) bool { return false })
+	if len(none) != 0 {
+		t.Errorf("expected no neighbors, got %v", none)
+	}
+	if len(NewGraph(Cosine, 0, 0).Search(vectors[0], 5, 10, nil)) != 0 {
+		t.Errorf("expected no neighbors in an empty graph")
+	}
+}
+
+func TestSimilarityScores(t *testing.T) {
+	a := []float32{1, 0}
+	b := []float32{0, 2}
+	c := []float32{-3, 0}
+	tests := []struct {
+		similarity Similarity
+		a, b       []float32
+		expected   float64
+	}{
+		{Cosine, a, a, 1},
+		{Cosine, a, b, 0.5},
+		{Cosine, a, c, 0},
+		{DotProduct, a, b, 1},
+		{DotProduct, b, b, 5},
+		{DotProduct, a, c, 0.25},
+		{L2, a, a, 1},
+		{L2, a, b, 1.0 / 6},
+	}
+	for _, test := range tests {
+		if got := test.similarity.Score(test.a, test.b); math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("expected %s of %v and %v to be %f, got %f",
+				test.similarity, test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestEncodeVector(t *testing.T) {
+	vector := []float32{1.5, -2, 0, float32(math.Inf(1)), 3e-20}
+	decoded, err := DecodeVector(EncodeVector(vector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, vector) {
+		t.Errorf("expected %v, got %v", vector, decoded)
+	}
+	if _, err = DecodeVector([]byte{1, 2, 3}); err == nil {
+		t.Errorf("expected error decoding 3 bytes")
+	}
+}
+
+func TestGraphEncoding(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	vectors := randomVectors(r, 300, 8)
+	for _, similarity := range []Similarity{Cosine, L2} {
+		g := NewGraph(similarity, 6, 40)
+		for i, vector := range vectors {
+			g.Add(uint64(i)*2, vector)
+		}
+		var buf bytes.Buffer
+		n, err := g.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(buf.Len()) {
+			t.Errorf("expected %d bytes written, got %d", buf.Len(), n)
+		}
+		// values following the graph can be read
+		buf.WriteByte(42)
+		br := bufio.NewReader(&buf)
+		decoded, err := ReadGraph(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next, err := br.ReadByte(); err != nil || next != 42 {
+			t.Errorf("expected the byte following the graph, got %d, %v", next, err)
+		}
+		// the random levels of vectors added later may differ
+		decoded.rand = g.rand
+		if !reflect.DeepEqual(decoded, g) {
+			t.Errorf("expected the decoded %s graph to be the graph encoded", similarity)
+		}
+
+		encoded := buf.Bytes()
+		for _, truncated := range [][]byte{nil, encoded[:len(encoded)/2]} {
+			if _, err = ReadGraph(bufio.NewReader(bytes.NewReader(truncated))); err == nil {
+				t.Errorf("expected error reading %d bytes of a graph", len(truncated))
+			}
+		}
+	}
+}
+
+func TestGraphRemap(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	vectors := randomVectors(r, 200, 4)
+	g := NewGraph(L2, 0, 0)
+	for i, vector := range vectors[:100] {
+		g.Add(uint64(i), vector)
+	}
+	remapped := g.Remap(func(id uint64) uint64 {
+		return id + 1000
+	})
+	for i, vector := range vectors[100:] {
+		remapped.Add(uint64(i+100), vector)
+	}
+	if g.Len() != 100 || remapped.Len() != 200 {
+		t.Fatalf("expected graphs of 100 and 200 vectors, got %d and %d", g.Len(), remapped.Len())
+	}
+	for _, query := range randomVectors(r, 10, 4) {
+		exact := remapped.ExactSearch(query, 1, nil)
+		approximate := remapped.Search(query, 1, 50, nil)
+		if exact[0].ID != approximate[0].ID {
+			t.Errorf("expected nearest neighbor %d, got %d", exact[0].ID, approximate[0].ID)
+		}
+		// the graph copied is unchanged
+		for _, n := range g.Search(query, 10, 50, nil) {
+			if n.ID >= 100 {
+				t.Errorf("expected only the vectors of the graph copied, got %d", n.ID)
+			}
+		}
+	}
+}
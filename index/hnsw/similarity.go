@@ -0,0 +1,110 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hnsw
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Similarity compares vectors, the scores of all
+// similarities are positive, greater for nearer vectors
+type Similarity int
+
+const (
+	// Cosine scores the cosine of the angle between
+	// the vectors, as (1 + cosine) / 2
+	Cosine Similarity = iota
+	// DotProduct scores the dot product of the vectors, as 1 + dot
+	// for positive products, and as 1 / (1 - dot) for negative ones
+	DotProduct
+	// L2 scores the euclidean distance between the
+	// vectors, as 1 / (1 + the squared distance)
+	L2
+)
+
+func (s Similarity) String() string {
+	switch s {
+	case Cosine:
+		return "cosine"
+	case DotProduct:
+		return "dot_product"
+	case L2:
+		return "l2"
+	}
+	return fmt.Sprintf("similarity(%d)", int(s))
+}
+
+// Score compares two vectors of the same number of dimensions
+func (s Similarity) Score(a, b []float32) float64 {
+	switch s {
+	case Cosine:
+		normA, normB := norm(a), norm(b)
+		if normA == 0 || normB == 0 {
+			return 0.5
+		}
+		return (1 + dot(a, b)/(normA*normB)) / 2
+	case L2:
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return 1 / (1 + sum)
+	}
+	return scoreDotProduct(dot(a, b))
+}
+
+func scoreDotProduct(product float64) float64 {
+	if product < 0 {
+		return 1 / (1 - product)
+	}
+	return product + 1
+}
+
+func dot(a, b []float32) float64 {
+	var rv float64
+	for i := range a {
+		rv += float64(a[i]) * float64(b[i])
+	}
+	return rv
+}
+
+func norm(v []float32) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+// EncodeVector encodes the vector as
+// little endian 32 bit floating point values
+func EncodeVector(vector []float32) []byte {
+	rv := make([]byte, 4*len(vector))
+	for i, val := range vector {
+		binary.LittleEndian.PutUint32(rv[4*i:], math.Float32bits(val))
+	}
+	return rv
+}
+
+// DecodeVector decodes a vector encoded by EncodeVector
+func DecodeVector(data []byte) ([]float32, error) {
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector of %d bytes", len(data))
+	}
+	rv := make([]float32, len(data)/4)
+	for i := range rv {
+		rv[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return rv, nil
+}
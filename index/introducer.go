@@ -26,6 +26,7 @@ import (
 type segmentIntroduction struct {
 	id        uint64
 	data      *segmentWrapper
+	sort      *segmentSort
 	obsoletes map[uint64]*roaring.Bitmap
 	idTerms   []segment.Term
 	internal  map[string][]byte
@@ -121,6 +122,7 @@ func (s *Writer) introduceSegment(next *segmentIntroduction, introduceSnapshotEp
 			id:      root.segment[i].id,
 			segment: root.segment[i].segment,
 			creator: root.segment[i].creator,
+			sort:    root.segment[i].sort,
 		}
 
 		// apply new obsoletions
@@ -159,6 +161,7 @@ func (s *Writer) introduceSegment(next *segmentIntroduction, introduceSnapshotEp
 			id:      next.id,
 			segment: next.data, // take ownership of next.data's ref-count
 			creator: "introduceSegment",
+			sort:    next.sort,
 		}
 		newSnapshot.segment = append(newSnapshot.segment, newSegmentSnapshot)
 		newSnapshot.offsets = append(newSnapshot.offsets, running)
@@ -199,11 +202,14 @@ func (s *Writer) introducePersist(persist *persistIntroduction, introduceSnapsho
 	for i, segSnapshot := range root.segment {
 		// see if this segment has been replaced
 		if replacement, ok := persist.persisted[segSnapshot.id]; ok {
+			// the graphs of the vectors are those of the segment replaced
+			replacement.vectors = segSnapshot.segment.vectors
 			newSegmentSnapshot := &segmentSnapshot{
 				id:      segSnapshot.id,
 				segment: replacement,
 				deleted: segSnapshot.deleted,
 				creator: "introducePersist",
+				sort:    segSnapshot.sort,
 			}
 			newIndexSnapshot.segment[i] = newSegmentSnapshot
 			delete(persist.persisted, segSnapshot.id)
@@ -266,6 +272,7 @@ func (s *Writer) introduceMerge(nextMerge *segmentMerge, introduceSnapshotEpoch
 				segment: root.segment[i].segment,
 				deleted: root.segment[i].deleted,
 				creator: root.segment[i].creator,
+				sort:    root.segment[i].sort,
 			})
 			root.segment[i].segment.AddRef()
 			newSnapshot.offsets = append(newSnapshot.offsets, running)
@@ -348,6 +355,9 @@ func (s *Writer) replaceRoot(newSnapshot *Snapshot, persistedCh chan error, pers
 	if s.root != nil {
 		atomic.StoreUint64(&s.stats.CurRootEpoch, s.root.epoch)
 	}
+	if s.queryCache != nil {
+		s.queryCache.retainSegments(s.root)
+	}
 	s.rootLock.Unlock()
 
 	if rootPrev != nil {
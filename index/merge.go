@@ -132,7 +132,7 @@ func (s *Writer) executeMergeTask(merges chan *segmentMerge, task *mergeplan.Mer
 
 	atomic.AddUint64(&s.stats.TotFileMergePlanTasksSegments, uint64(len(task.Segments)))
 
-	oldMap, segmentsToMerge, docsToDrop := s.planSegmentsToMerge(task)
+	oldMap, segmentsToMerge, docsToDrop, vectorsToMerge := s.planSegmentsToMerge(task)
 
 	newSegmentID := atomic.AddUint64(&s.nextSegmentID, 1)
 	var oldNewDocNums map[uint64][]uint64
@@ -141,7 +141,10 @@ func (s *Writer) executeMergeTask(merges chan *segmentMerge, task *mergeplan.Mer
 		fileMergeZapStartTime := time.Now()
 
 		atomic.AddUint64(&s.stats.TotFileMergeZapBeg, 1)
-		newDocNums, err := s.merge(segmentsToMerge, docsToDrop, newSegmentID)
+		var newDocNums [][]uint64
+		var newVectors segmentVectors
+		var err error
+		newDocNums, newVectors, err = s.merge(segmentsToMerge, docsToDrop, vectorsToMerge, newSegmentID)
 		atomic.AddUint64(&s.stats.TotFileMergeZapEnd, 1)
 
 		fileMergeZapTime := uint64(time.Since(fileMergeZapStartTime))
@@ -163,11 +166,11 @@ func (s *Writer) executeMergeTask(merges chan *segmentMerge, task *mergeplan.Mer
 			atomic.AddUint64(&s.stats.TotFileMergePlanTasksErr, 1)
 			return err
 		}
+		seg.vectors = newVectors
 		oldNewDocNums = make(map[uint64][]uint64)
 		for i, segNewDocNums := range newDocNums {
 			oldNewDocNums[task.Segments[i].ID()] = segNewDocNums
 		}
-
 		atomic.AddUint64(&s.stats.TotFileMergeSegments, uint64(len(segmentsToMerge)))
 	}
 
@@ -215,10 +218,11 @@ func (s *Writer) executeMergeTask(merges chan *segmentMerge, task *mergeplan.Mer
 }
 
 func (s *Writer) planSegmentsToMerge(task *mergeplan.MergeTask) (oldMap map[uint64]*segmentSnapshot,
-	segmentsToMerge []segment.Segment, docsToDrop []*roaring.Bitmap) {
+	segmentsToMerge []segment.Segment, docsToDrop []*roaring.Bitmap, vectorsToMerge []segmentVectors) {
 	oldMap = make(map[uint64]*segmentSnapshot)
 	segmentsToMerge = make([]segment.Segment, 0, len(task.Segments))
 	docsToDrop = make([]*roaring.Bitmap, 0, len(task.Segments))
+	vectorsToMerge = make([]segmentVectors, 0, len(task.Segments))
 	for _, planSegment := range task.Segments {
 		if segSnapshot, ok := planSegment.(*segmentSnapshot); ok {
 			oldMap[segSnapshot.id] = segSnapshot
@@ -229,11 +233,12 @@ func (s *Writer) planSegmentsToMerge(task *mergeplan.MergeTask) (oldMap map[uint
 				} else {
 					segmentsToMerge = append(segmentsToMerge, segSnapshot.segment.Segment)
 					docsToDrop = append(docsToDrop, segSnapshot.deleted)
+					vectorsToMerge = append(vectorsToMerge, segSnapshot.segment.vectors)
 				}
 			}
 		}
 	}
-	return oldMap, segmentsToMerge, docsToDrop
+	return oldMap, segmentsToMerge, docsToDrop, vectorsToMerge
 }
 
 type mergeTaskIntroStatus struct {
@@ -298,7 +303,11 @@ func (s *Writer) mergeSegmentBases(merges chan *segmentMerge, snapshot *Snapshot
 
 	newSegmentID := atomic.AddUint64(&s.nextSegmentID, 1)
 
-	newDocNums, err := s.merge(sbs, sbsDrops, newSegmentID)
+	sbsVectors := make([]segmentVectors, len(sbsIndexes))
+	for i, idx := range sbsIndexes {
+		sbsVectors[i] = snapshot.segment[idx].segment.vectors
+	}
+	newDocNums, newVectors, err := s.merge(sbs, sbsDrops, sbsVectors, newSegmentID)
 
 	atomic.AddUint64(&s.stats.TotMemMergeZapEnd, 1)
 
@@ -318,6 +327,7 @@ func (s *Writer) mergeSegmentBases(merges chan *segmentMerge, snapshot *Snapshot
 		atomic.AddUint64(&s.stats.TotMemMergeErr, 1)
 		return nil, 0, err
 	}
+	seg.vectors = newVectors
 
 	// update persisted stats
 	atomic.AddUint64(&s.stats.TotPersistedItems, seg.Count())
@@ -361,14 +371,24 @@ func (s *Writer) mergeSegmentBases(merges chan *segmentMerge, snapshot *Snapshot
 	return newSnapshot, newSegmentID, nil
 }
 
-func (s *Writer) merge(segments []segment.Segment, drops []*roaring.Bitmap, id uint64) (
-	[][]uint64, error) {
+// merge persists the segment merged from the segments, the documents
+// of each are concatenated, so the merged segment is not sorted by the
+// index sort, even when the segments merged are
+func (s *Writer) merge(segments []segment.Segment, drops []*roaring.Bitmap, vectors []segmentVectors, id uint64) (
+	[][]uint64, segmentVectors, error) {
 	merger := s.segPlugin.Merge(segments, drops, s.config.MergeBufferSize)
 
 	err := s.directory.Persist(ItemKindSegment, id, merger, s.closeCh)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	newDocNums := merger.DocumentNumbers()
+	newVectors := s.config.mergeVectors(vectors, newDocNums)
+	err = s.persistVectors(id, newVectors)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return merger.DocumentNumbers(), nil
+	return newDocNums, newVectors, nil
 }
@@ -295,6 +295,7 @@ func (s *Writer) persistSnapshotMaybeMerge(merges chan *segmentMerge, persists c
 				id:      newSegmentID,
 				segment: segment.segment,
 				deleted: nil, // nil since merging handled deletions
+				sort:    segment.sort,
 			})
 			break
 		}
@@ -317,6 +318,10 @@ func (s *Writer) persistSnapshotDirect(persists chan *persistIntroduction, snaps
 			if err != nil {
 				return fmt.Errorf("error persisting segment: %v", err)
 			}
+			err = s.persistVectors(segmentSnapshot.id, segmentSnapshot.segment.vectors)
+			if err != nil {
+				return fmt.Errorf("error persisting vectors of segment: %v", err)
+			}
 			newSegmentIds = append(newSegmentIds, segmentSnapshot.id)
 		}
 	}
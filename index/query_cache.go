@@ -0,0 +1,347 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RoaringBitmap/roaring"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// QueryCacheHistorySize is the number of most recent filter uses
+// considered when deciding if a filter is used frequently enough
+// for its results to be cached
+const QueryCacheHistorySize = 256
+
+// FilterIterator produces the global numbers of the documents
+// matching a filter, in increasing order
+type FilterIterator interface {
+	// Next returns the next matching document number,
+	// ok is false when there are no more matches
+	Next() (number uint64, ok bool, err error)
+
+	// Advance returns the first matching document number at or
+	// after the specified number, ok is false when there are no
+	// more matches
+	Advance(number uint64) (rv uint64, ok bool, err error)
+
+	Close() error
+}
+
+type queryCacheKey struct {
+	key       string
+	segmentID uint64
+}
+
+type queryCacheEntry struct {
+	queryCacheKey
+	docs *roaring.Bitmap
+	size uint64
+}
+
+// queryCache holds bitmaps of the documents matching a filter,
+// keyed by the filter key and the segment id.  Least recently used
+// entries are evicted once the cache exceeds the maximum size, and
+// filters are only admitted to the cache after they have been seen
+// minFrequency times among the last QueryCacheHistorySize uses.
+type queryCache struct {
+	maxBytes     uint64
+	minFrequency int
+	stats        *Stats
+
+	m         sync.Mutex // Protects the fields that follow.
+	curBytes  uint64
+	entries   map[queryCacheKey]*list.Element
+	lru       *list.List
+	history   []string
+	historyAt int
+	frequency map[string]int
+	// liveSegments holds the ids of segments in the current root,
+	// when nil all segments are considered live
+	liveSegments map[uint64]struct{}
+}
+
+func newQueryCache(maxBytes uint64, minFrequency int, stats *Stats) *queryCache {
+	return &queryCache{
+		maxBytes:     maxBytes,
+		minFrequency: minFrequency,
+		stats:        stats,
+		entries:      make(map[queryCacheKey]*list.Element),
+		lru:          list.New(),
+		history:      make([]string, 0, QueryCacheHistorySize),
+		frequency:    make(map[string]int),
+	}
+}
+
+// recordUse notes another use of the filter identified by key,
+// returning true if its results should now be cached
+func (c *queryCache) recordUse(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.history) < QueryCacheHistorySize {
+		c.history = append(c.history, key)
+	} else {
+		evicted := c.history[c.historyAt]
+		c.frequency[evicted]--
+		if c.frequency[evicted] <= 0 {
+			delete(c.frequency, evicted)
+		}
+		c.history[c.historyAt] = key
+		c.historyAt = (c.historyAt + 1) % QueryCacheHistorySize
+	}
+	c.frequency[key]++
+	return c.frequency[key] >= c.minFrequency
+}
+
+func (c *queryCache) get(key string, segmentID uint64) *roaring.Bitmap {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if elem, ok := c.entries[queryCacheKey{key: key, segmentID: segmentID}]; ok {
+		c.lru.MoveToFront(elem)
+		atomic.AddUint64(&c.stats.TotQueryCacheHits, 1)
+		return elem.Value.(*queryCacheEntry).docs
+	}
+	atomic.AddUint64(&c.stats.TotQueryCacheMisses, 1)
+	return nil
+}
+
+func (c *queryCache) put(key string, segmentID uint64, docs *roaring.Bitmap) {
+	entry := &queryCacheEntry{
+		queryCacheKey: queryCacheKey{key: key, segmentID: segmentID},
+		docs:          docs,
+	}
+	entry.size = docs.GetSizeInBytes() + uint64(len(key)+reflectStaticSizeQueryCacheEntry)
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.liveSegments != nil {
+		if _, live := c.liveSegments[segmentID]; !live {
+			// segment has already been merged away
+			return
+		}
+	}
+	if _, exists := c.entries[entry.queryCacheKey]; exists {
+		return
+	}
+	c.entries[entry.queryCacheKey] = c.lru.PushFront(entry)
+	c.curBytes += entry.size
+	for c.curBytes > c.maxBytes {
+		c.removeLocked(c.lru.Back())
+		atomic.AddUint64(&c.stats.TotQueryCacheEvictions, 1)
+	}
+	c.updateStatsLocked()
+}
+
+// retainSegments drops all entries for segments which are not
+// part of the provided snapshot, as they can never be used again
+func (c *queryCache) retainSegments(snapshot *Snapshot) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.liveSegments = make(map[uint64]struct{})
+	if snapshot != nil {
+		for _, seg := range snapshot.segment {
+			c.liveSegments[seg.id] = struct{}{}
+		}
+	}
+	for key, elem := range c.entries {
+		if _, live := c.liveSegments[key.segmentID]; !live {
+			c.removeLocked(elem)
+			atomic.AddUint64(&c.stats.TotQueryCacheInvalidations, 1)
+		}
+	}
+	c.updateStatsLocked()
+}
+
+func (c *queryCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*queryCacheEntry)
+	delete(c.entries, entry.queryCacheKey)
+	c.curBytes -= entry.size
+}
+
+func (c *queryCache) updateStatsLocked() {
+	atomic.StoreUint64(&c.stats.CurQueryCacheBytes, c.curBytes)
+	atomic.StoreUint64(&c.stats.CurQueryCacheEntries, uint64(len(c.entries)))
+}
+
+// QueryCacheEnabled returns true if the index was configured
+// with a query cache
+func (i *Snapshot) QueryCacheEnabled() bool {
+	return i.parent.queryCache != nil
+}
+
+// CachedFilter returns a postings iterator over the live documents
+// matching the filter identified by key.  The matches in each segment
+// are served from the query cache when possible, the remaining segments
+// are computed using the FilterIterator returned by build, and then
+// cached if the filter has been used frequently enough.
+// The key must uniquely identify the documents matched by the filter,
+// the documents must not depend on anything other than the index contents.
+func (i *Snapshot) CachedFilter(key string, build func() (FilterIterator, error)) (segment.PostingsIterator, error) {
+	cache := i.parent.queryCache
+	segmentDocs := make([]*roaring.Bitmap, len(i.segment))
+	var missing []int
+	for segIndex, seg := range i.segment {
+		if cache != nil {
+			segmentDocs[segIndex] = cache.get(key, seg.id)
+		}
+		if segmentDocs[segIndex] == nil {
+			missing = append(missing, segIndex)
+		}
+	}
+
+	admit := cache != nil && cache.recordUse(key)
+
+	if len(missing) > 0 {
+		err := i.computeFilter(build, missing, segmentDocs)
+		if err != nil {
+			return nil, err
+		}
+		if admit {
+			for _, segIndex := range missing {
+				cache.put(key, i.segment[segIndex].id, segmentDocs[segIndex])
+			}
+		}
+	}
+
+	return newFilterPostingsIterator(i, segmentDocs), nil
+}
+
+// computeFilter fills in the documents matching the filter
+// for the requested segment indexes, which must be in order
+func (i *Snapshot) computeFilter(build func() (FilterIterator, error), segIndexes []int,
+	segmentDocs []*roaring.Bitmap) (err error) {
+	itr, err := build()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := itr.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var cur uint64
+	var ok, started bool
+	for _, segIndex := range segIndexes {
+		start := i.offsets[segIndex]
+		end := start + i.segment[segIndex].segment.Count()
+		docs := roaring.NewBitmap()
+		if !started || (ok && cur < start) {
+			cur, ok, err = itr.Advance(start)
+			if err != nil {
+				return err
+			}
+			started = true
+		}
+		for ok && cur < end {
+			docs.Add(uint32(cur - start))
+			cur, ok, err = itr.Next()
+			if err != nil {
+				return err
+			}
+		}
+		docs.RunOptimize()
+		segmentDocs[segIndex] = docs
+	}
+	return nil
+}
+
+// filterPostingsIterator iterates the live documents in
+// per-segment bitmaps, translating them to global numbers
+type filterPostingsIterator struct {
+	snapshot    *Snapshot
+	segmentDocs []*roaring.Bitmap
+	segIndex    int
+	itr         roaring.IntPeekable
+	posting     unadornedPosting
+}
+
+func newFilterPostingsIterator(snapshot *Snapshot, segmentDocs []*roaring.Bitmap) *filterPostingsIterator {
+	rv := &filterPostingsIterator{
+		snapshot:    snapshot,
+		segmentDocs: segmentDocs,
+	}
+	for segIndex, seg := range snapshot.segment {
+		if seg.deleted != nil && !seg.deleted.IsEmpty() && segmentDocs[segIndex] != nil {
+			// cached results may include documents deleted since
+			rv.segmentDocs[segIndex] = roaring.AndNot(segmentDocs[segIndex], seg.deleted)
+		}
+	}
+	if len(segmentDocs) > 0 {
+		rv.itr = segmentDocs[0].Iterator()
+	}
+	return rv
+}
+
+func (i *filterPostingsIterator) Next() (segment.Posting, error) {
+	for i.segIndex < len(i.segmentDocs) {
+		if i.itr.HasNext() {
+			i.posting = unadornedPosting(uint64(i.itr.Next()) + i.snapshot.offsets[i.segIndex])
+			return &i.posting, nil
+		}
+		i.segIndex++
+		if i.segIndex < len(i.segmentDocs) {
+			i.itr = i.segmentDocs[i.segIndex].Iterator()
+		}
+	}
+	return nil, nil
+}
+
+func (i *filterPostingsIterator) Advance(number uint64) (segment.Posting, error) {
+	if len(i.segmentDocs) == 0 {
+		return nil, nil
+	}
+	segIndex, localDocNum := i.snapshot.segmentIndexAndLocalDocNumFromGlobal(number)
+	if segIndex >= len(i.segmentDocs) {
+		i.segIndex = len(i.segmentDocs)
+		return nil, nil
+	}
+	if segIndex != i.segIndex {
+		i.segIndex = segIndex
+		i.itr = i.segmentDocs[segIndex].Iterator()
+	}
+	i.itr.AdvanceIfNeeded(uint32(localDocNum))
+	return i.Next()
+}
+
+func (i *filterPostingsIterator) Size() int {
+	sizeInBytes := reflectStaticSizeFilterPostingsIterator
+	for _, docs := range i.segmentDocs {
+		sizeInBytes += sizeOfPtr + int(docs.GetSizeInBytes())
+	}
+	return sizeInBytes
+}
+
+func (i *filterPostingsIterator) Empty() bool {
+	return i.Count() == 0
+}
+
+func (i *filterPostingsIterator) Count() uint64 {
+	var rv uint64
+	for _, docs := range i.segmentDocs {
+		rv += docs.GetCardinality()
+	}
+	return rv
+}
+
+func (i *filterPostingsIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,205 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+type sliceFilterIterator struct {
+	numbers []uint64
+	pos     int
+}
+
+func (s *sliceFilterIterator) Next() (uint64, bool, error) {
+	if s.pos >= len(s.numbers) {
+		return 0, false, nil
+	}
+	s.pos++
+	return s.numbers[s.pos-1], true, nil
+}
+
+func (s *sliceFilterIterator) Advance(number uint64) (uint64, bool, error) {
+	for s.pos < len(s.numbers) && s.numbers[s.pos] < number {
+		s.pos++
+	}
+	return s.Next()
+}
+
+func (s *sliceFilterIterator) Close() error {
+	return nil
+}
+
+func TestQueryCacheAdmissionAndEviction(t *testing.T) {
+	var stats Stats
+	bm := roaring.BitmapOf(1, 2, 3)
+	entrySize := bm.GetSizeInBytes() + uint64(len("a")+reflectStaticSizeQueryCacheEntry)
+	qc := newQueryCache(2*entrySize, 2, &stats)
+
+	if qc.recordUse("a") {
+		t.Errorf("expected first use not to be admitted")
+	}
+	if !qc.recordUse("a") {
+		t.Errorf("expected second use to be admitted")
+	}
+
+	qc.put("a", 1, bm)
+	qc.put("b", 1, bm)
+	if qc.get("a", 1) == nil {
+		t.Fatalf("expected cached entry for a")
+	}
+	// a is now most recently used, so adding c evicts b
+	qc.put("c", 1, bm)
+	if qc.get("b", 1) != nil {
+		t.Errorf("expected b to have been evicted")
+	}
+	if qc.get("a", 1) == nil || qc.get("c", 1) == nil {
+		t.Errorf("expected a and c to remain cached")
+	}
+	if stats.TotQueryCacheEvictions != 1 {
+		t.Errorf("expected 1 eviction, got %d", stats.TotQueryCacheEvictions)
+	}
+	if stats.CurQueryCacheEntries != 2 || stats.CurQueryCacheBytes != 2*entrySize {
+		t.Errorf("expected 2 entries using %d bytes, got %d using %d",
+			2*entrySize, stats.CurQueryCacheEntries, stats.CurQueryCacheBytes)
+	}
+	if stats.TotQueryCacheHits != 3 || stats.TotQueryCacheMisses != 1 {
+		t.Errorf("expected 3 hits and 1 miss, got %d and %d",
+			stats.TotQueryCacheHits, stats.TotQueryCacheMisses)
+	}
+}
+
+func TestQueryCacheHistoryExpires(t *testing.T) {
+	var stats Stats
+	qc := newQueryCache(1024, 2, &stats)
+	qc.recordUse("a")
+	for i := 0; i < QueryCacheHistorySize; i++ {
+		qc.recordUse("other")
+	}
+	if qc.recordUse("a") {
+		t.Errorf("expected earlier use of a to have expired from history")
+	}
+}
+
+func TestSnapshotCachedFilter(t *testing.T) {
+	cfg, cleanup := CreateConfig("TestSnapshotCachedFilter")
+	defer func() {
+		err := cleanup()
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+
+	idx, err := OpenWriter(cfg.WithQueryCache(1024 * 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = idx.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, id := range []string{"1", "2", "3"} {
+		b := NewBatch()
+		b.Update(testIdentifier(id), &FakeDocument{
+			NewFakeField("_id", id, true, false, false),
+		})
+		err = idx.Batch(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader, err := idx.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	if !reader.QueryCacheEnabled() {
+		t.Fatalf("expected query cache to be enabled")
+	}
+
+	var builds int
+	build := func() (FilterIterator, error) {
+		builds++
+		// match every document except the second
+		var numbers []uint64
+		for segIndex := range reader.segment {
+			if segIndex != 1 {
+				numbers = append(numbers, reader.offsets[segIndex])
+			}
+		}
+		return &sliceFilterIterator{numbers: numbers}, nil
+	}
+
+	var expected []uint64
+	for segIndex := range reader.segment {
+		if segIndex != 1 {
+			expected = append(expected, reader.offsets[segIndex])
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		itr, err := reader.CachedFilter("f", build)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []uint64
+		p, err := itr.Next()
+		for err == nil && p != nil {
+			actual = append(actual, p.Number())
+			p, err = itr.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+	// first use is not admitted, second is computed and cached, third is a hit
+	if builds != 2 {
+		t.Errorf("expected filter to be built twice, got %d", builds)
+	}
+
+	// deleted documents are excluded from cached results
+	b := NewBatch()
+	b.Delete(testIdentifier("1"))
+	err = idx.Batch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader2, err := idx.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader2.Close()
+	}()
+	itr, err := reader2.CachedFilter("f", build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if itr.Count() != uint64(len(expected)-1) {
+		t.Errorf("expected %d matches after delete, got %d", len(expected)-1, itr.Count())
+	}
+}
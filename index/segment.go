@@ -31,6 +31,7 @@ type segmentSnapshot struct {
 	creator        string
 	segmentType    string
 	segmentVersion uint32
+	sort           *segmentSort
 }
 
 func (s *segmentSnapshot) Segment() segment.Segment {
@@ -84,7 +85,7 @@ func (s *segmentSnapshot) Fields() []string {
 }
 
 func (s *segmentSnapshot) Size() (rv int) {
-	rv = s.segment.Size()
+	rv = s.segment.Size() + s.segment.vectors.size()
 	if s.deleted != nil {
 		rv += int(s.deleted.GetSizeInBytes())
 	}
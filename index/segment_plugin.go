@@ -63,16 +63,21 @@ func loadSegmentPlugin(supportedSegmentPlugins map[string]map[uint32]*SegmentPlu
 
 func (s *Writer) newSegment(results []segment.Document) (*segmentWrapper, uint64, error) {
 	seg, count, err := s.segPlugin.New(results, s.config.NormCalc)
-	return &segmentWrapper{
+	if err != nil {
+		return nil, count, err
+	}
+	rv := &segmentWrapper{
 		Segment:    seg,
 		refCounter: noOpRefCounter{},
-	}, count, err
+	}
+	return rv, count, s.indexVectors(rv)
 }
 
 type segmentWrapper struct {
 	segment.Segment
 	refCounter
 	persisted bool
+	vectors   segmentVectors
 }
 
 func (s segmentWrapper) Persisted() bool {
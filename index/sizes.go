@@ -36,6 +36,10 @@ func init() {
 	reflectStaticSizeUnadornedPostingsIterator1Hit = int(reflect.TypeOf(pi1h).Size())
 	var up unadornedPosting
 	reflectStaticSizeUnadornedPosting = int(reflect.TypeOf(up).Size())
+	var qce queryCacheEntry
+	reflectStaticSizeQueryCacheEntry = int(reflect.TypeOf(qce).Size())
+	var fpi filterPostingsIterator
+	reflectStaticSizeFilterPostingsIterator = int(reflect.TypeOf(fpi).Size())
 }
 
 var sizeOfInt int
@@ -47,3 +51,5 @@ var reflectStaticSizeIndexSnapshot int
 var reflectStaticSizeUnadornedPostingsIteratorBitmap int
 var reflectStaticSizeUnadornedPostingsIterator1Hit int
 var reflectStaticSizeUnadornedPosting int
+var reflectStaticSizeQueryCacheEntry int
+var reflectStaticSizeFilterPostingsIterator int
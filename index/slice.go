@@ -0,0 +1,157 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"sync"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// SnapshotSlice is a view of some of the segments of a Snapshot.
+// Documents keep the numbers they have in the whole snapshot, and
+// scoring statistics are those of the whole snapshot, so searching
+// each slice finds and scores the same matches as searching the
+// whole snapshot.  A slice shares the resources of its snapshot,
+// it must not be used once the snapshot is closed.
+type SnapshotSlice struct {
+	*Snapshot
+	whole  *Snapshot
+	shared *sharedResults
+}
+
+// sharedResults holds the results computed
+// once for all of the slices of a search
+type sharedResults struct {
+	m       sync.Mutex
+	results map[interface{}]*sharedResult
+}
+
+type sharedResult struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// Slices partitions the segments of the snapshot into at most n
+// slices of contiguous segments, holding similar numbers of documents,
+// the slices of a search are expected to be partitioned together
+func (i *Snapshot) Slices(n int) []*SnapshotSlice {
+	if n > len(i.segment) {
+		n = len(i.segment)
+	}
+	if n < 1 {
+		n = 1
+	}
+	total, _ := i.Count()
+	shared := &sharedResults{
+		results: make(map[interface{}]*sharedResult),
+	}
+	rv := make([]*SnapshotSlice, 0, n)
+	var start int
+	var running uint64
+	for segIndex, seg := range i.segment {
+		running += seg.Count()
+		remainingSlices := n - len(rv) - 1
+		remainingSegments := len(i.segment) - segIndex - 1
+		// close the slice once it holds its share of the documents,
+		// or when every remaining slice needs a segment of its own
+		if remainingSlices > 0 &&
+			(running*uint64(n) >= total*uint64(len(rv)+1) || remainingSegments == remainingSlices) {
+			rv = append(rv, i.slice(start, segIndex+1, shared))
+			start = segIndex + 1
+		}
+	}
+	return append(rv, i.slice(start, len(i.segment), shared))
+}
+
+func (i *Snapshot) slice(start, end int, shared *sharedResults) *SnapshotSlice {
+	return &SnapshotSlice{
+		Snapshot: &Snapshot{
+			parent:  i.parent,
+			segment: i.segment[start:end],
+			offsets: i.offsets[start:end],
+			epoch:   i.epoch,
+			size:    i.size,
+			creator: i.creator,
+		},
+		whole:  i,
+		shared: shared,
+	}
+}
+
+// Whole returns the snapshot the slice is a view of
+func (s *SnapshotSlice) Whole() *Snapshot {
+	return s.whole
+}
+
+// Range returns the numbers of the documents of the slice
+func (s *SnapshotSlice) Range() DocumentRange {
+	if len(s.segment) == 0 {
+		return DocumentRange{}
+	}
+	last := len(s.segment) - 1
+	return DocumentRange{
+		Start: s.offsets[0],
+		End:   s.offsets[last] + s.segment[last].Count(),
+	}
+}
+
+// Once returns the result of compute for the key, which is only
+// computed once for all of the slices partitioned together, so
+// that results of the whole snapshot needed by each slice of a
+// search are computed once for the search, the results are shared
+// by the slices, so must not be modified
+func (s *SnapshotSlice) Once(key interface{}, compute func() (interface{}, error)) (interface{}, error) {
+	s.shared.m.Lock()
+	result, ok := s.shared.results[key]
+	if !ok {
+		result = &sharedResult{}
+		s.shared.results[key] = result
+	}
+	s.shared.m.Unlock()
+	result.once.Do(func() {
+		result.value, result.err = compute()
+	})
+	return result.value, result.err
+}
+
+// CollectionStats returns the statistics of the whole snapshot
+func (s *SnapshotSlice) CollectionStats(field string) (segment.CollectionStats, error) {
+	return s.whole.CollectionStats(field)
+}
+
+// DocumentFrequency returns the number of documents
+// of the whole snapshot using the term in the field
+func (s *SnapshotSlice) DocumentFrequency(term []byte, field string) (uint64, error) {
+	itr, err := s.whole.PostingsIterator(term, field, false, false, false)
+	if err != nil {
+		return 0, err
+	}
+	count := itr.Count()
+	return count, itr.Close()
+}
+
+// TotalTermFrequency returns the number of occurrences
+// of the term in the field, in the whole snapshot
+func (s *SnapshotSlice) TotalTermFrequency(term []byte, field string) (uint64, error) {
+	return s.whole.TotalTermFrequency(term, field)
+}
+
+// Close does nothing, the resources of a slice
+// are released by closing its snapshot
+func (s *SnapshotSlice) Close() error {
+	return nil
+}
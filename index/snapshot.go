@@ -241,6 +241,27 @@ func (i *Snapshot) CollectionStats(field string) (segment.CollectionStats, error
 	return rv, nil
 }
 
+// TotalTermFrequency returns the number of occurrences of the term
+// in the field, in all of the documents, it reads every posting of
+// the term, costing as much as searching for it
+func (i *Snapshot) TotalTermFrequency(term []byte, field string) (uint64, error) {
+	itr, err := i.PostingsIterator(term, field, true, false, false)
+	if err != nil {
+		return 0, err
+	}
+	var rv uint64
+	posting, err := itr.Next()
+	for err == nil && posting != nil {
+		rv += uint64(posting.Frequency())
+		posting, err = itr.Next()
+	}
+	if err != nil {
+		_ = itr.Close()
+		return 0, err
+	}
+	return rv, itr.Close()
+}
+
 func (i *Snapshot) Count() (uint64, error) {
 	var rv uint64
 	for _, seg := range i.segment {
@@ -318,6 +339,10 @@ func (i *Snapshot) segmentIndexAndLocalDocNumFromGlobal(docNum uint64) (segmentI
 		func(x int) bool {
 			return i.offsets[x] > docNum
 		}) - 1
+	if segmentIndex < 0 {
+		// the number precedes the segments of a snapshot slice
+		return 0, 0
+	}
 
 	localDocNum = docNum - i.offsets[segmentIndex]
 	return segmentIndex, localDocNum
@@ -444,9 +469,23 @@ func (i *Snapshot) unadornedPostingsIterator(
 }
 
 const blugeSnapshotFormatVersion1 = 1
-const blugeSnapshotFormatVersion = blugeSnapshotFormatVersion1
+
+// blugeSnapshotFormatVersion2 adds the index sort of each segment
+const blugeSnapshotFormatVersion2 = 2
 const crcWidth = 4
 
+// formatVersion returns the version of the format the snapshot is
+// written in, snapshots without sorted segments are written in the
+// first version, so that they can be read by older releases
+func (i *Snapshot) formatVersion() uint64 {
+	for _, segmentSnapshot := range i.segment {
+		if segmentSnapshot.sort != nil {
+			return blugeSnapshotFormatVersion2
+		}
+	}
+	return blugeSnapshotFormatVersion1
+}
+
 func (i *Snapshot) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
 	bw := bufio.NewWriter(w)
 	chw := newCountHashWriter(bw)
@@ -454,7 +493,8 @@ func (i *Snapshot) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
 	var bytesWritten int64
 	var intBuf = make([]byte, binary.MaxVarintLen64)
 	// write the bluge snapshot format version number
-	n := binary.PutUvarint(intBuf, uint64(blugeSnapshotFormatVersion))
+	version := i.formatVersion()
+	n := binary.PutUvarint(intBuf, version)
 	sz, err := chw.Write(intBuf[:n])
 	if err != nil {
 		return bytesWritten, fmt.Errorf("error writing snapshot %d: %w", i.epoch, err)
@@ -470,7 +510,8 @@ func (i *Snapshot) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
 	bytesWritten += int64(sz)
 
 	for _, segmentSnapshot := range i.segment {
-		sz, err = recordSegment(chw, segmentSnapshot, segmentSnapshot.id, segmentSnapshot.segment.Type(), segmentSnapshot.segment.Version())
+		sz, err = recordSegment(chw, segmentSnapshot, segmentSnapshot.id, segmentSnapshot.segment.Type(),
+			segmentSnapshot.segment.Version(), version)
 		if err != nil {
 			return bytesWritten, fmt.Errorf("error writing snapshot %d: %w", i.epoch, err)
 		}
@@ -494,7 +535,8 @@ func (i *Snapshot) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
 	return bytesWritten, nil
 }
 
-func recordSegment(w io.Writer, snapshot *segmentSnapshot, id uint64, typ string, ver uint32) (int, error) {
+func recordSegment(w io.Writer, snapshot *segmentSnapshot, id uint64, typ string, ver uint32,
+	formatVersion uint64) (int, error) {
 	var bytesWritten int
 	var intBuf = make([]byte, binary.MaxVarintLen64)
 	// record type
@@ -550,9 +592,27 @@ func recordSegment(w io.Writer, snapshot *segmentSnapshot, id uint64, typ string
 		bytesWritten += sz
 	}
 
+	if formatVersion >= blugeSnapshotFormatVersion2 {
+		sz, err = recordSegmentSort(w, intBuf, snapshot.sort)
+		bytesWritten += sz
+		if err != nil {
+			return bytesWritten, err
+		}
+	}
+
 	return bytesWritten, nil
 }
 
+// recordSegmentSort records the index sort key,
+// empty when the segment is not sorted
+func recordSegmentSort(w io.Writer, intBuf []byte, sort *segmentSort) (int, error) {
+	var key string
+	if sort != nil {
+		key = sort.key
+	}
+	return writeVarLenString(w, intBuf, key)
+}
+
 func writeVarLenString(w io.Writer, intBuf []byte, str string) (int, error) {
 	var bytesWritten int
 	n := binary.PutUvarint(intBuf, uint64(len(str)))
@@ -585,15 +645,16 @@ func (i *Snapshot) ReadFrom(r io.Reader) (int64, error) {
 	}
 	bytesRead += int64(sz)
 
-	if snapshotFormatVersion == blugeSnapshotFormatVersion1 {
-		n, err := i.readFromVersion1(br)
+	if snapshotFormatVersion == blugeSnapshotFormatVersion1 ||
+		snapshotFormatVersion == blugeSnapshotFormatVersion2 {
+		n, err := i.readFromVersion(br, snapshotFormatVersion)
 		return n + bytesRead, err
 	}
 
 	return bytesRead, fmt.Errorf("unsupportred snapshot format version: %d", snapshotFormatVersion)
 }
 
-func (i *Snapshot) readFromVersion1(br *bufio.Reader) (int64, error) {
+func (i *Snapshot) readFromVersion(br *bufio.Reader, version uint64) (int64, error) {
 	var bytesRead int64
 
 	// read number of segments
@@ -609,7 +670,7 @@ func (i *Snapshot) readFromVersion1(br *bufio.Reader) (int64, error) {
 	bytesRead += int64(sz)
 
 	for j := 0; j < int(numSegments); j++ {
-		segmentBytesRead, ss, err := i.readSegmentSnapshot(br)
+		segmentBytesRead, ss, err := i.readSegmentSnapshot(br, version)
 		if err != nil {
 			return bytesRead, err
 		}
@@ -621,7 +682,7 @@ func (i *Snapshot) readFromVersion1(br *bufio.Reader) (int64, error) {
 	return bytesRead, nil
 }
 
-func (i *Snapshot) readSegmentSnapshot(br *bufio.Reader) (bytesRead int64, ss *segmentSnapshot, err error) {
+func (i *Snapshot) readSegmentSnapshot(br *bufio.Reader, version uint64) (bytesRead int64, ss *segmentSnapshot, err error) {
 	var sz int
 	var segmentType string
 	// read type
@@ -689,9 +750,47 @@ func (i *Snapshot) readSegmentSnapshot(br *bufio.Reader) (bytesRead int64, ss *s
 			ss.deleted = deletedBitmap
 		}
 	}
+
+	if version >= blugeSnapshotFormatVersion2 {
+		var sortBytesRead int64
+		sortBytesRead, ss.sort, err = readSegmentSort(br)
+		bytesRead += sortBytesRead
+		if err != nil {
+			return bytesRead, nil, fmt.Errorf("error reading snapshot %d: %w", i.epoch, err)
+		}
+	}
 	return bytesRead, ss, nil
 }
 
+func readSegmentSort(br *bufio.Reader) (bytesRead int64, sort *segmentSort, err error) {
+	// the sort is recorded near the end of the snapshot,
+	// so peeking may reach the end of the data
+	readUvarint := func() (uint64, error) {
+		peek, err := br.Peek(binary.MaxVarintLen64)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		val, n := binary.Uvarint(peek)
+		sz, err := br.Discard(n)
+		bytesRead += int64(sz)
+		return val, err
+	}
+
+	keyLen, err := readUvarint()
+	if err != nil || keyLen == 0 {
+		return bytesRead, nil, err
+	}
+	keyBytes := make([]byte, keyLen)
+	sz, err := io.ReadFull(br, keyBytes)
+	bytesRead += int64(sz)
+	if err != nil {
+		return bytesRead, nil, err
+	}
+	return bytesRead, &segmentSort{
+		key: string(keyBytes),
+	}, nil
+}
+
 func readVarLenString(r *bufio.Reader) (n int, str string, err error) {
 	peek, err := r.Peek(binary.MaxVarintLen64)
 	if err != nil {
@@ -725,6 +824,12 @@ func (i *Snapshot) Backup(remote Directory, cancel chan struct{}) error {
 		if err != nil {
 			return fmt.Errorf("error backing up segment %d: %w", i.segment[j].id, err)
 		}
+		if vectors := i.segment[j].segment.vectors; vectors != nil {
+			err = remote.Persist(ItemKindVectors, i.segment[j].id, vectors, cancel)
+			if err != nil {
+				return fmt.Errorf("error backing up vectors of segment %d: %w", i.segment[j].id, err)
+			}
+		}
 	}
 	// now persist ourself (snapshot)
 	err := remote.Persist(ItemKindSnapshot, i.epoch, i, cancel)
@@ -0,0 +1,78 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// DocumentSort orders the documents within the segments
+// built by the writer
+type DocumentSort interface {
+	// Key uniquely identifies the order, it is recorded with each
+	// segment sorted by it.  An empty key disables sorting.
+	Key() string
+
+	// SortDocuments orders the analyzed documents in place
+	SortDocuments(docs []segment.Document)
+}
+
+// segmentSort records that the documents of a segment are
+// ordered by the index sort identified by key.  Segments built from
+// a batch are sorted, the documents of merged segments are not.
+type segmentSort struct {
+	key string
+}
+
+// DocumentRange is a range of global document numbers,
+// from Start inclusive to End exclusive
+type DocumentRange struct {
+	Start uint64
+	End   uint64
+}
+
+// sortDocuments returns the documents ordered by the configured
+// index sort, along with the resulting segment sort,
+// the provided slice is not modified
+func (config Config) sortDocuments(docs []segment.Document) ([]segment.Document, *segmentSort) {
+	if config.IndexSort == nil || config.IndexSort.Key() == "" {
+		return docs, nil
+	}
+	sorted := make([]segment.Document, len(docs))
+	copy(sorted, docs)
+	config.IndexSort.SortDocuments(sorted)
+	return sorted, &segmentSort{
+		key: config.IndexSort.Key(),
+	}
+}
+
+// SortedRanges returns the ranges of global document numbers
+// known to be ordered by the index sort identified by key
+func (i *Snapshot) SortedRanges(key string) []DocumentRange {
+	if key == "" {
+		return nil
+	}
+	var rv []DocumentRange
+	for segIndex, seg := range i.segment {
+		if seg.sort == nil || seg.sort.key != key {
+			continue
+		}
+		rv = append(rv, DocumentRange{
+			Start: i.offsets[segIndex],
+			End:   i.offsets[segIndex] + seg.segment.Count(),
+		})
+	}
+	return rv
+}
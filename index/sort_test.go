@@ -0,0 +1,178 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// idDescendingSort orders fake documents by descending _id
+type idDescendingSort struct{}
+
+func (idDescendingSort) Key() string {
+	return "-_id"
+}
+
+func (idDescendingSort) SortDocuments(docs []segment.Document) {
+	id := func(doc segment.Document) (rv string) {
+		doc.EachField(func(field segment.Field) {
+			if field.Name() == "_id" {
+				rv = string(field.Value())
+			}
+		})
+		return rv
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return id(docs[i]) > id(docs[j])
+	})
+}
+
+func TestIndexSort(t *testing.T) {
+	cfg, cleanup := CreateConfig("TestIndexSort")
+	defer func() {
+		err := cleanup()
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	cfg = cfg.WithIndexSort(idDescendingSort{})
+
+	idx, err := OpenWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single batch, merged segments are not sorted
+	b := NewBatch()
+	for _, id := range []string{"1", "3", "2", "4", "6", "5"} {
+		b.Update(testIdentifier(id), &FakeDocument{
+			NewFakeField("_id", id, true, false, false),
+		})
+	}
+	err = idx.Batch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = idx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen, to read the sort back from the persisted snapshot
+	reader, err := OpenReader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	var ids []string
+	count, err := reader.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for number := uint64(0); number < count; number++ {
+		err = reader.VisitStoredFields(number, func(field string, value []byte) bool {
+			if field == "_id" {
+				ids = append(ids, string(value))
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranges := reader.SortedRanges("-_id")
+	var rangeCount uint64
+	for _, r := range ranges {
+		// each range is sorted
+		for number := r.Start + 1; number < r.End; number++ {
+			if ids[number-1] < ids[number] {
+				t.Errorf("expected range %v to be sorted, got %v", r, ids[r.Start:r.End])
+			}
+		}
+		rangeCount += r.End - r.Start
+	}
+	if rangeCount != count {
+		t.Errorf("expected ranges to cover all %d documents, got %d in %v", count, rangeCount, ranges)
+	}
+	if len(reader.SortedRanges("_id")) != 0 {
+		t.Errorf("expected no ranges sorted by a different order")
+	}
+}
+
+func TestSnapshotFormatVersion(t *testing.T) {
+	cfg, cleanup := CreateConfig("TestSnapshotFormatVersion")
+	defer func() {
+		err := cleanup()
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	segPlugin, err := loadSegmentPlugin(cfg.supportedSegmentPlugins, cfg.SegmentType, cfg.SegmentVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, _, err := segPlugin.New([]segment.Document{&FakeDocument{
+		NewFakeField("_id", "1", true, false, false),
+	}}, cfg.NormCalc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sort    *segmentSort
+		version uint64
+	}{
+		// older releases can read snapshots without sorted segments
+		{sort: nil, version: blugeSnapshotFormatVersion1},
+		{sort: &segmentSort{key: "-_id"}, version: blugeSnapshotFormatVersion2},
+	}
+	for _, test := range tests {
+		snapshot := &Snapshot{
+			segment: []*segmentSnapshot{
+				{
+					id:      1,
+					segment: &segmentWrapper{Segment: seg},
+					sort:    test.sort,
+				},
+			},
+		}
+		var buf bytes.Buffer
+		_, err = snapshot.WriteTo(&buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := uint64(buf.Bytes()[0]); version != test.version {
+			t.Errorf("expected format version %d, got %d", test.version, version)
+		}
+
+		read := &Snapshot{}
+		_, err = read.ReadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(read.segment) != 1 || !reflect.DeepEqual(test.sort, read.segment[0].sort) {
+			t.Errorf("expected segment sort %v, got %v", test.sort, read.segment)
+		}
+	}
+}
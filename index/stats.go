@@ -15,12 +15,25 @@
 package index
 
 import (
+	"reflect"
 	"sync/atomic"
 )
 
 func (s *Writer) Stats() Stats {
-	// copy current stats
-	rv := s.stats
+	// copy current stats, the counters are updated concurrently
+	// so each one must be loaded atomically
+	var rv Stats
+	sv := reflect.ValueOf(&s.stats).Elem()
+	rvv := reflect.ValueOf(&rv).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		if sv.Type().Field(i).PkgPath != "" {
+			// internal stats are not exposed
+			continue
+		}
+		if counter, ok := sv.Field(i).Addr().Interface().(*uint64); ok {
+			rvv.Field(i).SetUint(atomic.LoadUint64(counter))
+		}
+	}
 
 	// add some computed values
 	numFilesOnDisk, numBytesUsedDisk := s.directory.Stats()
@@ -28,7 +41,7 @@ func (s *Writer) Stats() Stats {
 	rv.CurOnDiskBytes = numBytesUsedDisk
 	rv.CurOnDiskFiles = numFilesOnDisk
 
-	return s.stats
+	return rv
 }
 
 // Stats tracks statistics about the index, fields that are
@@ -141,6 +154,13 @@ type Stats struct {
 	CurOnDiskBytesUsedByRoot uint64 // FIXME not currently supported
 	CurOnDiskFiles           uint64
 
+	TotQueryCacheHits          uint64
+	TotQueryCacheMisses        uint64
+	TotQueryCacheEvictions     uint64
+	TotQueryCacheInvalidations uint64
+	CurQueryCacheBytes         uint64
+	CurQueryCacheEntries       uint64
+
 	// the following stats are only used internally
 	persistEpoch          uint64
 	persistSnapshotSize   uint64
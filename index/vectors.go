@@ -0,0 +1,466 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/index/hnsw"
+)
+
+// VectorField describes a field of dense vectors with a fixed number
+// of dimensions, which are indexed in a graph per segment, to find
+// the nearest neighbors of a vector.  The vectors are read from the
+// stored values of the field, encoded by hnsw.EncodeVector.
+type VectorField struct {
+	Dims       int
+	Similarity hnsw.Similarity
+
+	// M is the number of neighbors of each vector in the graph, and
+	// EFConstruction the number of candidates they are chosen among,
+	// zero values use hnsw.DefaultM and hnsw.DefaultEFConstruction
+	M              int
+	EFConstruction int
+}
+
+func (config Config) WithVectorField(name string, field VectorField) Config {
+	vectorFields := make(map[string]VectorField, len(config.VectorFields)+1)
+	for k, v := range config.VectorFields {
+		vectorFields[k] = v
+	}
+	vectorFields[name] = field
+	config.VectorFields = vectorFields
+	return config
+}
+
+// segmentVectors holds the graph of each vector field of a segment,
+// the graphs identify vectors by local document number, fields
+// without any vectors in the segment have a nil graph
+type segmentVectors map[string]*hnsw.Graph
+
+func (v segmentVectors) size() int {
+	var rv int
+	for _, g := range v {
+		if g != nil {
+			rv += g.Size()
+		}
+	}
+	return rv
+}
+
+const vectorsFormatVersion = 1
+
+// WriteTo records the graphs of the segment, along with the fields
+// without vectors, so that fields configured since are known
+func (v segmentVectors) WriteTo(w io.Writer, _ chan struct{}) (int64, error) {
+	bw := bufio.NewWriter(w)
+	chw := newCountHashWriter(bw)
+	intBuf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(val uint64) error {
+		n := binary.PutUvarint(intBuf, val)
+		_, err := chw.Write(intBuf[:n])
+		return err
+	}
+
+	err := writeUvarint(vectorsFormatVersion)
+	if err != nil {
+		return int64(chw.Count()), err
+	}
+	err = writeUvarint(uint64(len(v)))
+	if err != nil {
+		return int64(chw.Count()), err
+	}
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		_, err = writeVarLenString(chw, intBuf, field)
+		if err != nil {
+			return int64(chw.Count()), err
+		}
+		g := v[field]
+		if g == nil {
+			err = writeUvarint(0)
+		} else {
+			err = writeUvarint(1)
+			if err == nil {
+				_, err = g.WriteTo(chw)
+			}
+		}
+		if err != nil {
+			return int64(chw.Count()), err
+		}
+	}
+
+	binary.BigEndian.PutUint32(intBuf, chw.Sum32())
+	_, err = chw.Write(intBuf[:crcWidth])
+	if err != nil {
+		return int64(chw.Count()), err
+	}
+	return int64(chw.Count()), bw.Flush()
+}
+
+// readVectors reads the graphs recorded by segmentVectors.WriteTo,
+// graphs recorded for another configuration of the vector fields
+// cannot be used, and are reported as an error
+func (config Config) readVectors(data *segment.Data) (segmentVectors, error) {
+	if data.Len() < crcWidth {
+		return nil, fmt.Errorf("vectors of %d bytes are too short", data.Len())
+	}
+	crcReader := newCountHashReader(io.LimitReader(data.Reader(), int64(data.Len()-crcWidth)))
+	br := bufio.NewReader(crcReader)
+
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if version != vectorsFormatVersion {
+		return nil, fmt.Errorf("unsupported vectors format version %d", version)
+	}
+	numFields, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	rv := make(segmentVectors, len(config.VectorFields))
+	for i := uint64(0); i < numFields; i++ {
+		var field string
+		field, err = readVectorsField(br)
+		if err != nil {
+			return nil, err
+		}
+		var hasGraph uint64
+		hasGraph, err = binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		var g *hnsw.Graph
+		if hasGraph != 0 {
+			g, err = hnsw.ReadGraph(br)
+			if err != nil {
+				return nil, err
+			}
+		}
+		vectorField, ok := config.VectorFields[field]
+		if !ok {
+			continue
+		}
+		if g != nil && (g.Similarity() != vectorField.Similarity || g.Dims() != vectorField.Dims) {
+			return nil, fmt.Errorf("vectors of field %s were indexed for another similarity or dimensions", field)
+		}
+		rv[field] = g
+	}
+	for field := range config.VectorFields {
+		if _, ok := rv[field]; !ok {
+			return nil, fmt.Errorf("vectors of field %s were not indexed", field)
+		}
+	}
+
+	// reading to the end includes all of the data in the crc
+	_, err = br.Peek(1)
+	if err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after vectors")
+	}
+	crcBytes, err := data.Read(data.Len()-crcWidth, data.Len())
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(crcBytes) != crcReader.Sum32() {
+		return nil, fmt.Errorf("CRC mismatch reading vectors")
+	}
+	return rv, nil
+}
+
+func readVectorsField(br *bufio.Reader) (string, error) {
+	fieldLen, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	fieldBytes := make([]byte, fieldLen)
+	_, err = io.ReadFull(br, fieldBytes)
+	if err != nil {
+		return "", err
+	}
+	return string(fieldBytes), nil
+}
+
+// validateVectors checks the number of dimensions
+// of the vectors of the documents of a batch
+func (config Config) validateVectors(docs []segment.Document) error {
+	if len(config.VectorFields) == 0 {
+		return nil
+	}
+	var err error
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		doc.EachField(func(field segment.Field) {
+			if vectorField, ok := config.VectorFields[field.Name()]; ok && err == nil {
+				if len(field.Value()) != 4*vectorField.Dims {
+					err = fmt.Errorf("vector field %s has %d dimensions, expected %d",
+						field.Name(), len(field.Value())/4, vectorField.Dims)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildVectors indexes the vectors of each vector field of the
+// segment, as segments are immutable, so are their graphs, which
+// are persisted along with the segment, and merged when segments
+// are merged, vectors of the wrong number of dimensions are ignored
+func (config Config) buildVectors(seg segment.Segment) (segmentVectors, error) {
+	if len(config.VectorFields) == 0 {
+		return nil, nil
+	}
+	rv := make(segmentVectors, len(config.VectorFields))
+	for field := range config.VectorFields {
+		rv[field] = nil
+	}
+	var decodeErr error
+	for docNum := uint64(0); docNum < seg.Count(); docNum++ {
+		err := seg.VisitStoredFields(docNum, func(field string, value []byte) bool {
+			vectorField, ok := config.VectorFields[field]
+			if !ok || len(value) != 4*vectorField.Dims {
+				return true
+			}
+			vector, err := hnsw.DecodeVector(value)
+			if err != nil {
+				decodeErr = err
+				return false
+			}
+			g := rv[field]
+			if g == nil {
+				g = hnsw.NewGraph(vectorField.Similarity, vectorField.M, vectorField.EFConstruction)
+				rv[field] = g
+			}
+			g.Add(docNum, vector)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+	}
+	return rv, nil
+}
+
+// mergeVectors merges the graphs of the vectors of merged segments,
+// newDocNums holding the numbers of their documents in the merged
+// segment.  The vectors are added to a copy of the largest graph
+// without dropped documents, which is faster than adding them all
+// to an empty graph.
+func (config Config) mergeVectors(vectors []segmentVectors, newDocNums [][]uint64) segmentVectors {
+	if len(config.VectorFields) == 0 {
+		return nil
+	}
+	rv := make(segmentVectors, len(config.VectorFields))
+	for field, vectorField := range config.VectorFields {
+		base := -1
+		for i := range vectors {
+			g := vectors[i][field]
+			if g != nil && allKept(g, newDocNums[i]) && (base < 0 || g.Len() > vectors[base][field].Len()) {
+				base = i
+			}
+		}
+		var merged *hnsw.Graph
+		if base >= 0 {
+			merged = vectors[base][field].Remap(func(id uint64) uint64 {
+				return newDocNums[base][id]
+			})
+		}
+		for i := range vectors {
+			g := vectors[i][field]
+			if i == base || g == nil {
+				continue
+			}
+			g.Each(func(id uint64, vector []float32) {
+				newDocNum, ok := mergedDocNum(newDocNums[i], id)
+				if !ok {
+					return
+				}
+				if merged == nil {
+					merged = hnsw.NewGraph(vectorField.Similarity, vectorField.M, vectorField.EFConstruction)
+				}
+				merged.Add(newDocNum, vector)
+			})
+		}
+		rv[field] = merged
+	}
+	return rv
+}
+
+// docDropped is the new document number the segment merger
+// records for a document dropped by a merge
+const docDropped = math.MaxInt64
+
+// mergedDocNum returns the number of the document in the merged
+// segment, ok is false when the document was dropped by the merge
+func mergedDocNum(newDocNums []uint64, docNum uint64) (uint64, bool) {
+	if docNum >= uint64(len(newDocNums)) || newDocNums[docNum] == docDropped {
+		return 0, false
+	}
+	return newDocNums[docNum], true
+}
+
+// allKept reports whether none of the vectors
+// of the graph were dropped by the merge
+func allKept(g *hnsw.Graph, newDocNums []uint64) bool {
+	rv := true
+	g.Each(func(id uint64, _ []float32) {
+		if _, ok := mergedDocNum(newDocNums, id); !ok {
+			rv = false
+		}
+	})
+	return rv
+}
+
+// indexVectors builds the graphs of the vectors of a new segment
+func (s *Writer) indexVectors(seg *segmentWrapper) (err error) {
+	if seg == nil {
+		return nil
+	}
+	seg.vectors, err = s.config.buildVectors(seg.Segment)
+	return err
+}
+
+// persistVectors records the graphs of the vectors of a segment
+func (s *Writer) persistVectors(id uint64, vectors segmentVectors) error {
+	if vectors == nil {
+		return nil
+	}
+	return s.directory.Persist(ItemKindVectors, id, vectors, s.closeCh)
+}
+
+// loadVectors loads the graphs of the vectors of a persisted segment,
+// building them again when they were not recorded, such as by older
+// versions, or were recorded for another configuration
+func (s *Writer) loadVectors(id uint64, seg *segmentWrapper) error {
+	if len(s.config.VectorFields) == 0 {
+		return nil
+	}
+	data, closer, err := s.directory.Load(ItemKindVectors, id)
+	if err == nil && data != nil {
+		seg.vectors, err = s.config.readVectors(data)
+	}
+	if closer != nil {
+		_ = closer.Close()
+	}
+	if err == nil && seg.vectors != nil {
+		return nil
+	}
+	return s.indexVectors(seg)
+}
+
+// NearestNeighbors returns the k documents of the snapshot whose
+// vectors in the field are the most similar to the vector, best
+// first, among the numCandidates nearest found in each segment.
+// When filter is not nil, only the documents it contains are
+// considered, searching the vectors of segments in which it
+// contains at most numCandidates documents exhaustively.
+func (i *Snapshot) NearestNeighbors(field string, vector []float32, k, numCandidates int,
+	filter *roaring.Bitmap) ([]hnsw.Neighbor, error) {
+	if numCandidates < k {
+		numCandidates = k
+	}
+	var rv []hnsw.Neighbor
+	for segIndex, ss := range i.segment {
+		g := ss.segment.vectors[field]
+		if g == nil {
+			continue
+		}
+		if len(vector) != g.Dims() {
+			return nil, fmt.Errorf("vector has %d dimensions, field %s has %d",
+				len(vector), field, g.Dims())
+		}
+
+		offset := i.offsets[segIndex]
+		deleted := ss.deleted
+		accept := func(id uint64) bool {
+			if deleted != nil && deleted.Contains(uint32(id)) {
+				return false
+			}
+			return filter == nil || filter.Contains(uint32(offset+id))
+		}
+		var neighbors []hnsw.Neighbor
+		if filter != nil && filteredCount(filter, offset, ss.segment.Count()) <= uint64(numCandidates) {
+			neighbors = g.ExactSearch(vector, numCandidates, accept)
+		} else {
+			neighbors = g.Search(vector, numCandidates, numCandidates, accept)
+		}
+		for _, n := range neighbors {
+			n.ID += offset
+			rv = append(rv, n)
+		}
+	}
+
+	sort.SliceStable(rv, func(a, b int) bool {
+		return rv[a].Score > rv[b].Score
+	})
+	if len(rv) > k {
+		rv = rv[:k]
+	}
+	return rv, nil
+}
+
+// filteredCount returns the number of documents
+// of the segment at offset contained by the filter
+func filteredCount(filter *roaring.Bitmap, offset, count uint64) uint64 {
+	if count == 0 {
+		return 0
+	}
+	rv := filter.Rank(uint32(offset + count - 1))
+	if offset > 0 {
+		rv -= filter.Rank(uint32(offset - 1))
+	}
+	return rv
+}
+
+// NearestNeighbors returns the nearest neighbors in the whole
+// snapshot which are among the documents of the slice, so
+// that the matches of all of the slices are those of the
+// whole snapshot, they are searched for each slice, use Once
+// to search them once for all of the slices of a search
+func (s *SnapshotSlice) NearestNeighbors(field string, vector []float32, k, numCandidates int,
+	filter *roaring.Bitmap) ([]hnsw.Neighbor, error) {
+	neighbors, err := s.whole.NearestNeighbors(field, vector, k, numCandidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	docs := s.Range()
+	rv := neighbors[:0]
+	for _, n := range neighbors {
+		if n.ID >= docs.Start && n.ID < docs.End {
+			rv = append(rv, n)
+		}
+	}
+	return rv, nil
+}
@@ -0,0 +1,234 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+
+	"github.com/blugelabs/bluge/index/hnsw"
+)
+
+func TestVectorIndex(t *testing.T) {
+	cfg, cleanup := CreateConfig("TestVectorIndex")
+	defer func() {
+		err := cleanup()
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	cfg = cfg.WithVectorField("vec", VectorField{
+		Dims:       2,
+		Similarity: hnsw.L2,
+	})
+
+	idx, err := OpenWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vectorDoc := func(id int, vector ...float32) *FakeDocument {
+		return &FakeDocument{
+			NewFakeField("_id", strconv.Itoa(id), true, false, false),
+			&FakeField{N: "vec", V: hnsw.EncodeVector(vector), S: true},
+		}
+	}
+	// documents 0 to 9 lie on a line, in two batches
+	for _, ids := range [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}} {
+		b := NewBatch()
+		for _, id := range ids {
+			b.Update(testIdentifier(strconv.Itoa(id)), vectorDoc(id, float32(id), 0))
+		}
+		err = idx.Batch(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := NewBatch()
+	b.Delete(testIdentifier("5"))
+	err = idx.Batch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = NewBatch()
+	b.Update(testIdentifier("10"), vectorDoc(10, 1, 2, 3))
+	if err = idx.Batch(b); err == nil {
+		t.Errorf("expected error indexing a vector of the wrong number of dimensions")
+	}
+
+	nearest := func(reader *Snapshot, filter *roaring.Bitmap) []string {
+		neighbors, err := reader.NearestNeighbors("vec", []float32{5.2, 0}, 3, 10, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rv []string
+		for _, n := range neighbors {
+			err = reader.VisitStoredFields(n.ID, func(field string, value []byte) bool {
+				if field == "_id" {
+					rv = append(rv, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rv
+	}
+
+	reader, err := idx.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deleted document 5 is nearest
+	expected := []string{"6", "4", "7"}
+	if actual := nearest(reader, nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected neighbors %v, got %v", expected, actual)
+	}
+	// the documents with odd identifiers, numbered as segments
+	// are merged, which may have happened in the background
+	odd := roaring.New()
+	var numbers uint64
+	for _, ss := range reader.segment {
+		numbers += ss.segment.Count()
+	}
+	for number := uint64(0); number < numbers; number++ {
+		err = reader.VisitStoredFields(number, func(field string, value []byte) bool {
+			if id, _ := strconv.Atoi(string(value)); field == "_id" && id%2 == 1 {
+				odd.Add(uint32(number))
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedOdd := []string{"7", "3", "9"}
+	if actual := nearest(reader, odd); !reflect.DeepEqual(actual, expectedOdd) {
+		t.Errorf("expected filtered neighbors %v, got %v", expectedOdd, actual)
+	}
+	if _, err = reader.NearestNeighbors("vec", []float32{1}, 3, 10, nil); err == nil {
+		t.Errorf("expected error searching a vector of the wrong number of dimensions")
+	}
+	_ = reader.Close()
+	err = idx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen, to load the vectors persisted with the segments
+	reader, err = OpenReader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	if actual := nearest(reader, nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected neighbors %v after reopening, got %v", expected, actual)
+	}
+	dir := cfg.DirectoryFunc()
+	for _, ss := range reader.segment {
+		data, closer, err := dir.Load(ItemKindVectors, ss.id)
+		if err != nil {
+			t.Fatalf("expected vectors persisted for segment %d: %v", ss.id, err)
+		}
+		persisted, err := cfg.readVectors(data)
+		if closer != nil {
+			_ = closer.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(persisted, ss.segment.vectors) {
+			t.Errorf("expected the persisted vectors of segment %d to be loaded", ss.id)
+		}
+	}
+
+	// vectors recorded for another configuration are indexed again
+	otherCfg := cfg.WithVectorField("vec", VectorField{
+		Dims:       2,
+		Similarity: hnsw.Cosine,
+	})
+	for _, ss := range reader.segment {
+		data, closer, err := dir.Load(ItemKindVectors, ss.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = otherCfg.readVectors(data); err == nil {
+			t.Errorf("expected error reading vectors of another similarity")
+		}
+		if closer != nil {
+			_ = closer.Close()
+		}
+	}
+}
+
+func TestMergeVectors(t *testing.T) {
+	cfg := Config{}.WithVectorField("vec", VectorField{
+		Dims:       2,
+		Similarity: hnsw.L2,
+	})
+	graph := func(ids ...uint64) *hnsw.Graph {
+		rv := hnsw.NewGraph(hnsw.L2, 0, 0)
+		for _, id := range ids {
+			rv.Add(id, []float32{float32(id), 0})
+		}
+		return rv
+	}
+	// the graph of the second segment has no dropped documents, so is
+	// copied, even though the first holds more vectors, which are added
+	vectors := []segmentVectors{
+		{"vec": graph(0, 1, 2, 3)},
+		{"vec": graph(0, 1, 2)},
+		{"vec": nil},
+	}
+	newDocNums := [][]uint64{
+		{0, docDropped, 1, 2},
+		{3, 4, 5},
+		{6},
+	}
+	merged := cfg.mergeVectors(vectors, newDocNums)
+	got := make(map[uint64][]float32)
+	merged["vec"].Each(func(id uint64, vector []float32) {
+		got[id] = vector
+	})
+	expected := map[uint64][]float32{
+		0: {0, 0},
+		1: {2, 0},
+		2: {3, 0},
+		3: {0, 0},
+		4: {1, 0},
+		5: {2, 0},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected merged vectors %v, got %v", expected, got)
+	}
+	var order []uint64
+	merged["vec"].Each(func(id uint64, _ []float32) {
+		order = append(order, id)
+	})
+	if order[0] != 3 {
+		t.Errorf("expected the graph of the second segment to be copied, got order %v", order)
+	}
+	// the graphs merged are not modified
+	if vectors[1]["vec"].Len() != 3 {
+		t.Errorf("expected the merged graph to be a copy")
+	}
+}
@@ -39,6 +39,7 @@ type Writer struct {
 	deletionPolicy DeletionPolicy
 	directory      Directory
 	segPlugin      *SegmentPlugin // segment plug-in in use
+	queryCache     *queryCache    // nil when disabled
 
 	rootLock sync.RWMutex
 	root     *Snapshot // holds 1 ref-count on the root
@@ -62,6 +63,9 @@ func OpenWriter(config Config) (*Writer, error) {
 		directory:      config.DirectoryFunc(),
 		closeCh:        make(chan struct{}),
 	}
+	if config.QueryCacheMaxBytes > 0 {
+		rv.queryCache = newQueryCache(config.QueryCacheMaxBytes, config.QueryCacheMinFrequency, &rv.stats)
+	}
 
 	// start the requested number of analysis workers
 	for i := 0; i < config.NumAnalysisWorkers; i++ {
@@ -258,9 +262,16 @@ func (s *Writer) Batch(batch *Batch) (err error) {
 	s.fireEvent(EventKindBatchIntroductionStart, 0)
 
 	var newSegment *segmentWrapper
+	var newSegmentSort *segmentSort
 	var bufBytes uint64
 	if numUpdates > 0 {
-		newSegment, bufBytes, err = s.newSegment(batch.documents)
+		err = s.config.validateVectors(batch.documents)
+		if err != nil {
+			return err
+		}
+		var docs []segment.Document
+		docs, newSegmentSort = s.config.sortDocuments(batch.documents)
+		newSegment, bufBytes, err = s.newSegment(docs)
 		if err != nil {
 			return err
 		}
@@ -269,7 +280,7 @@ func (s *Writer) Batch(batch *Batch) (err error) {
 		atomic.AddUint64(&s.stats.TotBatchesEmpty, 1)
 	}
 
-	err = s.prepareSegment(newSegment, batch.ids, nil, batch.PersistedCallback())
+	err = s.prepareSegment(newSegment, newSegmentSort, batch.ids, nil, batch.PersistedCallback())
 	if err != nil {
 		if newSegment != nil {
 			_ = newSegment.Close()
@@ -287,12 +298,13 @@ func (s *Writer) Batch(batch *Batch) (err error) {
 	return err
 }
 
-func (s *Writer) prepareSegment(newSegment *segmentWrapper, idTerms []segment.Term,
+func (s *Writer) prepareSegment(newSegment *segmentWrapper, newSegmentSort *segmentSort, idTerms []segment.Term,
 	internalOps map[string][]byte, persistedCallback func(error)) error {
 	// new introduction
 	introduction := &segmentIntroduction{
 		id:                atomic.AddUint64(&s.nextSegmentID, 1),
 		data:              newSegment,
+		sort:              newSegmentSort,
 		idTerms:           idTerms,
 		obsoletes:         make(map[uint64]*roaring.Bitmap),
 		internal:          internalOps,
@@ -413,6 +425,9 @@ func OpenReader(config Config) (*Snapshot, error) {
 		config:    config,
 		directory: config.DirectoryFunc(),
 	}
+	if config.QueryCacheMaxBytes > 0 {
+		parent.queryCache = newQueryCache(config.QueryCacheMaxBytes, config.QueryCacheMinFrequency, &parent.stats)
+	}
 
 	var err error
 	parent.segPlugin, err = loadSegmentPlugin(config.supportedSegmentPlugins,
@@ -514,6 +529,10 @@ func (s *Writer) loadSnapshot(epoch uint64) (*Snapshot, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error opening segment %d: %w", segSnapshot.id, err)
 		}
+		err = s.loadVectors(segSnapshot.id, segSnapshot.segment)
+		if err != nil {
+			return nil, fmt.Errorf("error loading vectors of segment %d: %w", segSnapshot.id, err)
+		}
 
 		snapshot.offsets = append(snapshot.offsets, running)
 		running += segSnapshot.segment.Count()
@@ -31,6 +31,7 @@ type WriterOffline struct {
 	segPlugin *SegmentPlugin
 	segCount  uint64
 	segIDs    []uint64
+	segSorts  map[uint64]*segmentSort
 
 	mergeMax int
 }
@@ -40,6 +41,7 @@ func OpenOfflineWriter(config Config) (writer *WriterOffline, err error) {
 		config:    config,
 		directory: config.DirectoryFunc(),
 		segPlugin: nil,
+		segSorts:  make(map[uint64]*segmentSort),
 		mergeMax:  10,
 	}
 
@@ -70,7 +72,13 @@ func (s *WriterOffline) Batch(batch *Batch) (err error) {
 		}
 	}
 
-	newSegment, _, err := s.segPlugin.New(batch.documents, s.config.NormCalc)
+	err = s.config.validateVectors(batch.documents)
+	if err != nil {
+		return err
+	}
+
+	docs, newSegmentSort := s.config.sortDocuments(batch.documents)
+	newSegment, _, err := s.segPlugin.New(docs, s.config.NormCalc)
 	if err != nil {
 		return err
 	}
@@ -80,6 +88,7 @@ func (s *WriterOffline) Batch(batch *Batch) (err error) {
 		return fmt.Errorf("error persisting segment: %v", err)
 	}
 	s.segIDs = append(s.segIDs, s.segCount)
+	s.segSorts[s.segCount] = newSegmentSort
 	s.segCount++
 
 	return nil
@@ -141,6 +150,11 @@ func (s *WriterOffline) doMerge() error {
 			_ = closeOpenedSegs()
 			return fmt.Errorf("error merging segments (%v): %w", mergeIDs, err)
 		}
+
+		// the merged segment is not sorted
+		for _, mergeID := range mergeIDs {
+			delete(s.segSorts, mergeID)
+		}
 		s.segIDs = append(s.segIDs, s.segCount)
 		s.segCount++
 
@@ -185,6 +199,18 @@ func (s *WriterOffline) Close() error {
 		return fmt.Errorf("error loading segment: %w", err)
 	}
 
+	// index the vectors of the segment once it is merged
+	vectors, err := s.config.buildVectors(finalSeg)
+	if err == nil && vectors != nil {
+		err = s.directory.Persist(ItemKindVectors, s.segIDs[0], vectors, nil)
+	}
+	if err != nil {
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return fmt.Errorf("error indexing vectors: %w", err)
+	}
+
 	// fake snapshot referencing this segment
 	snapshot := &Snapshot{
 		segment: []*segmentSnapshot{
@@ -197,6 +223,7 @@ func (s *WriterOffline) Close() error {
 				},
 				segmentType:    s.segPlugin.Type,
 				segmentVersion: s.segPlugin.Version,
+				sort:           s.segSorts[s.segIDs[0]],
 			},
 		},
 		epoch: s.segIDs[0],
@@ -17,7 +17,9 @@ package bluge
 import (
 	"context"
 
+	"github.com/blugelabs/bluge/index"
 	"github.com/blugelabs/bluge/search"
+	segment "github.com/blugelabs/bluge_segment_api"
 )
 
 type MultiSearcherList struct {
@@ -46,6 +48,7 @@ func (m *MultiSearcherList) Next(ctx *search.Context) (*search.DocumentMatch, er
 			m.index++
 			return m.Next(ctx)
 		}
+		dm.SourceIndex = m.index
 		return dm, nil
 	}
 	return nil, nil
@@ -73,23 +76,205 @@ func (m *MultiSearcherList) Close() (err error) {
 	return err
 }
 
+// MultiSearch searches several readers as if they were a single
+// index.  Matches are scored using the statistics of all the readers,
+// so that their scores are comparable.  When the collector supports it,
+// the readers are searched concurrently, using the SearchExecutor of
+// the first reader, and their results merged, otherwise they are
+// searched one after another.  Document numbers
+// are only unique within a reader, the SourceIndex of each match
+// is the position of the reader which found it.
 func MultiSearch(ctx context.Context, req SearchRequest, readers ...*Reader) (search.DocumentMatchIterator, error) {
+	if fr, ok := req.(fusingRequest); ok {
+		return fr.fuse(func(req SearchRequest) (search.DocumentMatchIterator, error) {
+			return MultiSearch(ctx, req, readers...)
+		})
+	}
+	snapshots := make([]*index.Snapshot, len(readers))
+	for i, reader := range readers {
+		snapshots[i] = reader.reader
+	}
+
+	dmItr, err := multiSearch(ctx, req, readers, snapshots)
+	if err != nil {
+		return nil, err
+	}
+	if rr, ok := req.(rescoringRequest); ok {
+		dmItr, err = rr.rescore(dmItr, func(source int) (search.Reader, Config) {
+			config := readers[source].config
+			config.searchContext = ctx
+			return &federatedReader{
+				Snapshot: readers[source].reader,
+				all:      snapshots,
+			}, config
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if er, ok := req.(expandingRequest); ok {
+		return er.expand(dmItr, func(req SearchRequest) (search.DocumentMatchIterator, error) {
+			return MultiSearch(ctx, req, readers...)
+		})
+	}
+	return dmItr, nil
+}
+
+func multiSearch(ctx context.Context, req SearchRequest, readers []*Reader,
+	snapshots []*index.Snapshot) (search.DocumentMatchIterator, error) {
 	collector := req.Collector()
+	cc, ok := collector.(search.ConcurrentCollector)
+	if !ok {
+		return multiSearchSequential(ctx, req, collector, readers, snapshots)
+	}
 
+	searches := make([]func() (search.DocumentMatchIterator, error), len(readers))
+	for i := range readers {
+		source, reader := i, readers[i]
+		searches[i] = func() (search.DocumentMatchIterator, error) {
+			fr := &federatedReader{
+				Snapshot: reader.reader,
+				all:      snapshots,
+			}
+			return reader.search(ctx, req, fr, source, cc.PartitionCollector())
+		}
+	}
+	// the readers are searched using the executor of the first
+	var executor func(func())
+	if len(readers) > 0 {
+		executor = readers[0].config.SearchExecutor
+	}
+	partitions, err := searchConcurrently(executor, searches)
+	if err != nil {
+		return nil, err
+	}
+
+	return cc.Merge(req.Aggregations(), partitions)
+}
+
+func multiSearchSequential(ctx context.Context, req SearchRequest, collector search.Collector,
+	readers []*Reader, snapshots []*index.Snapshot) (search.DocumentMatchIterator, error) {
 	var searchers []search.Searcher
 	for _, reader := range readers {
-		searcher, err := req.Searcher(reader.reader, reader.config)
+		fr := &federatedReader{
+			Snapshot: reader.reader,
+			all:      snapshots,
+		}
+		config := reader.config
+		config.searchContext = ctx
+		searcher, err := req.Searcher(fr, config)
 		if err != nil {
+			_ = NewMultiSearcherList(searchers).Close()
 			return nil, err
 		}
 		searchers = append(searchers, searcher)
 	}
 
+	// the aggregations search using the config of the first reader
+	var config Config
+	if len(readers) > 0 {
+		config = readers[0].config
+	}
+	config.searchContext = ctx
 	msl := NewMultiSearcherList(searchers)
-	dmItr, err := collector.Collect(ctx, req.Aggregations(), msl)
+	dmItr, err := collector.Collect(ctx, searchAggregations(req, config), msl)
 	if err != nil {
 		return nil, err
 	}
 
 	return dmItr, nil
 }
+
+// federatedReader searches one of several readers, scoring
+// matches using the statistics of all of the readers
+type federatedReader struct {
+	*index.Snapshot
+	all []*index.Snapshot
+}
+
+// CollectionStats returns the statistics of all the readers
+func (f *federatedReader) CollectionStats(field string) (segment.CollectionStats, error) {
+	var rv segment.CollectionStats
+	for _, snapshot := range f.all {
+		stats, err := snapshot.CollectionStats(field)
+		if err != nil {
+			return nil, err
+		}
+		if stats == nil {
+			continue
+		}
+		if rv == nil {
+			rv = stats
+		} else {
+			rv.Merge(stats)
+		}
+	}
+	return rv, nil
+}
+
+// DocumentFrequency returns the number of documents
+// of all the readers using the term in the field
+func (f *federatedReader) DocumentFrequency(term []byte, field string) (uint64, error) {
+	var rv uint64
+	for _, snapshot := range f.all {
+		itr, err := snapshot.PostingsIterator(term, field, false, false, false)
+		if err != nil {
+			return 0, err
+		}
+		rv += itr.Count()
+		err = itr.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return rv, nil
+}
+
+// TotalTermFrequency returns the number of occurrences
+// of the term in the field, in all the readers
+func (f *federatedReader) TotalTermFrequency(term []byte, field string) (uint64, error) {
+	var rv uint64
+	for _, snapshot := range f.all {
+		ttf, err := snapshot.TotalTermFrequency(term, field)
+		if err != nil {
+			return 0, err
+		}
+		rv += ttf
+	}
+	return rv, nil
+}
+
+// Close does nothing, the snapshot is closed by its reader
+func (f *federatedReader) Close() error {
+	return nil
+}
+
+// sourceSearcher sets the SourceIndex of the
+// matches found by one of several readers
+type sourceSearcher struct {
+	search.Searcher
+	source int
+}
+
+func (s *sourceSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Next(ctx)
+	if dm != nil {
+		dm.SourceIndex = s.source
+	}
+	return dm, err
+}
+
+func (s *sourceSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	dm, err := s.Searcher.Advance(ctx, number)
+	if dm != nil {
+		dm.SourceIndex = s.source
+	}
+	return dm, err
+}
+
+func (s *sourceSearcher) SetMinCompetitiveScore(score float64) bool {
+	if css, ok := s.Searcher.(search.CompetitiveScoreSearcher); ok {
+		return css.SetMinCompetitiveScore(score)
+	}
+	return false
+}
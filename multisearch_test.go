@@ -16,7 +16,13 @@ package bluge
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
 	"testing"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/aggregations"
 )
 
 func TestMultiSearch(t *testing.T) {
@@ -102,3 +108,316 @@ func TestMultiSearch(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMultiSearchGlobalStatistics(t *testing.T) {
+	openReader := func(start, end int) *Reader {
+		indexWriter, err := OpenWriter(InMemoryOnlyConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := NewBatch()
+		for i := start; i < end; i++ {
+			text := "common"
+			if i%4 == 0 {
+				text = "rare common"
+			}
+			doc := NewDocument(fmt.Sprintf("%03d", i)).
+				AddField(NewTextField("desc", text))
+			batch.Update(doc.ID(), doc)
+		}
+		if err = indexWriter.Batch(batch); err != nil {
+			t.Fatal(err)
+		}
+		indexReader, err := indexWriter.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = indexWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return indexReader
+	}
+	combined := openReader(0, 60)
+	first := openReader(0, 20)
+	second := openReader(20, 60)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	type hit struct {
+		id     string
+		score  float64
+		source int
+	}
+	collect := func(dmi search.DocumentMatchIterator, err error) (hits []hit, matches []*search.DocumentMatch) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			h := hit{score: next.Score, source: next.SourceIndex}
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					h.id = string(value)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hits = append(hits, h)
+			matches = append(matches, next)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hits, matches
+	}
+
+	q := NewMatchQuery("rare common").SetField("desc")
+	expected, _ := collect(combined.Search(context.Background(), NewTopNSearch(60, q)))
+	actual, _ := collect(MultiSearch(context.Background(), NewTopNSearch(60, q), first, second))
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d hits, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		expectedSource := 0
+		if expected[i].id >= "020" {
+			expectedSource = 1
+		}
+		if actual[i].id != expected[i].id || actual[i].score != expected[i].score ||
+			actual[i].source != expectedSource {
+			t.Errorf("hit %d: expected %v from source %d, got %v", i, expected[i], expectedSource, actual[i])
+		}
+	}
+
+	// page through the matches by descending id
+	var paged []hit
+	var after [][]byte
+	for {
+		req := NewTopNSearch(7, q).SortBy([]string{"-_id"}).WithStandardAggregations()
+		if after != nil {
+			req.After(after)
+		}
+		dmi, err := MultiSearch(context.Background(), req, first, second)
+		if err == nil && dmi.Aggregations().Count() == 0 {
+			t.Fatalf("expected aggregations to be merged")
+		}
+		page, matches := collect(dmi, err)
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		after = matches[len(matches)-1].SortValue
+	}
+	if len(paged) != 60 {
+		t.Fatalf("expected 60 hits paging, got %d", len(paged))
+	}
+	for i, h := range paged {
+		if id := fmt.Sprintf("%03d", 59-i); h.id != id {
+			t.Errorf("expected hit %d to be %s, got %s", i, id, h.id)
+		}
+	}
+
+	// and back again
+	before := after
+	var back []string
+	for {
+		dmi, err := MultiSearch(context.Background(),
+			NewTopNSearch(7, q).SortBy([]string{"-_id"}).Before(before), first, second)
+		page, matches := collect(dmi, err)
+		if len(page) == 0 {
+			break
+		}
+		for i := len(page) - 1; i >= 0; i-- {
+			back = append(back, page[i].id)
+		}
+		before = matches[0].SortValue
+	}
+	var expectedBack []string
+	for i := 1; i < 60; i++ {
+		expectedBack = append(expectedBack, fmt.Sprintf("%03d", i))
+	}
+	if !reflect.DeepEqual(expectedBack, back) {
+		t.Errorf("expected %v paging back, got %v", expectedBack, back)
+	}
+}
+
+func TestMultiSearchTopHits(t *testing.T) {
+	openReader := func(start, end int) *Reader {
+		indexWriter, err := OpenWriter(InMemoryOnlyConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := NewBatch()
+		for i := start; i < end; i++ {
+			doc := NewDocument(fmt.Sprintf("%03d", i)).
+				AddField(NewKeywordField("author", fmt.Sprintf("author%d", i%3)).Aggregatable()).
+				AddField(NewNumericField("rating", float64((i*7)%20)).Aggregatable())
+			batch.Update(doc.ID(), doc)
+		}
+		if err = indexWriter.Batch(batch); err != nil {
+			t.Fatal(err)
+		}
+		indexReader, err := indexWriter.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = indexWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return indexReader
+	}
+	combined := openReader(0, 30)
+	first := openReader(0, 10)
+	second := openReader(10, 30)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	topHits := func(dmi search.DocumentMatchIterator, err error) map[string][]string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv := make(map[string][]string)
+		for _, bucket := range dmi.Aggregations().Buckets("authors") {
+			for _, hit := range bucket.Aggregation("best").(*aggregations.TopHitsCalculator).Hits() {
+				err = hit.VisitStoredFields(func(field string, value []byte) bool {
+					if field == "_id" {
+						rv[bucket.Name()] = append(rv[bucket.Name()], string(value))
+					}
+					return true
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return rv
+	}
+	request := func() SearchRequest {
+		authors := aggregations.NewTermsAggregation(search.Field("author"), 10)
+		authors.AddAggregation("best", aggregations.TopHits(3).
+			SortBy(search.SortOrder{
+				search.SortBy(search.Field("rating")).Desc(),
+				search.SortBy(search.Field("_id")),
+			}))
+		req := NewTopNSearch(1, NewMatchAllQuery())
+		req.AddAggregation("authors", authors)
+		return req
+	}
+
+	expected := topHits(combined.Search(context.Background(), request()))
+	if len(expected) != 3 || len(expected["author0"]) != 3 {
+		t.Fatalf("expected 3 hits for each of 3 authors, got %v", expected)
+	}
+	actual := topHits(MultiSearch(context.Background(), request(), first, second))
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected top hits %v, got %v", expected, actual)
+	}
+}
+
+func TestMultiSearchExecutor(t *testing.T) {
+	var executed int64
+	executor := func(f func()) {
+		atomic.AddInt64(&executed, 1)
+		go f()
+	}
+	openReader := func(id string) *Reader {
+		indexWriter, err := OpenWriter(InMemoryOnlyConfig().WithConcurrentSearch(1, executor))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := NewDocument(id).AddField(NewTextField("desc", "common"))
+		if err = indexWriter.Update(doc.ID(), doc); err != nil {
+			t.Fatal(err)
+		}
+		indexReader, err := indexWriter.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = indexWriter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return indexReader
+	}
+	first := openReader("a")
+	second := openReader("b")
+	defer func() {
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	req := NewTopNSearch(10, NewMatchQuery("common").SetField("desc")).WithStandardAggregations()
+	dmi, err := MultiSearch(context.Background(), req, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dmi.Aggregations().Count() != 2 {
+		t.Errorf("expected 2 matches, got %d", dmi.Aggregations().Count())
+	}
+	if atomic.LoadInt64(&executed) < 2 {
+		t.Errorf("expected each reader to be searched using the executor, got %d", executed)
+	}
+}
+
+func TestMultiSearchAfter(t *testing.T) {
+	buildDoc := func(i int) *Document {
+		return NewDocument(fmt.Sprintf("%03d", i)).
+			AddField(NewNumericField("price", float64(i%4)).Sortable())
+	}
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 30, 10, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 30, 60, 10, buildDoc)
+	defer func() {
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	page := func(size int, sortBy []string, after [][]byte) (ids []string, last [][]byte) {
+		req := NewTopNSearch(size, NewMatchAllQuery()).SortBy(sortBy)
+		if after != nil {
+			req.After(after)
+		}
+		dmi, err := MultiSearch(context.Background(), req, first, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			ids = append(ids, string(next.SortValue[len(sortBy)-1]))
+			last = next.SortValue
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, last
+	}
+
+	// matches tied with the search after key are skipped in every reader
+	_, after := page(1, []string{"price"}, nil)
+	ids, _ := page(60, []string{"price"}, after)
+	if len(ids) != 45 {
+		t.Errorf("expected the 45 matches priced above the first, got %d", len(ids))
+	}
+
+	// paging through ties visits every match of both readers once
+	all, _ := page(60, []string{"price", "_id"}, nil)
+	if len(all) != 60 {
+		t.Fatalf("expected 60 matches, got %d", len(all))
+	}
+	var paged []string
+	ids, after = page(7, []string{"price", "_id"}, nil)
+	for len(ids) > 0 {
+		paged = append(paged, ids...)
+		ids, after = page(7, []string{"price", "_id"}, after)
+	}
+	if !reflect.DeepEqual(paged, all) {
+		t.Errorf("expected pages of %v, got %v", all, paged)
+	}
+}
@@ -20,10 +20,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/RoaringBitmap/roaring"
+
 	"github.com/blugelabs/bluge/search/similarity"
 
 	"github.com/blugelabs/bluge/analysis"
 	"github.com/blugelabs/bluge/analysis/tokenizer"
+	"github.com/blugelabs/bluge/index"
+	"github.com/blugelabs/bluge/index/hnsw"
 	"github.com/blugelabs/bluge/numeric"
 	"github.com/blugelabs/bluge/numeric/geo"
 	"github.com/blugelabs/bluge/search"
@@ -396,6 +400,154 @@ func isDatetimeCompatible(t time.Time) bool {
 	return true
 }
 
+type FilterQuery struct {
+	query    Query
+	cacheKey string
+	boost    *boost
+}
+
+// NewFilterQuery creates a new Query which matches
+// the same documents as the provided query, but
+// gives them all a constant score, the boost (default 1).
+// When the index is configured with a query cache,
+// the documents matched in each segment are cached,
+// and reused by later filters with the same key.
+// Keys are derived automatically for term, range,
+// prefix, wildcard, regexp, fuzzy, geo, match all
+// and boolean combinations of these queries,
+// other queries must set a key with SetCacheKey
+// to use the cache.
+func NewFilterQuery(q Query) *FilterQuery {
+	return &FilterQuery{
+		query: q,
+	}
+}
+
+// Query returns the query whose matches are filtered
+func (q *FilterQuery) Query() Query {
+	return q.query
+}
+
+// SetCacheKey sets the key used to cache the filter,
+// the key must uniquely identify the documents matched
+// by the query
+func (q *FilterQuery) SetCacheKey(key string) *FilterQuery {
+	q.cacheKey = key
+	return q
+}
+
+// CacheKey returns the key used to cache the filter
+func (q *FilterQuery) CacheKey() string {
+	return q.cacheKey
+}
+
+func (q *FilterQuery) SetBoost(b float64) *FilterQuery {
+	boostVal := boost(b)
+	q.boost = &boostVal
+	return q
+}
+
+func (q *FilterQuery) Boost() float64 {
+	return q.boost.Value()
+}
+
+func (q *FilterQuery) Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error) {
+	// scores of the filtered query are never used
+	filterOptions := options
+	filterOptions.Score = "none"
+	filterOptions.Explain = false
+
+	key := q.cacheKey
+	if key == "" {
+		key = queryCacheKey(q.query, options)
+	}
+	if cr, ok := i.(cachingReader); ok && key != "" && cr.QueryCacheEnabled() {
+		postings, err := cr.CachedFilter(key, func() (index.FilterIterator, error) {
+			s, err := q.query.Searcher(i, filterOptions)
+			if err != nil {
+				return nil, err
+			}
+			return newSearcherFilterIterator(s), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return searcher.NewPostingsSearcher(i, postings, similarity.ConstantScorer(q.boost.Value()), options), nil
+	}
+
+	s, err := q.query.Searcher(i, filterOptions)
+	if err != nil {
+		return nil, err
+	}
+	return searcher.NewConstantScoreSearcher(s, q.boost.Value(), options), nil
+}
+
+func (q *FilterQuery) Validate() error {
+	if vq, ok := q.query.(validatableQuery); ok {
+		return vq.Validate()
+	}
+	return nil
+}
+
+type FunctionScoreQuery struct {
+	query  Query
+	source search.NumericValueSource
+	boost  *boost
+}
+
+// NewFunctionScoreQuery creates a new Query which
+// matches the same documents as the provided query,
+// but replaces their score with the value computed
+// by the provided source, for example a compiled
+// expression from the search/expression package.
+// The original score is available to the source
+// through the DocumentMatch.  Matches for which the
+// source computes NaN (missing) are scored 0.
+func NewFunctionScoreQuery(q Query, source search.NumericValueSource) *FunctionScoreQuery {
+	return &FunctionScoreQuery{
+		query:  q,
+		source: source,
+	}
+}
+
+// Query returns the query whose matches are scored
+func (q *FunctionScoreQuery) Query() Query {
+	return q.query
+}
+
+// Source returns the source used to compute the score
+func (q *FunctionScoreQuery) Source() search.NumericValueSource {
+	return q.source
+}
+
+func (q *FunctionScoreQuery) SetBoost(b float64) *FunctionScoreQuery {
+	boostVal := boost(b)
+	q.boost = &boostVal
+	return q
+}
+
+func (q *FunctionScoreQuery) Boost() float64 {
+	return q.boost.Value()
+}
+
+func (q *FunctionScoreQuery) Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error) {
+	s, err := q.query.Searcher(i, options)
+	if err != nil {
+		return nil, err
+	}
+	return searcher.NewFunctionScoreSearcher(i, s, q.source, q.boost.Value(), options), nil
+}
+
+func (q *FunctionScoreQuery) Validate() error {
+	if q.source == nil {
+		return fmt.Errorf("function score query must specify a source")
+	}
+	if vq, ok := q.query.(validatableQuery); ok {
+		return vq.Validate()
+	}
+	return nil
+}
+
 type FuzzyQuery struct {
 	term      string
 	prefix    int
@@ -686,6 +838,194 @@ func (q *GeoBoundingPolygonQuery) Validate() error {
 	return nil
 }
 
+type KNNQuery struct {
+	field         string
+	vector        []float32
+	k             int
+	numCandidates int
+	filter        Query
+	boost         *boost
+}
+
+// NewKNNQuery creates a Query which matches the k
+// documents whose vectors in the field are nearest
+// to the vector, scored by their similarity to it,
+// as configured for the field, see
+// Config.WithDenseVectorField.  The neighbors are
+// found approximately, among the nearest candidates
+// found in each segment, by default one and a half
+// times k.  When searching several readers with
+// MultiSearch, each finds k matches.
+func NewKNNQuery(field string, vector []float32, k int) *KNNQuery {
+	return &KNNQuery{
+		field:         field,
+		vector:        vector,
+		k:             k,
+		numCandidates: k + k/2,
+	}
+}
+
+// Field returns the field of the vectors
+func (q *KNNQuery) Field() string {
+	return q.field
+}
+
+// Vector returns the vector whose neighbors are matched
+func (q *KNNQuery) Vector() []float32 {
+	return q.vector
+}
+
+// K returns the number of neighbors matched
+func (q *KNNQuery) K() int {
+	return q.k
+}
+
+// SetNumCandidates sets the number of candidates the
+// neighbors are chosen among in each segment, more
+// candidates find the nearest neighbors more reliably,
+// but more slowly
+func (q *KNNQuery) SetNumCandidates(n int) *KNNQuery {
+	q.numCandidates = n
+	return q
+}
+
+func (q *KNNQuery) NumCandidates() int {
+	return q.numCandidates
+}
+
+// SetFilter restricts the neighbors to the documents
+// matched by the query, the k nearest of them are
+// matched, even when other documents are nearer
+func (q *KNNQuery) SetFilter(filter Query) *KNNQuery {
+	q.filter = filter
+	return q
+}
+
+func (q *KNNQuery) Filter() Query {
+	return q.filter
+}
+
+func (q *KNNQuery) SetBoost(b float64) *KNNQuery {
+	boostVal := boost(b)
+	q.boost = &boostVal
+	return q
+}
+
+func (q *KNNQuery) Boost() float64 {
+	return q.boost.Value()
+}
+
+func (q *KNNQuery) Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error) {
+	var neighbors []search.Neighbor
+	var err error
+	if slice, ok := i.(*index.SnapshotSlice); ok {
+		// the neighbors are those of the whole index,
+		// found once for all of the slices searched
+		var found interface{}
+		found, err = slice.Once(q, func() (interface{}, error) {
+			return q.nearestNeighbors(slice.Whole(), options)
+		})
+		if err == nil {
+			neighbors = sliceNeighbors(found.([]search.Neighbor), slice.Range())
+		}
+	} else {
+		neighbors, err = q.nearestNeighbors(i, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return searcher.NewKNNSearcher(i, q.field, neighbors, q.boost.Value(), options)
+}
+
+// vectorReader is implemented by the readers of indexes
+// with vector fields, see index.Snapshot.NearestNeighbors
+type vectorReader interface {
+	NearestNeighbors(field string, vector []float32, k, numCandidates int,
+		filter *roaring.Bitmap) ([]hnsw.Neighbor, error)
+}
+
+// nearestNeighbors finds the neighbors among the documents matched
+// by the filter, best first, readers which do not index vectors
+// have no neighbors
+func (q *KNNQuery) nearestNeighbors(i search.Reader, options search.SearcherOptions) ([]search.Neighbor, error) {
+	vr, ok := i.(vectorReader)
+	if !ok {
+		return nil, nil
+	}
+	var filter *roaring.Bitmap
+	if q.filter != nil {
+		var err error
+		filter, err = q.filterBitmap(i, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	found, err := vr.NearestNeighbors(q.field, q.vector, q.k, q.numCandidates, filter)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]search.Neighbor, len(found))
+	for j, n := range found {
+		rv[j] = search.Neighbor{
+			Number: n.ID,
+			Score:  n.Score,
+		}
+	}
+	return rv, nil
+}
+
+// sliceNeighbors returns a copy of the neighbors among the documents
+// of a slice, as the neighbors are shared by all of the slices
+func sliceNeighbors(neighbors []search.Neighbor, docs index.DocumentRange) []search.Neighbor {
+	var rv []search.Neighbor
+	for _, n := range neighbors {
+		if n.Number >= docs.Start && n.Number < docs.End {
+			rv = append(rv, n)
+		}
+	}
+	return rv
+}
+
+// filterBitmap returns the numbers of the documents matched by the filter
+func (q *KNNQuery) filterBitmap(i search.Reader, options search.SearcherOptions) (*roaring.Bitmap, error) {
+	filterOptions := options
+	filterOptions.Score = "none"
+	filterOptions.Explain = false
+	s, err := q.filter.Searcher(i, filterOptions)
+	if err != nil {
+		return nil, err
+	}
+	rv := roaring.New()
+	ctx := search.NewSearchContext(s.DocumentMatchPoolSize(), 0)
+	next, err := s.Next(ctx)
+	for err == nil && next != nil {
+		rv.Add(uint32(next.Number))
+		ctx.DocumentMatchPool.Put(next)
+		next, err = s.Next(ctx)
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return rv, s.Close()
+}
+
+func (q *KNNQuery) Validate() error {
+	if q.k <= 0 {
+		return fmt.Errorf("knn query must match at least one neighbor")
+	}
+	if q.numCandidates < q.k {
+		return fmt.Errorf("knn query must have at least k candidates")
+	}
+	if len(q.vector) == 0 {
+		return fmt.Errorf("knn query must specify a vector")
+	}
+	if vq, ok := q.filter.(validatableQuery); ok {
+		return vq.Validate()
+	}
+	return nil
+}
+
 type MatchAllQuery struct {
 	boost *boost
 }
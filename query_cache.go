@@ -0,0 +1,158 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge/index"
+	"github.com/blugelabs/bluge/search"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// cachingReader is implemented by readers which
+// can cache the documents matched by filters
+type cachingReader interface {
+	QueryCacheEnabled() bool
+	CachedFilter(key string, build func() (index.FilterIterator, error)) (segment.PostingsIterator, error)
+}
+
+// queryCacheKey derives a key uniquely identifying the documents
+// matched by the query, or the empty string if the query
+// cannot be cached automatically
+func queryCacheKey(q Query, options search.SearcherOptions) string {
+	field := func(f string) string {
+		if f == "" {
+			return options.DefaultSearchField
+		}
+		return f
+	}
+	switch q := q.(type) {
+	case *TermQuery:
+		return fmt.Sprintf("term(%q,%q)", field(q.field), q.term)
+	case *TermRangeQuery:
+		return fmt.Sprintf("term_range(%q,%q,%t,%q,%t)", field(q.field),
+			q.min, q.inclusiveMin, q.max, q.inclusiveMax)
+	case *NumericRangeQuery:
+		return fmt.Sprintf("numeric_range(%q,%g,%t,%g,%t)", field(q.field),
+			q.min, q.inclusiveMin, q.max, q.inclusiveMax)
+	case *DateRangeQuery:
+		return fmt.Sprintf("date_range(%q,%s,%t,%s,%t)", field(q.field),
+			q.start.Format(time.RFC3339Nano), q.inclusiveStart, q.end.Format(time.RFC3339Nano), q.inclusiveEnd)
+	case *PrefixQuery:
+		return fmt.Sprintf("prefix(%q,%q)", field(q.field), q.prefix)
+	case *WildcardQuery:
+		return fmt.Sprintf("wildcard(%q,%q)", field(q.field), q.wildcard)
+	case *RegexpQuery:
+		return fmt.Sprintf("regexp(%q,%q)", field(q.field), q.regexp)
+	case *FuzzyQuery:
+		return fmt.Sprintf("fuzzy(%q,%q,%d,%d)", field(q.field), q.term, q.prefix, q.fuzziness)
+	case *GeoBoundingBoxQuery:
+		return fmt.Sprintf("geo_bbox(%q,%v,%v)", field(q.field), q.topLeft, q.bottomRight)
+	case *GeoDistanceQuery:
+		return fmt.Sprintf("geo_distance(%q,%v,%q)", field(q.field), q.location, q.distance)
+	case *GeoBoundingPolygonQuery:
+		return fmt.Sprintf("geo_polygon(%q,%v)", field(q.field), q.points)
+	case *MatchAllQuery:
+		return "match_all"
+	case *MatchNoneQuery:
+		return "match_none"
+	case *FilterQuery:
+		if q.cacheKey != "" {
+			return q.cacheKey
+		}
+		return queryCacheKey(q.query, options)
+	case *BooleanQuery:
+		return booleanQueryCacheKey(q, options)
+	}
+	return ""
+}
+
+func booleanQueryCacheKey(q *BooleanQuery, options search.SearcherOptions) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "bool(%d", q.minShould)
+	for _, clauses := range []struct {
+		name    string
+		queries querySlice
+	}{
+		{"must", q.musts},
+		{"should", q.shoulds},
+		{"must_not", q.mustNots},
+	} {
+		sb.WriteString("," + clauses.name + "[")
+		for i, clause := range clauses.queries {
+			key := queryCacheKey(clause, options)
+			if key == "" {
+				return ""
+			}
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(key)
+		}
+		sb.WriteByte(']')
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// searcherFilterIterator adapts a Searcher to the
+// index.FilterIterator interface, so that its
+// matches can be cached
+type searcherFilterIterator struct {
+	searcher search.Searcher
+	ctx      *search.Context
+	last     *search.DocumentMatch
+}
+
+func newSearcherFilterIterator(s search.Searcher) *searcherFilterIterator {
+	return &searcherFilterIterator{
+		searcher: s,
+		ctx:      search.NewSearchContext(s.DocumentMatchPoolSize(), 0),
+	}
+}
+
+func (s *searcherFilterIterator) Next() (uint64, bool, error) {
+	s.recycle()
+	next, err := s.searcher.Next(s.ctx)
+	return s.result(next, err)
+}
+
+func (s *searcherFilterIterator) Advance(number uint64) (uint64, bool, error) {
+	s.recycle()
+	adv, err := s.searcher.Advance(s.ctx, number)
+	return s.result(adv, err)
+}
+
+func (s *searcherFilterIterator) recycle() {
+	if s.last != nil {
+		s.ctx.DocumentMatchPool.Put(s.last)
+		s.last = nil
+	}
+}
+
+func (s *searcherFilterIterator) result(d *search.DocumentMatch, err error) (uint64, bool, error) {
+	if err != nil || d == nil {
+		return 0, false, err
+	}
+	s.last = d
+	return d.Number, true, nil
+}
+
+func (s *searcherFilterIterator) Close() error {
+	return s.searcher.Close()
+}
@@ -17,6 +17,7 @@ package bluge
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/blugelabs/bluge/index"
 
@@ -57,12 +58,157 @@ func (r *Reader) VisitStoredFields(number uint64, visitor StoredFieldVisitor) er
 	return r.reader.VisitStoredFields(number, segment.StoredFieldVisitor(visitor))
 }
 
+// indexSortedCollector is implemented by collectors which can skip
+// matches in ranges of documents the index has already sorted
+type indexSortedCollector interface {
+	SortOrder() search.SortOrder
+	SetIndexSortedRanges(ranges []search.DocumentRange)
+}
+
+// indexSortedRanges returns the ranges of documents
+// the index has already sorted in the provided order
+func (r *Reader) indexSortedRanges(order search.SortOrder) []search.DocumentRange {
+	ranges := r.reader.SortedRanges(order.Key())
+	if len(ranges) == 0 {
+		return nil
+	}
+	rv := make([]search.DocumentRange, len(ranges))
+	for i, docRange := range ranges {
+		rv[i] = search.DocumentRange{
+			Start: docRange.Start,
+			End:   docRange.End,
+		}
+	}
+	return rv
+}
+
 func (r *Reader) Search(ctx context.Context, req SearchRequest) (search.DocumentMatchIterator, error) {
+	if fr, ok := req.(fusingRequest); ok {
+		return fr.fuse(func(req SearchRequest) (search.DocumentMatchIterator, error) {
+			return r.Search(ctx, req)
+		})
+	}
+	var dmItr search.DocumentMatchIterator
+	var err error
 	collector := req.Collector()
-	searcher, err := req.Searcher(r.reader, r.config)
+	if cc, ok := collector.(search.ConcurrentCollector); ok && r.config.SearchSlices > 1 &&
+		len(r.reader.Segments()) > 1 {
+		dmItr, err = r.searchSlices(ctx, req, cc, r.reader.Slices(r.config.SearchSlices))
+	} else {
+		dmItr, err = r.search(ctx, req, r.reader, 0, collector)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dmItr, err = r.rescore(ctx, req, dmItr)
+	if err != nil {
+		return nil, err
+	}
+	return r.expand(ctx, req, dmItr)
+}
+
+func (r *Reader) rescore(ctx context.Context, req SearchRequest,
+	dmi search.DocumentMatchIterator) (search.DocumentMatchIterator, error) {
+	rr, ok := req.(rescoringRequest)
+	if !ok {
+		return dmi, nil
+	}
+	return rr.rescore(dmi, func(int) (search.Reader, Config) {
+		config := r.config
+		config.searchContext = ctx
+		return r.reader, config
+	})
+}
+
+// expandingRequest is implemented by requests
+// which complete their matches with further searches
+type expandingRequest interface {
+	expand(dmi search.DocumentMatchIterator,
+		searchFunc func(SearchRequest) (search.DocumentMatchIterator, error)) (search.DocumentMatchIterator, error)
+}
+
+func (r *Reader) expand(ctx context.Context, req SearchRequest,
+	dmi search.DocumentMatchIterator) (search.DocumentMatchIterator, error) {
+	er, ok := req.(expandingRequest)
+	if !ok {
+		return dmi, nil
+	}
+	return er.expand(dmi, func(req SearchRequest) (search.DocumentMatchIterator, error) {
+		return r.Search(ctx, req)
+	})
+}
+
+// searchSlices searches each slice of the index with its own
+// partition collector, using the configured executor,
+// and then merges the results
+func (r *Reader) searchSlices(ctx context.Context, req SearchRequest, collector search.ConcurrentCollector,
+	slices []*index.SnapshotSlice) (search.DocumentMatchIterator, error) {
+	searches := make([]func() (search.DocumentMatchIterator, error), len(slices))
+	for i := range slices {
+		slice := slices[i]
+		searches[i] = func() (search.DocumentMatchIterator, error) {
+			return r.search(ctx, req, slice, 0, collector.PartitionCollector())
+		}
+	}
+	partitions, err := searchConcurrently(r.config.SearchExecutor, searches)
+	if err != nil {
+		return nil, err
+	}
+
+	return collector.Merge(req.Aggregations(), partitions)
+}
+
+// searchConcurrently runs each search using executor, or in its own
+// goroutine when executor is nil, and returns the results in order
+func searchConcurrently(executor func(func()),
+	searches []func() (search.DocumentMatchIterator, error)) ([]search.DocumentMatchIterator, error) {
+	if executor == nil {
+		executor = func(f func()) {
+			go f()
+		}
+	}
+
+	rv := make([]search.DocumentMatchIterator, len(searches))
+	errs := make([]error, len(searches))
+	var wg sync.WaitGroup
+	for i := range searches {
+		i := i
+		wg.Add(1)
+		executor(func() {
+			defer wg.Done()
+			rv[i], errs[i] = searches[i]()
+		})
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// search collects the matches of the request in the reader, tagging
+// them with the source index when it is not the first source
+func (r *Reader) search(ctx context.Context, req SearchRequest, reader search.Reader, source int,
+	collector search.Collector) (search.DocumentMatchIterator, error) {
+	config := r.config
+	config.searchContext = ctx
+	searcher, err := req.Searcher(reader, config)
 	if err != nil {
 		return nil, err
 	}
+	if source > 0 {
+		searcher = &sourceSearcher{
+			Searcher: searcher,
+			source:   source,
+		}
+	}
+
+	if isc, ok := collector.(indexSortedCollector); ok {
+		isc.SetIndexSortedRanges(r.indexSortedRanges(isc.SortOrder()))
+	}
 
 	memNeeded := memNeededForSearch(searcher, collector)
 	if r.config.SearchStartFunc != nil {
@@ -76,7 +222,7 @@ func (r *Reader) Search(ctx context.Context, req SearchRequest) (search.Document
 	}
 
 	var dmItr search.DocumentMatchIterator
-	dmItr, err = collector.Collect(ctx, req.Aggregations(), searcher)
+	dmItr, err = collector.Collect(ctx, searchAggregations(req, config), searcher)
 	if err != nil {
 		return nil, err
 	}
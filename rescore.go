@@ -0,0 +1,307 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluge
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/ranking"
+)
+
+type RescoreMode int
+
+const (
+	// The scores of both queries are added, this is the default.
+	RescoreModeTotal RescoreMode = iota
+	// The scores of both queries are multiplied.
+	RescoreModeMultiply
+	// The scores of both queries are averaged.
+	RescoreModeAvg
+	// The greatest of the scores of both queries is kept.
+	RescoreModeMax
+	// The least of the scores of both queries is kept.
+	RescoreModeMin
+)
+
+// Rescorer scores the top matches of a search again, with a
+// query too costly to score every match with, such as a phrase
+// query, a function score or a model
+type Rescorer struct {
+	query         Query
+	window        int
+	queryWeight   float64
+	rescoreWeight float64
+	mode          RescoreMode
+
+	features *FeatureSet
+	model    ranking.Model
+}
+
+// NewRescorer rescores the top window matches with the query,
+// by default their scores are the sum of the scores of both
+// queries, whatever the score mode, matches not matching the
+// query keep their score, multiplied by the query weight
+func NewRescorer(q Query, window int) *Rescorer {
+	return &Rescorer{
+		query:         q,
+		window:        window,
+		queryWeight:   1,
+		rescoreWeight: 1,
+	}
+}
+
+// NewModelRescorer rescores the top window matches with the
+// model, evaluated over the features of the set, by default their
+// scores are the sum of the query score and the model score
+func NewModelRescorer(features *FeatureSet, model ranking.Model, window int) *Rescorer {
+	return &Rescorer{
+		window:        window,
+		queryWeight:   1,
+		rescoreWeight: 1,
+		features:      features,
+		model:         model,
+	}
+}
+
+// SetQueryWeight sets the weight of the score of the search query
+func (r *Rescorer) SetQueryWeight(weight float64) *Rescorer {
+	r.queryWeight = weight
+	return r
+}
+
+// SetRescoreQueryWeight sets the weight of the score of the rescore query
+func (r *Rescorer) SetRescoreQueryWeight(weight float64) *Rescorer {
+	r.rescoreWeight = weight
+	return r
+}
+
+// SetScoreMode sets how the weighted scores are combined
+func (r *Rescorer) SetScoreMode(mode RescoreMode) *Rescorer {
+	r.mode = mode
+	return r
+}
+
+func (r *Rescorer) combine(score, rescore float64) float64 {
+	score *= r.queryWeight
+	rescore *= r.rescoreWeight
+	switch r.mode {
+	case RescoreModeMultiply:
+		return score * rescore
+	case RescoreModeAvg:
+		return (score + rescore) / 2
+	case RescoreModeMax:
+		return math.Max(score, rescore)
+	case RescoreModeMin:
+		return math.Min(score, rescore)
+	}
+	return score + rescore
+}
+
+func (r *Rescorer) modeName() string {
+	switch r.mode {
+	case RescoreModeMultiply:
+		return "product"
+	case RescoreModeAvg:
+		return "avg"
+	case RescoreModeMax:
+		return "max"
+	case RescoreModeMin:
+		return "min"
+	}
+	return "sum"
+}
+
+// rescoringRequest is implemented by requests which score
+// their top matches again, the readers func returns the
+// reader of each source of matches and its configuration
+type rescoringRequest interface {
+	rescore(dmi search.DocumentMatchIterator,
+		readers func(source int) (search.Reader, Config)) (search.DocumentMatchIterator, error)
+}
+
+func (s *TopNSearch) rescore(dmi search.DocumentMatchIterator,
+	readers func(source int) (search.Reader, Config)) (search.DocumentMatchIterator, error) {
+	if s.rescorer == nil && s.featureLog == nil {
+		return dmi, nil
+	}
+	if s.rescorer != nil &&
+		(!s.sort.ScoreDescending() || s.after != nil || s.collapseField != "") {
+		return nil, fmt.Errorf("rescoring requires sorting by descending score, without paging after a match or collapsing")
+	}
+
+	var matches []*search.DocumentMatch
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		matches = append(matches, next)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.rescorer != nil {
+		matches, err = s.rescoreWindow(matches, readers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.featureLog != nil {
+		for source, sourceMatches := range bySource(matches) {
+			reader, config := readers(source)
+			err = s.featureLog.extract(reader, config, sourceMatches)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &rescoredIterator{
+		DocumentMatchIterator: dmi,
+		matches:               matches,
+	}, nil
+}
+
+// rescoreWindow rescores the window of top matches, and
+// returns the matches requested in their new order
+func (s *TopNSearch) rescoreWindow(matches []*search.DocumentMatch,
+	readers func(source int) (search.Reader, Config)) ([]*search.DocumentMatch, error) {
+	window := matches
+	if len(window) > s.rescorer.window {
+		window = window[:s.rescorer.window]
+	}
+	for source, sourceMatches := range bySource(window) {
+		reader, config := readers(source)
+		err := s.rescoreSource(reader, config, sourceMatches)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the matches are already in order of their hit numbers
+	sort.SliceStable(window, func(i, j int) bool {
+		return window[i].Score > window[j].Score
+	})
+	for _, match := range window {
+		match.SortValue = nil
+		s.sort.Compute(match)
+	}
+
+	from := s.from
+	if from > len(matches) {
+		from = len(matches)
+	}
+	matches = matches[from:]
+	if len(matches) > s.n {
+		matches = matches[:s.n]
+	}
+	return matches, nil
+}
+
+func bySource(matches []*search.DocumentMatch) map[int][]*search.DocumentMatch {
+	rv := make(map[int][]*search.DocumentMatch)
+	for _, match := range matches {
+		rv[match.SourceIndex] = append(rv[match.SourceIndex], match)
+	}
+	return rv
+}
+
+// rescoreSource scores the matches of one reader with the rescore
+// query, advancing its searcher to each match in turn, or with
+// the model, over the features of the matches
+func (s *TopNSearch) rescoreSource(reader search.Reader, config Config,
+	matches []*search.DocumentMatch) error {
+	rescores := make(map[*search.DocumentMatch]float64, len(matches))
+	explanations := make(map[*search.DocumentMatch]*search.Explanation, len(matches))
+	if s.rescorer.model != nil {
+		err := s.rescorer.features.extract(reader, config, matches)
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			rescores[match] = s.rescorer.model.Score(match.Features)
+			explanations[match] = search.NewExplanation(rescores[match], "model score")
+		}
+	} else {
+		err := scoreMatches(s.rescorer.query, reader, searchOptionsFromConfig(config, s.options),
+			sortedByNumber(matches), func(match, dm *search.DocumentMatch) {
+				rescores[match] = dm.Score
+				explanations[match] = dm.Explanation
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, match := range matches {
+		rescore, ok := rescores[match]
+		if !ok {
+			score := match.Score * s.rescorer.queryWeight
+			if s.options.ExplainScores {
+				match.Explanation = search.NewExplanation(score,
+					fmt.Sprintf("product of query score and weight %f, not matching the rescore query",
+						s.rescorer.queryWeight),
+					match.Explanation)
+			}
+			match.Score = score
+			continue
+		}
+		score := s.rescorer.combine(match.Score, rescore)
+		if s.options.ExplainScores {
+			match.Explanation = search.NewExplanation(score,
+				fmt.Sprintf("%s of weighted query and rescore query scores", s.rescorer.modeName()),
+				search.NewExplanation(match.Score*s.rescorer.queryWeight,
+					fmt.Sprintf("product of query score and weight %f", s.rescorer.queryWeight),
+					match.Explanation),
+				search.NewExplanation(rescore*s.rescorer.rescoreWeight,
+					fmt.Sprintf("product of rescore query score and weight %f", s.rescorer.rescoreWeight),
+					explanations[match]))
+		}
+		match.Score = score
+	}
+	return nil
+}
+
+// rescoredIterator iterates the matches in the order of their new scores
+type rescoredIterator struct {
+	search.DocumentMatchIterator
+	matches []*search.DocumentMatch
+	index   int
+}
+
+func (i *rescoredIterator) Next() (*search.DocumentMatch, error) {
+	if i.index < len(i.matches) {
+		rv := i.matches[i.index]
+		i.index++
+		return rv, nil
+	}
+	return nil, nil
+}
+
+func (i *rescoredIterator) TimedOut() bool {
+	pri, ok := i.DocumentMatchIterator.(search.PartialResultsIterator)
+	return ok && pri.TimedOut()
+}
+
+func (i *rescoredIterator) TerminatedEarly() bool {
+	pri, ok := i.DocumentMatchIterator.(search.PartialResultsIterator)
+	return ok && pri.TerminatedEarly()
+}
+
+func (i *rescoredIterator) CountIsLowerBound() bool {
+	pri, ok := i.DocumentMatchIterator.(search.PartialResultsIterator)
+	return ok && pri.CountIsLowerBound()
+}
@@ -0,0 +1,76 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bluge
+
+import (
+	"github.com/blugelabs/bluge/search"
+)
+
+// SearchResponse is a stable JSON form of the results of a search,
+// the total is the count aggregation, and the aggregations are
+// encoded by search.CalculatorJSON
+type SearchResponse struct {
+	Total             uint64                 `json:"total"`
+	TotalIsLowerBound bool                   `json:"total_is_lower_bound,omitempty"`
+	TimedOut          bool                   `json:"timed_out,omitempty"`
+	TerminatedEarly   bool                   `json:"terminated_early,omitempty"`
+	GroupCount        uint64                 `json:"group_count,omitempty"`
+	Hits              []*search.HitJSON      `json:"hits"`
+	Aggregations      map[string]interface{} `json:"aggregations,omitempty"`
+}
+
+// NewSearchResponse reads all the matches of the iterator, loading
+// their identifiers and the values of the stored fields of the format,
+// the identifiers are loaded from the _id field, unless the format
+// names another field.  A search whose aggregations failed fails.
+func NewSearchResponse(dmi search.DocumentMatchIterator, format search.HitJSONFormat) (*SearchResponse, error) {
+	if format.IDField == "" {
+		format.IDField = _idField
+	}
+	rv := &SearchResponse{
+		Hits: []*search.HitJSON{},
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var hit *search.HitJSON
+		hit, err = search.NewHitJSON(next, &format)
+		if err != nil {
+			return nil, err
+		}
+		rv.Hits = append(rv.Hits, hit)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if pri, ok := dmi.(search.PartialResultsIterator); ok {
+		rv.TotalIsLowerBound = pri.CountIsLowerBound()
+		rv.TimedOut = pri.TimedOut()
+		rv.TerminatedEarly = pri.TerminatedEarly()
+	}
+	if cri, ok := dmi.(search.CollapsedResultsIterator); ok {
+		rv.GroupCount = cri.GroupCount()
+	}
+	if bucket := dmi.Aggregations(); bucket != nil {
+		if err = bucket.Err(); err != nil {
+			return nil, err
+		}
+		bucketJSON := bucket.JSON()
+		rv.Total = bucketJSON.Count
+		rv.Aggregations = bucketJSON.Aggregations
+	}
+	return rv, nil
+}
@@ -15,6 +15,8 @@
 package bluge
 
 import (
+	"fmt"
+
 	"github.com/blugelabs/bluge/search"
 	"github.com/blugelabs/bluge/search/aggregations"
 	"github.com/blugelabs/bluge/search/collector"
@@ -64,6 +66,17 @@ type TopNSearch struct {
 	sort     search.SortOrder
 	after    [][]byte
 	reversed bool
+
+	allowPartialResults bool
+	terminateAfter      int
+	trackTotalHits      int
+
+	collapseField string
+	innerHitsSize int
+	innerHitsSort search.SortOrder
+
+	rescorer   *Rescorer
+	featureLog *FeatureSet
 }
 
 // NewTopNSearch creates a search which will find the matches and return the first N when ordered by the
@@ -78,6 +91,7 @@ func NewTopNSearch(n int, q Query) *TopNSearch {
 		sort: search.SortOrder{
 			search.SortBy(search.DocumentScore()).Desc(),
 		},
+		trackTotalHits: collector.TrackTotalHitsExact,
 	}
 }
 
@@ -166,33 +180,231 @@ func (s *TopNSearch) SetScore(mode string) *TopNSearch {
 	return s
 }
 
+// AllowPartialResults returns the matches and aggregations collected
+// so far, instead of an error, when the deadline of the search context
+// passes.  The returned iterator reports TimedOut in this case.
+func (s *TopNSearch) AllowPartialResults() *TopNSearch {
+	s.allowPartialResults = true
+	return s
+}
+
+// TerminateAfter stops the search once n matches have been collected.
+// The returned iterator reports TerminatedEarly in this case.
+func (s *TopNSearch) TerminateAfter(n int) *TopNSearch {
+	s.terminateAfter = n
+	return s
+}
+
+// TrackTotalHitsExact counts every match, this is the default.
+func (s *TopNSearch) TrackTotalHitsExact() *TopNSearch {
+	s.trackTotalHits = collector.TrackTotalHitsExact
+	return s
+}
+
+// TrackTotalHitsUpTo counts matches exactly up to n, after that,
+// when sorting by descending score, matches which cannot make the
+// top N may be skipped.  Skipped matches are not seen by aggregations.
+// The returned iterator reports CountIsLowerBound if any were skipped.
+func (s *TopNSearch) TrackTotalHitsUpTo(n int) *TopNSearch {
+	s.trackTotalHits = n
+	return s
+}
+
+// DisableTrackTotalHits does not require any matches to be counted,
+// so that, when sorting by descending score, matches which cannot
+// make the top N may be skipped from the start.
+// Skipped matches are not seen by aggregations.
+func (s *TopNSearch) DisableTrackTotalHits() *TopNSearch {
+	s.trackTotalHits = collector.TrackTotalHitsDisabled
+	return s
+}
+
+// CollapseBy keeps only the best match of each group of matches
+// having the same document value for the field, matches without
+// a value forming a group of their own.  The search then returns
+// the best match of each of the top N groups, and the iterator
+// implements search.CollapsedResultsIterator to report the number
+// of groups.  The field must be indexed with document values.
+// Collapsed searches fail when they allow partial results, terminate
+// early or track fewer total hits, and cannot be paged using
+// After or Before, as a group may reappear through its other matches,
+// use SetFrom instead.
+func (s *TopNSearch) CollapseBy(field string) *TopNSearch {
+	s.collapseField = field
+	return s
+}
+
+// CollapseInnerHits also returns the top n matches of each group,
+// ordered by the provided sort order, or the order of the search
+// when empty, in the InnerHits of the best match of the group.
+// The inner hits are found by searching each group separately.
+func (s *TopNSearch) CollapseInnerHits(n int, order []string) *TopNSearch {
+	s.innerHitsSize = n
+	s.innerHitsSort = nil
+	if len(order) > 0 {
+		s.innerHitsSort = search.ParseSortOrderStrings(order)
+	}
+	return s
+}
+
+// Rescore scores the top matches again with the rescorer, which
+// sorts them by their new scores, before the first N are returned.
+// The window of matches rescored includes at least those returned.
+// Rescoring requires sorting by descending score, and is not
+// supported with After, Before or CollapseBy.  Aggregations
+// see the scores of the search query.
+func (s *TopNSearch) Rescore(rescorer *Rescorer) *TopNSearch {
+	s.rescorer = rescorer
+	return s
+}
+
+// LogFeatures sets the Features of each match returned to the
+// values of the features of the set, after any rescoring, so
+// that they may be logged to train ranking models
+func (s *TopNSearch) LogFeatures(features *FeatureSet) *TopNSearch {
+	s.featureLog = features
+	return s
+}
+
+func (s *TopNSearch) Searcher(i search.Reader, config Config) (search.Searcher, error) {
+	if s.collapseField != "" {
+		switch {
+		case s.after != nil:
+			return nil, fmt.Errorf("collapsed searches cannot be paged using after or before")
+		case s.allowPartialResults:
+			return nil, fmt.Errorf("collapsed searches cannot return partial results")
+		case s.terminateAfter > 0:
+			return nil, fmt.Errorf("collapsed searches cannot terminate early")
+		case s.trackTotalHits != collector.TrackTotalHitsExact:
+			return nil, fmt.Errorf("collapsed searches must track the total hits exactly")
+		}
+	}
+	return s.BaseSearch.Searcher(i, config)
+}
+
 func (s *TopNSearch) Collector() search.Collector {
+	collectorSort := s.sort
+	if s.after != nil && s.reversed {
+		// preserve original sort order in the request
+		collectorSort = s.sort.Copy()
+		collectorSort.Reverse()
+	}
+	if s.collapseField != "" {
+		return collector.NewCollapsingCollector(search.Field(s.collapseField), s.n, s.from, s.sort)
+	}
+
+	var rv *collector.TopNCollector
 	if s.after != nil {
-		collectorSort := s.sort
-		if s.reversed {
-			// preserve original sort order in the request
-			collectorSort = s.sort.Copy()
-			collectorSort.Reverse()
+		rv = collector.NewTopNCollectorAfter(s.n, collectorSort, s.after, s.reversed)
+	} else if s.rescorer != nil {
+		// collect the whole window, as rescoring may reorder it
+		window := s.from + s.n
+		if s.rescorer.window > window {
+			window = s.rescorer.window
 		}
-		rv := collector.NewTopNCollectorAfter(s.n, collectorSort, s.after, s.reversed)
-		return rv
+		rv = collector.NewTopNCollector(window, 0, s.sort)
+	} else {
+		rv = collector.NewTopNCollector(s.n, s.from, s.sort)
+	}
+	rv.SetAllowPartialResults(s.allowPartialResults)
+	rv.SetTerminateAfter(s.terminateAfter)
+	rv.SetTrackTotalHits(s.trackTotalHits)
+	return rv
+}
+
+// expand completes collapsed matches with their inner hits,
+// using searchFunc to search each group
+func (s *TopNSearch) expand(dmi search.DocumentMatchIterator,
+	searchFunc func(SearchRequest) (search.DocumentMatchIterator, error)) (search.DocumentMatchIterator, error) {
+	if s.collapseField == "" || s.innerHitsSize <= 0 {
+		return dmi, nil
+	}
+	rv := &expandedIterator{
+		DocumentMatchIterator: dmi,
+	}
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		var inner search.DocumentMatchIterator
+		inner, err = searchFunc(s.innerHitsSearch(next))
+		if err != nil {
+			return nil, err
+		}
+		var innerHit *search.DocumentMatch
+		innerHit, err = inner.Next()
+		for err == nil && innerHit != nil {
+			next.InnerHits = append(next.InnerHits, innerHit)
+			innerHit, err = inner.Next()
+		}
+		if err != nil {
+			return nil, err
+		}
+		rv.matches = append(rv.matches, next)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		return nil, err
 	}
-	return collector.NewTopNCollector(s.n, s.from, s.sort)
+	return rv, nil
+}
+
+// innerHitsSearch returns a search for the
+// top matches of the group of the match
+func (s *TopNSearch) innerHitsSearch(match *search.DocumentMatch) *TopNSearch {
+	group := NewBooleanQuery().AddMust(s.query)
+	if value := search.Field(s.collapseField).Value(match); value != nil {
+		group.AddMust(NewFilterQuery(NewTermQuery(string(value)).SetField(s.collapseField)).SetBoost(0))
+	} else {
+		group.AddMustNot(NewWildcardQuery("*").SetField(s.collapseField))
+	}
+	rv := NewTopNSearch(s.innerHitsSize, group)
+	rv.options = s.options
+	rv.sort = s.innerHitsSort
+	if rv.sort == nil {
+		rv.sort = s.sort
+	}
+	return rv
+}
+
+// expandedIterator iterates collapsed matches
+// which have been completed with their inner hits
+type expandedIterator struct {
+	search.DocumentMatchIterator
+	matches []*search.DocumentMatch
+	index   int
+}
+
+func (i *expandedIterator) Next() (*search.DocumentMatch, error) {
+	if i.index < len(i.matches) {
+		rv := i.matches[i.index]
+		i.index++
+		return rv, nil
+	}
+	return nil, nil
+}
+
+func (i *expandedIterator) GroupCount() uint64 {
+	if cri, ok := i.DocumentMatchIterator.(search.CollapsedResultsIterator); ok {
+		return cri.GroupCount()
+	}
+	return 0
+}
+
+// searchAggregations returns the aggregations of the request, those
+// which search the index using the same default field, analyzer and
+// context as the request
+func searchAggregations(req SearchRequest, config Config) search.Aggregations {
+	return req.Aggregations().WithSearcherOptions(searchOptionsFromConfig(config, SearchOptions{}))
 }
 
 func searchOptionsFromConfig(config Config, options SearchOptions) search.SearcherOptions {
 	return search.SearcherOptions{
-		SimilarityForField: func(field string) search.Similarity {
-			if pfs, ok := config.PerFieldSimilarity[field]; ok {
-				return pfs
-			}
-			return config.DefaultSimilarity
-		},
+		SimilarityForField: config.SimilarityForField,
 		DefaultSearchField: config.DefaultSearchField,
 		DefaultAnalyzer:    config.DefaultSearchAnalyzer,
 		Explain:            options.ExplainScores,
 		IncludeTermVectors: options.IncludeLocations,
 		Score:              options.Score,
+		Context:            config.searchContext,
 	}
 }
 
@@ -243,6 +455,7 @@ func (s *TopNSearch) AllMatches(i search.Reader, config Config) (search.Searcher
 		DefaultSearchField: config.DefaultSearchField,
 		Explain:            s.options.ExplainScores,
 		IncludeTermVectors: s.options.IncludeLocations,
+		Context:            config.searchContext,
 	})
 }
 
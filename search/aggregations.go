@@ -15,6 +15,9 @@
 package search
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -37,6 +40,35 @@ func (a Aggregations) Fields() []string {
 	return rv
 }
 
+// SearchingAggregation is implemented by Aggregations which search
+// the index, or whose sub-aggregations may, so they can be given
+// the options of the search, such as its default field and context
+type SearchingAggregation interface {
+	Aggregation
+	WithSearcherOptions(options SearcherOptions) Aggregation
+}
+
+// WithSearcherOptions returns the aggregations, those
+// searching the index using the options of the search
+func (a Aggregations) WithSearcherOptions(options SearcherOptions) Aggregations {
+	var rv Aggregations
+	for name, aggregation := range a {
+		if sa, ok := aggregation.(SearchingAggregation); ok {
+			if rv == nil {
+				rv = make(Aggregations, len(a))
+				for n, agg := range a {
+					rv[n] = agg
+				}
+			}
+			rv[name] = sa.WithSearcherOptions(options)
+		}
+	}
+	if rv == nil {
+		return a
+	}
+	return rv
+}
+
 type Calculator interface {
 	Consume(*DocumentMatch)
 	Finish()
@@ -58,6 +90,22 @@ type BucketCalculator interface {
 	Buckets() []*Bucket
 }
 
+// FallibleCalculator is implemented by Calculators which may fail
+// to calculate their results, a search fails with the error
+type FallibleCalculator interface {
+	Calculator
+	Err() error
+}
+
+// PipelineCalculator calculates its results from the results of
+// other aggregations, referenced by paths such as "histogram>sales",
+// once they are finished, rather than from the matches
+type PipelineCalculator interface {
+	Calculator
+	BucketsPaths() []string
+	Pipeline(name string, bucket *Bucket)
+}
+
 type Bucket struct {
 	name         string
 	aggregations map[string]Calculator
@@ -95,9 +143,63 @@ func (b *Bucket) Consume(d *DocumentMatch) {
 }
 
 func (b *Bucket) Finish() {
-	for _, aggCalc := range b.aggregations {
+	var pipelines []string
+	for name, aggCalc := range b.aggregations {
+		if _, ok := aggCalc.(PipelineCalculator); ok {
+			pipelines = append(pipelines, name)
+			continue
+		}
 		aggCalc.Finish()
 	}
+	if len(pipelines) > 0 {
+		b.runPipelines(pipelines)
+	}
+}
+
+// runPipelines runs the pipelines once the other aggregations are
+// finished, a pipeline using the results of another runs after it
+func (b *Bucket) runPipelines(names []string) {
+	sort.Strings(names)
+	done := make(map[string]bool, len(names))
+	var run func(name string)
+	run = func(name string) {
+		if _, seen := done[name]; seen {
+			return
+		}
+		done[name] = false
+		pipeline := b.aggregations[name].(PipelineCalculator)
+		for _, dependency := range names {
+			if dependency != name && pipelineUses(pipeline, dependency) {
+				run(dependency)
+			}
+		}
+		pipeline.Pipeline(name, b)
+		done[name] = true
+	}
+	for _, name := range names {
+		run(name)
+	}
+}
+
+func pipelineUses(pipeline PipelineCalculator, name string) bool {
+	for _, path := range pipeline.BucketsPaths() {
+		for _, element := range strings.FieldsFunc(path, isPathSeparator) {
+			if element == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '>' || r == '.'
+}
+
+// SetAggregation sets the calculator of the named aggregation,
+// typically to add the results of a pipeline to the bucket
+func (b *Bucket) SetAggregation(name string, calculator Calculator) {
+	b.aggregations[name] = calculator
 }
 
 func (b *Bucket) Aggregations() map[string]Calculator {
@@ -143,3 +245,28 @@ func (b *Bucket) Buckets(name string) []*Bucket {
 func (b *Bucket) Aggregation(name string) Calculator {
 	return b.aggregations[name]
 }
+
+// Err returns the first error of the aggregations of the bucket,
+// including those of the buckets of its bucket aggregations
+func (b *Bucket) Err() error {
+	names := make([]string, 0, len(b.aggregations))
+	for name := range b.aggregations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if calc, ok := b.aggregations[name].(FallibleCalculator); ok {
+			if err := calc.Err(); err != nil {
+				return fmt.Errorf("aggregation %s: %w", name, err)
+			}
+		}
+		if calc, ok := b.aggregations[name].(BucketCalculator); ok {
+			for _, bucket := range calc.Buckets() {
+				if err := bucket.Err(); err != nil {
+					return fmt.Errorf("aggregation %s: %w", name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -16,11 +16,15 @@ package aggregations
 
 import (
 	"math"
+	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
 	segment "github.com/blugelabs/bluge_segment_api"
 
 	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/numeric/geo"
 	"github.com/blugelabs/bluge/search"
 )
 
@@ -322,3 +326,856 @@ func buildTestBucketExpectations() *bucketExpectation {
 		},
 	}
 }
+
+func TestTopHitsMerge(t *testing.T) {
+	aggs := search.Aggregations{
+		"byType": NewTermsAggregation(search.Field("type"), 2),
+	}
+	byType := aggs["byType"].(*TermsAggregation)
+	byType.AddAggregation("oldest", TopHits(2).
+		SortBy(search.SortOrder{search.SortBy(search.Field("age")).Desc()}).
+		LoadDocumentValues("name"))
+	byType.AddAggregation("best", TopHits(3))
+
+	testDocs := buildTestDocs()
+	consume := func(name string, docs []*search.DocumentMatch) *search.Bucket {
+		bucket := search.NewBucket(name, aggs)
+		for _, doc := range docs {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket.Consume(doc)
+			// matches are reused by collectors
+			doc.Reset()
+		}
+		bucket.Finish()
+		return bucket
+	}
+	shard1 := consume("shard1", testDocs[0:5])
+	shard2 := consume("shard2", buildTestDocs()[5:])
+	shard1.Merge(shard2)
+
+	expected := map[string]map[string][]uint64{
+		"employee": {
+			"oldest": {7, 5},
+			"best":   {3, 9, 1},
+		},
+		"contractor": {
+			"oldest": {4, 8},
+			"best":   {4, 8},
+		},
+	}
+	for _, bucket := range shard1.Buckets("byType") {
+		for name, numbers := range expected[bucket.Name()] {
+			var actual []uint64
+			for _, hit := range bucket.Aggregation(name).(*TopHitsCalculator).Hits() {
+				actual = append(actual, hit.Number)
+			}
+			if !reflect.DeepEqual(numbers, actual) {
+				t.Errorf("expected %s %s hits %v, got %v", bucket.Name(), name, numbers, actual)
+			}
+		}
+	}
+
+	oldest := shard1.Buckets("byType")[0].Aggregation("oldest").(*TopHitsCalculator).Hits()[0]
+	if string(oldest.DocValues("name")[0]) != "gary" {
+		t.Errorf("expected name gary, got %s", oldest.DocValues("name"))
+	}
+}
+
+func bucketCounts(buckets []*search.Bucket) map[string]uint64 {
+	rv := make(map[string]uint64, len(buckets))
+	for _, bucket := range buckets {
+		rv[bucket.Name()] = bucket.Count()
+	}
+	return rv
+}
+
+func bucketNames(buckets []*search.Bucket) []string {
+	rv := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		rv[i] = bucket.Name()
+	}
+	return rv
+}
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		histogram *HistogramAggregation
+		names     []string
+		counts    map[string]uint64
+		maxAges   map[string]float64
+	}{
+		{
+			histogram: Histogram(search.Field("age"), 20).
+				AddAggregation("max_age", Max(search.Field("age"))),
+			names: []string{"0", "20", "40", "60", "80"},
+			counts: map[string]uint64{
+				"0": 4, "20": 3, "40": 1, "60": 1, "80": 1,
+			},
+			maxAges: map[string]float64{
+				"0": 16, "20": 39, "40": 48, "60": 63, "80": 95,
+			},
+		},
+		{
+			histogram: Histogram(search.Field("age"), 20).Offset(5),
+			names:     []string{"-15", "5", "25", "45", "65", "85"},
+			counts: map[string]uint64{
+				"-15": 2, "5": 2, "25": 3, "45": 2, "65": 0, "85": 1,
+			},
+		},
+		{
+			histogram: Histogram(search.Field("age"), 20).Offset(5).MinDocCount(2),
+			names:     []string{"-15", "5", "25", "45"},
+			counts: map[string]uint64{
+				"-15": 2, "5": 2, "25": 3, "45": 2,
+			},
+		},
+		{
+			histogram: Histogram(search.Field("age"), 50).ExtendedBounds(-60, 160),
+			names:     []string{"-100", "-50", "0", "50", "100", "150"},
+			counts: map[string]uint64{
+				"-100": 0, "-50": 0, "0": 8, "50": 2, "100": 0, "150": 0,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		aggs := search.Aggregations{
+			"histogram": test.histogram,
+		}
+		// split the docs, to check the buckets merge
+		shard1 := search.NewBucket("shard1", aggs)
+		shard2 := search.NewBucket("shard2", aggs)
+		for i, doc := range buildTestDocs() {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i%2 == 0 {
+				shard1.Consume(doc)
+			} else {
+				shard2.Consume(doc)
+			}
+		}
+		shard1.Finish()
+		shard2.Finish()
+		merged := search.NewBucket("merged", aggs)
+		merged.Merge(shard1)
+		merged.Merge(shard2)
+		merged.Finish()
+
+		buckets := merged.Buckets("histogram")
+		if !reflect.DeepEqual(test.names, bucketNames(buckets)) {
+			t.Errorf("expected buckets %v, got %v", test.names, bucketNames(buckets))
+		}
+		if !reflect.DeepEqual(test.counts, bucketCounts(buckets)) {
+			t.Errorf("expected counts %v, got %v", test.counts, bucketCounts(buckets))
+		}
+		for _, bucket := range buckets {
+			if maxAge, ok := test.maxAges[bucket.Name()]; ok && bucket.Metric("max_age") != maxAge {
+				t.Errorf("expected max age %f in bucket %s, got %f", maxAge, bucket.Name(), bucket.Metric("max_age"))
+			}
+		}
+	}
+}
+
+func TestHistogramLimits(t *testing.T) {
+	tests := []struct {
+		histogram search.Aggregation
+		buckets   int
+		err       bool
+	}{
+		{
+			histogram: Histogram(search.Field("age"), 0),
+			err:       true,
+		},
+		{
+			histogram: Histogram(search.Field("age"), -1),
+			err:       true,
+		},
+		{
+			histogram: DateHistogram(search.Field("age"), 0),
+			err:       true,
+		},
+		{
+			histogram: Composite(10, HistogramSource("age", Histogram(search.Field("age"), 0))),
+			err:       true,
+		},
+		{
+			// the keys of the buckets would overflow
+			histogram: Histogram(search.Field("age"), 1e-300),
+		},
+		{
+			// too many empty buckets in between
+			histogram: Histogram(search.Field("age"), 1).MaxBuckets(10),
+			err:       true,
+		},
+		{
+			// too many buckets of matches
+			histogram: Histogram(search.Field("age"), 1).MinDocCount(1).MaxBuckets(5),
+			err:       true,
+		},
+		{
+			histogram: Histogram(search.Field("age"), 1).MinDocCount(1).MaxBuckets(10),
+			buckets:   10,
+		},
+	}
+
+	for _, test := range tests {
+		aggs := search.Aggregations{
+			"histogram": test.histogram,
+		}
+		bucket := search.NewBucket("", aggs)
+		for _, doc := range buildTestDocs() {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket.Consume(doc)
+		}
+		bucket.Finish()
+
+		if err := bucket.Err(); (err != nil) != test.err {
+			t.Errorf("expected error %t, got %v", test.err, err)
+		}
+		if !test.err && len(bucket.Buckets("histogram")) != test.buckets {
+			t.Errorf("expected %d buckets, got %d", test.buckets, len(bucket.Buckets("histogram")))
+		}
+	}
+}
+
+func TestDateHistogram(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+
+	// clocks went back from 3am to 2am on the 27th of October 2019
+	dates := []time.Time{
+		time.Date(2019, 10, 26, 23, 30, 0, 0, amsterdam),
+		time.Date(2019, 10, 27, 0, 30, 0, 0, time.UTC), // 2:30 before clocks went back
+		time.Date(2019, 10, 27, 1, 30, 0, 0, time.UTC), // 2:30 after clocks went back
+		time.Date(2019, 10, 27, 23, 30, 0, 0, amsterdam),
+		time.Date(2019, 12, 31, 23, 30, 0, 0, amsterdam),
+		time.Date(2020, 1, 1, 0, 30, 0, 0, amsterdam),
+	}
+
+	tests := []struct {
+		histogram *DateHistogramAggregation
+		dates     []time.Time
+		names     []string
+		counts    map[string]uint64
+	}{
+		{
+			histogram: CalendarDateHistogram(search.Field("date"), Month).InLocation(amsterdam),
+			names: []string{
+				"2019-10-01T00:00:00+02:00",
+				"2019-11-01T00:00:00+01:00",
+				"2019-12-01T00:00:00+01:00",
+				"2020-01-01T00:00:00+01:00",
+			},
+			counts: map[string]uint64{
+				"2019-10-01T00:00:00+02:00": 4,
+				"2019-11-01T00:00:00+01:00": 0,
+				"2019-12-01T00:00:00+01:00": 1,
+				"2020-01-01T00:00:00+01:00": 1,
+			},
+		},
+		{
+			// in UTC, the last day of the year is still 2019
+			histogram: CalendarDateHistogram(search.Field("date"), Year),
+			names: []string{
+				"2019-01-01T00:00:00Z",
+			},
+			counts: map[string]uint64{
+				"2019-01-01T00:00:00Z": 6,
+			},
+		},
+		{
+			// the day the clocks went back lasts 25 hours
+			histogram: CalendarDateHistogram(search.Field("date"), Day).InLocation(amsterdam).MinDocCount(1),
+			names: []string{
+				"2019-10-26T00:00:00+02:00",
+				"2019-10-27T00:00:00+02:00",
+				"2019-12-31T00:00:00+01:00",
+				"2020-01-01T00:00:00+01:00",
+			},
+			counts: map[string]uint64{
+				"2019-10-26T00:00:00+02:00": 1,
+				"2019-10-27T00:00:00+02:00": 3,
+				"2019-12-31T00:00:00+01:00": 1,
+				"2020-01-01T00:00:00+01:00": 1,
+			},
+		},
+		{
+			// 2am happens twice
+			histogram: CalendarDateHistogram(search.Field("date"), Hour).InLocation(amsterdam).MinDocCount(1),
+			names: []string{
+				"2019-10-26T23:00:00+02:00",
+				"2019-10-27T02:00:00+02:00",
+				"2019-10-27T02:00:00+01:00",
+				"2019-10-27T23:00:00+01:00",
+				"2019-12-31T23:00:00+01:00",
+				"2020-01-01T00:00:00+01:00",
+			},
+		},
+		{
+			// days starting at 6am, with extended bounds
+			histogram: DateHistogram(search.Field("date"), 24*time.Hour).Offset(6*time.Hour).
+				ExtendedBounds(time.Date(2019, 10, 25, 12, 0, 0, 0, time.UTC), time.Date(2019, 10, 28, 12, 0, 0, 0, time.UTC)).
+				MinDocCount(0),
+			dates: dates[:4],
+			names: []string{
+				"2019-10-25T06:00:00Z",
+				"2019-10-26T06:00:00Z",
+				"2019-10-27T06:00:00Z",
+				"2019-10-28T06:00:00Z",
+			},
+			counts: map[string]uint64{
+				"2019-10-25T06:00:00Z": 0,
+				"2019-10-26T06:00:00Z": 3,
+				"2019-10-27T06:00:00Z": 1,
+				"2019-10-28T06:00:00Z": 0,
+			},
+		},
+		{
+			// weeks of a fixed duration start on Thursday, as the epoch does
+			histogram: DateHistogram(search.Field("date"), 7*24*time.Hour),
+			dates:     dates[:1],
+			names: []string{
+				"2019-10-24T00:00:00Z",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if test.dates == nil {
+			test.dates = dates
+		}
+		aggs := search.Aggregations{
+			"histogram": test.histogram,
+		}
+		bucket := search.NewBucket("", aggs)
+		for _, date := range test.dates {
+			doc := newDocumentMatch(0, 1, map[string][]byte{
+				"date": numeric.MustNewPrefixCodedInt64(date.UnixNano(), 0),
+			})
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket.Consume(doc)
+		}
+		bucket.Finish()
+
+		buckets := bucket.Buckets("histogram")
+		if !reflect.DeepEqual(test.names, bucketNames(buckets)) {
+			t.Errorf("expected buckets %v, got %v", test.names, bucketNames(buckets))
+		}
+		if test.counts != nil && !reflect.DeepEqual(test.counts, bucketCounts(buckets)) {
+			t.Errorf("expected counts %v, got %v", test.counts, bucketCounts(buckets))
+		}
+	}
+}
+
+func newGeoDocumentMatches(points ...geo.Point) []*search.DocumentMatch {
+	rv := make([]*search.DocumentMatch, len(points))
+	for i, point := range points {
+		rv[i] = newDocumentMatch(uint64(i), 1, map[string][]byte{
+			"location": numeric.MustNewPrefixCodedInt64(int64(geo.MortonHash(point.Lon, point.Lat)), 0),
+		})
+	}
+	return rv
+}
+
+func TestGeoAggregations(t *testing.T) {
+	amsterdam := geo.Point{Lon: 4.9, Lat: 52.37}
+	london := geo.Point{Lon: -0.12, Lat: 51.5}
+	newYork := geo.Point{Lon: -74, Lat: 40.7}
+	tokyo := geo.Point{Lon: 139.7, Lat: 35.7}
+
+	aggs := search.Aggregations{
+		"geohash": GeohashGrid(search.Field("location"), 1),
+		"geotile": GeotileGrid(search.Field("location"), 1),
+		"bounds":  GeoBounds(search.Field("location")),
+	}
+	rings, err := GeoDistance(search.Field("location"), amsterdam, "km")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rings.AddRange(NamedRange("near", 0, 500)).
+		AddRange(NamedRange("far", 500, 10000)).
+		AddAggregation("centroid", GeoCentroid(search.Field("location")))
+	aggs.Add("rings", rings)
+	if _, err = GeoDistance(search.Field("location"), amsterdam, "parsecs"); err == nil {
+		t.Errorf("expected error for unknown unit")
+	}
+
+	bucket := search.NewBucket("", aggs)
+	for _, doc := range newGeoDocumentMatches(amsterdam, london, newYork, tokyo) {
+		err = doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket.Consume(doc)
+	}
+	bucket.Finish()
+
+	expectedCounts := map[string]map[string]uint64{
+		"geohash": {"u": 1, "g": 1, "d": 1, "x": 1},
+		"geotile": {"1/0/0": 2, "1/1/0": 2},
+		"rings":   {"near": 2, "far": 2},
+	}
+	for name, expected := range expectedCounts {
+		if actual := bucketCounts(bucket.Buckets(name)); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected %s counts %v, got %v", name, expected, actual)
+		}
+	}
+
+	near := bucket.Buckets("rings")[0].Aggregation("centroid").(*GeoCentroidCalculator).Centroid()
+	assertPoint(t, &geo.Point{Lon: (4.9 - 0.12) / 2, Lat: (52.37 + 51.5) / 2}, near)
+
+	bounds := bucket.Aggregation("bounds").(*GeoBoundsCalculator)
+	assertPoint(t, &geo.Point{Lon: -74, Lat: 52.37}, bounds.TopLeft())
+	assertPoint(t, &geo.Point{Lon: 139.7, Lat: 35.7}, bounds.BottomRight())
+
+	// the box around fiji crosses the date line, unless prevented
+	for _, wrap := range []bool{true, false} {
+		bounds = GeoBounds(search.Field("location")).WrapLongitude(wrap).Calculator().(*GeoBoundsCalculator)
+		for _, doc := range newGeoDocumentMatches(geo.Point{Lon: 179, Lat: -17}, geo.Point{Lon: -179, Lat: -16}) {
+			err = doc.LoadDocumentValues(search.NewSearchContext(0, 0), []string{"location"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds.Consume(doc)
+		}
+		left, right := -179.0, 179.0
+		if wrap {
+			left, right = right, left
+		}
+		assertPoint(t, &geo.Point{Lon: left, Lat: -16}, bounds.TopLeft())
+		assertPoint(t, &geo.Point{Lon: right, Lat: -17}, bounds.BottomRight())
+	}
+}
+
+func assertPoint(t *testing.T, expected, actual *geo.Point) {
+	t.Helper()
+	if actual == nil || math.Abs(expected.Lon-actual.Lon) > 1e-6 || math.Abs(expected.Lat-actual.Lat) > 1e-6 {
+		t.Errorf("expected point %v, got %v", expected, actual)
+	}
+}
+
+func TestPipelineAggregations(t *testing.T) {
+	aggs := search.Aggregations{
+		"byAge": Histogram(search.Field("age"), 20).
+			AddAggregation("total", Sum(search.Field("age"))),
+		"deriv":     Derivative("byAge>total"),
+		"cum":       CumulativeSum("byAge>total"),
+		"cum_deriv": Derivative("byAge>cum"),
+		"moving":    MovingFunction("byAge>_count", 2, UnweightedAvg),
+		"ratio": BucketScript(map[string]string{"t": "byAge>total", "c": "byAge>_count"},
+			func(vars map[string]float64) float64 {
+				return vars["t"] / vars["c"]
+			}),
+		"selected": BucketSelector(map[string]string{"c": "byAge>_count"},
+			func(vars map[string]float64) bool {
+				return vars["c"] >= 2
+			}),
+		"sorted":    BucketSort("byAge").SortBy("total", true).From(1).Size(2),
+		"max_total": MaxBucket("byAge>total"),
+		"avg_count": AvgBucket("byAge>_count"),
+	}
+
+	// split the docs, to check the pipelines run again once merged
+	shard1 := search.NewBucket("shard1", aggs)
+	shard2 := search.NewBucket("shard2", aggs)
+	for i, doc := range buildTestDocs() {
+		err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 {
+			shard1.Consume(doc)
+		} else {
+			shard2.Consume(doc)
+		}
+	}
+	shard1.Finish()
+	shard2.Finish()
+	merged := search.NewBucket("merged", aggs)
+	merged.Merge(shard1)
+	merged.Merge(shard2)
+	merged.Finish()
+
+	nan := math.NaN()
+	expected := map[string][]float64{
+		"total":     {32, 96, 48, 63, 95},
+		"deriv":     {nan, 64, -48, 15, 32},
+		"cum":       {32, 128, 176, 239, 334},
+		"cum_deriv": {nan, 96, 48, 63, 95},
+		"moving":    {nan, 4, 3.5, 2, 1},
+		"ratio":     {8, 32, 48, 63, 95},
+	}
+	buckets := merged.Buckets("byAge")
+	if len(buckets) != 5 {
+		t.Fatalf("expected 5 buckets, got %d", len(buckets))
+	}
+	for name, values := range expected {
+		for i, value := range values {
+			actual := buckets[i].Metric(name)
+			if actual != value && !(math.IsNaN(actual) && math.IsNaN(value)) {
+				t.Errorf("expected %s of bucket %s to be %f, got %f", name, buckets[i].Name(), value, actual)
+			}
+		}
+	}
+
+	if names := bucketNames(merged.Buckets("selected")); !reflect.DeepEqual(names, []string{"0", "20"}) {
+		t.Errorf("expected selected buckets [0 20], got %v", names)
+	}
+	if names := bucketNames(merged.Buckets("sorted")); !reflect.DeepEqual(names, []string{"80", "60"}) {
+		t.Errorf("expected sorted buckets [80 60], got %v", names)
+	}
+	maxTotal := merged.Aggregation("max_total").(*BucketMetricCalculator)
+	if maxTotal.Value() != 96 || !reflect.DeepEqual(maxTotal.Keys(), []string{"20"}) {
+		t.Errorf("expected max total 96 in bucket 20, got %f in %v", maxTotal.Value(), maxTotal.Keys())
+	}
+	if merged.Metric("avg_count") != 2 {
+		t.Errorf("expected average count 2, got %f", merged.Metric("avg_count"))
+	}
+}
+
+// singleBucketAggregation puts all the matches into a single bucket
+type singleBucketAggregation map[string]search.Aggregation
+
+func (a singleBucketAggregation) Fields() []string {
+	return search.Aggregations(a).Fields()
+}
+
+func (a singleBucketAggregation) Calculator() search.Calculator {
+	return &singleBucketTestCalculator{
+		bucket: search.NewBucket("single", a),
+	}
+}
+
+type singleBucketTestCalculator struct {
+	bucket *search.Bucket
+}
+
+func (c *singleBucketTestCalculator) Consume(d *search.DocumentMatch) {
+	c.bucket.Consume(d)
+}
+
+func (c *singleBucketTestCalculator) Finish() {
+	c.bucket.Finish()
+}
+
+func (c *singleBucketTestCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*singleBucketTestCalculator); ok {
+		c.bucket.Merge(other.bucket)
+	}
+}
+
+func (c *singleBucketTestCalculator) Bucket() *search.Bucket {
+	return c.bucket
+}
+
+func TestPipelineBucketsPaths(t *testing.T) {
+	byAge := func(aggs search.Aggregations) *HistogramAggregation {
+		rv := Histogram(search.Field("age"), 20)
+		for name, agg := range aggs {
+			rv.AddAggregation(name, agg)
+		}
+		return rv
+	}
+	tests := []struct {
+		aggs search.Aggregations
+		err  bool
+	}{
+		{
+			// through single buckets, to the histogram, and to the metric
+			aggs: search.Aggregations{
+				"all": singleBucketAggregation{
+					"byAge": byAge(search.Aggregations{
+						"all": singleBucketAggregation{
+							"total": Sum(search.Field("age")),
+						},
+					}),
+				},
+				"max_total": MaxBucket("all>byAge>all>total"),
+				"sorted":    BucketSort("all>byAge").SortBy("all>total", true).Size(1),
+			},
+		},
+		{
+			aggs: search.Aggregations{
+				"byAge":  byAge(nil),
+				"moving": MovingFunction("byAge>_count", -1, UnweightedAvg),
+			},
+			err: true,
+		},
+		{
+			aggs: search.Aggregations{
+				"byAge":  byAge(nil),
+				"sorted": BucketSort("byAge").From(-1),
+			},
+			err: true,
+		},
+		{
+			aggs: search.Aggregations{
+				"byAge":  byAge(nil),
+				"sorted": BucketSort("byAge").Size(-1),
+			},
+			err: true,
+		},
+		{
+			aggs: search.Aggregations{
+				"max_total": MaxBucket("missing>total"),
+			},
+			err: true,
+		},
+		{
+			// a pipeline cannot use the buckets of its parent
+			aggs: search.Aggregations{
+				"byAge": byAge(search.Aggregations{
+					"total": Sum(search.Field("age")),
+					"deriv": Derivative("total"),
+				}),
+			},
+			err: true,
+		},
+	}
+
+	for i, test := range tests {
+		bucket := search.NewBucket("", test.aggs)
+		for _, doc := range buildTestDocs() {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), test.aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucket.Consume(doc)
+		}
+		bucket.Finish()
+
+		err := bucket.Err()
+		if (err != nil) != test.err {
+			t.Errorf("test %d: expected error %t, got %v", i, test.err, err)
+		}
+		if maxTotal, ok := bucket.Aggregation("max_total").(*BucketMetricCalculator); ok && !test.err {
+			if maxTotal.Value() != 96 || !reflect.DeepEqual(maxTotal.Keys(), []string{"20"}) {
+				t.Errorf("expected max total 96 in bucket 20, got %f in %v", maxTotal.Value(), maxTotal.Keys())
+			}
+			if names := bucketNames(bucket.Buckets("sorted")); !reflect.DeepEqual(names, []string{"20"}) {
+				t.Errorf("expected sorted buckets [20], got %v", names)
+			}
+		}
+	}
+}
+
+func TestStatsMerge(t *testing.T) {
+	aggs := search.Aggregations{
+		"stats": ExtendedStats(search.Field("age")).Sigma(3),
+		"byAge": Histogram(search.Field("age"), 50).
+			AddAggregation("stats", Stats(search.Field("age"))),
+		"max_avg": MaxBucket("byAge>stats.avg"),
+	}
+
+	// merge unevenly split shards, including an empty one
+	testDocs := buildTestDocs()
+	merged := search.NewBucket("merged", aggs)
+	for _, docs := range [][]*search.DocumentMatch{testDocs[:3], nil, testDocs[3:]} {
+		shard := search.NewBucket("shard", aggs)
+		for _, doc := range docs {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			shard.Consume(doc)
+		}
+		shard.Finish()
+		merged.Merge(shard)
+	}
+	merged.Finish()
+
+	ages := []float64{1, 25, 16, 32, 48, 63, 4, 95, 39, 11}
+	var sum, sumOfSquares float64
+	for _, age := range ages {
+		sum += age
+		sumOfSquares += age * age
+	}
+	avg := sum / float64(len(ages))
+	variance := sumOfSquares/float64(len(ages)) - avg*avg
+	varianceSampling := variance * float64(len(ages)) / float64(len(ages)-1)
+
+	stats := merged.Aggregation("stats").(*StatsCalculator)
+	if stats.Count() != 10 {
+		t.Errorf("expected count 10, got %d", stats.Count())
+	}
+	upper, lower := stats.StdDeviationBounds()
+	for name, values := range map[string][2]float64{
+		"min":                    {1, stats.Min()},
+		"max":                    {95, stats.Max()},
+		"sum":                    {sum, stats.Sum()},
+		"avg":                    {avg, stats.Avg()},
+		"sum_of_squares":         {sumOfSquares, stats.SumOfSquares()},
+		"variance":               {variance, stats.Variance()},
+		"variance_sampling":      {varianceSampling, stats.VarianceSampling()},
+		"std_deviation":          {math.Sqrt(variance), stats.StdDeviation()},
+		"std_deviation_sampling": {math.Sqrt(varianceSampling), stats.StdDeviationSampling()},
+		"std_upper":              {avg + 3*math.Sqrt(variance), upper},
+		"std_lower":              {avg - 3*math.Sqrt(variance), lower},
+	} {
+		if math.Abs(values[0]-values[1]) > 1e-9 {
+			t.Errorf("expected %s %f, got %f", name, values[0], values[1])
+		}
+		if math.Abs(values[0]-stats.ValueOf(name)) > 1e-9 {
+			t.Errorf("expected %s by name %f, got %f", name, values[0], stats.ValueOf(name))
+		}
+	}
+
+	// buckets 0 [1 25 16 32 48 4 39 11] and 50 [63 95]
+	if merged.Metric("max_avg") != 79 {
+		t.Errorf("expected max bucket avg 79, got %f", merged.Metric("max_avg"))
+	}
+
+	empty := Stats(search.Field("age")).Calculator().(*StatsCalculator)
+	if !math.IsNaN(empty.Avg()) || !math.IsNaN(empty.Min()) || !math.IsNaN(empty.Variance()) {
+		t.Errorf("expected NaN stats without values, got avg %f min %f variance %f",
+			empty.Avg(), empty.Min(), empty.Variance())
+	}
+}
+
+func TestStatsNumericallyStable(t *testing.T) {
+	calc := Stats(search.Field("val")).Calculator().(*StatsCalculator)
+	other := Stats(search.Field("val")).Calculator().(*StatsCalculator)
+	for i, val := range []float64{4, 7, 13, 16} {
+		doc := newDocumentMatch(uint64(i), 1, map[string][]byte{
+			"val": numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(1e9+val), 0),
+		})
+		err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), []string{"val"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			calc.Consume(doc)
+		} else {
+			other.Consume(doc)
+		}
+	}
+	calc.Merge(other)
+	if math.Abs(calc.VarianceSampling()-30) > 1e-6 {
+		t.Errorf("expected sample variance 30, got %f", calc.VarianceSampling())
+	}
+}
+
+func TestTermsOptions(t *testing.T) {
+	byMaxAge := NewTermsAggregation(search.Field("name"), 3).SortBy("max_age", true)
+	byMaxAge.AddAggregation("max_age", Max(search.Field("age")))
+	aggs := search.Aggregations{
+		"byKey":       NewTermsAggregation(search.Field("name"), 3).SortBy("_key", false),
+		"byMaxAge":    byMaxAge,
+		"minDocCount": NewTermsAggregation(search.Field("name"), 10).MinDocCount(2),
+		"filtered": NewTermsAggregation(search.Field("name"), 10).
+			Include(regexp.MustCompile("^j")).
+			IncludeTerms("carol").
+			ExcludeTerms("judy"),
+		"trimmed":    NewTermsAggregation(search.Field("name"), 1),
+		"oversample": NewTermsAggregation(search.Field("name"), 1).ShardSize(10),
+	}
+
+	// split the docs, so that john is trimmed from the first shard
+	testDocs := buildTestDocs()
+	merged := search.NewBucket("merged", aggs)
+	for _, docs := range [][]*search.DocumentMatch{testDocs[:5], testDocs[5:]} {
+		shard := search.NewBucket("shard", aggs)
+		for _, doc := range docs {
+			err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			shard.Consume(doc)
+		}
+		shard.Finish()
+		merged.Merge(shard)
+	}
+	merged.Finish()
+
+	expected := map[string][]string{
+		"byKey":       {"barbara", "carol", "dale"},
+		"byMaxAge":    {"gary", "donna", "judy"},
+		"minDocCount": {"john", "barbara"},
+		"filtered":    {"john", "carol"},
+		"trimmed":     {"barbara"},
+		"oversample":  {"john"},
+	}
+	for name, names := range expected {
+		if actual := bucketNames(merged.Buckets(name)); !reflect.DeepEqual(actual, names) {
+			t.Errorf("expected %s buckets %v, got %v", name, names, actual)
+		}
+	}
+
+	// john, trimmed from the first shard, may have 1 more than its 2 once merged
+	trimmed := merged.Aggregation("trimmed").(*TermsCalculator)
+	if trimmed.ErrorBound() != 3 {
+		t.Errorf("expected error bound 3, got %d", trimmed.ErrorBound())
+	}
+	if bound := trimmed.BucketErrorBound(trimmed.Buckets()[0]); bound != 1 {
+		t.Errorf("expected barbara error bound 1, got %d", bound)
+	}
+	oversample := merged.Aggregation("oversample").(*TermsCalculator)
+	if oversample.ErrorBound() != 0 || oversample.Buckets()[0].Count() != 3 {
+		t.Errorf("expected exact count 3, got %d with error bound %d",
+			oversample.Buckets()[0].Count(), oversample.ErrorBound())
+	}
+	if oversample.Other() != 7 {
+		t.Errorf("expected other 7, got %d", oversample.Other())
+	}
+}
+
+func TestSortByMissingMetric(t *testing.T) {
+	rating := func(val float64) []byte {
+		return numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(val), 0)
+	}
+	// bob has no rating, so no average rating
+	docs := []*search.DocumentMatch{
+		newDocumentMatch(0, 1, map[string][]byte{"name": []byte("alice"), "rating": rating(5)}),
+		newDocumentMatch(1, 1, map[string][]byte{"name": []byte("bob")}),
+		newDocumentMatch(2, 1, map[string][]byte{"name": []byte("carol"), "rating": rating(2)}),
+	}
+
+	terms := func(desc bool) *TermsAggregation {
+		rv := NewTermsAggregation(search.Field("name"), 10).SortBy("best", desc)
+		rv.AddAggregation("best", Avg(search.Field("rating")))
+		return rv
+	}
+	byName := NewTermsAggregation(search.Field("name"), 10)
+	byName.AddAggregation("best", Avg(search.Field("rating")))
+	aggs := search.Aggregations{
+		"desc":       terms(true),
+		"asc":        terms(false),
+		"byName":     byName,
+		"sortedDesc": BucketSort("byName").SortBy("best", true),
+		"sortedAsc":  BucketSort("byName").SortBy("best", false),
+	}
+	bucket := search.NewBucket("", aggs)
+	for _, doc := range docs {
+		err := doc.LoadDocumentValues(search.NewSearchContext(0, 0), aggs.Fields())
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket.Consume(doc)
+	}
+	bucket.Finish()
+
+	expected := map[string][]string{
+		"desc":       {"alice", "carol", "bob"},
+		"asc":        {"carol", "alice", "bob"},
+		"sortedDesc": {"alice", "carol", "bob"},
+		"sortedAsc":  {"carol", "alice", "bob"},
+	}
+	for name, names := range expected {
+		if actual := bucketNames(bucket.Buckets(name)); !reflect.DeepEqual(actual, names) {
+			t.Errorf("expected %s buckets %v, got %v", name, names, actual)
+		}
+	}
+}
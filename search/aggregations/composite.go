@@ -0,0 +1,481 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/search"
+)
+
+// CompositeSource provides one of the values of the
+// key of the buckets of a composite aggregation
+type CompositeSource interface {
+	Name() string
+	Fields() []string
+
+	// Keys returns the values of the match, as
+	// bytes which sort in the order of the buckets
+	Keys(d *search.DocumentMatch) [][]byte
+
+	// Format returns the value shown in the key of a bucket
+	Format(key []byte) string
+
+	// Parse returns the bytes of a value shown in the key
+	// of a bucket, to page through the buckets after it
+	Parse(value string) ([]byte, error)
+}
+
+// CompositeAggregation builds a bucket for every combination of the
+// values of its sources, in the order of these values.  Only the
+// first 'size' buckets are kept, the following buckets are found
+// by searching again, after the key of the last bucket.
+type CompositeAggregation struct {
+	size    int
+	sources []CompositeSource
+	after   [][]byte
+
+	aggregations map[string]search.Aggregation
+}
+
+func Composite(size int, sources ...CompositeSource) *CompositeAggregation {
+	return &CompositeAggregation{
+		size:    size,
+		sources: sources,
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+// After only keeps the buckets after the provided key,
+// typically the AfterKey of the previous page
+func (a *CompositeAggregation) After(key map[string]string) error {
+	after := make([][]byte, len(a.sources))
+	for i, source := range a.sources {
+		value, ok := key[source.Name()]
+		if !ok {
+			return fmt.Errorf("after key is missing source '%s'", source.Name())
+		}
+		var err error
+		after[i], err = source.Parse(value)
+		if err != nil {
+			return fmt.Errorf("error parsing after key of source '%s': %w", source.Name(), err)
+		}
+	}
+	a.after = after
+	return nil
+}
+
+func (a *CompositeAggregation) AddAggregation(name string, agg search.Aggregation) *CompositeAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (a *CompositeAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *CompositeAggregation) Fields() []string {
+	var rv []string
+	for _, source := range a.sources {
+		rv = append(rv, source.Fields()...)
+	}
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+// validatingSource is implemented by sources
+// whose configuration may be invalid
+type validatingSource interface {
+	validate() error
+}
+
+func (a *CompositeAggregation) Calculator() search.Calculator {
+	rv := &CompositeCalculator{
+		agg:     a,
+		buckets: make(map[string]*compositeBucket),
+	}
+	for _, source := range a.sources {
+		if vs, ok := source.(validatingSource); ok && rv.err == nil {
+			if err := vs.validate(); err != nil {
+				rv.err = fmt.Errorf("invalid source '%s': %w", source.Name(), err)
+			}
+		}
+	}
+	return rv
+}
+
+type compositeBucket struct {
+	key    [][]byte
+	bucket *search.Bucket
+}
+
+// CompositeCalculator keeps the buckets with the lowest keys,
+// once more than twice as many are found, the buckets with the
+// highest keys are dropped, and later keys ignored
+type CompositeCalculator struct {
+	agg *CompositeAggregation
+
+	buckets map[string]*compositeBucket
+	// once buckets are dropped, keys above the bound are ignored
+	bound [][]byte
+
+	bucketsList []*search.Bucket
+	keys        map[*search.Bucket][][]byte
+
+	matchKeys [][][]byte
+	err       error
+}
+
+func compareCompositeKeys(a, b [][]byte) int {
+	for i := range a {
+		if c := bytes.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func encodeCompositeKey(key [][]byte) string {
+	var buf []byte
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, value := range key {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(value)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, value...)
+	}
+	return string(buf)
+}
+
+func (c *CompositeCalculator) Consume(d *search.DocumentMatch) {
+	c.matchKeys = c.matchKeys[:0]
+	for _, source := range c.agg.sources {
+		keys := source.Keys(d)
+		if len(keys) == 0 {
+			// without a value for every source, there is no bucket
+			return
+		}
+		c.matchKeys = append(c.matchKeys, keys)
+	}
+	seen := make(map[string]struct{})
+	c.consumeCombinations(d, make([][]byte, len(c.matchKeys)), 0, seen)
+	if len(c.buckets) > 2*c.agg.size {
+		c.trim()
+	}
+}
+
+// consumeCombinations consumes the match in the bucket of
+// every combination of the values of the sources
+func (c *CompositeCalculator) consumeCombinations(d *search.DocumentMatch, key [][]byte, i int,
+	seen map[string]struct{}) {
+	if i == len(c.matchKeys) {
+		c.consumeKey(d, key, seen)
+		return
+	}
+	for _, value := range c.matchKeys[i] {
+		key[i] = value
+		c.consumeCombinations(d, key, i+1, seen)
+	}
+}
+
+func (c *CompositeCalculator) consumeKey(d *search.DocumentMatch, key [][]byte, seen map[string]struct{}) {
+	if c.agg.after != nil && compareCompositeKeys(key, c.agg.after) <= 0 {
+		return
+	}
+	if c.bound != nil && compareCompositeKeys(key, c.bound) > 0 {
+		return
+	}
+	encoded := encodeCompositeKey(key)
+	if _, ok := seen[encoded]; ok {
+		// a match is only counted once per bucket
+		return
+	}
+	seen[encoded] = struct{}{}
+	cb, ok := c.buckets[encoded]
+	if !ok {
+		keyCopy := make([][]byte, len(key))
+		names := make([]string, len(key))
+		for i, value := range key {
+			keyCopy[i] = append([]byte(nil), value...)
+			names[i] = c.agg.sources[i].Format(value)
+		}
+		cb = &compositeBucket{
+			key:    keyCopy,
+			bucket: search.NewBucket(strings.Join(names, ","), c.agg.aggregations),
+		}
+		c.buckets[encoded] = cb
+	}
+	cb.bucket.Consume(d)
+}
+
+// sorted returns the buckets in the order of their keys
+func (c *CompositeCalculator) sorted() []*compositeBucket {
+	rv := make([]*compositeBucket, 0, len(c.buckets))
+	for _, cb := range c.buckets {
+		rv = append(rv, cb)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		return compareCompositeKeys(rv[i].key, rv[j].key) < 0
+	})
+	return rv
+}
+
+// trim drops the buckets after the first 'size', as there are
+// 'size' buckets before them, they can never be in the results
+func (c *CompositeCalculator) trim() []*compositeBucket {
+	sorted := c.sorted()
+	if len(sorted) <= c.agg.size {
+		return sorted
+	}
+	for _, cb := range sorted[c.agg.size:] {
+		delete(c.buckets, encodeCompositeKey(cb.key))
+	}
+	sorted = sorted[:c.agg.size]
+	if len(sorted) > 0 {
+		c.bound = sorted[len(sorted)-1].key
+	}
+	return sorted
+}
+
+func (c *CompositeCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*CompositeCalculator); ok {
+		for encoded, otherBucket := range other.buckets {
+			if cb, ok := c.buckets[encoded]; ok {
+				cb.bucket.Merge(otherBucket.bucket)
+			} else {
+				c.buckets[encoded] = otherBucket
+			}
+		}
+		if c.err == nil {
+			c.err = other.err
+		}
+		// now re-invoke finish, this should trim to correct size again
+		c.Finish()
+	}
+}
+
+func (c *CompositeCalculator) Finish() {
+	sorted := c.trim()
+	c.bucketsList = make([]*search.Bucket, len(sorted))
+	c.keys = make(map[*search.Bucket][][]byte, len(sorted))
+	for i, cb := range sorted {
+		cb.bucket.Finish()
+		c.bucketsList[i] = cb.bucket
+		c.keys[cb.bucket] = cb.key
+	}
+}
+
+// Err reports a source which cannot provide the keys of the buckets
+func (c *CompositeCalculator) Err() error {
+	return c.err
+}
+
+// Buckets returns the buckets in the order of their keys
+func (c *CompositeCalculator) Buckets() []*search.Bucket {
+	return c.bucketsList
+}
+
+// Key returns the value of each source in the key of the bucket
+func (c *CompositeCalculator) Key(bucket *search.Bucket) map[string]string {
+	key, ok := c.keys[bucket]
+	if !ok {
+		return nil
+	}
+	rv := make(map[string]string, len(key))
+	for i, source := range c.agg.sources {
+		rv[source.Name()] = source.Format(key[i])
+	}
+	return rv
+}
+
+// AfterKey returns the key of the last bucket, to page through the
+// following buckets, or nil when there are no buckets
+func (c *CompositeCalculator) AfterKey() map[string]string {
+	if len(c.bucketsList) == 0 {
+		return nil
+	}
+	return c.Key(c.bucketsList[len(c.bucketsList)-1])
+}
+
+type compositeBucketJSON struct {
+	*search.BucketJSON
+	Key map[string]string `json:"key"`
+}
+
+func (c *CompositeCalculator) MarshalJSON() ([]byte, error) {
+	buckets := make([]compositeBucketJSON, len(c.bucketsList))
+	for i, bucket := range c.bucketsList {
+		buckets[i] = compositeBucketJSON{
+			BucketJSON: bucket.JSON(),
+			Key:        c.Key(bucket),
+		}
+	}
+	return json.Marshal(struct {
+		Buckets  []compositeBucketJSON `json:"buckets"`
+		AfterKey map[string]string     `json:"after_key,omitempty"`
+	}{
+		Buckets:  buckets,
+		AfterKey: c.AfterKey(),
+	})
+}
+
+// TermsSource provides the terms of the values source
+func TermsSource(name string, src search.TextValuesSource) CompositeSource {
+	return &termsCompositeSource{
+		name: name,
+		src:  src,
+	}
+}
+
+type termsCompositeSource struct {
+	name string
+	src  search.TextValuesSource
+}
+
+func (s *termsCompositeSource) Name() string {
+	return s.name
+}
+
+func (s *termsCompositeSource) Fields() []string {
+	return s.src.Fields()
+}
+
+func (s *termsCompositeSource) Keys(d *search.DocumentMatch) [][]byte {
+	return s.src.Values(d)
+}
+
+func (s *termsCompositeSource) Format(key []byte) string {
+	return string(key)
+}
+
+func (s *termsCompositeSource) Parse(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+// HistogramSource provides the start of the
+// buckets of the histogram of the values
+func HistogramSource(name string, histogram *HistogramAggregation) CompositeSource {
+	return &histogramCompositeSource{
+		name:      name,
+		histogram: histogram,
+	}
+}
+
+type histogramCompositeSource struct {
+	name      string
+	histogram *HistogramAggregation
+}
+
+func (s *histogramCompositeSource) Name() string {
+	return s.name
+}
+
+func (s *histogramCompositeSource) Fields() []string {
+	return s.histogram.src.Fields()
+}
+
+func (s *histogramCompositeSource) validate() error {
+	return s.histogram.validate()
+}
+
+func (s *histogramCompositeSource) Keys(d *search.DocumentMatch) [][]byte {
+	var rv [][]byte
+	for _, val := range s.histogram.src.Numbers(d) {
+		if key, ok := s.histogram.key(val); ok {
+			start := float64(key)*s.histogram.interval + s.histogram.offset
+			rv = append(rv, numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(start), 0))
+		}
+	}
+	return rv
+}
+
+func (s *histogramCompositeSource) Format(key []byte) string {
+	i64, _ := numeric.PrefixCoded(key).Int64()
+	return strconv.FormatFloat(numeric.Int64ToFloat64(i64), 'f', -1, 64)
+}
+
+func (s *histogramCompositeSource) Parse(value string) ([]byte, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(f) {
+		return nil, fmt.Errorf("invalid histogram key: %s", value)
+	}
+	return numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(f), 0), nil
+}
+
+// DateHistogramSource provides the start of the
+// buckets of the date histogram of the dates
+func DateHistogramSource(name string, histogram *DateHistogramAggregation) CompositeSource {
+	return &dateHistogramCompositeSource{
+		name:      name,
+		histogram: histogram,
+	}
+}
+
+type dateHistogramCompositeSource struct {
+	name      string
+	histogram *DateHistogramAggregation
+}
+
+func (s *dateHistogramCompositeSource) Name() string {
+	return s.name
+}
+
+func (s *dateHistogramCompositeSource) Fields() []string {
+	return s.histogram.src.Fields()
+}
+
+func (s *dateHistogramCompositeSource) validate() error {
+	return s.histogram.validate()
+}
+
+func (s *dateHistogramCompositeSource) Keys(d *search.DocumentMatch) [][]byte {
+	var rv [][]byte
+	for _, val := range s.histogram.src.Dates(d) {
+		rv = append(rv, numeric.MustNewPrefixCodedInt64(s.histogram.round(val).UnixNano(), 0))
+	}
+	return rv
+}
+
+func (s *dateHistogramCompositeSource) Format(key []byte) string {
+	i64, _ := numeric.PrefixCoded(key).Int64()
+	return time.Unix(0, i64).In(s.histogram.location).Format(time.RFC3339Nano)
+}
+
+func (s *dateHistogramCompositeSource) Parse(value string) ([]byte, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return numeric.MustNewPrefixCodedInt64(t.UnixNano(), 0), nil
+}
@@ -0,0 +1,354 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"github.com/bits-and-blooms/bitset"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/similarity"
+)
+
+// FilterQuery finds the documents of a bucket,
+// any bluge.Query may be used
+type FilterQuery interface {
+	Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error)
+}
+
+// FilterAggregation puts the matches which also
+// match the query into a single bucket
+type FilterAggregation struct {
+	query        FilterQuery
+	options      search.SearcherOptions
+	aggregations map[string]search.Aggregation
+}
+
+func Filter(query FilterQuery) *FilterAggregation {
+	return &FilterAggregation{
+		query:   query,
+		options: defaultSearcherOptions(),
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+func (a *FilterAggregation) Fields() []string {
+	var rv []string
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+func (a *FilterAggregation) AddAggregation(name string, agg search.Aggregation) *FilterAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation, searching
+// for the matches of the query using the options of the search
+func (a *FilterAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.options = options
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *FilterAggregation) Calculator() search.Calculator {
+	return &FilterCalculator{
+		matcher: newQueryMatcher(a.query, a.options),
+		bucket:  search.NewBucket("filter", a.aggregations),
+	}
+}
+
+type FilterCalculator struct {
+	matcher *queryMatcher
+	bucket  *search.Bucket
+}
+
+func (c *FilterCalculator) Consume(d *search.DocumentMatch) {
+	if c.matcher.matches(d) {
+		c.bucket.Consume(d)
+	}
+}
+
+func (c *FilterCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*FilterCalculator); ok {
+		c.bucket.Merge(other.bucket)
+		c.matcher.merge(other.matcher)
+	}
+}
+
+func (c *FilterCalculator) Finish() {
+	c.bucket.Finish()
+}
+
+// Bucket returns the bucket of the matches of the query
+func (c *FilterCalculator) Bucket() *search.Bucket {
+	return c.bucket
+}
+
+// Buckets returns the only bucket, named "filter"
+func (c *FilterCalculator) Buckets() []*search.Bucket {
+	return []*search.Bucket{c.bucket}
+}
+
+// Err returns the first error searching for the matches of the query
+func (c *FilterCalculator) Err() error {
+	return c.matcher.err
+}
+
+// MarshalJSON encodes the only bucket, rather than a list of buckets
+func (c *FilterCalculator) MarshalJSON() ([]byte, error) {
+	return c.bucket.MarshalJSON()
+}
+
+// FiltersAggregation puts the matches into a named bucket for each
+// query they match, and optionally, those matching none of them
+// into an other bucket
+type FiltersAggregation struct {
+	names        []string
+	queries      []FilterQuery
+	options      search.SearcherOptions
+	otherName    string
+	other        bool
+	aggregations map[string]search.Aggregation
+}
+
+func Filters() *FiltersAggregation {
+	return &FiltersAggregation{
+		options: defaultSearcherOptions(),
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+// AddFilter adds a bucket for the matches of the query,
+// the buckets are in the order they are added
+func (a *FiltersAggregation) AddFilter(name string, query FilterQuery) *FiltersAggregation {
+	a.names = append(a.names, name)
+	a.queries = append(a.queries, query)
+	return a
+}
+
+// OtherBucket adds a last bucket, with this name,
+// for the matches of none of the queries
+func (a *FiltersAggregation) OtherBucket(name string) *FiltersAggregation {
+	a.otherName = name
+	a.other = true
+	return a
+}
+
+func (a *FiltersAggregation) Fields() []string {
+	var rv []string
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+func (a *FiltersAggregation) AddAggregation(name string, agg search.Aggregation) *FiltersAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation, searching
+// for the matches of the queries using the options of the search
+func (a *FiltersAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.options = options
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *FiltersAggregation) Calculator() search.Calculator {
+	rv := &FiltersCalculator{}
+	for i, query := range a.queries {
+		rv.matchers = append(rv.matchers, newQueryMatcher(query, a.options))
+		rv.buckets = append(rv.buckets, search.NewBucket(a.names[i], a.aggregations))
+	}
+	if a.other {
+		rv.other = search.NewBucket(a.otherName, a.aggregations)
+	}
+	return rv
+}
+
+type FiltersCalculator struct {
+	matchers []*queryMatcher
+	buckets  []*search.Bucket
+	other    *search.Bucket
+}
+
+func (c *FiltersCalculator) Consume(d *search.DocumentMatch) {
+	var matched bool
+	for i, matcher := range c.matchers {
+		if matcher.matches(d) {
+			c.buckets[i].Consume(d)
+			matched = true
+		}
+	}
+	if !matched && c.other != nil {
+		c.other.Consume(d)
+	}
+}
+
+func (c *FiltersCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*FiltersCalculator); ok {
+		if len(c.buckets) == len(other.buckets) {
+			for i := range c.buckets {
+				c.buckets[i].Merge(other.buckets[i])
+				c.matchers[i].merge(other.matchers[i])
+			}
+		}
+		if c.other != nil && other.other != nil {
+			c.other.Merge(other.other)
+		}
+	}
+}
+
+func (c *FiltersCalculator) Finish() {
+	for _, bucket := range c.buckets {
+		bucket.Finish()
+	}
+	if c.other != nil {
+		c.other.Finish()
+	}
+}
+
+// Buckets returns the bucket of each query,
+// followed by the other bucket, if any
+func (c *FiltersCalculator) Buckets() []*search.Bucket {
+	if c.other != nil {
+		return append(c.buckets[:len(c.buckets):len(c.buckets)], c.other)
+	}
+	return c.buckets
+}
+
+// Other returns the bucket of the matches of none
+// of the queries, or nil without an other bucket
+func (c *FiltersCalculator) Other() *search.Bucket {
+	return c.other
+}
+
+// Err returns the first error searching for the matches of the queries
+func (c *FiltersCalculator) Err() error {
+	for _, matcher := range c.matchers {
+		if matcher.err != nil {
+			return matcher.err
+		}
+	}
+	return nil
+}
+
+// queryMatcher finds whether documents match a query, the first
+// time a document of a reader is seen, all the documents of that
+// reader matching the query are found, and remembered
+type queryMatcher struct {
+	query   FilterQuery
+	options search.SearcherOptions
+	readers []*readerMatches
+	err     error
+}
+
+type readerMatches struct {
+	reader search.MatchReader
+	docs   *bitset.BitSet
+}
+
+func newQueryMatcher(query FilterQuery, options search.SearcherOptions) *queryMatcher {
+	return &queryMatcher{
+		query:   query,
+		options: matchingOptions(options),
+	}
+}
+
+// matchingOptions returns the options of a search,
+// finding which documents match, without scoring them
+func matchingOptions(options search.SearcherOptions) search.SearcherOptions {
+	options.Score = "none"
+	options.Explain = false
+	options.IncludeTermVectors = false
+	return options
+}
+
+// defaultSearcherOptions are the options used to search the index,
+// unless an aggregation is given the options of the search
+func defaultSearcherOptions() search.SearcherOptions {
+	return search.SearcherOptions{
+		SimilarityForField: func(field string) search.Similarity {
+			return similarity.NewBM25Similarity()
+		},
+		Score: "none",
+	}
+}
+
+func (m *queryMatcher) matches(d *search.DocumentMatch) bool {
+	reader := d.Reader()
+	var docs *bitset.BitSet
+	for i := len(m.readers) - 1; i >= 0; i-- {
+		if m.readers[i].reader == reader {
+			docs = m.readers[i].docs
+			break
+		}
+	}
+	if docs == nil {
+		var err error
+		docs, err = m.search(reader)
+		if err != nil && m.err == nil {
+			m.err = err
+		}
+		m.readers = append(m.readers, &readerMatches{
+			reader: reader,
+			docs:   docs,
+		})
+	}
+	return docs.Test(uint(d.Number))
+}
+
+func (m *queryMatcher) merge(other *queryMatcher) {
+	if m.err == nil {
+		m.err = other.err
+	}
+}
+
+// search finds the documents of the reader matching the query,
+// documents without a search.Reader match nothing
+func (m *queryMatcher) search(reader search.MatchReader) (docs *bitset.BitSet, err error) {
+	docs = bitset.New(0)
+	indexReader, ok := reader.(search.Reader)
+	if !ok {
+		return docs, nil
+	}
+	searcher, err := m.query.Searcher(indexReader, m.options)
+	if err != nil {
+		return docs, err
+	}
+	defer func() {
+		if cerr := searcher.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx := search.NewSearchContext(searcher.DocumentMatchPoolSize(), 0)
+	dm, err := searcher.Next(ctx)
+	for err == nil && dm != nil {
+		docs.Set(uint(dm.Number))
+		ctx.DocumentMatchPool.Put(dm)
+		dm, err = searcher.Next(ctx)
+	}
+	return docs, err
+}
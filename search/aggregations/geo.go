@@ -0,0 +1,350 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/blugelabs/bluge/numeric/geo"
+	"github.com/blugelabs/bluge/search"
+)
+
+const defaultGeoGridSize = 10000
+
+// GeohashGrid groups points into the cells of the geohash of the
+// provided precision, from 1 to 12, each bucket is named by the
+// geohash of its cell, the 'size' cells with the most matches are kept
+func GeohashGrid(src search.GeoPointValuesSource, precision int) *TermsAggregation {
+	if precision < 1 {
+		precision = 1
+	} else if precision > 12 {
+		precision = 12
+	}
+	return NewTermsAggregation(&geohashCellSource{
+		src:       src,
+		precision: precision,
+	}, defaultGeoGridSize)
+}
+
+type geohashCellSource struct {
+	src       search.GeoPointValuesSource
+	precision int
+}
+
+func (g *geohashCellSource) Fields() []string {
+	return g.src.Fields()
+}
+
+func (g *geohashCellSource) Values(match *search.DocumentMatch) [][]byte {
+	var rv [][]byte
+	for _, point := range g.src.GeoPoints(match) {
+		rv = append(rv, []byte(geo.EncodeGeoHash(point.Lat, point.Lon)[:g.precision]))
+	}
+	return rv
+}
+
+// maxGeotileLat is the latitude at which the web mercator
+// projection is square, points further north or south
+// are in the first or last row of tiles
+const maxGeotileLat = 85.05112878
+
+// GeotileGrid groups points into the map tiles of the provided zoom
+// level, from 0 to 29, each bucket is named "zoom/x/y", the
+// 'size' tiles with the most matches are kept
+func GeotileGrid(src search.GeoPointValuesSource, zoom int) *TermsAggregation {
+	if zoom < 0 {
+		zoom = 0
+	} else if zoom > 29 {
+		zoom = 29
+	}
+	return NewTermsAggregation(&geotileCellSource{
+		src:  src,
+		zoom: zoom,
+	}, defaultGeoGridSize)
+}
+
+type geotileCellSource struct {
+	src  search.GeoPointValuesSource
+	zoom int
+}
+
+func (g *geotileCellSource) Fields() []string {
+	return g.src.Fields()
+}
+
+func (g *geotileCellSource) Values(match *search.DocumentMatch) [][]byte {
+	var rv [][]byte
+	for _, point := range g.src.GeoPoints(match) {
+		x, y := geotile(point, g.zoom)
+		rv = append(rv, []byte(fmt.Sprintf("%d/%d/%d", g.zoom, x, y)))
+	}
+	return rv
+}
+
+func geotile(point *geo.Point, zoom int) (x, y int) {
+	tiles := 1 << uint(zoom)
+	lat := math.Max(-maxGeotileLat, math.Min(maxGeotileLat, point.Lat))
+	latRad := geo.DegreesToRadians(lat)
+	x = int(math.Floor((point.Lon + 180) / 360 * float64(tiles)))
+	y = int(math.Floor((1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * float64(tiles)))
+	return clampTile(x, tiles), clampTile(y, tiles)
+}
+
+func clampTile(i, tiles int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= tiles {
+		return tiles - 1
+	}
+	return i
+}
+
+// GeoDistance groups points into ranges of distance from the
+// origin, such as rings around a city, the distances of the ranges
+// are in the unit, parsed by geo.ParseDistanceUnit
+func GeoDistance(src search.GeoPointValuesSource, origin geo.Point, unit string) (*RangeAggregation, error) {
+	multiplier, err := geo.ParseDistanceUnit(unit)
+	if err != nil {
+		return nil, err
+	}
+	return Ranges(&geoDistanceSource{
+		src:        src,
+		origin:     origin,
+		multiplier: multiplier,
+	}), nil
+}
+
+type geoDistanceSource struct {
+	src        search.GeoPointValuesSource
+	origin     geo.Point
+	multiplier float64
+}
+
+func (g *geoDistanceSource) Fields() []string {
+	return g.src.Fields()
+}
+
+func (g *geoDistanceSource) Numbers(match *search.DocumentMatch) []float64 {
+	var rv []float64
+	for _, point := range g.src.GeoPoints(match) {
+		// the distance is returned in km
+		dist := geo.Haversin(g.origin.Lon, g.origin.Lat, point.Lon, point.Lat)
+		rv = append(rv, dist*1000/g.multiplier)
+	}
+	return rv
+}
+
+// GeoBoundsMetric finds the bounding box of the points
+type GeoBoundsMetric struct {
+	src           search.GeoPointValuesSource
+	wrapLongitude bool
+}
+
+func GeoBounds(src search.GeoPointValuesSource) *GeoBoundsMetric {
+	return &GeoBoundsMetric{
+		src:           src,
+		wrapLongitude: true,
+	}
+}
+
+// WrapLongitude controls whether the bounding box may cross
+// the international date line when this makes it smaller,
+// it is allowed by default
+func (g *GeoBoundsMetric) WrapLongitude(wrap bool) *GeoBoundsMetric {
+	g.wrapLongitude = wrap
+	return g
+}
+
+func (g *GeoBoundsMetric) Fields() []string {
+	return g.src.Fields()
+}
+
+func (g *GeoBoundsMetric) Calculator() search.Calculator {
+	return &GeoBoundsCalculator{
+		src:           g.src,
+		wrapLongitude: g.wrapLongitude,
+		top:           math.Inf(-1),
+		bottom:        math.Inf(1),
+		posLeft:       math.Inf(1),
+		posRight:      math.Inf(-1),
+		negLeft:       math.Inf(1),
+		negRight:      math.Inf(-1),
+	}
+}
+
+// GeoBoundsCalculator tracks the extent of the eastern and
+// western longitudes apart, to decide whether the box
+// crosses the date line once all points are seen
+type GeoBoundsCalculator struct {
+	src           search.GeoPointValuesSource
+	wrapLongitude bool
+
+	top, bottom       float64
+	posLeft, posRight float64
+	negLeft, negRight float64
+}
+
+func (c *GeoBoundsCalculator) Consume(d *search.DocumentMatch) {
+	for _, point := range c.src.GeoPoints(d) {
+		c.add(point.Lat, point.Lat, point.Lon, point.Lon, point.Lon, point.Lon)
+	}
+}
+
+func (c *GeoBoundsCalculator) add(top, bottom, posLeft, posRight, negLeft, negRight float64) {
+	c.top = math.Max(c.top, top)
+	c.bottom = math.Min(c.bottom, bottom)
+	if posLeft >= 0 {
+		c.posLeft = math.Min(c.posLeft, posLeft)
+	}
+	if posRight >= 0 {
+		c.posRight = math.Max(c.posRight, posRight)
+	}
+	if negLeft < 0 {
+		c.negLeft = math.Min(c.negLeft, negLeft)
+	}
+	if negRight < 0 {
+		c.negRight = math.Max(c.negRight, negRight)
+	}
+}
+
+func (c *GeoBoundsCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*GeoBoundsCalculator); ok {
+		c.add(other.top, other.bottom, other.posLeft, other.posRight, other.negLeft, other.negRight)
+	}
+}
+
+func (c *GeoBoundsCalculator) Finish() {}
+
+// wraps returns true if the bounding box should cross the date line
+func (c *GeoBoundsCalculator) wraps() bool {
+	if !c.wrapLongitude || math.IsInf(c.posLeft, 0) || math.IsInf(c.negLeft, 0) {
+		return false
+	}
+	unwrapped := c.posRight - c.negLeft
+	wrapped := 360 + c.negRight - c.posLeft
+	return wrapped < unwrapped
+}
+
+// TopLeft returns the north west corner of the bounding box,
+// or nil when there were no points
+func (c *GeoBoundsCalculator) TopLeft() *geo.Point {
+	if math.IsInf(c.top, 0) {
+		return nil
+	}
+	left := c.negLeft
+	if math.IsInf(left, 0) || c.wraps() {
+		left = c.posLeft
+	}
+	return &geo.Point{Lon: left, Lat: c.top}
+}
+
+// BottomRight returns the south east corner of the bounding box,
+// or nil when there were no points
+func (c *GeoBoundsCalculator) BottomRight() *geo.Point {
+	if math.IsInf(c.bottom, 0) {
+		return nil
+	}
+	right := c.posRight
+	if math.IsInf(right, 0) || c.wraps() {
+		right = c.negRight
+	}
+	return &geo.Point{Lon: right, Lat: c.bottom}
+}
+
+func (c *GeoBoundsCalculator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		TopLeft     *geo.Point `json:"top_left"`
+		BottomRight *geo.Point `json:"bottom_right"`
+	}{
+		TopLeft:     c.TopLeft(),
+		BottomRight: c.BottomRight(),
+	})
+}
+
+// GeoCentroidMetric finds the mean position of the points
+type GeoCentroidMetric struct {
+	src search.GeoPointValuesSource
+}
+
+func GeoCentroid(src search.GeoPointValuesSource) *GeoCentroidMetric {
+	return &GeoCentroidMetric{
+		src: src,
+	}
+}
+
+func (g *GeoCentroidMetric) Fields() []string {
+	return g.src.Fields()
+}
+
+func (g *GeoCentroidMetric) Calculator() search.Calculator {
+	return &GeoCentroidCalculator{
+		src: g.src,
+	}
+}
+
+type GeoCentroidCalculator struct {
+	src    search.GeoPointValuesSource
+	count  int
+	sumLon float64
+	sumLat float64
+}
+
+func (c *GeoCentroidCalculator) Consume(d *search.DocumentMatch) {
+	for _, point := range c.src.GeoPoints(d) {
+		c.count++
+		c.sumLon += point.Lon
+		c.sumLat += point.Lat
+	}
+}
+
+func (c *GeoCentroidCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*GeoCentroidCalculator); ok {
+		c.count += other.count
+		c.sumLon += other.sumLon
+		c.sumLat += other.sumLat
+	}
+}
+
+func (c *GeoCentroidCalculator) Finish() {}
+
+// Count returns the number of points
+func (c *GeoCentroidCalculator) Count() int {
+	return c.count
+}
+
+// Centroid returns the mean position of the points,
+// or nil when there were no points
+func (c *GeoCentroidCalculator) Centroid() *geo.Point {
+	if c.count == 0 {
+		return nil
+	}
+	return &geo.Point{
+		Lon: c.sumLon / float64(c.count),
+		Lat: c.sumLat / float64(c.count),
+	}
+}
+
+func (c *GeoCentroidCalculator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count    int        `json:"count"`
+		Location *geo.Point `json:"location"`
+	}{
+		Count:    c.count,
+		Location: c.Centroid(),
+	})
+}
@@ -0,0 +1,309 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// DefaultMaxBuckets is the most buckets a histogram may have,
+// including the empty buckets in between, unless set otherwise
+const DefaultMaxBuckets = 65536
+
+// HistogramAggregation groups values into buckets of a fixed
+// interval, the bucket of a value starts at
+// floor((value - offset) / interval) * interval + offset
+type HistogramAggregation struct {
+	src          search.NumericValuesSource
+	interval     float64
+	offset       float64
+	minDocCount  uint64
+	maxBuckets   int
+	bounds       bool
+	boundsMin    float64
+	boundsMax    float64
+	aggregations map[string]search.Aggregation
+}
+
+func Histogram(src search.NumericValuesSource, interval float64) *HistogramAggregation {
+	return &HistogramAggregation{
+		src:        src,
+		interval:   interval,
+		maxBuckets: DefaultMaxBuckets,
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+// Offset shifts the start of the buckets
+func (a *HistogramAggregation) Offset(offset float64) *HistogramAggregation {
+	a.offset = offset
+	return a
+}
+
+// MinDocCount only keeps buckets with at least this many matches,
+// by default empty buckets between the lowest and highest
+// buckets are included
+func (a *HistogramAggregation) MinDocCount(count uint64) *HistogramAggregation {
+	a.minDocCount = count
+	return a
+}
+
+// MaxBuckets sets the most buckets the histogram may have, a search
+// fails rather than building more, such as when the interval is
+// much smaller than the range of the values
+func (a *HistogramAggregation) MaxBuckets(max int) *HistogramAggregation {
+	a.maxBuckets = max
+	return a
+}
+
+// ExtendedBounds includes empty buckets from min to max,
+// even when no match falls that low or that high,
+// only applies when empty buckets are included
+func (a *HistogramAggregation) ExtendedBounds(min, max float64) *HistogramAggregation {
+	a.bounds = true
+	a.boundsMin = min
+	a.boundsMax = max
+	return a
+}
+
+func (a *HistogramAggregation) AddAggregation(name string, agg search.Aggregation) *HistogramAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (a *HistogramAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *HistogramAggregation) Fields() []string {
+	rv := a.src.Fields()
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+// validate reports an interval which cannot divide the values into buckets
+func (a *HistogramAggregation) validate() error {
+	if !(a.interval > 0) {
+		return fmt.Errorf("histogram interval must be positive, got %v", a.interval)
+	}
+	return nil
+}
+
+// key returns the key of the bucket of the value, values whose
+// key does not fit in an int64 have no bucket
+func (a *HistogramAggregation) key(val float64) (int64, bool) {
+	if a.interval <= 0 || math.IsNaN(val) || math.IsInf(val, 0) {
+		return 0, false
+	}
+	key := math.Floor((val - a.offset) / a.interval)
+	if key < math.MinInt64 || key >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(key), true
+}
+
+func (a *HistogramAggregation) Calculator() search.Calculator {
+	rv := newHistogramCalculator(a.aggregations, a.minDocCount, a.maxBuckets)
+	rv.err = a.validate()
+	rv.keys = func(d *search.DocumentMatch, keys []int64) []int64 {
+		for _, val := range a.src.Numbers(d) {
+			if key, ok := a.key(val); ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	rv.next = func(key int64) int64 {
+		return key + 1
+	}
+	rv.name = func(key int64) string {
+		return strconv.FormatFloat(float64(key)*a.interval+a.offset, 'f', -1, 64)
+	}
+	if a.bounds {
+		var minOK, maxOK bool
+		rv.boundsMin, minOK = a.key(a.boundsMin)
+		rv.boundsMax, maxOK = a.key(a.boundsMax)
+		rv.bounds = minOK && maxOK
+	}
+	return rv
+}
+
+// HistogramCalculator calculates the buckets of histograms,
+// each bucket is identified by a key, ordered as the buckets are
+type HistogramCalculator struct {
+	aggregations map[string]search.Aggregation
+	minDocCount  uint64
+	maxBuckets   int
+
+	// keys appends the keys of the buckets of the match
+	keys func(d *search.DocumentMatch, keys []int64) []int64
+	// next returns the key of the following bucket
+	next func(key int64) int64
+	// name returns the name of the bucket
+	name func(key int64) string
+
+	bounds    bool
+	boundsMin int64
+	boundsMax int64
+
+	bucketsMap  map[int64]*search.Bucket
+	bucketsList []*search.Bucket
+
+	matchKeys []int64
+	err       error
+}
+
+func newHistogramCalculator(aggregations map[string]search.Aggregation, minDocCount uint64,
+	maxBuckets int) *HistogramCalculator {
+	return &HistogramCalculator{
+		aggregations: aggregations,
+		minDocCount:  minDocCount,
+		maxBuckets:   maxBuckets,
+		bucketsMap:   make(map[int64]*search.Bucket),
+	}
+}
+
+func (h *HistogramCalculator) tooManyBuckets() {
+	if h.err == nil {
+		h.err = fmt.Errorf("histogram has more than %d buckets", h.maxBuckets)
+	}
+}
+
+func (h *HistogramCalculator) Consume(d *search.DocumentMatch) {
+	h.matchKeys = h.keys(d, h.matchKeys[:0])
+	for i, key := range h.matchKeys {
+		if alreadyConsumed(h.matchKeys[:i], key) {
+			// a match is only counted once per bucket
+			continue
+		}
+		bucket, ok := h.bucketsMap[key]
+		if !ok {
+			if len(h.bucketsMap) >= h.maxBuckets {
+				h.tooManyBuckets()
+				continue
+			}
+			bucket = search.NewBucket(h.name(key), h.aggregations)
+			h.bucketsMap[key] = bucket
+		}
+		bucket.Consume(d)
+	}
+}
+
+func alreadyConsumed(keys []int64, key int64) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HistogramCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*HistogramCalculator); ok {
+		for key, otherBucket := range other.bucketsMap {
+			if bucket, ok := h.bucketsMap[key]; ok {
+				bucket.Merge(otherBucket)
+			} else {
+				h.bucketsMap[key] = otherBucket
+			}
+		}
+		if h.err == nil {
+			h.err = other.err
+		}
+		// now re-invoke finish, to list the merged buckets
+		h.Finish()
+	}
+}
+
+func (h *HistogramCalculator) Finish() {
+	if len(h.bucketsMap) > h.maxBuckets {
+		// merged partitions may have too many buckets together
+		h.tooManyBuckets()
+		return
+	}
+	keys := make([]int64, 0, len(h.bucketsMap))
+	for key, bucket := range h.bucketsMap {
+		bucket.Finish()
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	h.bucketsList = h.bucketsList[:0]
+	if h.minDocCount > 0 {
+		for _, key := range keys {
+			bucket := h.bucketsMap[key]
+			if bucket.Count() >= h.minDocCount {
+				h.bucketsList = append(h.bucketsList, bucket)
+			}
+		}
+		return
+	}
+
+	// include the empty buckets in between
+	if h.bounds {
+		if len(keys) == 0 || h.boundsMin < keys[0] {
+			keys = append([]int64{h.boundsMin}, keys...)
+		}
+		if h.boundsMax > keys[len(keys)-1] {
+			keys = append(keys, h.boundsMax)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	last := keys[len(keys)-1]
+	for key := keys[0]; key <= last; {
+		if len(h.bucketsList) >= h.maxBuckets {
+			h.tooManyBuckets()
+			return
+		}
+		bucket, ok := h.bucketsMap[key]
+		if !ok {
+			bucket = search.NewBucket(h.name(key), h.aggregations)
+			bucket.Finish()
+		}
+		h.bucketsList = append(h.bucketsList, bucket)
+		next := h.next(key)
+		if next <= key {
+			break
+		}
+		key = next
+	}
+}
+
+// Buckets returns the buckets in ascending order
+func (h *HistogramCalculator) Buckets() []*search.Bucket {
+	return h.bucketsList
+}
+
+// Err returns an error when the histogram has too many buckets
+func (h *HistogramCalculator) Err() error {
+	return h.err
+}
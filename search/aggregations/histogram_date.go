@@ -0,0 +1,272 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// CalendarInterval is an interval whose duration varies, such as
+// a month, or a day which is longer when clocks go back
+type CalendarInterval int
+
+const (
+	Minute CalendarInterval = iota
+	Hour
+	Day
+	Week
+	Month
+	Quarter
+	Year
+)
+
+// DateHistogramAggregation groups dates into buckets of either a
+// calendar interval or a fixed duration, in a time zone
+type DateHistogramAggregation struct {
+	src          search.DateValuesSource
+	calendar     CalendarInterval
+	fixed        time.Duration
+	isFixed      bool
+	location     *time.Location
+	offset       time.Duration
+	minDocCount  uint64
+	maxBuckets   int
+	bounds       bool
+	boundsMin    time.Time
+	boundsMax    time.Time
+	aggregations map[string]search.Aggregation
+}
+
+// DateHistogram groups dates into buckets of a fixed duration, starting
+// at multiples of the duration since the Unix epoch on the wall clock of
+// the time zone, so buckets of a day start at midnight, but buckets of
+// a week start on Thursday, as the epoch does
+func DateHistogram(src search.DateValuesSource, interval time.Duration) *DateHistogramAggregation {
+	rv := CalendarDateHistogram(src, Day)
+	rv.fixed = interval
+	rv.isFixed = true
+	return rv
+}
+
+// CalendarDateHistogram groups dates into buckets of a calendar
+// interval, weeks start on Monday
+func CalendarDateHistogram(src search.DateValuesSource, interval CalendarInterval) *DateHistogramAggregation {
+	return &DateHistogramAggregation{
+		src:        src,
+		calendar:   interval,
+		location:   time.UTC,
+		maxBuckets: DefaultMaxBuckets,
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+// InLocation sets the time zone of the buckets,
+// which is UTC by default
+func (a *DateHistogramAggregation) InLocation(location *time.Location) *DateHistogramAggregation {
+	a.location = location
+	return a
+}
+
+// Offset shifts the start of the buckets, for example
+// by 6 hours for days starting at 6am
+func (a *DateHistogramAggregation) Offset(offset time.Duration) *DateHistogramAggregation {
+	a.offset = offset
+	return a
+}
+
+// MinDocCount only keeps buckets with at least this many matches,
+// by default empty buckets between the lowest and highest
+// buckets are included
+func (a *DateHistogramAggregation) MinDocCount(count uint64) *DateHistogramAggregation {
+	a.minDocCount = count
+	return a
+}
+
+// MaxBuckets sets the most buckets the histogram may have, a search
+// fails rather than building more, such as when the interval is
+// much shorter than the period of the dates
+func (a *DateHistogramAggregation) MaxBuckets(max int) *DateHistogramAggregation {
+	a.maxBuckets = max
+	return a
+}
+
+// ExtendedBounds includes empty buckets from start to end,
+// even when no match falls that early or that late,
+// only applies when empty buckets are included
+func (a *DateHistogramAggregation) ExtendedBounds(start, end time.Time) *DateHistogramAggregation {
+	a.bounds = true
+	a.boundsMin = start
+	a.boundsMax = end
+	return a
+}
+
+func (a *DateHistogramAggregation) AddAggregation(name string, agg search.Aggregation) *DateHistogramAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (a *DateHistogramAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *DateHistogramAggregation) Fields() []string {
+	rv := a.src.Fields()
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+// round returns the start of the bucket of t
+func (a *DateHistogramAggregation) round(t time.Time) time.Time {
+	t = t.Add(-a.offset).In(a.location)
+	if a.fixed > 0 {
+		return a.roundFixed(t, a.fixed).Add(a.offset)
+	}
+	year, month, day := t.Date()
+	switch a.calendar {
+	case Minute:
+		t = a.roundFixed(t, time.Minute)
+	case Hour:
+		t = a.roundFixed(t, time.Hour)
+	case Day:
+		t = time.Date(year, month, day, 0, 0, 0, 0, a.location)
+	case Week:
+		t = time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, a.location)
+	case Month:
+		t = time.Date(year, month, 1, 0, 0, 0, 0, a.location)
+	case Quarter:
+		t = time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, a.location)
+	case Year:
+		t = time.Date(year, 1, 1, 0, 0, 0, 0, a.location)
+	}
+	return t.Add(a.offset)
+}
+
+// roundFixed rounds down to a multiple of interval on the wall clock,
+// keeping apart the hours repeated when clocks go back
+func (a *DateHistogramAggregation) roundFixed(t time.Time, interval time.Duration) time.Time {
+	_, zoneOffset := t.Zone()
+	wall := t.UnixNano() + int64(zoneOffset)*int64(time.Second)
+	rounded := wall - floorMod(wall, int64(interval))
+	rv := time.Unix(0, rounded-int64(zoneOffset)*int64(time.Second)).In(a.location)
+	if _, roundedOffset := rv.Zone(); roundedOffset != zoneOffset {
+		// the clocks changed since the start of the interval
+		alt := time.Unix(0, rounded-int64(roundedOffset)*int64(time.Second)).In(a.location)
+		if _, altOffset := alt.Zone(); altOffset == roundedOffset && !alt.After(t) {
+			rv = alt
+		}
+	}
+	return rv
+}
+
+func floorMod(a, b int64) int64 {
+	rv := a % b
+	if rv < 0 {
+		rv += b
+	}
+	return rv
+}
+
+// next returns the start of the bucket following the one starting at t
+func (a *DateHistogramAggregation) next(t time.Time) time.Time {
+	t = t.Add(-a.offset).In(a.location)
+	if a.fixed > 0 {
+		t = t.Add(a.fixed)
+	} else {
+		year, month, day := t.Date()
+		switch a.calendar {
+		case Minute:
+			t = t.Add(time.Minute)
+		case Hour:
+			t = t.Add(time.Hour)
+		case Day:
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, a.location)
+		case Week:
+			t = time.Date(year, month, day+7, 0, 0, 0, 0, a.location)
+		case Month:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, a.location)
+		case Quarter:
+			t = time.Date(year, month+3, 1, 0, 0, 0, 0, a.location)
+		case Year:
+			t = time.Date(year+1, 1, 1, 0, 0, 0, 0, a.location)
+		}
+	}
+	return a.round(t.Add(a.offset))
+}
+
+// the earliest and latest dates whose bucket has a key
+var (
+	minDateKey = time.Unix(0, math.MinInt64)
+	maxDateKey = time.Unix(0, math.MaxInt64)
+)
+
+// validate reports a fixed interval which cannot divide the dates into buckets
+func (a *DateHistogramAggregation) validate() error {
+	if a.isFixed && a.fixed <= 0 {
+		return fmt.Errorf("date histogram interval must be positive, got %v", a.fixed)
+	}
+	return nil
+}
+
+// key returns the key of the bucket of the date, the start of the
+// bucket in nanoseconds, dates whose bucket starts too early or too
+// late to be represented in an int64 have no bucket
+func (a *DateHistogramAggregation) key(t time.Time) (int64, bool) {
+	if t.Before(minDateKey) || t.After(maxDateKey) {
+		return 0, false
+	}
+	start := a.round(t)
+	if start.Before(minDateKey) || start.After(maxDateKey) {
+		return 0, false
+	}
+	return start.UnixNano(), true
+}
+
+func (a *DateHistogramAggregation) Calculator() search.Calculator {
+	rv := newHistogramCalculator(a.aggregations, a.minDocCount, a.maxBuckets)
+	rv.err = a.validate()
+	rv.keys = func(d *search.DocumentMatch, keys []int64) []int64 {
+		for _, val := range a.src.Dates(d) {
+			if key, ok := a.key(val); ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	rv.next = func(key int64) int64 {
+		return a.next(time.Unix(0, key)).UnixNano()
+	}
+	rv.name = func(key int64) string {
+		return time.Unix(0, key).In(a.location).Format(time.RFC3339)
+	}
+	if a.bounds {
+		var minOK, maxOK bool
+		rv.boundsMin, minOK = a.key(a.boundsMin)
+		rv.boundsMax, maxOK = a.key(a.boundsMax)
+		rv.bounds = minOK && maxOK
+	}
+	return rv
+}
@@ -15,7 +15,9 @@
 package aggregations
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/blugelabs/bluge/search"
 	"github.com/caio/go-tdigest"
@@ -24,12 +26,17 @@ import (
 type QuantilesMetric struct {
 	src         search.NumericValuesSource
 	compression float64
+	percents    []float64
 }
 
+// defaultPercents are the percents of the quantiles in the JSON form
+var defaultPercents = []float64{1, 5, 25, 50, 75, 95, 99}
+
 func Quantiles(src search.NumericValuesSource) *QuantilesMetric {
 	return &QuantilesMetric{
 		src:         src,
 		compression: 100,
+		percents:    defaultPercents,
 	}
 }
 
@@ -41,21 +48,36 @@ func (c *QuantilesMetric) SetCompression(compression float64) error {
 	return nil
 }
 
+// SetPercents sets the percents, between 0 and 100, of the quantiles
+// in the JSON form, by default the 1st, 5th, 25th, 50th, 75th, 95th
+// and 99th percentiles
+func (c *QuantilesMetric) SetPercents(percents ...float64) error {
+	for _, percent := range percents {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("percents must be between 0 and 100")
+		}
+	}
+	c.percents = percents
+	return nil
+}
+
 func (c *QuantilesMetric) Fields() []string {
 	return c.src.Fields()
 }
 
 func (c *QuantilesMetric) Calculator() search.Calculator {
 	rv := &QuantilesCalculator{
-		src: c.src,
+		src:      c.src,
+		percents: c.percents,
 	}
 	rv.tdigest, _ = tdigest.New(tdigest.Compression(c.compression))
 	return rv
 }
 
 type QuantilesCalculator struct {
-	src     search.NumericValuesSource
-	tdigest *tdigest.TDigest
+	src      search.NumericValuesSource
+	percents []float64
+	tdigest  *tdigest.TDigest
 }
 
 func (c *QuantilesCalculator) Quantile(percent float64) (float64, error) {
@@ -80,3 +102,16 @@ func (c *QuantilesCalculator) Merge(other search.Calculator) {
 func (c *QuantilesCalculator) Finish() {
 
 }
+
+// MarshalJSON encodes the quantiles of the percents
+// of the metric, keyed by percent
+func (c *QuantilesCalculator) MarshalJSON() ([]byte, error) {
+	values := make(map[string]search.JSONFloat, len(c.percents))
+	for _, percent := range c.percents {
+		key := strconv.FormatFloat(percent, 'f', -1, 64)
+		values[key] = search.JSONFloat(c.tdigest.Quantile(percent / 100))
+	}
+	return json.Marshal(map[string]interface{}{
+		"values": values,
+	})
+}
@@ -0,0 +1,699 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// Pipeline aggregations calculate their results from the results of
+// their sibling aggregations, once these are finished.  They refer to
+// the metric of each bucket of a sibling bucket aggregation by a path
+// such as "histogram>sales", where "_count" is the number of matches
+// of the bucket.  Paths may go through aggregations with a single
+// bucket, such as filters, both to reach the bucket aggregation and
+// the metric of its buckets, as in "recent>histogram>errors>_count".
+// Missing or undefined values are NaN.
+//
+// Pipelines only use the results of their siblings, not those of a
+// parent bucket aggregation, a pipeline whose path does not lead to a
+// bucket aggregation, such as one added to the buckets of a histogram,
+// fails the search.
+//
+// Derivative, CumulativeSum, MovingFunction and BucketScript add their
+// value to each bucket, under their own name, so that other pipelines
+// may use it in turn.  BucketSelector and BucketSort list the buckets
+// they select, in their order, leaving the sibling untouched.
+
+// pipelineValue is the result of a pipeline added to a bucket,
+// which is calculated again, rather than merged
+type pipelineValue float64
+
+func (p pipelineValue) Consume(*search.DocumentMatch) {}
+
+func (p pipelineValue) Finish() {}
+
+func (p pipelineValue) Merge(search.Calculator) {}
+
+func (p pipelineValue) Value() float64 {
+	return float64(p)
+}
+
+// pipelineBase implements the methods of calculators
+// which do not apply to pipelines, and holds their error
+type pipelineBase struct {
+	err error
+}
+
+func (pipelineBase) Consume(*search.DocumentMatch) {}
+
+func (pipelineBase) Finish() {}
+
+func (pipelineBase) Merge(search.Calculator) {}
+
+// Err returns the error running the pipeline, such as
+// when its buckets path does not lead to a bucket aggregation
+func (p pipelineBase) Err() error {
+	return p.err
+}
+
+// singleBucketCalculator is a bucket aggregation with
+// a single bucket, such as a filter, which paths go through
+type singleBucketCalculator interface {
+	Bucket() *search.Bucket
+}
+
+// pathBuckets follows the path through the single bucket aggregations
+// to a bucket aggregation, returning its buckets and the remainder of
+// the path, the metric of these buckets
+func pathBuckets(bucket *search.Bucket, path string) (buckets []*search.Bucket, metric string, err error) {
+	names := strings.Split(path, ">")
+	for i, name := range names {
+		switch calc := bucket.Aggregation(name).(type) {
+		case singleBucketCalculator:
+			bucket = calc.Bucket()
+		case search.BucketCalculator:
+			return calc.Buckets(), strings.Join(names[i+1:], ">"), nil
+		default:
+			return nil, "", fmt.Errorf("buckets path %s: %s is not a sibling bucket aggregation", path, name)
+		}
+	}
+	return nil, "", fmt.Errorf("buckets path %s does not lead to a bucket aggregation", path)
+}
+
+// multiValueMetric is a metric calculating several values,
+// such as stats, which are named by "agg.value" in paths
+type multiValueMetric interface {
+	ValueOf(name string) float64
+}
+
+// bucketMetric returns the value of the metric of the bucket,
+// "_count" being the number of matches, or NaN if there is no such metric
+func bucketMetric(bucket *search.Bucket, metric string) float64 {
+	for i := strings.Index(metric, ">"); i >= 0; i = strings.Index(metric, ">") {
+		calc, ok := bucket.Aggregation(metric[:i]).(singleBucketCalculator)
+		if !ok {
+			return math.NaN()
+		}
+		bucket = calc.Bucket()
+		metric = metric[i+1:]
+	}
+	if metric == "_count" {
+		return float64(bucket.Count())
+	}
+	if calc, ok := bucket.Aggregation(metric).(search.MetricCalculator); ok {
+		return calc.Value()
+	}
+	if i := strings.LastIndex(metric, "."); i > 0 {
+		if calc, ok := bucket.Aggregation(metric[:i]).(multiValueMetric); ok {
+			return calc.ValueOf(metric[i+1:])
+		}
+	}
+	return math.NaN()
+}
+
+type DerivativeAggregation struct {
+	path string
+}
+
+// Derivative calculates the difference between the metric
+// of each bucket and the metric of the previous bucket
+func Derivative(bucketsPath string) *DerivativeAggregation {
+	return &DerivativeAggregation{
+		path: bucketsPath,
+	}
+}
+
+func (a *DerivativeAggregation) Fields() []string {
+	return nil
+}
+
+func (a *DerivativeAggregation) Calculator() search.Calculator {
+	return &DerivativeCalculator{
+		path: a.path,
+	}
+}
+
+type DerivativeCalculator struct {
+	pipelineBase
+	path string
+}
+
+func (c *DerivativeCalculator) BucketsPaths() []string {
+	return []string{c.path}
+}
+
+func (c *DerivativeCalculator) Pipeline(name string, bucket *search.Bucket) {
+	var buckets []*search.Bucket
+	var metric string
+	buckets, metric, c.err = pathBuckets(bucket, c.path)
+	previous := math.NaN()
+	for _, b := range buckets {
+		value := bucketMetric(b, metric)
+		b.SetAggregation(name, pipelineValue(value-previous))
+		previous = value
+	}
+}
+
+type CumulativeSumAggregation struct {
+	path string
+}
+
+// CumulativeSum calculates the sum of the metric
+// of each bucket and all the previous buckets
+func CumulativeSum(bucketsPath string) *CumulativeSumAggregation {
+	return &CumulativeSumAggregation{
+		path: bucketsPath,
+	}
+}
+
+func (a *CumulativeSumAggregation) Fields() []string {
+	return nil
+}
+
+func (a *CumulativeSumAggregation) Calculator() search.Calculator {
+	return &CumulativeSumCalculator{
+		path: a.path,
+	}
+}
+
+type CumulativeSumCalculator struct {
+	pipelineBase
+	path string
+}
+
+func (c *CumulativeSumCalculator) BucketsPaths() []string {
+	return []string{c.path}
+}
+
+func (c *CumulativeSumCalculator) Pipeline(name string, bucket *search.Bucket) {
+	var buckets []*search.Bucket
+	var metric string
+	buckets, metric, c.err = pathBuckets(bucket, c.path)
+	var sum float64
+	for _, b := range buckets {
+		// missing values are skipped
+		if value := bucketMetric(b, metric); !math.IsNaN(value) {
+			sum += value
+		}
+		b.SetAggregation(name, pipelineValue(sum))
+	}
+}
+
+// MovingFunc calculates a value from the values of a window of buckets
+type MovingFunc func(values []float64) float64
+
+type MovingFunctionAggregation struct {
+	path   string
+	window int
+	fn     MovingFunc
+}
+
+// MovingFunction applies the function to the metric of the
+// 'window' buckets preceding each bucket, missing values are
+// left out, so the function may see fewer values, a negative
+// window fails the search
+func MovingFunction(bucketsPath string, window int, fn MovingFunc) *MovingFunctionAggregation {
+	return &MovingFunctionAggregation{
+		path:   bucketsPath,
+		window: window,
+		fn:     fn,
+	}
+}
+
+func (a *MovingFunctionAggregation) Fields() []string {
+	return nil
+}
+
+func (a *MovingFunctionAggregation) Calculator() search.Calculator {
+	return &MovingFunctionCalculator{
+		agg: a,
+	}
+}
+
+type MovingFunctionCalculator struct {
+	pipelineBase
+	agg *MovingFunctionAggregation
+}
+
+func (c *MovingFunctionCalculator) BucketsPaths() []string {
+	return []string{c.agg.path}
+}
+
+func (c *MovingFunctionCalculator) Pipeline(name string, bucket *search.Bucket) {
+	if c.agg.window < 0 {
+		c.err = fmt.Errorf("moving function window must not be negative, got %d", c.agg.window)
+		return
+	}
+	var buckets []*search.Bucket
+	var metric string
+	buckets, metric, c.err = pathBuckets(bucket, c.agg.path)
+	values := make([]float64, len(buckets))
+	for i, b := range buckets {
+		values[i] = bucketMetric(b, metric)
+	}
+	window := make([]float64, 0, c.agg.window)
+	for i, b := range buckets {
+		window = window[:0]
+		start := i - c.agg.window
+		if start < 0 {
+			start = 0
+		}
+		for _, value := range values[start:i] {
+			if !math.IsNaN(value) {
+				window = append(window, value)
+			}
+		}
+		b.SetAggregation(name, pipelineValue(c.agg.fn(window)))
+	}
+}
+
+// UnweightedAvg is the mean of the values, or NaN without values
+func UnweightedAvg(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// LinearWeightedAvg is the mean of the values, weighting the
+// later values more, or NaN without values
+func LinearWeightedAvg(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	var sum, weights float64
+	for i, value := range values {
+		weight := float64(i + 1)
+		sum += value * weight
+		weights += weight
+	}
+	return sum / weights
+}
+
+type BucketScriptAggregation struct {
+	paths  map[string]string
+	script func(vars map[string]float64) float64
+}
+
+// BucketScript calculates a value for each bucket from metrics
+// of the bucket, the script is provided the value of each path
+// by its variable name, all the paths must share the same
+// bucket aggregation, such as "histogram>sales" and "histogram>_count"
+func BucketScript(bucketsPaths map[string]string,
+	script func(vars map[string]float64) float64) *BucketScriptAggregation {
+	return &BucketScriptAggregation{
+		paths:  bucketsPaths,
+		script: script,
+	}
+}
+
+func (a *BucketScriptAggregation) Fields() []string {
+	return nil
+}
+
+func (a *BucketScriptAggregation) Calculator() search.Calculator {
+	return &BucketScriptCalculator{
+		paths:  a.paths,
+		script: a.script,
+	}
+}
+
+type BucketScriptCalculator struct {
+	pipelineBase
+	paths  map[string]string
+	script func(vars map[string]float64) float64
+}
+
+func (c *BucketScriptCalculator) BucketsPaths() []string {
+	return pathValues(c.paths)
+}
+
+func (c *BucketScriptCalculator) Pipeline(name string, bucket *search.Bucket) {
+	c.err = eachBucketVars(bucket, c.paths, func(b *search.Bucket, vars map[string]float64) {
+		b.SetAggregation(name, pipelineValue(c.script(vars)))
+	})
+}
+
+func pathValues(paths map[string]string) []string {
+	rv := make([]string, 0, len(paths))
+	for _, path := range paths {
+		rv = append(rv, path)
+	}
+	return rv
+}
+
+// eachBucketVars calls f with each bucket of the bucket aggregation
+// of the paths, and the value of each path in this bucket
+func eachBucketVars(bucket *search.Bucket, paths map[string]string,
+	f func(b *search.Bucket, vars map[string]float64)) error {
+	var buckets []*search.Bucket
+	metrics := make(map[string]string, len(paths))
+	for name, path := range paths {
+		var err error
+		buckets, metrics[name], err = pathBuckets(bucket, path)
+		if err != nil {
+			return err
+		}
+	}
+	vars := make(map[string]float64, len(paths))
+	for _, b := range buckets {
+		for name, metric := range metrics {
+			vars[name] = bucketMetric(b, metric)
+		}
+		f(b, vars)
+	}
+	return nil
+}
+
+type BucketSelectorAggregation struct {
+	paths    map[string]string
+	selector func(vars map[string]float64) bool
+}
+
+// BucketSelector selects the buckets of a bucket aggregation for
+// which the selector returns true, the selector is provided the
+// value of each path by its variable name
+func BucketSelector(bucketsPaths map[string]string,
+	selector func(vars map[string]float64) bool) *BucketSelectorAggregation {
+	return &BucketSelectorAggregation{
+		paths:    bucketsPaths,
+		selector: selector,
+	}
+}
+
+func (a *BucketSelectorAggregation) Fields() []string {
+	return nil
+}
+
+func (a *BucketSelectorAggregation) Calculator() search.Calculator {
+	return &BucketSelectorCalculator{
+		paths:    a.paths,
+		selector: a.selector,
+	}
+}
+
+type BucketSelectorCalculator struct {
+	pipelineBase
+	paths    map[string]string
+	selector func(vars map[string]float64) bool
+	buckets  []*search.Bucket
+}
+
+func (c *BucketSelectorCalculator) BucketsPaths() []string {
+	return pathValues(c.paths)
+}
+
+func (c *BucketSelectorCalculator) Pipeline(_ string, bucket *search.Bucket) {
+	c.buckets = nil
+	c.err = eachBucketVars(bucket, c.paths, func(b *search.Bucket, vars map[string]float64) {
+		if c.selector(vars) {
+			c.buckets = append(c.buckets, b)
+		}
+	})
+}
+
+// Buckets returns the selected buckets
+func (c *BucketSelectorCalculator) Buckets() []*search.Bucket {
+	return c.buckets
+}
+
+type bucketSortField struct {
+	metric string
+	desc   bool
+}
+
+type BucketSortAggregation struct {
+	agg   string
+	sort  []bucketSortField
+	from  int
+	size  int
+	limit bool
+}
+
+// BucketSort sorts the buckets of the named bucket aggregation, which
+// may be a path through single bucket aggregations, and then keeps
+// some of them, by default, all of them
+func BucketSort(agg string) *BucketSortAggregation {
+	return &BucketSortAggregation{
+		agg: agg,
+	}
+}
+
+// SortBy sorts the buckets by a metric, "_key" sorting them
+// by name, buckets which are equal remain in the same order
+func (a *BucketSortAggregation) SortBy(metric string, desc bool) *BucketSortAggregation {
+	a.sort = append(a.sort, bucketSortField{
+		metric: metric,
+		desc:   desc,
+	})
+	return a
+}
+
+// From skips the first buckets, a negative number fails the search
+func (a *BucketSortAggregation) From(from int) *BucketSortAggregation {
+	a.from = from
+	return a
+}
+
+// Size keeps this many buckets, a negative number fails the search
+func (a *BucketSortAggregation) Size(size int) *BucketSortAggregation {
+	a.size = size
+	a.limit = true
+	return a
+}
+
+func (a *BucketSortAggregation) Fields() []string {
+	return nil
+}
+
+func (a *BucketSortAggregation) Calculator() search.Calculator {
+	return &BucketSortCalculator{
+		agg: a,
+	}
+}
+
+type BucketSortCalculator struct {
+	pipelineBase
+	agg     *BucketSortAggregation
+	buckets []*search.Bucket
+}
+
+func (c *BucketSortCalculator) BucketsPaths() []string {
+	rv := make([]string, len(c.agg.sort))
+	for i, field := range c.agg.sort {
+		rv[i] = c.agg.agg + ">" + field.metric
+	}
+	return rv
+}
+
+func (c *BucketSortCalculator) Pipeline(_ string, bucket *search.Bucket) {
+	c.buckets = nil
+	if c.agg.from < 0 || (c.agg.limit && c.agg.size < 0) {
+		c.err = fmt.Errorf("bucket sort from and size must not be negative, got %d and %d",
+			c.agg.from, c.agg.size)
+		return
+	}
+	var buckets []*search.Bucket
+	buckets, _, c.err = pathBuckets(bucket, c.agg.agg)
+	c.buckets = append(c.buckets, buckets...)
+	sort.SliceStable(c.buckets, func(i, j int) bool {
+		return c.compare(c.buckets[i], c.buckets[j]) < 0
+	})
+
+	from := c.agg.from
+	if from > len(c.buckets) {
+		from = len(c.buckets)
+	}
+	c.buckets = c.buckets[from:]
+	if c.agg.limit && c.agg.size < len(c.buckets) {
+		c.buckets = c.buckets[:c.agg.size]
+	}
+}
+
+func (c *BucketSortCalculator) compare(a, b *search.Bucket) int {
+	for _, field := range c.agg.sort {
+		var cmp int
+		if field.metric == "_key" {
+			cmp = strings.Compare(a.Name(), b.Name())
+			if field.desc {
+				cmp = -cmp
+			}
+		} else {
+			cmp = compareMetrics(bucketMetric(a, field.metric), bucketMetric(b, field.metric), field.desc)
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// compareMetrics orders the values ascending, or descending,
+// missing values are ordered last in both directions
+func compareMetrics(a, b float64, desc bool) int {
+	var cmp int
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	case a < b:
+		cmp = -1
+	case a > b:
+		cmp = 1
+	}
+	if desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// Buckets returns the sorted buckets
+func (c *BucketSortCalculator) Buckets() []*search.Bucket {
+	return c.buckets
+}
+
+type BucketMetricAggregation struct {
+	path    string
+	compute func(c *BucketMetricCalculator, b *search.Bucket, value float64)
+}
+
+// MaxBucket finds the greatest metric of the buckets
+// of a bucket aggregation, and the buckets with it
+func MaxBucket(bucketsPath string) *BucketMetricAggregation {
+	return &BucketMetricAggregation{
+		path:    bucketsPath,
+		compute: extremeBucket(1),
+	}
+}
+
+// MinBucket finds the least metric of the buckets
+// of a bucket aggregation, and the buckets with it
+func MinBucket(bucketsPath string) *BucketMetricAggregation {
+	return &BucketMetricAggregation{
+		path:    bucketsPath,
+		compute: extremeBucket(-1),
+	}
+}
+
+func extremeBucket(direction float64) func(c *BucketMetricCalculator, b *search.Bucket, value float64) {
+	return func(c *BucketMetricCalculator, b *search.Bucket, value float64) {
+		switch {
+		case math.IsNaN(c.value) || value*direction > c.value*direction:
+			c.value = value
+			c.keys = append(c.keys[:0], b.Name())
+		case value == c.value:
+			c.keys = append(c.keys, b.Name())
+		}
+	}
+}
+
+// AvgBucket finds the mean metric of the buckets of a bucket aggregation
+func AvgBucket(bucketsPath string) *BucketMetricAggregation {
+	return &BucketMetricAggregation{
+		path: bucketsPath,
+		compute: func(c *BucketMetricCalculator, b *search.Bucket, value float64) {
+			c.count++
+			if math.IsNaN(c.value) {
+				c.value = 0
+			}
+			c.value += (value - c.value) / float64(c.count)
+		},
+	}
+}
+
+// SumBucket finds the sum of the metric of the buckets of a bucket aggregation
+func SumBucket(bucketsPath string) *BucketMetricAggregation {
+	return &BucketMetricAggregation{
+		path: bucketsPath,
+		compute: func(c *BucketMetricCalculator, b *search.Bucket, value float64) {
+			if math.IsNaN(c.value) {
+				c.value = 0
+			}
+			c.value += value
+		},
+	}
+}
+
+func (a *BucketMetricAggregation) Fields() []string {
+	return nil
+}
+
+func (a *BucketMetricAggregation) Calculator() search.Calculator {
+	return &BucketMetricCalculator{
+		agg:   a,
+		value: math.NaN(),
+	}
+}
+
+type BucketMetricCalculator struct {
+	pipelineBase
+	agg   *BucketMetricAggregation
+	value float64
+	count int
+	keys  []string
+}
+
+func (c *BucketMetricCalculator) BucketsPaths() []string {
+	return []string{c.agg.path}
+}
+
+func (c *BucketMetricCalculator) Pipeline(_ string, bucket *search.Bucket) {
+	c.value = math.NaN()
+	c.count = 0
+	c.keys = nil
+	var buckets []*search.Bucket
+	var metric string
+	buckets, metric, c.err = pathBuckets(bucket, c.agg.path)
+	for _, b := range buckets {
+		// missing values are skipped
+		if value := bucketMetric(b, metric); !math.IsNaN(value) {
+			c.agg.compute(c, b, value)
+		}
+	}
+}
+
+func (c *BucketMetricCalculator) Value() float64 {
+	return c.value
+}
+
+// Keys returns the names of the buckets with the
+// greatest, or least, metric
+func (c *BucketMetricCalculator) Keys() []string {
+	return c.keys
+}
+
+func (c *BucketMetricCalculator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Value search.JSONFloat `json:"value"`
+		Keys  []string         `json:"keys"`
+	}{
+		Value: search.JSONFloat(c.value),
+		Keys:  c.keys,
+	})
+}
@@ -36,7 +36,11 @@ func Ranges(src search.NumericValuesSource) *RangeAggregation {
 }
 
 func (a *RangeAggregation) Fields() []string {
-	return a.src.Fields()
+	rv := a.src.Fields()
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
 }
 
 func (a *RangeAggregation) AddRange(rang *NumericRange) *RangeAggregation {
@@ -49,6 +53,14 @@ func (a *RangeAggregation) AddAggregation(name string, agg search.Aggregation) *
 	return a
 }
 
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (a *RangeAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
 func (a *RangeAggregation) Calculator() search.Calculator {
 	rv := &RangeCalculator{
 		src:    a.src,
@@ -37,7 +37,11 @@ func DateRanges(src search.DateValuesSource) *DateRangeAggregation {
 }
 
 func (a *DateRangeAggregation) Fields() []string {
-	return a.src.Fields()
+	rv := a.src.Fields()
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
 }
 
 func (a *DateRangeAggregation) AddRange(rang *DateRange) *DateRangeAggregation {
@@ -50,6 +54,14 @@ func (a *DateRangeAggregation) AddAggregation(name string, agg search.Aggregatio
 	return a
 }
 
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (a *DateRangeAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
 func (a *DateRangeAggregation) Calculator() search.Calculator {
 	rv := &DateRangeCalculator{
 		src:    a.src,
@@ -0,0 +1,537 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// SignificanceHeuristic scores how unusually frequent a term is in
+// the matches, the subset, compared to the background, the superset
+type SignificanceHeuristic interface {
+	Score(subsetFreq, subsetSize, supersetFreq, supersetSize uint64) float64
+}
+
+// BackgroundQuery finds the documents of the background,
+// any bluge.Query may be used
+type BackgroundQuery interface {
+	Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error)
+}
+
+// backgroundReader is implemented by the readers
+// whose documents form the background
+type backgroundReader interface {
+	search.Reader
+	Count() (uint64, error)
+}
+
+// SignificantTermsAggregation finds the terms which are unusually
+// frequent in the matches, compared to the documents of the index
+type SignificantTermsAggregation struct {
+	field       string
+	src         search.TextValuesSource
+	size        int
+	minDocCount uint64
+	heuristic   SignificanceHeuristic
+	background  BackgroundQuery
+	options     search.SearcherOptions
+
+	aggregations map[string]search.Aggregation
+}
+
+// SignificantTerms finds the 'size' most significant terms of the
+// field, which must have document values, by default scored using JLH
+func SignificantTerms(field string, size int) *SignificantTermsAggregation {
+	return &SignificantTermsAggregation{
+		field:       field,
+		src:         search.Field(field),
+		size:        size,
+		minDocCount: 3,
+		heuristic:   JLH(),
+		options:     defaultSearcherOptions(),
+		aggregations: map[string]search.Aggregation{
+			"count": CountMatches(),
+		},
+	}
+}
+
+// Heuristic sets how the significance of terms is scored
+func (a *SignificantTermsAggregation) Heuristic(heuristic SignificanceHeuristic) *SignificantTermsAggregation {
+	a.heuristic = heuristic
+	return a
+}
+
+// BackgroundFilter compares the matches to the documents
+// matching the query, instead of all of the documents
+func (a *SignificantTermsAggregation) BackgroundFilter(q BackgroundQuery) *SignificantTermsAggregation {
+	a.background = q
+	return a
+}
+
+// MinDocCount only keeps terms in at least this many matches, 3 by
+// default, as rare terms are otherwise too easily significant
+func (a *SignificantTermsAggregation) MinDocCount(count uint64) *SignificantTermsAggregation {
+	a.minDocCount = count
+	return a
+}
+
+func (a *SignificantTermsAggregation) AddAggregation(name string, agg search.Aggregation) *SignificantTermsAggregation {
+	a.aggregations[name] = agg
+	return a
+}
+
+// WithSearcherOptions returns a copy of the aggregation, searching
+// for the background using the options of the search
+func (a *SignificantTermsAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *a
+	rv.options = matchingOptions(options)
+	rv.aggregations = search.Aggregations(a.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+func (a *SignificantTermsAggregation) Fields() []string {
+	rv := a.src.Fields()
+	for _, agg := range a.aggregations {
+		rv = append(rv, agg.Fields()...)
+	}
+	return rv
+}
+
+func (a *SignificantTermsAggregation) Calculator() search.Calculator {
+	return &SignificantTermsCalculator{
+		agg:        a,
+		bucketsMap: make(map[string]*search.Bucket),
+		scores:     make(map[string]float64),
+	}
+}
+
+// SignificantTermsCalculator counts the terms of the matches, and
+// once finished, the same terms in the background of each reader
+// in which matches were found, these readers are then the superset
+type SignificantTermsCalculator struct {
+	agg *SignificantTermsAggregation
+
+	subsetSize  uint64
+	bucketsMap  map[string]*search.Bucket
+	readers     []*readerBackground
+	bucketsList []*search.Bucket
+
+	supersetSize  uint64
+	supersetFreqs map[string]uint64
+	scores        map[string]float64
+	err           error
+}
+
+func (c *SignificantTermsCalculator) Consume(d *search.DocumentMatch) {
+	c.subsetSize++
+	if reader, ok := d.Reader().(backgroundReader); ok && (len(c.readers) == 0 ||
+		c.readers[len(c.readers)-1].reader != reader) {
+		c.addReader(&readerBackground{
+			reader:  reader,
+			options: c.agg.options,
+		})
+	}
+
+	values := c.agg.src.Values(d)
+	for i, term := range values {
+		if alreadyCounted(values[:i], term) {
+			continue
+		}
+		termStr := string(term)
+		bucket, ok := c.bucketsMap[termStr]
+		if !ok {
+			bucket = search.NewBucket(termStr, c.agg.aggregations)
+			c.bucketsMap[termStr] = bucket
+		}
+		bucket.Consume(d)
+	}
+}
+
+func alreadyCounted(terms [][]byte, term []byte) bool {
+	for _, t := range terms {
+		if string(t) == string(term) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *SignificantTermsCalculator) addReader(rb *readerBackground) {
+	for _, existing := range c.readers {
+		if existing.reader == rb.reader {
+			return
+		}
+	}
+	c.readers = append(c.readers, rb)
+}
+
+func (c *SignificantTermsCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*SignificantTermsCalculator); ok {
+		c.subsetSize += other.subsetSize
+		for name, otherBucket := range other.bucketsMap {
+			if bucket, ok := c.bucketsMap[name]; ok {
+				bucket.Merge(otherBucket)
+			} else {
+				c.bucketsMap[name] = otherBucket
+			}
+		}
+		for _, rb := range other.readers {
+			c.addReader(rb)
+		}
+		if c.err == nil {
+			c.err = other.err
+		}
+		// now re-invoke finish, to score the merged terms
+		c.Finish()
+	}
+}
+
+func (c *SignificantTermsCalculator) Finish() {
+	c.bucketsList = c.bucketsList[:0]
+	c.supersetSize = 0
+	c.supersetFreqs = make(map[string]uint64, len(c.bucketsMap))
+	for _, rb := range c.readers {
+		err := c.addBackground(rb)
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+
+	c.scores = make(map[string]float64, len(c.bucketsMap))
+	for name, bucket := range c.bucketsMap {
+		bucket.Finish()
+		subsetFreq := bucket.Count()
+		if subsetFreq < c.agg.minDocCount {
+			continue
+		}
+		score := c.agg.heuristic.Score(subsetFreq, c.subsetSize, c.supersetFreqs[name], c.supersetSize)
+		if score > 0 && !math.IsInf(score, 0) && !math.IsNaN(score) {
+			c.scores[name] = score
+			c.bucketsList = append(c.bucketsList, bucket)
+		}
+	}
+
+	sort.Slice(c.bucketsList, func(i, j int) bool {
+		si, sj := c.scores[c.bucketsList[i].Name()], c.scores[c.bucketsList[j].Name()]
+		if si != sj {
+			return si > sj
+		}
+		return c.bucketsList[i].Name() < c.bucketsList[j].Name()
+	})
+	if len(c.bucketsList) > c.agg.size {
+		c.bucketsList = c.bucketsList[:c.agg.size]
+	}
+}
+
+func (c *SignificantTermsCalculator) addBackground(rb *readerBackground) error {
+	size, err := rb.size(c.agg.field, c.agg.background)
+	if err != nil {
+		return err
+	}
+	c.supersetSize += size
+	for name := range c.bucketsMap {
+		freq, err := rb.freq(c.agg.field, name, c.agg.background)
+		if err != nil {
+			return err
+		}
+		c.supersetFreqs[name] += freq
+	}
+	return nil
+}
+
+// Buckets returns the most significant terms, most significant first
+func (c *SignificantTermsCalculator) Buckets() []*search.Bucket {
+	return c.bucketsList
+}
+
+// Score returns the significance of the term of the bucket
+func (c *SignificantTermsCalculator) Score(bucket *search.Bucket) float64 {
+	return c.scores[bucket.Name()]
+}
+
+// BackgroundCount returns the number of background
+// documents with the term of the bucket
+func (c *SignificantTermsCalculator) BackgroundCount(bucket *search.Bucket) uint64 {
+	return c.supersetFreqs[bucket.Name()]
+}
+
+// SubsetSize returns the number of matches
+func (c *SignificantTermsCalculator) SubsetSize() uint64 {
+	return c.subsetSize
+}
+
+// SupersetSize returns the number of background documents
+func (c *SignificantTermsCalculator) SupersetSize() uint64 {
+	return c.supersetSize
+}
+
+// Err returns the error reading the background, if any
+func (c *SignificantTermsCalculator) Err() error {
+	return c.err
+}
+
+type significantTermsBucketJSON struct {
+	*search.BucketJSON
+	Score           search.JSONFloat `json:"score"`
+	BackgroundCount uint64           `json:"background_count"`
+}
+
+func (c *SignificantTermsCalculator) MarshalJSON() ([]byte, error) {
+	buckets := make([]significantTermsBucketJSON, len(c.bucketsList))
+	for i, bucket := range c.bucketsList {
+		buckets[i] = significantTermsBucketJSON{
+			BucketJSON:      bucket.JSON(),
+			Score:           search.JSONFloat(c.Score(bucket)),
+			BackgroundCount: c.BackgroundCount(bucket),
+		}
+	}
+	return json.Marshal(struct {
+		Buckets      []significantTermsBucketJSON `json:"buckets"`
+		SubsetSize   uint64                       `json:"subset_size"`
+		SupersetSize uint64                       `json:"superset_size"`
+	}{
+		Buckets:      buckets,
+		SubsetSize:   c.SubsetSize(),
+		SupersetSize: c.SupersetSize(),
+	})
+}
+
+// readerBackground reads, and remembers, the background of one reader
+type readerBackground struct {
+	reader  backgroundReader
+	options search.SearcherOptions
+
+	docCount uint64
+	freqs    map[string]uint64
+
+	// with a background filter, the terms of all
+	// the documents matching the filter are counted
+	filtered bool
+}
+
+func (r *readerBackground) size(field string, background BackgroundQuery) (uint64, error) {
+	if background != nil {
+		err := r.filter(field, background)
+		return r.docCount, err
+	}
+	if r.freqs == nil {
+		count, err := r.reader.Count()
+		if err != nil {
+			return 0, err
+		}
+		r.docCount = count
+		r.freqs = make(map[string]uint64)
+	}
+	return r.docCount, nil
+}
+
+func (r *readerBackground) freq(field, term string, background BackgroundQuery) (uint64, error) {
+	if background != nil {
+		err := r.filter(field, background)
+		return r.freqs[term], err
+	}
+	if freq, ok := r.freqs[term]; ok {
+		return freq, nil
+	}
+	postings, err := r.reader.PostingsIterator([]byte(term), field, false, false, false)
+	if err != nil {
+		return 0, err
+	}
+	freq := postings.Count()
+	err = postings.Close()
+	if err != nil {
+		return 0, err
+	}
+	r.freqs[term] = freq
+	return freq, nil
+}
+
+// filter counts the terms of the documents matching the background filter
+func (r *readerBackground) filter(field string, background BackgroundQuery) (err error) {
+	if r.filtered {
+		return nil
+	}
+	r.filtered = true
+	r.freqs = make(map[string]uint64)
+
+	searcher, err := background.Searcher(r.reader, r.options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := searcher.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	src := search.Field(field)
+	fields := []string{field}
+	ctx := search.NewSearchContext(searcher.DocumentMatchPoolSize(), 0)
+	dm, err := searcher.Next(ctx)
+	for err == nil && dm != nil {
+		r.docCount++
+		err = dm.LoadDocumentValues(ctx, fields)
+		if err != nil {
+			return err
+		}
+		values := src.Values(dm)
+		for i, term := range values {
+			if !alreadyCounted(values[:i], term) {
+				r.freqs[string(term)]++
+			}
+		}
+		ctx.DocumentMatchPool.Put(dm)
+		dm, err = searcher.Next(ctx)
+	}
+	return err
+}
+
+// JLH scores terms by both the absolute and the relative change
+// of their frequency, favouring terms whose frequency rose most
+func JLH() SignificanceHeuristic {
+	return jlh{}
+}
+
+type jlh struct{}
+
+func (jlh) Score(subsetFreq, subsetSize, supersetFreq, supersetSize uint64) float64 {
+	if subsetSize == 0 || supersetSize == 0 || supersetFreq == 0 {
+		return 0
+	}
+	subsetProbability := float64(subsetFreq) / float64(subsetSize)
+	supersetProbability := float64(supersetFreq) / float64(supersetSize)
+	if subsetProbability <= supersetProbability {
+		return 0
+	}
+	absoluteChange := subsetProbability - supersetProbability
+	relativeChange := subsetProbability / supersetProbability
+	return absoluteChange * relativeChange
+}
+
+// PercentageScore scores terms by the fraction of
+// the background documents with the term which matched
+func PercentageScore() SignificanceHeuristic {
+	return percentageScore{}
+}
+
+type percentageScore struct{}
+
+func (percentageScore) Score(subsetFreq, _, supersetFreq, _ uint64) float64 {
+	if supersetFreq == 0 {
+		return 0
+	}
+	return float64(subsetFreq) / float64(supersetFreq)
+}
+
+// contingency is the table of the number of documents with
+// (1) or without (0) the term, in (1) or out of (0) the subset
+type contingency struct {
+	n11, n10, n01, n00 float64
+	n                  float64
+}
+
+// newContingency builds the table, when the background is a
+// superset of the matches, the matches are removed from it
+func newContingency(subsetFreq, subsetSize, supersetFreq, supersetSize uint64,
+	backgroundIsSuperset bool) contingency {
+	rv := contingency{
+		n11: float64(subsetFreq),
+		n01: float64(subsetSize) - float64(subsetFreq),
+	}
+	if backgroundIsSuperset {
+		rv.n10 = float64(supersetFreq) - float64(subsetFreq)
+		rv.n00 = float64(supersetSize) - float64(subsetSize) - rv.n10
+		rv.n = float64(supersetSize)
+	} else {
+		rv.n10 = float64(supersetFreq)
+		rv.n00 = float64(supersetSize) - float64(supersetFreq)
+		rv.n = float64(supersetSize) + float64(subsetSize)
+	}
+	return rv
+}
+
+// lessFrequent returns true if the term is less frequent in
+// the subset than outside of it
+func (c contingency) lessFrequent() bool {
+	return c.n11/(c.n11+c.n01) < c.n10/(c.n10+c.n00)
+}
+
+// ChiSquare scores terms by the chi-square statistic of their
+// frequency in and out of the matches, unless negatives are included
+// terms less frequent in the matches are not significant, the
+// background is a superset when it includes the matches
+func ChiSquare(includeNegatives, backgroundIsSuperset bool) SignificanceHeuristic {
+	return chiSquare{
+		includeNegatives:     includeNegatives,
+		backgroundIsSuperset: backgroundIsSuperset,
+	}
+}
+
+type chiSquare struct {
+	includeNegatives     bool
+	backgroundIsSuperset bool
+}
+
+func (h chiSquare) Score(subsetFreq, subsetSize, supersetFreq, supersetSize uint64) float64 {
+	c := newContingency(subsetFreq, subsetSize, supersetFreq, supersetSize, h.backgroundIsSuperset)
+	if !h.includeNegatives && c.lessFrequent() {
+		return math.Inf(-1)
+	}
+	diff := c.n11*c.n00 - c.n01*c.n10
+	return c.n * diff * diff /
+		((c.n11 + c.n01) * (c.n11 + c.n10) * (c.n10 + c.n00) * (c.n01 + c.n00))
+}
+
+// MutualInformation scores terms by how much knowing whether a
+// document has the term tells about whether it matched, unless
+// negatives are included terms less frequent in the matches are
+// not significant, the background is a superset when it
+// includes the matches
+func MutualInformation(includeNegatives, backgroundIsSuperset bool) SignificanceHeuristic {
+	return mutualInformation{
+		includeNegatives:     includeNegatives,
+		backgroundIsSuperset: backgroundIsSuperset,
+	}
+}
+
+type mutualInformation struct {
+	includeNegatives     bool
+	backgroundIsSuperset bool
+}
+
+func (h mutualInformation) Score(subsetFreq, subsetSize, supersetFreq, supersetSize uint64) float64 {
+	c := newContingency(subsetFreq, subsetSize, supersetFreq, supersetSize, h.backgroundIsSuperset)
+	if !h.includeNegatives && c.lessFrequent() {
+		return math.Inf(-1)
+	}
+	n1x, n0x := c.n11+c.n10, c.n01+c.n00
+	nx1, nx0 := c.n11+c.n01, c.n10+c.n00
+	return mutualInformationTerm(c.n11, n1x, nx1, c.n) +
+		mutualInformationTerm(c.n01, n0x, nx1, c.n) +
+		mutualInformationTerm(c.n10, n1x, nx0, c.n) +
+		mutualInformationTerm(c.n00, n0x, nx0, c.n)
+}
+
+func mutualInformationTerm(nxy, nx, ny, n float64) float64 {
+	if nxy <= 0 || nx <= 0 || ny <= 0 {
+		return 0
+	}
+	return nxy / n * math.Log2(n*nxy/(nx*ny))
+}
@@ -0,0 +1,258 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// StatsMetric calculates the count, min, max, sum and average of the
+// values in a single pass, and for extended stats, their variance
+// and standard deviation as well
+type StatsMetric struct {
+	src   search.NumericValuesSource
+	sigma float64
+}
+
+func Stats(src search.NumericValuesSource) *StatsMetric {
+	return &StatsMetric{
+		src:   src,
+		sigma: 2,
+	}
+}
+
+// ExtendedStats is the same as Stats, as the variance is always
+// calculated, and is provided for clarity where it is used
+func ExtendedStats(src search.NumericValuesSource) *StatsMetric {
+	return Stats(src)
+}
+
+// Sigma sets how many standard deviations from the
+// average the bounds are, 2 by default
+func (s *StatsMetric) Sigma(sigma float64) *StatsMetric {
+	s.sigma = sigma
+	return s
+}
+
+func (s *StatsMetric) Fields() []string {
+	return s.src.Fields()
+}
+
+func (s *StatsMetric) Calculator() search.Calculator {
+	return &StatsCalculator{
+		src:   s.src,
+		sigma: s.sigma,
+		min:   math.Inf(1),
+		max:   math.Inf(-1),
+	}
+}
+
+// StatsCalculator updates the average and the sum of squared
+// differences from it with each value, using Welford's algorithm,
+// which unlike summing squares, is numerically stable
+type StatsCalculator struct {
+	src   search.NumericValuesSource
+	sigma float64
+
+	count uint64
+	min   float64
+	max   float64
+	sum   float64
+	mean  float64
+	m2    float64
+}
+
+func (s *StatsCalculator) Consume(d *search.DocumentMatch) {
+	for _, val := range s.src.Numbers(d) {
+		s.count++
+		s.sum += val
+		if val < s.min {
+			s.min = val
+		}
+		if val > s.max {
+			s.max = val
+		}
+		delta := val - s.mean
+		s.mean += delta / float64(s.count)
+		s.m2 += delta * (val - s.mean)
+	}
+}
+
+// Merge combines the averages and the sums of squared differences
+// of both, as in the parallel algorithm of Chan et al.
+func (s *StatsCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*StatsCalculator); ok {
+		if other.count == 0 {
+			return
+		}
+		count := s.count + other.count
+		delta := other.mean - s.mean
+		s.mean += delta * float64(other.count) / float64(count)
+		s.m2 += other.m2 + delta*delta*float64(s.count)*float64(other.count)/float64(count)
+		s.count = count
+		s.sum += other.sum
+		s.min = math.Min(s.min, other.min)
+		s.max = math.Max(s.max, other.max)
+	}
+}
+
+func (s *StatsCalculator) Finish() {}
+
+// Count returns the number of values
+func (s *StatsCalculator) Count() uint64 {
+	return s.count
+}
+
+// Min returns the least value, or NaN without values
+func (s *StatsCalculator) Min() float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.min
+}
+
+// Max returns the greatest value, or NaN without values
+func (s *StatsCalculator) Max() float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.max
+}
+
+// Sum returns the sum of the values
+func (s *StatsCalculator) Sum() float64 {
+	return s.sum
+}
+
+// Avg returns the average of the values, or NaN without values
+func (s *StatsCalculator) Avg() float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.mean
+}
+
+// SumOfSquares returns the sum of the squares of the values
+func (s *StatsCalculator) SumOfSquares() float64 {
+	return s.m2 + float64(s.count)*s.mean*s.mean
+}
+
+// Variance returns the population variance of the values,
+// or NaN without values
+func (s *StatsCalculator) Variance() float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.m2 / float64(s.count)
+}
+
+// VarianceSampling returns the sample variance of the values,
+// or NaN with less than two values
+func (s *StatsCalculator) VarianceSampling() float64 {
+	if s.count < 2 {
+		return math.NaN()
+	}
+	return s.m2 / float64(s.count-1)
+}
+
+// StdDeviation returns the population standard deviation
+// of the values, or NaN without values
+func (s *StatsCalculator) StdDeviation() float64 {
+	return math.Sqrt(s.Variance())
+}
+
+// StdDeviationSampling returns the sample standard deviation
+// of the values, or NaN with less than two values
+func (s *StatsCalculator) StdDeviationSampling() float64 {
+	return math.Sqrt(s.VarianceSampling())
+}
+
+// StdDeviationBounds returns the average plus and minus
+// sigma standard deviations
+func (s *StatsCalculator) StdDeviationBounds() (upper, lower float64) {
+	avg, dev := s.Avg(), s.StdDeviation()
+	return avg + s.sigma*dev, avg - s.sigma*dev
+}
+
+// ValueOf returns one of the values calculated, by name, "count",
+// "min", "max", "sum", "avg", "sum_of_squares", "variance",
+// "variance_sampling", "std_deviation", "std_deviation_sampling",
+// "std_upper" or "std_lower", or NaN for other names
+func (s *StatsCalculator) ValueOf(name string) float64 {
+	switch name {
+	case "count":
+		return float64(s.count)
+	case "min":
+		return s.Min()
+	case "max":
+		return s.Max()
+	case "sum":
+		return s.Sum()
+	case "avg":
+		return s.Avg()
+	case "sum_of_squares":
+		return s.SumOfSquares()
+	case "variance":
+		return s.Variance()
+	case "variance_sampling":
+		return s.VarianceSampling()
+	case "std_deviation":
+		return s.StdDeviation()
+	case "std_deviation_sampling":
+		return s.StdDeviationSampling()
+	case "std_upper":
+		upper, _ := s.StdDeviationBounds()
+		return upper
+	case "std_lower":
+		_, lower := s.StdDeviationBounds()
+		return lower
+	}
+	return math.NaN()
+}
+
+func (s *StatsCalculator) MarshalJSON() ([]byte, error) {
+	upper, lower := s.StdDeviationBounds()
+	return json.Marshal(struct {
+		Count                uint64                      `json:"count"`
+		Min                  search.JSONFloat            `json:"min"`
+		Max                  search.JSONFloat            `json:"max"`
+		Sum                  search.JSONFloat            `json:"sum"`
+		Avg                  search.JSONFloat            `json:"avg"`
+		SumOfSquares         search.JSONFloat            `json:"sum_of_squares"`
+		Variance             search.JSONFloat            `json:"variance"`
+		VarianceSampling     search.JSONFloat            `json:"variance_sampling"`
+		StdDeviation         search.JSONFloat            `json:"std_deviation"`
+		StdDeviationSampling search.JSONFloat            `json:"std_deviation_sampling"`
+		StdDeviationBounds   map[string]search.JSONFloat `json:"std_deviation_bounds"`
+	}{
+		Count:                s.count,
+		Min:                  search.JSONFloat(s.Min()),
+		Max:                  search.JSONFloat(s.Max()),
+		Sum:                  search.JSONFloat(s.Sum()),
+		Avg:                  search.JSONFloat(s.Avg()),
+		SumOfSquares:         search.JSONFloat(s.SumOfSquares()),
+		Variance:             search.JSONFloat(s.Variance()),
+		VarianceSampling:     search.JSONFloat(s.VarianceSampling()),
+		StdDeviation:         search.JSONFloat(s.StdDeviation()),
+		StdDeviationSampling: search.JSONFloat(s.StdDeviationSampling()),
+		StdDeviationBounds: map[string]search.JSONFloat{
+			"upper": search.JSONFloat(upper),
+			"lower": search.JSONFloat(lower),
+		},
+	})
+}
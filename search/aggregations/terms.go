@@ -15,32 +15,44 @@
 package aggregations
 
 import (
+	"encoding/json"
+	"regexp"
 	"sort"
 
 	"github.com/blugelabs/bluge/search"
 )
 
 type TermsAggregation struct {
-	src  search.TextValuesSource
-	size int
+	src       search.TextValuesSource
+	size      int
+	shardSize int
 
 	aggregations map[string]search.Aggregation
 
 	lessFunc func(a, b *search.Bucket) bool
 	desc     bool
 	sortFunc func(p sort.Interface)
+
+	minDocCount uint64
+
+	include      *regexp.Regexp
+	exclude      *regexp.Regexp
+	includeTerms map[string]struct{}
+	excludeTerms map[string]struct{}
 }
 
 func NewTermsAggregation(src search.TextValuesSource, size int) *TermsAggregation {
 	rv := &TermsAggregation{
-		src:  src,
-		size: size,
-		desc: true,
+		src:       src,
+		size:      size,
+		shardSize: size,
+		desc:      true,
 		lessFunc: func(a, b *search.Bucket) bool {
 			return a.Aggregations()["count"].(search.MetricCalculator).Value() < b.Aggregations()["count"].(search.MetricCalculator).Value()
 		},
 		aggregations: make(map[string]search.Aggregation),
-		sortFunc:     sort.Sort,
+		sortFunc:     sort.Stable,
+		minDocCount:  1,
 	}
 	rv.aggregations["count"] = CountMatches()
 	return rv
@@ -58,29 +70,146 @@ func (t *TermsAggregation) AddAggregation(name string, aggregation search.Aggreg
 	t.aggregations[name] = aggregation
 }
 
+// WithSearcherOptions returns a copy of the aggregation
+// whose sub-aggregations search using the options
+func (t *TermsAggregation) WithSearcherOptions(options search.SearcherOptions) search.Aggregation {
+	rv := *t
+	rv.aggregations = search.Aggregations(t.aggregations).WithSearcherOptions(options)
+	return &rv
+}
+
+// SortBy orders the buckets by a metric of their aggregations,
+// "_key" ordering them by term and "_count" by the number of
+// matches, which is the default, descending, buckets which are
+// equal remain in the order their terms were first seen
+func (t *TermsAggregation) SortBy(metric string, desc bool) *TermsAggregation {
+	switch metric {
+	case "_key":
+		t.lessFunc = func(a, b *search.Bucket) bool {
+			return a.Name() < b.Name()
+		}
+	default:
+		// buckets are compared in reverse when sorting in descending
+		// order, so that buckets missing the metric are still last
+		t.lessFunc = func(a, b *search.Bucket) bool {
+			if desc {
+				return compareMetrics(bucketMetric(b, metric), bucketMetric(a, metric), desc) < 0
+			}
+			return compareMetrics(bucketMetric(a, metric), bucketMetric(b, metric), desc) < 0
+		}
+	}
+	t.desc = desc
+	return t
+}
+
+// MinDocCount omits the buckets with fewer matches, 1 by default
+func (t *TermsAggregation) MinDocCount(minDocCount uint64) *TermsAggregation {
+	t.minDocCount = minDocCount
+	return t
+}
+
+// ShardSize is the number of buckets kept for merging the results
+// of the parts of a search, such as segments or indexes, at least
+// the size, and by default the size, keeping more buckets lowers
+// the error in their counts once merged
+func (t *TermsAggregation) ShardSize(shardSize int) *TermsAggregation {
+	t.shardSize = shardSize
+	return t
+}
+
+// Include only counts the terms matching the regular expression,
+// it matches part of the term, unless anchored with ^ and $
+func (t *TermsAggregation) Include(pattern *regexp.Regexp) *TermsAggregation {
+	t.include = pattern
+	return t
+}
+
+// IncludeTerms only counts these terms, terms matching
+// the Include regular expression are counted as well
+func (t *TermsAggregation) IncludeTerms(terms ...string) *TermsAggregation {
+	t.includeTerms = addTermSet(t.includeTerms, terms)
+	return t
+}
+
+// Exclude does not count the terms matching the regular expression,
+// it matches part of the term, unless anchored with ^ and $
+func (t *TermsAggregation) Exclude(pattern *regexp.Regexp) *TermsAggregation {
+	t.exclude = pattern
+	return t
+}
+
+// ExcludeTerms does not count these terms
+func (t *TermsAggregation) ExcludeTerms(terms ...string) *TermsAggregation {
+	t.excludeTerms = addTermSet(t.excludeTerms, terms)
+	return t
+}
+
+func addTermSet(set map[string]struct{}, terms []string) map[string]struct{} {
+	if set == nil {
+		set = make(map[string]struct{}, len(terms))
+	}
+	for _, term := range terms {
+		set[term] = struct{}{}
+	}
+	return set
+}
+
+// accept returns true if the term is included and not excluded
+func (t *TermsAggregation) accept(term string) bool {
+	if t.include != nil || t.includeTerms != nil {
+		_, included := t.includeTerms[term]
+		if !included && (t.include == nil || !t.include.MatchString(term)) {
+			return false
+		}
+	}
+	if _, excluded := t.excludeTerms[term]; excluded {
+		return false
+	}
+	return t.exclude == nil || !t.exclude.MatchString(term)
+}
+
 func (t *TermsAggregation) Calculator() search.Calculator {
+	shardSize := t.shardSize
+	if shardSize < t.size {
+		shardSize = t.size
+	}
 	return &TermsCalculator{
 		src:          t.src,
 		size:         t.size,
+		shardSize:    shardSize,
 		aggregations: t.aggregations,
+		agg:          t,
 		desc:         t.desc,
 		lessFunc:     t.lessFunc,
 		sortFunc:     t.sortFunc,
 		bucketsMap:   make(map[string]*search.Bucket),
+		errors:       make(map[string]uint64),
 	}
 }
 
+// TermsCalculator keeps the shard size buckets which sort first, of
+// which the first size buckets with enough matches are returned,
+// when buckets are trimmed, the count of a term may be too low once
+// merged with other results, but not by more than its error bound
 type TermsCalculator struct {
-	src  search.TextValuesSource
-	size int
+	src       search.TextValuesSource
+	size      int
+	shardSize int
 
 	aggregations map[string]search.Aggregation
+	agg          *TermsAggregation
 
 	bucketsList []*search.Bucket
 	bucketsMap  map[string]*search.Bucket
+	buckets     []*search.Bucket
 	total       int
 	other       int
 
+	// errors are the error bounds of the buckets kept, and
+	// missing the error bound of the terms without a bucket
+	errors  map[string]uint64
+	missing uint64
+
 	desc     bool
 	lessFunc func(a, b *search.Bucket) bool
 	sortFunc func(p sort.Interface)
@@ -90,6 +219,9 @@ func (a *TermsCalculator) Consume(d *search.DocumentMatch) {
 	a.total++
 	for _, term := range a.src.Values(d) {
 		termStr := string(term)
+		if !a.agg.accept(termStr) {
+			continue
+		}
 		bucket, ok := a.bucketsMap[termStr]
 		if ok {
 			bucket.Consume(d)
@@ -106,20 +238,27 @@ func (a *TermsCalculator) Merge(other search.Calculator) {
 	if other, ok := other.(*TermsCalculator); ok {
 		// first sum to the totals and others
 		a.total += other.total
+		// terms only in one of the results may have
+		// been trimmed from the other results
+		for name := range a.bucketsMap {
+			if _, ok := other.bucketsMap[name]; !ok {
+				a.errors[name] += other.missing
+			}
+		}
 		// now, walk all of the other buckets
 		// if we have a local match, merge otherwise append
-		for i := range other.bucketsList {
-			var foundLocal bool
-			for j := range a.bucketsList {
-				if other.bucketsList[i].Name() == a.bucketsList[j].Name() {
-					a.bucketsList[j].Merge(other.bucketsList[i])
-					foundLocal = true
-				}
-			}
-			if !foundLocal {
-				a.bucketsList = append(a.bucketsList, other.bucketsList[i])
+		for _, otherBucket := range other.bucketsList {
+			name := otherBucket.Name()
+			if bucket, ok := a.bucketsMap[name]; ok {
+				bucket.Merge(otherBucket)
+				a.errors[name] += other.errors[name]
+			} else {
+				a.bucketsMap[name] = otherBucket
+				a.bucketsList = append(a.bucketsList, otherBucket)
+				a.errors[name] = other.errors[name] + a.missing
 			}
 		}
+		a.missing += other.missing
 		// now re-invoke finish, this should trim to correct size again
 		// and recalculate other
 		a.Finish()
@@ -128,38 +267,98 @@ func (a *TermsCalculator) Merge(other search.Calculator) {
 
 func (a *TermsCalculator) Finish() {
 	// sort the buckets
-	if a.desc {
-		a.sortFunc(sort.Reverse(a))
-	} else {
-		a.sortFunc(a)
-	}
+	a.sortFunc(a)
 
-	trimTopN := a.size
+	// trim to the shard size, a term trimmed may have
+	// as many matches as the most of those trimmed
+	trimTopN := a.shardSize
 	if trimTopN > len(a.bucketsList) {
 		trimTopN = len(a.bucketsList)
 	}
+	for _, bucket := range a.bucketsList[trimTopN:] {
+		name := bucket.Name()
+		if bound := bucket.Count() + a.errors[name]; bound > a.missing {
+			a.missing = bound
+		}
+		delete(a.bucketsMap, name)
+		delete(a.errors, name)
+	}
 	a.bucketsList = a.bucketsList[:trimTopN]
 
+	a.buckets = a.buckets[:0]
 	var notOther int
 	for _, bucket := range a.bucketsList {
-		notOther += int(bucket.Aggregations()["count"].(search.MetricCalculator).Value())
+		if len(a.buckets) >= a.size {
+			break
+		}
+		if bucket.Count() < a.agg.minDocCount {
+			continue
+		}
+		a.buckets = append(a.buckets, bucket)
+		notOther += int(bucket.Count())
 	}
 	a.other = a.total - notOther
 }
 
 func (a *TermsCalculator) Buckets() []*search.Bucket {
-	return a.bucketsList
+	return a.buckets
 }
 
 func (a *TermsCalculator) Other() int {
 	return a.other
 }
 
+// ErrorBound returns how many more matches any term may have,
+// than counted, because it was trimmed from some of the results
+// merged, or 0 when the counts are exact
+func (a *TermsCalculator) ErrorBound() uint64 {
+	rv := a.missing
+	for _, bound := range a.errors {
+		if bound > rv {
+			rv = bound
+		}
+	}
+	return rv
+}
+
+// BucketErrorBound returns how many more matches
+// the term of the bucket may have than counted
+func (a *TermsCalculator) BucketErrorBound(bucket *search.Bucket) uint64 {
+	return a.errors[bucket.Name()]
+}
+
+type termsBucketJSON struct {
+	*search.BucketJSON
+	ErrorBound uint64 `json:"error_bound,omitempty"`
+}
+
+func (a *TermsCalculator) MarshalJSON() ([]byte, error) {
+	buckets := make([]termsBucketJSON, len(a.buckets))
+	for i, bucket := range a.buckets {
+		buckets[i] = termsBucketJSON{
+			BucketJSON: bucket.JSON(),
+			ErrorBound: a.BucketErrorBound(bucket),
+		}
+	}
+	return json.Marshal(struct {
+		Buckets    []termsBucketJSON `json:"buckets"`
+		Other      int               `json:"other"`
+		ErrorBound uint64            `json:"error_bound,omitempty"`
+	}{
+		Buckets:    buckets,
+		Other:      a.other,
+		ErrorBound: a.ErrorBound(),
+	})
+}
+
 func (a *TermsCalculator) Len() int {
 	return len(a.bucketsList)
 }
 
 func (a *TermsCalculator) Less(i, j int) bool {
+	if a.desc {
+		return a.lessFunc(a.bucketsList[j], a.bucketsList[i])
+	}
 	return a.lessFunc(a.bucketsList[i], a.bucketsList[j])
 }
 
@@ -0,0 +1,189 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregations
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// TopHitsAggregation keeps the top matches of a bucket,
+// typically used as a sub-aggregation, to show the
+// best matches of each term or range
+type TopHitsAggregation struct {
+	size   int
+	sort   search.SortOrder
+	fields []string
+	format search.HitJSONFormat
+}
+
+// TopHits keeps the top 'size' matches, by descending score
+// unless another sort order is provided
+func TopHits(size int) *TopHitsAggregation {
+	return &TopHitsAggregation{
+		size: size,
+		sort: search.SortOrder{
+			search.SortBy(search.DocumentScore()).Desc(),
+		},
+		format: search.HitJSONFormat{
+			IDField: "_id",
+		},
+	}
+}
+
+// SortBy sets the order of the matches
+func (a *TopHitsAggregation) SortBy(order search.SortOrder) *TopHitsAggregation {
+	a.sort = order
+	return a
+}
+
+// LoadDocumentValues loads the document values
+// of these fields for each of the top matches
+func (a *TopHitsAggregation) LoadDocumentValues(fields ...string) *TopHitsAggregation {
+	a.fields = append(a.fields, fields...)
+	return a
+}
+
+// JSONFormat sets the stored fields and sort values of the
+// matches in the JSON form, and how they are decoded,
+// by default only the identifiers from the _id field
+func (a *TopHitsAggregation) JSONFormat(format search.HitJSONFormat) *TopHitsAggregation {
+	a.format = format
+	return a
+}
+
+func (a *TopHitsAggregation) Fields() []string {
+	return append(a.sort.Fields(), a.fields...)
+}
+
+func (a *TopHitsAggregation) Calculator() search.Calculator {
+	return &TopHitsCalculator{
+		size:   a.size,
+		sort:   a.sort,
+		format: &a.format,
+	}
+}
+
+type TopHitsCalculator struct {
+	size   int
+	sort   search.SortOrder
+	format *search.HitJSONFormat
+	hits   []*search.DocumentMatch
+
+	// candidate holds the sort value of each match consumed,
+	// to compare it with the top matches before copying it
+	candidate search.DocumentMatch
+}
+
+// Hits returns the top matches, in order
+func (c *TopHitsCalculator) Hits() []*search.DocumentMatch {
+	return c.hits
+}
+
+// compare orders matches by the sort order, matches which
+// sort the same are ordered by reader and then document number,
+// so that the order does not depend on how the search was split
+func (c *TopHitsCalculator) compare(i, j *search.DocumentMatch) int {
+	if cmp := c.sort.CompareSortValues(i, j); cmp != 0 {
+		return cmp
+	}
+	if i.SourceIndex != j.SourceIndex {
+		if i.SourceIndex < j.SourceIndex {
+			return -1
+		}
+		return 1
+	}
+	if i.Number != j.Number {
+		if i.Number < j.Number {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c *TopHitsCalculator) Consume(d *search.DocumentMatch) {
+	if c.size <= 0 {
+		return
+	}
+	// the sort value of the match is that of the collector,
+	// so compute ours, and compare before keeping the match
+	collectorSortValue := d.SortValue
+	d.SortValue = c.candidate.SortValue[:0]
+	c.sort.Compute(d)
+	c.candidate.SortValue, d.SortValue = d.SortValue, collectorSortValue
+	c.candidate.SourceIndex = d.SourceIndex
+	c.candidate.Number = d.Number
+	if !c.competitive(&c.candidate) {
+		return
+	}
+
+	// the match is reused once the collector is done with it, so keep a copy
+	hit := d.Copy()
+	hit.SortValue = append([][]byte(nil), c.candidate.SortValue...)
+	c.insert(hit)
+}
+
+// competitive reports whether the hit would be among the top matches
+func (c *TopHitsCalculator) competitive(hit *search.DocumentMatch) bool {
+	return len(c.hits) < c.size || c.compare(hit, c.hits[len(c.hits)-1]) < 0
+}
+
+func (c *TopHitsCalculator) insert(hit *search.DocumentMatch) {
+	if !c.competitive(hit) {
+		return
+	}
+	i := sort.Search(len(c.hits), func(i int) bool {
+		return c.compare(hit, c.hits[i]) < 0
+	})
+	if len(c.hits) < c.size {
+		c.hits = append(c.hits, nil)
+	}
+	copy(c.hits[i+1:], c.hits[i:])
+	c.hits[i] = hit
+}
+
+func (c *TopHitsCalculator) Merge(other search.Calculator) {
+	if other, ok := other.(*TopHitsCalculator); ok {
+		for _, hit := range other.hits {
+			c.insert(hit)
+		}
+	}
+}
+
+func (c *TopHitsCalculator) Finish() {
+	for _, hit := range c.hits {
+		hit.Complete(nil)
+	}
+}
+
+// MarshalJSON encodes the top matches in the JSON format of the
+// aggregation, loading their stored fields, so the readers
+// searched must still be open
+func (c *TopHitsCalculator) MarshalJSON() ([]byte, error) {
+	hits := make([]*search.HitJSON, len(c.hits))
+	for i, hit := range c.hits {
+		var err error
+		hits[i], err = search.NewHitJSON(hit, c.format)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"hits": hits,
+	})
+}
@@ -25,6 +25,20 @@ type Collector interface {
 	BackingSize() int
 }
 
+// ConcurrentCollector is implemented by Collectors which can
+// collect separate partitions of the documents concurrently
+type ConcurrentCollector interface {
+	Collector
+
+	// PartitionCollector returns a new collector
+	// for the matches of a single partition
+	PartitionCollector() Collector
+
+	// Merge combines the results of the partition collectors,
+	// as if the matches of all partitions were collected together
+	Merge(aggs Aggregations, partitions []DocumentMatchIterator) (DocumentMatchIterator, error)
+}
+
 type Collectible interface {
 	Next(ctx *Context) (*DocumentMatch, error)
 	DocumentMatchPoolSize() int
@@ -35,3 +49,19 @@ type DocumentMatchIterator interface {
 	Next() (*DocumentMatch, error)
 	Aggregations() *Bucket
 }
+
+// PartialResultsIterator is implemented by DocumentMatchIterators
+// which may not collect every match
+type PartialResultsIterator interface {
+	DocumentMatchIterator
+	TimedOut() bool
+	TerminatedEarly() bool
+	CountIsLowerBound() bool
+}
+
+// CollapsedResultsIterator is implemented by DocumentMatchIterators
+// returning the best match of each group of matches
+type CollapsedResultsIterator interface {
+	DocumentMatchIterator
+	GroupCount() uint64
+}
@@ -31,7 +31,7 @@ func (a *AllCollector) Collect(ctx context.Context, aggs search.Aggregations,
 	searcher search.Collectible) (search.DocumentMatchIterator, error) {
 	return &AllIterator{
 		ctx:           ctx,
-		neededFields:  aggs.Fields(),
+		neededFields:  appendFields(nil, aggs.Fields()...),
 		bucket:        search.NewBucket("", aggs),
 		searcher:      searcher,
 		searchContext: search.NewSearchContext(searcher.DocumentMatchPoolSize(), 0),
@@ -83,7 +83,7 @@ func (a *AllIterator) Next() (next *search.DocumentMatch, err error) {
 	if next == nil {
 		a.bucket.Finish()
 		a.doneCleanup()
-		return nil, nil
+		return nil, a.bucket.Err()
 	}
 
 	a.hitNumber++
@@ -0,0 +1,243 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// collapseKey identifies a group, matches
+// without a value form a group of their own
+type collapseKey struct {
+	value   string
+	missing bool
+}
+
+type collapsedGroup struct {
+	key  collapseKey
+	head *search.DocumentMatch
+}
+
+// CollapsingCollector collects the top N groups of matches, grouping
+// matches by a value, typically the document values of a field.
+// Each group is represented by its highest ranking match, and groups
+// are ranked by these matches.  Only the top groups are tracked, a
+// group which drops out of them is forgotten, should a later match of
+// the group rank highly enough, it rejoins them with this better match.
+type CollapsingCollector struct {
+	size   int
+	skip   int
+	sort   search.SortOrder
+	source search.TextValueSource
+
+	neededFields []string
+
+	// top groups, ordered by their head
+	groups []*collapsedGroup
+	byKey  map[collapseKey]*collapsedGroup
+
+	// every group seen, to count them
+	seen map[collapseKey]struct{}
+}
+
+// NewCollapsingCollector builds a collector to find the top 'size'
+// groups of matches, skipping over the first 'skip' groups,
+// matches are grouped by the value of source and
+// ordered by the provided sort order
+func NewCollapsingCollector(source search.TextValueSource, size, skip int,
+	sort search.SortOrder) *CollapsingCollector {
+	return &CollapsingCollector{
+		size:         size,
+		skip:         skip,
+		sort:         sort,
+		source:       source,
+		neededFields: appendFields(sort.Fields(), source.Fields()...),
+		byKey:        make(map[collapseKey]*collapsedGroup),
+		seen:         make(map[collapseKey]struct{}),
+	}
+}
+
+func (cc *CollapsingCollector) Size() int {
+	sizeInBytes := reflectStaticSizeCollapsingCollector + sizeOfPtr
+
+	for _, entry := range cc.neededFields {
+		sizeInBytes += len(entry) + sizeOfString
+	}
+
+	return sizeInBytes
+}
+
+func (cc *CollapsingCollector) BackingSize() int {
+	return cc.size + cc.skip + 1
+}
+
+// Collect goes to the index to find the matching documents
+func (cc *CollapsingCollector) Collect(ctx context.Context, aggs search.Aggregations,
+	searcher search.Collectible) (search.DocumentMatchIterator, error) {
+	// ensure that we always close the searcher
+	defer func() {
+		_ = searcher.Close()
+	}()
+
+	searchContext := search.NewSearchContext(cc.BackingSize()+searcher.DocumentMatchPoolSize(), len(cc.sort))
+
+	// add fields needed by aggregations
+	cc.neededFields = appendFields(cc.neededFields, aggs.Fields()...)
+	bucket := search.NewBucket("", aggs)
+
+	var hitNumber int
+	for {
+		if hitNumber%CheckDoneEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		next, err := searcher.Next(searchContext)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			break
+		}
+
+		hitNumber++
+		next.HitNumber = hitNumber
+
+		err = cc.collectSingle(searchContext, next, bucket)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bucket.Finish()
+	err := bucket.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &CollapsingIterator{
+		TopNIterator: TopNIterator{
+			results: cc.finalizeResults(),
+			bucket:  bucket,
+		},
+		groupCount: uint64(len(cc.seen)),
+	}, nil
+}
+
+func (cc *CollapsingCollector) collectSingle(ctx *search.Context, d *search.DocumentMatch,
+	bucket *search.Bucket) error {
+	if len(cc.neededFields) > 0 {
+		err := d.LoadDocumentValues(ctx, cc.neededFields)
+		if err != nil {
+			return err
+		}
+	}
+
+	// compute this hits sort value
+	cc.sort.Compute(d)
+
+	// calculate aggregations
+	bucket.Consume(d)
+
+	value := cc.source.Value(d)
+	key := collapseKey{
+		value:   string(value),
+		missing: value == nil,
+	}
+	cc.seen[key] = struct{}{}
+
+	if group, ok := cc.byKey[key]; ok {
+		if cc.sort.Compare(d, group.head) >= 0 {
+			ctx.DocumentMatchPool.Put(d)
+			return nil
+		}
+		// the group has a better match, so it may rank higher
+		cc.removeGroup(group)
+		ctx.DocumentMatchPool.Put(group.head)
+		group.head = d
+		cc.insertGroup(group)
+		return nil
+	}
+
+	limit := cc.size + cc.skip
+	if len(cc.groups) >= limit {
+		if limit == 0 || cc.sort.Compare(d, cc.groups[limit-1].head) >= 0 {
+			ctx.DocumentMatchPool.Put(d)
+			return nil
+		}
+		// the lowest ranking group drops out
+		bottom := cc.groups[limit-1]
+		cc.groups = cc.groups[:limit-1]
+		delete(cc.byKey, bottom.key)
+		ctx.DocumentMatchPool.Put(bottom.head)
+	}
+
+	group := &collapsedGroup{
+		key:  key,
+		head: d,
+	}
+	cc.byKey[key] = group
+	cc.insertGroup(group)
+	return nil
+}
+
+func (cc *CollapsingCollector) insertGroup(group *collapsedGroup) {
+	i := sort.Search(len(cc.groups), func(i int) bool {
+		return cc.sort.Compare(group.head, cc.groups[i].head) < 0
+	})
+	cc.groups = append(cc.groups, nil)
+	copy(cc.groups[i+1:], cc.groups[i:])
+	cc.groups[i] = group
+}
+
+func (cc *CollapsingCollector) removeGroup(group *collapsedGroup) {
+	for i := range cc.groups {
+		if cc.groups[i] == group {
+			cc.groups = append(cc.groups[:i], cc.groups[i+1:]...)
+			return
+		}
+	}
+}
+
+// finalizeResults skips the first groups and
+// returns the best match of each remaining group
+func (cc *CollapsingCollector) finalizeResults() search.DocumentMatchCollection {
+	if cc.skip >= len(cc.groups) {
+		return nil
+	}
+	groups := cc.groups[cc.skip:]
+	rv := make(search.DocumentMatchCollection, len(groups))
+	for i, group := range groups {
+		group.head.Complete(nil)
+		rv[i] = group.head
+	}
+	return rv
+}
+
+// CollapsingIterator iterates the best match of each group
+type CollapsingIterator struct {
+	TopNIterator
+
+	groupCount uint64
+}
+
+// GroupCount returns the number of distinct groups among all matches
+func (i *CollapsingIterator) GroupCount() uint64 {
+	return i.groupCount
+}
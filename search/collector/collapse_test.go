@@ -0,0 +1,109 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// groupSource groups matches by their number modulo 7,
+// matches whose number is a multiple of 7 have no group
+type groupSource struct{}
+
+func (groupSource) Fields() []string {
+	return nil
+}
+
+func (groupSource) Value(match *search.DocumentMatch) []byte {
+	if match.Number%7 == 0 {
+		return nil
+	}
+	return []byte(strconv.Itoa(int(match.Number % 7)))
+}
+
+func TestCollapsingCollector(t *testing.T) {
+	makeSearcher := func() *stubSearcher {
+		matches := makeMatches(60, 0)
+		for _, match := range matches {
+			// scores rise and fall, so groups drop out and rejoin the top groups
+			match.Score = float64((match.Number * 37) % 61)
+		}
+		return &stubSearcher{
+			matches: matches,
+		}
+	}
+
+	// find the best match of each group by brute force
+	best := map[string]*search.DocumentMatch{}
+	for _, match := range makeSearcher().matches {
+		key := string(groupSource{}.Value(match)) + "/" + strconv.FormatBool(match.Number%7 == 0)
+		if best[key] == nil || match.Score > best[key].Score {
+			best[key] = match
+		}
+	}
+	var heads []uint64
+	for _, match := range best {
+		heads = append(heads, match.Number)
+	}
+	sort.Slice(heads, func(i, j int) bool {
+		return (heads[i]*37)%61 > (heads[j]*37)%61
+	})
+
+	scoreDesc := search.SortOrder{search.SortBy(search.DocumentScore()).Desc()}
+	tests := []struct {
+		size, skip int
+	}{
+		{size: 3},
+		{size: 3, skip: 2},
+		{size: 10},
+		{size: 0},
+	}
+	for _, test := range tests {
+		collector := NewCollapsingCollector(groupSource{}, test.size, test.skip, scoreDesc)
+		dmi, err := collector.Collect(context.Background(), search.Aggregations{}, makeSearcher())
+		if err != nil {
+			t.Fatal(err)
+		}
+		numbers := []uint64{}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			numbers = append(numbers, next.Number)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []uint64{}
+		if test.skip < len(heads) {
+			expected = heads[test.skip:]
+		}
+		if len(expected) > test.size {
+			expected = expected[:test.size]
+		}
+		if !reflect.DeepEqual(expected, numbers) {
+			t.Errorf("size %d skip %d: expected %v, got %v", test.size, test.skip, expected, numbers)
+		}
+		if groups := dmi.(search.CollapsedResultsIterator).GroupCount(); groups != 7 {
+			t.Errorf("expected 7 groups, got %d", groups)
+		}
+	}
+}
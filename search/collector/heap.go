@@ -51,6 +51,13 @@ func (c *collectStoreHeap) removeLast() *search.DocumentMatch {
 	return heap.Pop(c).(*search.DocumentMatch)
 }
 
+func (c *collectStoreHeap) Last() *search.DocumentMatch {
+	if len(c.heap) == 0 {
+		return nil
+	}
+	return c.heap[0]
+}
+
 func (c *collectStoreHeap) Final(skip int, fixup collectorFixup) (search.DocumentMatchCollection, error) {
 	count := c.Len()
 	size := count - skip
@@ -23,6 +23,10 @@ type TopNIterator struct {
 	bucket  *search.Bucket
 	index   int
 	err     error
+
+	timedOut        bool
+	terminatedEarly bool
+	countLowerBound bool
 }
 
 func (i *TopNIterator) Next() (*search.DocumentMatch, error) {
@@ -40,3 +44,24 @@ func (i *TopNIterator) Next() (*search.DocumentMatch, error) {
 func (i *TopNIterator) Aggregations() *search.Bucket {
 	return i.bucket
 }
+
+// TimedOut reports whether the deadline of the search passed
+// before all matches were collected, in which case the results
+// and aggregations only reflect the matches collected in time
+func (i *TopNIterator) TimedOut() bool {
+	return i.timedOut
+}
+
+// TerminatedEarly reports whether the search stopped after
+// collecting the terminate after limit of matches
+func (i *TopNIterator) TerminatedEarly() bool {
+	return i.terminatedEarly
+}
+
+// CountIsLowerBound reports whether the count, and the other
+// aggregations, may not reflect every match, because the search timed
+// out, terminated early, or skipped matches which could not be competitive.
+// Otherwise the count is exact.
+func (i *TopNIterator) CountIsLowerBound() bool {
+	return i.countLowerBound
+}
@@ -59,6 +59,13 @@ func (c *collectStoreSlice) removeLast() *search.DocumentMatch {
 	return rv
 }
 
+func (c *collectStoreSlice) Last() *search.DocumentMatch {
+	if len(c.slice) == 0 {
+		return nil
+	}
+	return c.slice[len(c.slice)-1]
+}
+
 func (c *collectStoreSlice) Final(skip int, fixup collectorFixup) (search.DocumentMatchCollection, error) {
 	for i := skip; i < len(c.slice); i++ {
 		err := fixup(c.slice[i])
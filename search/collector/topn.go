@@ -16,6 +16,7 @@ package collector
 
 import (
 	"context"
+	"sort"
 
 	"github.com/blugelabs/bluge/search"
 )
@@ -26,6 +27,10 @@ type collectorStore interface {
 	// exceeded, nil is returned.
 	AddNotExceedingSize(doc *search.DocumentMatch, size int) *search.DocumentMatch
 
+	// Last returns the last element of the store, without removing it,
+	// or nil if the store is empty.
+	Last() *search.DocumentMatch
+
 	Final(skip int, fixup collectorFixup) (search.DocumentMatchCollection, error)
 }
 
@@ -52,6 +57,15 @@ type TopNCollector struct {
 
 	lowestMatchOutsideResults *search.DocumentMatch
 	searchAfter               *search.DocumentMatch
+
+	allowPartialResults bool
+	terminateAfter      int
+
+	trackTotalHits      int
+	competitiveSearcher search.CompetitiveScoreSearcher
+	skippedMatches      bool
+
+	indexSortedRanges []search.DocumentRange
 }
 
 // CheckDoneEvery controls how frequently we check the context deadline
@@ -84,6 +98,8 @@ func newTopNCollector(size, skip int, sort search.SortOrder, reverse bool) *TopN
 		skip:    skip,
 		sort:    sort,
 		reverse: reverse,
+
+		trackTotalHits: TrackTotalHitsExact,
 	}
 
 	// pre-allocate space on the store to avoid reslicing
@@ -110,6 +126,54 @@ func newTopNCollector(size, skip int, sort search.SortOrder, reverse bool) *TopN
 	return hc
 }
 
+// SetAllowPartialResults controls what happens when the deadline of the
+// search context passes before all matches have been collected, when
+// allowed the results collected so far are returned and the iterator
+// reports TimedOut, otherwise the context error is returned
+func (hc *TopNCollector) SetAllowPartialResults(allow bool) {
+	hc.allowPartialResults = allow
+}
+
+// SetTerminateAfter stops the search once n matches have been
+// collected, the results and aggregations only reflect these
+// matches and the iterator reports TerminatedEarly, zero means
+// no limit
+func (hc *TopNCollector) SetTerminateAfter(n int) {
+	hc.terminateAfter = n
+}
+
+// TrackTotalHitsExact counts every match, it is the default
+const TrackTotalHitsExact = -1
+
+// TrackTotalHitsDisabled does not require any matches to be counted
+const TrackTotalHitsDisabled = 0
+
+// SetTrackTotalHits sets the number of matches which must be counted
+// exactly, TrackTotalHitsExact or TrackTotalHitsDisabled.
+// Once that many matches have been collected, when the results are
+// sorted by descending score, the searcher is allowed to skip over
+// matches which score too low to be collected.  Skipped matches are
+// not seen by aggregations, so aggregations, including the count, only
+// reflect the competitive matches, and the iterator reports
+// CountIsLowerBound.
+func (hc *TopNCollector) SetTrackTotalHits(n int) {
+	hc.trackTotalHits = n
+}
+
+// SetIndexSortedRanges provides the ranges of document numbers
+// which the index has already ordered by the sort order of this
+// collector.  Within each range, once a match ranks too low to be
+// collected, the rest of the range is skipped, subject to the number
+// of matches which must be counted, see SetTrackTotalHits.
+func (hc *TopNCollector) SetIndexSortedRanges(ranges []search.DocumentRange) {
+	hc.indexSortedRanges = ranges
+}
+
+// SortOrder returns the order in which this collector ranks matches
+func (hc *TopNCollector) SortOrder() search.SortOrder {
+	return hc.sort
+}
+
 func (hc *TopNCollector) Size() int {
 	sizeInBytes := reflectStaticSizeTopNCollector + sizeOfPtr
 
@@ -137,42 +201,83 @@ func (hc *TopNCollector) Collect(ctx context.Context, aggs search.Aggregations,
 
 	searchContext := search.NewSearchContext(hc.backingSize+searcher.DocumentMatchPoolSize(), len(hc.sort))
 
+	if hc.trackTotalHits >= 0 && hc.sort.ScoreDescending() {
+		hc.competitiveSearcher, _ = searcher.(search.CompetitiveScoreSearcher)
+	}
+	advancer, canAdvance := searcher.(search.Searcher)
+	skipRanges := canAdvance && hc.trackTotalHits >= 0 && len(hc.indexSortedRanges) > 0
+	var rangeIndex int
+	var skipTo uint64
+	var skipping bool
+
 	// add fields needed by aggregations
-	hc.neededFields = append(hc.neededFields, aggs.Fields()...)
+	hc.neededFields = appendFields(hc.neededFields, aggs.Fields()...)
 	bucket := search.NewBucket("", aggs)
 
 	var hitNumber int
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
-		next, err = searcher.Next(searchContext)
-	}
-	for err == nil && next != nil {
+	var timedOut, terminatedEarly bool
+	for {
 		if hitNumber%CheckDoneEvery == 0 {
-			select {
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			default:
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				if !hc.allowPartialResults || ctxErr != context.DeadlineExceeded {
+					return nil, ctxErr
+				}
+				timedOut = true
+				break
 			}
 		}
+		if hc.terminateAfter > 0 && hitNumber >= hc.terminateAfter {
+			terminatedEarly = true
+			break
+		}
+
+		if skipping {
+			next, err = advancer.Advance(searchContext, skipTo)
+			skipping = false
+		} else {
+			next, err = searcher.Next(searchContext)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			break
+		}
 
 		hitNumber++
 		next.HitNumber = hitNumber
+		number := next.Number
 
-		err = hc.collectSingle(searchContext, next, bucket)
+		var outranked bool
+		outranked, err = hc.collectSingle(searchContext, next, bucket)
 		if err != nil {
 			return nil, err
 		}
 
-		next, err = searcher.Next(searchContext)
+		if outranked && skipRanges && hitNumber >= hc.trackTotalHits {
+			// later matches in the same sorted range rank lower still
+			for rangeIndex < len(hc.indexSortedRanges) && hc.indexSortedRanges[rangeIndex].End <= number {
+				rangeIndex++
+			}
+			if rangeIndex < len(hc.indexSortedRanges) && hc.indexSortedRanges[rangeIndex].Start <= number {
+				skipTo = hc.indexSortedRanges[rangeIndex].End
+				skipping = true
+				hc.skippedMatches = true
+			}
+		}
+
+		if hitNumber == hc.trackTotalHits && hc.lowestMatchOutsideResults != nil {
+			// enough matches have been counted, skipping can begin
+			hc.publishMinCompetitiveScore(hitNumber)
+		}
 	}
+
+	bucket.Finish()
+	err = bucket.Err()
 	if err != nil {
 		return nil, err
 	}
 
-	bucket.Finish()
-
 	// finalize actual results
 	err = hc.finalizeResults()
 	if err != nil {
@@ -180,21 +285,25 @@ func (hc *TopNCollector) Collect(ctx context.Context, aggs search.Aggregations,
 	}
 
 	rv := &TopNIterator{
-		results: hc.results,
-		bucket:  bucket,
-		index:   0,
-		err:     nil,
+		results:         hc.results,
+		bucket:          bucket,
+		index:           0,
+		err:             nil,
+		timedOut:        timedOut,
+		terminatedEarly: terminatedEarly,
+		countLowerBound: hc.skippedMatches || timedOut || terminatedEarly,
 	}
 	return rv, nil
 }
 
-func (hc *TopNCollector) collectSingle(ctx *search.Context, d *search.DocumentMatch, bucket *search.Bucket) error {
-	var err error
-
+// collectSingle adds the match to the results if it ranks highly enough,
+// outranked is true when matches already collected rank above it
+func (hc *TopNCollector) collectSingle(ctx *search.Context, d *search.DocumentMatch,
+	bucket *search.Bucket) (outranked bool, err error) {
 	if len(hc.neededFields) > 0 {
 		err = d.LoadDocumentValues(ctx, hc.neededFields)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
@@ -212,7 +321,7 @@ func (hc *TopNCollector) collectSingle(ctx *search.Context, d *search.DocumentMa
 		// but we want to allow for exact match, so we pretend
 		hc.searchAfter.HitNumber = d.HitNumber
 		if hc.sort.Compare(d, hc.searchAfter) <= 0 {
-			return nil
+			return false, nil
 		}
 	}
 
@@ -224,7 +333,7 @@ func (hc *TopNCollector) collectSingle(ctx *search.Context, d *search.DocumentMa
 		if cmp >= 0 {
 			// this hit can't possibly be in the result set, so avoid heap ops
 			ctx.DocumentMatchPool.Put(d)
-			return nil
+			return true, nil
 		}
 	}
 
@@ -240,8 +349,106 @@ func (hc *TopNCollector) collectSingle(ctx *search.Context, d *search.DocumentMa
 				ctx.DocumentMatchPool.Put(tmp)
 			}
 		}
+		hc.publishMinCompetitiveScore(d.HitNumber)
+	}
+	return removed == d, nil
+}
+
+// publishMinCompetitiveScore informs the searcher of the lowest score
+// a match can have and still be collected.  As the results are sorted
+// by descending score, and the store is full, a match scoring less than
+// the worst result kept could never be collected, so it can be skipped,
+// once the number of matches which must be counted have been collected.
+func (hc *TopNCollector) publishMinCompetitiveScore(hitNumber int) {
+	if hc.competitiveSearcher == nil || hitNumber < hc.trackTotalHits {
+		return
+	}
+	if hc.competitiveSearcher.SetMinCompetitiveScore(hc.store.Last().Score) {
+		hc.skippedMatches = true
+	} else {
+		// searcher cannot skip matches, stop trying
+		hc.competitiveSearcher = nil
+	}
+}
+
+// PartitionCollector returns a collector for the matches of one
+// partition of the documents, keeping the top size+skip matches
+// so that Merge can find the overall top matches.  Each partition
+// applies the terminate after limit and counts the number of matches
+// required by the track total hits setting on its own.
+func (hc *TopNCollector) PartitionCollector() search.Collector {
+	rv := newTopNCollector(hc.size+hc.skip, 0, hc.sort, false)
+	if hc.searchAfter != nil {
+		// each partition records its own hit numbers on the search after
+		// match, so partitions collected concurrently cannot share it
+		rv.searchAfter = &search.DocumentMatch{
+			SortValue: hc.searchAfter.SortValue,
+		}
 	}
-	return nil
+	rv.allowPartialResults = hc.allowPartialResults
+	rv.terminateAfter = hc.terminateAfter
+	rv.trackTotalHits = hc.trackTotalHits
+	return rv
+}
+
+// Merge combines the results of partition collectors, the matches
+// are ranked together and the aggregations are merged
+func (hc *TopNCollector) Merge(aggs search.Aggregations,
+	partitions []search.DocumentMatchIterator) (search.DocumentMatchIterator, error) {
+	rv := &TopNIterator{
+		bucket: search.NewBucket("", aggs),
+	}
+	var results search.DocumentMatchCollection
+	for _, partition := range partitions {
+		next, err := partition.Next()
+		for err == nil && next != nil {
+			results = append(results, next)
+			next, err = partition.Next()
+		}
+		if err != nil {
+			return nil, err
+		}
+		rv.bucket.Merge(partition.Aggregations())
+		if pri, ok := partition.(search.PartialResultsIterator); ok {
+			rv.timedOut = rv.timedOut || pri.TimedOut()
+			rv.terminatedEarly = rv.terminatedEarly || pri.TerminatedEarly()
+			rv.countLowerBound = rv.countLowerBound || pri.CountIsLowerBound()
+		}
+	}
+	rv.bucket.Finish()
+	err := rv.bucket.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// hit numbers are only meaningful within a partition,
+	// so ties are broken by source and document number instead
+	sort.SliceStable(results, func(i, j int) bool {
+		c := hc.sort.CompareSortValues(results[i], results[j])
+		if c != 0 {
+			return c < 0
+		}
+		if results[i].SourceIndex != results[j].SourceIndex {
+			return results[i].SourceIndex < results[j].SourceIndex
+		}
+		return results[i].Number < results[j].Number
+	})
+	if hc.skip >= len(results) {
+		results = nil
+	} else {
+		results = results[hc.skip:]
+	}
+	if len(results) > hc.size {
+		results = results[:hc.size]
+	}
+
+	if hc.reverse {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+	rv.results = results
+	return rv, nil
 }
 
 // finalizeResults starts with the heap containing the final top size+skip
@@ -262,3 +469,19 @@ func (hc *TopNCollector) finalizeResults() error {
 
 	return err
 }
+
+// appendFields appends the fields not already in the list, so the
+// values of a field needed by both the sort and an aggregation, or
+// by several aggregations, are loaded once
+func appendFields(fields []string, more ...string) []string {
+OUTER:
+	for _, field := range more {
+		for _, existing := range fields {
+			if existing == field {
+				continue OUTER
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/blugelabs/bluge/search/aggregations"
 
@@ -400,3 +401,197 @@ func BenchmarkTop10000of1000000Scores(b *testing.B) {
 		return NewTopNCollector(10000, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
 	}, b)
 }
+
+type deadlineSearcher struct {
+	stubSearcher
+	ctx       context.Context
+	waitAfter int
+}
+
+func (ds *deadlineSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	if ds.index == ds.waitAfter {
+		// simulate a slow search
+		<-ds.ctx.Done()
+	}
+	return ds.stubSearcher.Next(ctx)
+}
+
+func TestTopNPartialResultsOnDeadline(t *testing.T) {
+	aggs := make(search.Aggregations)
+	aggs.Add("count", aggregations.CountMatches())
+
+	collect := func(allowPartialResults bool) (search.DocumentMatchIterator, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		searcher := &deadlineSearcher{
+			stubSearcher: stubSearcher{matches: makeMatches(3*CheckDoneEvery, 1)},
+			ctx:          ctx,
+			waitAfter:    CheckDoneEvery / 2,
+		}
+		collector := NewTopNCollector(10, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+		collector.SetAllowPartialResults(allowPartialResults)
+		return collector.Collect(ctx, aggs, searcher)
+	}
+
+	_, err := collect(false)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	dmi, err := collect(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr := dmi.(search.PartialResultsIterator)
+	if !itr.TimedOut() {
+		t.Errorf("expected search to report timed out")
+	}
+	total := dmi.Aggregations().Count()
+	if total != uint64(CheckDoneEvery) {
+		t.Errorf("expected %d matches collected before the deadline, got %d", CheckDoneEvery, total)
+	}
+	next, err := dmi.Next()
+	if err != nil || next == nil {
+		t.Errorf("expected partial results, got %v %v", next, err)
+	}
+}
+
+func TestTopNTerminateAfter(t *testing.T) {
+	aggs := make(search.Aggregations)
+	aggs.Add("count", aggregations.CountMatches())
+
+	collector := NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+	collector.SetTerminateAfter(5)
+	dmi, err := collector.Collect(context.Background(), aggs, &stubSearcher{matches: makeMatches(20, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr := dmi.(search.PartialResultsIterator)
+	if !itr.TerminatedEarly() || itr.TimedOut() {
+		t.Errorf("expected search to report terminated early only")
+	}
+	total := dmi.Aggregations().Count()
+	if total != 5 {
+		t.Errorf("expected 5 matches collected, got %d", total)
+	}
+
+	collector = NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+	collector.SetTerminateAfter(50)
+	dmi, err = collector.Collect(context.Background(), aggs, &stubSearcher{matches: makeMatches(20, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dmi.(search.PartialResultsIterator).TerminatedEarly() {
+		t.Errorf("expected search not to report terminated early")
+	}
+}
+
+type competitiveSearcher struct {
+	stubSearcher
+	minCompetitiveScore float64
+}
+
+func (cs *competitiveSearcher) SetMinCompetitiveScore(score float64) bool {
+	cs.minCompetitiveScore = score
+	return true
+}
+
+func (cs *competitiveSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	for cs.index < len(cs.matches) && cs.matches[cs.index].Score < cs.minCompetitiveScore {
+		cs.index++
+	}
+	return cs.stubSearcher.Next(ctx)
+}
+
+func TestTopNSkipNonCompetitive(t *testing.T) {
+	var matches []*search.DocumentMatch
+	for i := 1; i <= 20; i++ {
+		matches = append(matches, &search.DocumentMatch{
+			Number: uint64(i),
+			Score:  float64(i % 7),
+		})
+	}
+
+	aggs := make(search.Aggregations)
+	aggs.Add("count", aggregations.CountMatches())
+
+	searcher := &competitiveSearcher{stubSearcher: stubSearcher{matches: matches}}
+	collector := NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+	collector.SetTrackTotalHits(TrackTotalHitsDisabled)
+	dmi, err := collector.Collect(context.Background(), aggs, searcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scores []float64
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		scores = append(scores, next.Score)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != 3 || scores[0] != 6 || scores[1] != 6 || scores[2] != 6 {
+		t.Errorf("expected top scores [6 6 6], got %v", scores)
+	}
+	if searcher.minCompetitiveScore != 6 {
+		t.Errorf("expected minimum competitive score of the worst result kept 6, got %f",
+			searcher.minCompetitiveScore)
+	}
+	if !dmi.(*TopNIterator).CountIsLowerBound() {
+		t.Errorf("expected count to be a lower bound")
+	}
+	if dmi.Aggregations().Count() >= 20 {
+		t.Errorf("expected some matches to be skipped, got %d", dmi.Aggregations().Count())
+	}
+
+	// not sorted by score, so nothing can be skipped
+	searcher = &competitiveSearcher{stubSearcher: stubSearcher{matches: matches}}
+	collector = NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore())})
+	collector.SetTrackTotalHits(TrackTotalHitsDisabled)
+	dmi, err = collector.Collect(context.Background(), aggs, searcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if searcher.minCompetitiveScore != 0 || dmi.(*TopNIterator).CountIsLowerBound() {
+		t.Errorf("expected no skipping when not sorted by descending score")
+	}
+	if dmi.Aggregations().Count() != 20 {
+		t.Errorf("expected 20 matches, got %d", dmi.Aggregations().Count())
+	}
+}
+
+func TestTopNTrackTotalHitsUpTo(t *testing.T) {
+	aggs := make(search.Aggregations)
+	aggs.Add("count", aggregations.CountMatches())
+
+	// every match scores the same, so any skipping would still find the top 3
+	searcher := &competitiveSearcher{stubSearcher: stubSearcher{matches: makeMatches(20, 1)}}
+	collector := NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+	collector.SetTrackTotalHits(10)
+	dmi, err := collector.Collect(context.Background(), aggs, searcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dmi.(search.PartialResultsIterator).CountIsLowerBound() {
+		t.Errorf("expected count to be a lower bound")
+	}
+	if dmi.Aggregations().Count() < 10 {
+		t.Errorf("expected at least 10 matches counted, got %d", dmi.Aggregations().Count())
+	}
+
+	// fewer matches than the threshold are counted exactly
+	searcher = &competitiveSearcher{stubSearcher: stubSearcher{matches: makeMatches(20, 1)}}
+	collector = NewTopNCollector(3, 0, search.SortOrder{search.SortBy(search.DocumentScore()).Desc()})
+	collector.SetTrackTotalHits(50)
+	dmi, err = collector.Collect(context.Background(), aggs, searcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dmi.(search.PartialResultsIterator).CountIsLowerBound() || searcher.minCompetitiveScore != 0 {
+		t.Errorf("expected exact count below threshold")
+	}
+	if dmi.Aggregations().Count() != 20 {
+		t.Errorf("expected 20 matches, got %d", dmi.Aggregations().Count())
+	}
+}
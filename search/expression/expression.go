@@ -0,0 +1,141 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package expression implements a small, safe arithmetic language
+// for computing numeric values from a DocumentMatch.
+//
+// Expressions are compiled once and can then be used anywhere a numeric or
+// text value source is accepted, for example to sort with SortByCustom,
+// as the source of an aggregation, or to compute scores.
+//
+// The language supports:
+//   - numeric literals: 1, 2.5, 1e-3
+//   - the document score: _score
+//   - numeric doc-value fields by name: popularity, stats.views
+//     other field names can be written field("my-field")
+//   - arithmetic: + - * / %
+//   - comparison: == != < <= > >= (producing 1 or 0)
+//   - logic: && || ! (zero and NaN are false, everything else is true)
+//   - conditionals: cond ? a : b, if(cond, a, b)
+//   - math functions: abs ceil floor round trunc sqrt cbrt exp expm1 ln log
+//     log1p log2 log10 sin cos tan asin acos atan sinh cosh tanh signum
+//     sigmoid isnan pow atan2 hypot mod min max clamp coalesce pi e
+//   - document functions: exists(field), date(field) (seconds since epoch),
+//     geo_distance(field, lon, lat [, unit]) (default unit kilometers)
+//   - now() (seconds since epoch at compile time)
+//
+// Fields which are missing from a document evaluate to NaN, which
+// propagates through arithmetic.  Expressions evaluating to NaN
+// are treated as missing values by sorting and aggregations.
+// Expressions are pure, they cannot loop or have side-effects,
+// and their nesting depth is limited by MaxDepth.
+package expression
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/search"
+)
+
+// Expression is a compiled expression
+type Expression struct {
+	source    string
+	root      node
+	fields    []string
+	usesScore bool
+}
+
+// Compile parses the expression, returning an error if it is not valid
+func Compile(expr string) (*Expression, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling expression '%s': %w", expr, err)
+	}
+	p := newParser(tokens)
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("error compiling expression '%s': %w", expr, err)
+	}
+	return &Expression{
+		source:    expr,
+		root:      root,
+		fields:    p.fields,
+		usesScore: p.usesScore,
+	}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is not valid
+func MustCompile(expr string) *Expression {
+	rv, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return rv
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Fields returns the doc-value fields the expression reads
+func (e *Expression) Fields() []string {
+	return e.fields
+}
+
+// UsesScore reports whether the expression reads the document score
+func (e *Expression) UsesScore() bool {
+	return e.usesScore
+}
+
+// Number evaluates the expression for the provided match
+func (e *Expression) Number(match *search.DocumentMatch) float64 {
+	return e.root.eval(match)
+}
+
+// Numbers evaluates the expression for the provided match, returning
+// no values if the result is NaN
+func (e *Expression) Numbers(match *search.DocumentMatch) []float64 {
+	val := e.Number(match)
+	if math.IsNaN(val) {
+		return nil
+	}
+	return []float64{val}
+}
+
+// Value evaluates the expression for the provided match, returning the
+// result encoded so that it sorts numerically, or nil if the result is NaN
+func (e *Expression) Value(match *search.DocumentMatch) []byte {
+	val := e.Number(match)
+	if math.IsNaN(val) {
+		return nil
+	}
+	return numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(val), 0)
+}
+
+// Values is like Value, but returns a slice for multi-value sources
+func (e *Expression) Values(match *search.DocumentMatch) [][]byte {
+	val := e.Value(match)
+	if val == nil {
+		return nil
+	}
+	return [][]byte{val}
+}
+
+var _ search.NumericValueSource = (*Expression)(nil)
+var _ search.NumericValuesSource = (*Expression)(nil)
+var _ search.TextValueSource = (*Expression)(nil)
+var _ search.TextValuesSource = (*Expression)(nil)
@@ -0,0 +1,219 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/numeric/geo"
+	"github.com/blugelabs/bluge/search"
+)
+
+type matchReader struct {
+	docVals map[string][]byte
+}
+
+func (mr *matchReader) DocumentValueReader(fields []string) (segment.DocumentValueReader, error) {
+	return mr, nil
+}
+
+func (mr *matchReader) VisitDocumentValues(number uint64, visitor segment.DocumentValueVisitor) error {
+	for k, v := range mr.docVals {
+		visitor(k, v)
+	}
+	return nil
+}
+
+func (mr *matchReader) VisitStoredFields(number uint64, visitor segment.StoredFieldVisitor) error {
+	return nil
+}
+
+func encodeNumber(f float64) []byte {
+	return numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(f), 0)
+}
+
+func buildTestMatch(t *testing.T, score float64, fields []string) *search.DocumentMatch {
+	rv := &search.DocumentMatch{
+		Score: score,
+	}
+	rv.SetReader(&matchReader{docVals: map[string][]byte{
+		"popularity":  encodeNumber(9),
+		"recency_day": encodeNumber(3),
+		"odd-name":    encodeNumber(7),
+		"published":   numeric.MustNewPrefixCodedInt64(time.Unix(86400, 0).UnixNano(), 0),
+		"location":    numeric.MustNewPrefixCodedInt64(int64(geo.MortonHash(-122.4, 37.8)), 0),
+	}})
+	err := rv.LoadDocumentValues(search.NewSearchContext(0, 0), fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+func TestExpressionEval(t *testing.T) {
+	tests := []struct {
+		expr   string
+		expect float64
+		fields []string
+	}{
+		{expr: "1 + 2 * 3", expect: 7},
+		{expr: "(1 + 2) * 3", expect: 9},
+		{expr: "10 - 4 - 3", expect: 3},
+		{expr: "7 % 4", expect: 3},
+		{expr: "-2 * -3", expect: 6},
+		{expr: "1.5e1", expect: 15},
+		{expr: "_score * 2", expect: 3},
+		{expr: "_score * log1p(popularity) + 0.1 * recency_day", expect: 1.5*math.Log1p(9) + 0.1*3,
+			fields: []string{"popularity", "recency_day"}},
+		{expr: "popularity > 5 ? 1 : 2", expect: 1, fields: []string{"popularity"}},
+		{expr: "if(popularity < 5, 1, 2)", expect: 2, fields: []string{"popularity"}},
+		{expr: "popularity >= 9 && recency_day == 3", expect: 1, fields: []string{"popularity", "recency_day"}},
+		{expr: "popularity != 9 || !recency_day", expect: 0, fields: []string{"popularity", "recency_day"}},
+		{expr: "max(1, popularity, 4)", expect: 9, fields: []string{"popularity"}},
+		{expr: "min(3, 2)", expect: 2},
+		{expr: "clamp(popularity, 0, 5)", expect: 5, fields: []string{"popularity"}},
+		{expr: "pow(2, 10)", expect: 1024},
+		{expr: "sqrt(16) + abs(-1)", expect: 5},
+		{expr: "floor(pi())", expect: 3},
+		{expr: "field('odd-name') * 2", expect: 14, fields: []string{"odd-name"}},
+		{expr: "exists(missing)", expect: 0, fields: []string{"missing"}},
+		{expr: "exists(popularity)", expect: 1, fields: []string{"popularity"}},
+		{expr: "coalesce(missing, 42)", expect: 42, fields: []string{"missing"}},
+		{expr: "date(published) / 86400", expect: 1, fields: []string{"published"}},
+		{expr: "round(geo_distance(location, -122.4, 37.8))", expect: 0, fields: []string{"location"}},
+		{expr: "round(geo_distance(location, -122.4, 37.8, 'm'))", expect: 0, fields: []string{"location"}},
+	}
+
+	for _, test := range tests {
+		expr, err := Compile(test.expr)
+		if err != nil {
+			t.Fatalf("unexpected error compiling '%s': %v", test.expr, err)
+		}
+		if len(test.fields) == 0 && len(expr.Fields()) != 0 {
+			t.Errorf("expected no fields for '%s', got %v", test.expr, expr.Fields())
+		} else if len(test.fields) > 0 && !reflect.DeepEqual(test.fields, expr.Fields()) {
+			t.Errorf("expected fields %v for '%s', got %v", test.fields, test.expr, expr.Fields())
+		}
+		match := buildTestMatch(t, 1.5, expr.Fields())
+		actual := expr.Number(match)
+		if math.Abs(actual-test.expect) > 1e-9 {
+			t.Errorf("expected '%s' to evaluate to %f, got %f", test.expr, test.expect, actual)
+		}
+	}
+}
+
+func TestExpressionGeoDistanceUnits(t *testing.T) {
+	km := MustCompile("geo_distance(location, -122.0, 37.0)")
+	mi := MustCompile("geo_distance(location, -122.0, 37.0, 'mi')")
+	match := buildTestMatch(t, 0, []string{"location"})
+	kmVal := km.Number(match)
+	miVal := mi.Number(match)
+	if kmVal < 90 || kmVal > 100 {
+		t.Errorf("expected distance around 95km, got %f", kmVal)
+	}
+	if math.Abs(miVal-kmVal/1.609344) > 1e-6 {
+		t.Errorf("expected %f miles, got %f", kmVal/1.609344, miVal)
+	}
+}
+
+func TestExpressionMissingValues(t *testing.T) {
+	expr := MustCompile("missing * 2")
+	match := buildTestMatch(t, 1, expr.Fields())
+	if !math.IsNaN(expr.Number(match)) {
+		t.Errorf("expected NaN for missing field, got %f", expr.Number(match))
+	}
+	if expr.Numbers(match) != nil {
+		t.Errorf("expected no numbers for missing field")
+	}
+	if expr.Value(match) != nil {
+		t.Errorf("expected no value for missing field")
+	}
+	if expr.Values(match) != nil {
+		t.Errorf("expected no values for missing field")
+	}
+}
+
+func TestExpressionValueSortsNumerically(t *testing.T) {
+	expr := MustCompile("_score - 5")
+	low := expr.Value(&search.DocumentMatch{Score: 1})
+	high := expr.Value(&search.DocumentMatch{Score: 10})
+	if string(low) >= string(high) {
+		t.Errorf("expected encoded value of -4 to sort before 5")
+	}
+	if !expr.UsesScore() {
+		t.Errorf("expected expression to use score")
+	}
+}
+
+func TestExpressionConstantFolding(t *testing.T) {
+	expr := MustCompile("1 + 2 * pow(2, 3)")
+	if c, ok := expr.root.(constantNode); !ok || float64(c) != 17 {
+		t.Errorf("expected expression to fold to constant 17, got %#v", expr.root)
+	}
+}
+
+func TestExpressionNow(t *testing.T) {
+	defer func() { clock = time.Now }()
+	clock = func() time.Time {
+		return time.Unix(1000, 0)
+	}
+	expr := MustCompile("now() - 10")
+	if expr.Number(&search.DocumentMatch{}) != 990 {
+		t.Errorf("expected 990, got %f", expr.Number(&search.DocumentMatch{}))
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "", err: "unexpected end of expression"},
+		{expr: "1 +", err: "unexpected end of expression"},
+		{expr: "(1 + 2", err: "expected ')'"},
+		{expr: "1 2", err: "unexpected '2'"},
+		{expr: "a ? b", err: "expected ':'"},
+		{expr: "1 $ 2", err: "unexpected character '$'"},
+		{expr: "nope(1)", err: "unknown function 'nope'"},
+		{expr: "sqrt(1, 2)", err: "expects 1 arguments, got 2"},
+		{expr: "max()", err: "expects at least 1 arguments, got 0"},
+		{expr: "'abc'", err: "only allowed as a function argument"},
+		{expr: "sqrt('abc')", err: "does not accept string arguments"},
+		{expr: "exists(1)", err: "expects a field name"},
+		{expr: "geo_distance(loc, 1, 2, 'parsecs')", err: "unknown distance unit"},
+		{expr: "geo_distance(loc, 1, 2, 3)", err: "expects a distance unit string"},
+		{expr: "'unterminated", err: "unterminated string"},
+		{expr: strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100), err: "nested more than"},
+		{expr: strings.Repeat("-", 100) + "1", err: "nested more than"},
+	}
+
+	for _, test := range tests {
+		_, err := Compile(test.expr)
+		if err == nil {
+			t.Errorf("expected error compiling '%s'", test.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expected error compiling '%s' to contain '%s', got '%v'", test.expr, test.err, err)
+		}
+	}
+}
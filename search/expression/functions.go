@@ -0,0 +1,238 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blugelabs/bluge/numeric/geo"
+	"github.com/blugelabs/bluge/search"
+)
+
+var unaryFunctions = map[string]func(float64) float64{
+	"abs":     math.Abs,
+	"ceil":    math.Ceil,
+	"floor":   math.Floor,
+	"round":   math.Round,
+	"trunc":   math.Trunc,
+	"sqrt":    math.Sqrt,
+	"cbrt":    math.Cbrt,
+	"exp":     math.Exp,
+	"expm1":   math.Expm1,
+	"ln":      math.Log,
+	"log":     math.Log,
+	"log1p":   math.Log1p,
+	"log2":    math.Log2,
+	"log10":   math.Log10,
+	"sin":     math.Sin,
+	"cos":     math.Cos,
+	"tan":     math.Tan,
+	"asin":    math.Asin,
+	"acos":    math.Acos,
+	"atan":    math.Atan,
+	"sinh":    math.Sinh,
+	"cosh":    math.Cosh,
+	"tanh":    math.Tanh,
+	"signum":  signum,
+	"sigmoid": sigmoid,
+	"isnan":   func(v float64) float64 { return boolToFloat(math.IsNaN(v)) },
+}
+
+var binaryFunctions = map[string]func(a, b float64) float64{
+	"pow":   math.Pow,
+	"atan2": math.Atan2,
+	"hypot": math.Hypot,
+	"mod":   math.Mod,
+	// coalesce returns the first argument unless it is NaN (missing)
+	"coalesce": func(a, b float64) float64 {
+		if math.IsNaN(a) {
+			return b
+		}
+		return a
+	},
+}
+
+var reduceFunctions = map[string]func(a, b float64) float64{
+	"min": math.Min,
+	"max": math.Max,
+}
+
+var constants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+// fieldArgFunctions are functions whose first argument names a
+// field, rather than being an expression which is evaluated
+var fieldArgFunctions = map[string]func(p *parser, field string, args []node, tok token) (node, error){
+	"field":        buildField,
+	"exists":       buildExists,
+	"date":         buildDate,
+	"geo_distance": buildGeoDistance,
+}
+
+func signum(v float64) float64 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return v
+}
+
+func sigmoid(v float64) float64 {
+	return 1 / (1 + math.Exp(-v))
+}
+
+func buildFunction(p *parser, tok token, args []node) (node, error) {
+	name := tok.val
+	if fn, ok := unaryFunctions[name]; ok {
+		if err := checkArity(tok, args, 1, 1); err != nil {
+			return nil, err
+		}
+		rv := &unaryFuncNode{fn: fn, arg: args[0]}
+		return fold(rv, args...), nil
+	}
+	if fn, ok := binaryFunctions[name]; ok {
+		if err := checkArity(tok, args, 2, 2); err != nil {
+			return nil, err
+		}
+		rv := &binaryFuncNode{fn: fn, argA: args[0], argB: args[1]}
+		return fold(rv, args...), nil
+	}
+	if fn, ok := reduceFunctions[name]; ok {
+		if err := checkArity(tok, args, 1, -1); err != nil {
+			return nil, err
+		}
+		rv := &reduceFuncNode{fn: fn, args: args}
+		return fold(rv, args...), nil
+	}
+	if val, ok := constants[name]; ok {
+		if err := checkArity(tok, args, 0, 0); err != nil {
+			return nil, err
+		}
+		return constantNode(val), nil
+	}
+	switch name {
+	case "now":
+		if err := checkArity(tok, args, 0, 0); err != nil {
+			return nil, err
+		}
+		return constantNode(float64(p.now.UnixNano()) / 1e9), nil
+	case "if":
+		if err := checkArity(tok, args, 3, 3); err != nil {
+			return nil, err
+		}
+		return newConditional(args[0], args[1], args[2]), nil
+	case "clamp":
+		if err := checkArity(tok, args, 3, 3); err != nil {
+			return nil, err
+		}
+		rv := &reduceFuncNode{fn: math.Min, args: []node{
+			&reduceFuncNode{fn: math.Max, args: []node{args[0], args[1]}},
+			args[2],
+		}}
+		return fold(rv, args...), nil
+	}
+	return nil, fmt.Errorf("unknown function '%s' at offset %d", name, tok.pos)
+}
+
+func checkArity(tok token, args []node, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		switch {
+		case min == max:
+			return fmt.Errorf("function '%s' at offset %d expects %d arguments, got %d",
+				tok.val, tok.pos, min, len(args))
+		case max < 0:
+			return fmt.Errorf("function '%s' at offset %d expects at least %d arguments, got %d",
+				tok.val, tok.pos, min, len(args))
+		}
+		return fmt.Errorf("function '%s' at offset %d expects %d to %d arguments, got %d",
+			tok.val, tok.pos, min, max, len(args))
+	}
+	return nil
+}
+
+func buildField(p *parser, field string, args []node, tok token) (node, error) {
+	if err := checkArity(tok, args, 0, 0); err != nil {
+		return nil, err
+	}
+	return p.field(field), nil
+}
+
+func buildExists(p *parser, field string, args []node, tok token) (node, error) {
+	if err := checkArity(tok, args, 0, 0); err != nil {
+		return nil, err
+	}
+	p.addField(field)
+	return &existsNode{source: search.Field(field)}, nil
+}
+
+func buildDate(p *parser, field string, args []node, tok token) (node, error) {
+	if err := checkArity(tok, args, 0, 0); err != nil {
+		return nil, err
+	}
+	p.addField(field)
+	return &dateNode{source: search.Field(field)}, nil
+}
+
+func buildGeoDistance(p *parser, field string, args []node, tok token) (node, error) {
+	if err := checkArity(tok, args, 2, 3); err != nil {
+		return nil, err
+	}
+	for _, arg := range args[:2] {
+		if _, ok := arg.(stringNode); ok {
+			return nil, fmt.Errorf("function '%s' at offset %d expects numeric lon and lat arguments",
+				tok.val, tok.pos)
+		}
+	}
+	conv := 1.0
+	if len(args) == 3 {
+		unit, ok := args[2].(stringNode)
+		if !ok {
+			return nil, fmt.Errorf("function '%s' at offset %d expects a distance unit string as last argument",
+				tok.val, tok.pos)
+		}
+		unitMeters, err := geo.ParseDistanceUnit(string(unit))
+		if err != nil {
+			return nil, fmt.Errorf("function '%s' at offset %d: %w", tok.val, tok.pos, err)
+		}
+		// haversin computes kilometers
+		conv = 1000 / unitMeters
+	}
+	p.addField(field)
+	return &geoDistanceNode{
+		source: search.Field(field),
+		lon:    args[0],
+		lat:    args[1],
+		conv:   conv,
+	}, nil
+}
+
+// fold evaluates a node whose arguments are all constant once,
+// at compile time, and replaces it with the resulting constant
+func fold(n node, args ...node) node {
+	if isConstant(args...) {
+		return constantNode(n.eval(nil))
+	}
+	return n
+}
+
+// clock is used to resolve now(), it is a variable to allow tests to
+// control the time
+var clock = time.Now
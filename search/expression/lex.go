@@ -0,0 +1,180 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenNumber
+	tokenIdent
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+	tokenQuestion
+	tokenColon
+)
+
+type token struct {
+	typ tokenType
+	val string
+	num float64
+	pos int
+}
+
+func (t token) String() string {
+	if t.typ == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.val)
+}
+
+// operators are listed longest first so that the lexer
+// always prefers the longest possible match
+var operators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "<", ">", "!",
+}
+
+func lex(input string) ([]token, error) {
+	var rv []token
+	pos := 0
+	for pos < len(input) {
+		r, width := utf8.DecodeRuneInString(input[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += width
+		case r == '(':
+			rv = append(rv, token{typ: tokenLeftParen, val: "(", pos: pos})
+			pos++
+		case r == ')':
+			rv = append(rv, token{typ: tokenRightParen, val: ")", pos: pos})
+			pos++
+		case r == ',':
+			rv = append(rv, token{typ: tokenComma, val: ",", pos: pos})
+			pos++
+		case r == '?':
+			rv = append(rv, token{typ: tokenQuestion, val: "?", pos: pos})
+			pos++
+		case r == ':':
+			rv = append(rv, token{typ: tokenColon, val: ":", pos: pos})
+			pos++
+		case r == '"' || r == '\'':
+			tok, err := lexString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			rv = append(rv, tok)
+			pos += len(tok.val) + 2
+		case isDigit(r) || (r == '.' && pos+1 < len(input) && isDigit(rune(input[pos+1]))):
+			tok, err := lexNumber(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			rv = append(rv, tok)
+			pos += len(tok.val)
+		case isIdentStart(r):
+			end := pos + width
+			for end < len(input) {
+				r, width = utf8.DecodeRuneInString(input[end:])
+				if !isIdentPart(r) {
+					break
+				}
+				end += width
+			}
+			rv = append(rv, token{typ: tokenIdent, val: input[pos:end], pos: pos})
+			pos = end
+		default:
+			op := lexOperator(input[pos:])
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c' at offset %d", r, pos)
+			}
+			rv = append(rv, token{typ: tokenOperator, val: op, pos: pos})
+			pos += len(op)
+		}
+	}
+	rv = append(rv, token{typ: tokenEOF, pos: pos})
+	return rv, nil
+}
+
+func lexOperator(input string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(input, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func lexString(input string, start int) (token, error) {
+	quote := input[start]
+	end := strings.IndexByte(input[start+1:], quote)
+	if end < 0 {
+		return token{}, fmt.Errorf("unterminated string starting at offset %d", start)
+	}
+	return token{
+		typ: tokenString,
+		val: input[start+1 : start+1+end],
+		pos: start,
+	}, nil
+}
+
+func lexNumber(input string, start int) (token, error) {
+	end := start
+	for end < len(input) && (isDigit(rune(input[end])) || input[end] == '.') {
+		end++
+	}
+	// optional exponent
+	if end < len(input) && (input[end] == 'e' || input[end] == 'E') {
+		expEnd := end + 1
+		if expEnd < len(input) && (input[expEnd] == '+' || input[expEnd] == '-') {
+			expEnd++
+		}
+		if expEnd < len(input) && isDigit(rune(input[expEnd])) {
+			for expEnd < len(input) && isDigit(rune(input[expEnd])) {
+				expEnd++
+			}
+			end = expEnd
+		}
+	}
+	val := input[start:end]
+	num, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return token{}, fmt.Errorf("invalid number '%s' at offset %d", val, start)
+	}
+	return token{typ: tokenNumber, val: val, num: num, pos: start}, nil
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,187 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"math"
+
+	"github.com/blugelabs/bluge/numeric/geo"
+	"github.com/blugelabs/bluge/search"
+)
+
+// node is a single element of a compiled expression tree
+type node interface {
+	eval(d *search.DocumentMatch) float64
+}
+
+type constantNode float64
+
+func (c constantNode) eval(_ *search.DocumentMatch) float64 {
+	return float64(c)
+}
+
+func isConstant(nodes ...node) bool {
+	for _, n := range nodes {
+		if _, ok := n.(constantNode); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type scoreNode struct{}
+
+func (scoreNode) eval(d *search.DocumentMatch) float64 {
+	return d.Score
+}
+
+type fieldNode struct {
+	source search.FieldSource
+}
+
+func (f *fieldNode) eval(d *search.DocumentMatch) float64 {
+	return f.source.Number(d)
+}
+
+type existsNode struct {
+	source search.FieldSource
+}
+
+func (e *existsNode) eval(d *search.DocumentMatch) float64 {
+	return boolToFloat(len(e.source.Values(d)) > 0)
+}
+
+type dateNode struct {
+	source search.FieldSource
+}
+
+func (t *dateNode) eval(d *search.DocumentMatch) float64 {
+	dates := t.source.Dates(d)
+	if len(dates) == 0 {
+		return math.NaN()
+	}
+	return float64(dates[0].UnixNano()) / 1e9
+}
+
+type geoDistanceNode struct {
+	source   search.FieldSource
+	lon, lat node
+	// multiplier to convert kilometers into the requested unit
+	conv float64
+}
+
+func (g *geoDistanceNode) eval(d *search.DocumentMatch) float64 {
+	point := g.source.GeoPoint(d)
+	if point == nil {
+		return math.NaN()
+	}
+	return geo.Haversin(point.Lon, point.Lat, g.lon.eval(d), g.lat.eval(d)) * g.conv
+}
+
+type negateNode struct {
+	operand node
+}
+
+func (n *negateNode) eval(d *search.DocumentMatch) float64 {
+	return -n.operand.eval(d)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(d *search.DocumentMatch) float64 {
+	return boolToFloat(!truthy(n.operand.eval(d)))
+}
+
+type binaryNode struct {
+	op          func(a, b float64) float64
+	left, right node
+}
+
+func (b *binaryNode) eval(d *search.DocumentMatch) float64 {
+	return b.op(b.left.eval(d), b.right.eval(d))
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (a *andNode) eval(d *search.DocumentMatch) float64 {
+	return boolToFloat(truthy(a.left.eval(d)) && truthy(a.right.eval(d)))
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (o *orNode) eval(d *search.DocumentMatch) float64 {
+	return boolToFloat(truthy(o.left.eval(d)) || truthy(o.right.eval(d)))
+}
+
+type conditionalNode struct {
+	cond, then, otherwise node
+}
+
+func (c *conditionalNode) eval(d *search.DocumentMatch) float64 {
+	if truthy(c.cond.eval(d)) {
+		return c.then.eval(d)
+	}
+	return c.otherwise.eval(d)
+}
+
+type unaryFuncNode struct {
+	fn  func(float64) float64
+	arg node
+}
+
+func (u *unaryFuncNode) eval(d *search.DocumentMatch) float64 {
+	return u.fn(u.arg.eval(d))
+}
+
+type binaryFuncNode struct {
+	fn         func(a, b float64) float64
+	argA, argB node
+}
+
+func (b *binaryFuncNode) eval(d *search.DocumentMatch) float64 {
+	return b.fn(b.argA.eval(d), b.argB.eval(d))
+}
+
+type reduceFuncNode struct {
+	fn   func(a, b float64) float64
+	args []node
+}
+
+func (r *reduceFuncNode) eval(d *search.DocumentMatch) float64 {
+	rv := r.args[0].eval(d)
+	for _, arg := range r.args[1:] {
+		rv = r.fn(rv, arg.eval(d))
+	}
+	return rv
+}
+
+// truthy reports whether a value is considered true when used as a
+// condition, any value other than zero and NaN is true
+func truthy(v float64) bool {
+	return v != 0 && !math.IsNaN(v)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,360 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expression
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// MaxDepth limits how deeply expressions may be nested
+var MaxDepth = 64
+
+// scoreIdentifier refers to the score of the document being evaluated
+const scoreIdentifier = "_score"
+
+// stringNode is only valid as a function argument, it is never evaluated
+type stringNode string
+
+func (s stringNode) eval(_ *search.DocumentMatch) float64 {
+	return math.NaN()
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	now    time.Time
+
+	fields     []string
+	seenFields map[string]struct{}
+	usesScore  bool
+}
+
+func newParser(tokens []token) *parser {
+	return &parser{
+		tokens:     tokens,
+		now:        clock(),
+		seenFields: make(map[string]struct{}),
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *parser) next() token {
+	rv := p.tokens[p.pos]
+	if rv.typ != tokenEOF {
+		p.pos++
+	}
+	return rv
+}
+
+func (p *parser) acceptOperator(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.typ == tokenOperator {
+		for _, op := range ops {
+			if tok.val == op {
+				p.pos++
+				return tok, true
+			}
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) expect(typ tokenType, what string) (token, error) {
+	tok := p.next()
+	if tok.typ != typ {
+		return tok, fmt.Errorf("expected %s at offset %d, got %s", what, tok.pos, tok)
+	}
+	return tok, nil
+}
+
+func (p *parser) addField(field string) {
+	if _, seen := p.seenFields[field]; !seen {
+		p.seenFields[field] = struct{}{}
+		p.fields = append(p.fields, field)
+	}
+}
+
+func (p *parser) field(field string) node {
+	p.addField(field)
+	return &fieldNode{source: search.Field(field)}
+}
+
+func (p *parser) parse() (node, error) {
+	rv, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.typ != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	return rv, nil
+}
+
+func (p *parser) parseExpression() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression nested more than %d levels deep", MaxDepth)
+	}
+	return p.parseConditional()
+}
+
+func (p *parser) parseConditional() (node, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokenQuestion {
+		return cond, nil
+	}
+	p.next()
+	then, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.expect(tokenColon, "':'"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return newConditional(cond, then, otherwise), nil
+}
+
+func newConditional(cond, then, otherwise node) node {
+	if c, ok := cond.(constantNode); ok {
+		if truthy(float64(c)) {
+			return then
+		}
+		return otherwise
+	}
+	return &conditionalNode{cond: cond, then: then, otherwise: otherwise}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = fold(&orNode{left: left, right: right}, left, right)
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		left = fold(&andNode{left: left, right: right}, left, right)
+	}
+}
+
+// binaryPrecedence lists the left-associative binary operators
+// from lowest to highest precedence
+var binaryPrecedence = []map[string]func(a, b float64) float64{
+	{
+		"==": func(a, b float64) float64 { return boolToFloat(a == b) },
+		"!=": func(a, b float64) float64 { return boolToFloat(a != b) },
+	},
+	{
+		"<":  func(a, b float64) float64 { return boolToFloat(a < b) },
+		"<=": func(a, b float64) float64 { return boolToFloat(a <= b) },
+		">":  func(a, b float64) float64 { return boolToFloat(a > b) },
+		">=": func(a, b float64) float64 { return boolToFloat(a >= b) },
+	},
+	{
+		"+": func(a, b float64) float64 { return a + b },
+		"-": func(a, b float64) float64 { return a - b },
+	},
+	{
+		"*": func(a, b float64) float64 { return a * b },
+		"/": func(a, b float64) float64 { return a / b },
+		"%": math.Mod,
+	},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level >= len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.typ != tokenOperator {
+			return left, nil
+		}
+		op, ok := binaryPrecedence[level][tok.val]
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = fold(&binaryNode{op: op, left: left, right: right}, left, right)
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	tok, ok := p.acceptOperator("-", "+", "!")
+	if !ok {
+		return p.parsePrimary()
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression nested more than %d levels deep", MaxDepth)
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	switch tok.val {
+	case "-":
+		return fold(&negateNode{operand: operand}, operand), nil
+	case "!":
+		return fold(&notNode{operand: operand}, operand), nil
+	}
+	return operand, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.typ {
+	case tokenNumber:
+		return constantNode(tok.num), nil
+	case tokenLeftParen:
+		rv, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRightParen, "')'"); err != nil {
+			return nil, err
+		}
+		return rv, nil
+	case tokenIdent:
+		if p.peek().typ == tokenLeftParen {
+			return p.parseCall(tok)
+		}
+		if tok.val == scoreIdentifier {
+			p.usesScore = true
+			return scoreNode{}, nil
+		}
+		return p.field(tok.val), nil
+	case tokenString:
+		return nil, fmt.Errorf("string at offset %d is only allowed as a function argument", tok.pos)
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	p.next() // consume '('
+
+	var field string
+	fieldFunc, isFieldFunc := fieldArgFunctions[name.val]
+	if isFieldFunc {
+		tok := p.next()
+		if tok.typ != tokenIdent && tok.typ != tokenString {
+			return nil, fmt.Errorf("function '%s' at offset %d expects a field name as first argument",
+				name.val, name.pos)
+		}
+		field = tok.val
+		if p.peek().typ == tokenComma {
+			p.next()
+		}
+	}
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+
+	if isFieldFunc {
+		return fieldFunc(p, field, args, name)
+	}
+	for _, arg := range args {
+		if _, ok := arg.(stringNode); ok {
+			return nil, fmt.Errorf("function '%s' at offset %d does not accept string arguments",
+				name.val, name.pos)
+		}
+	}
+	return buildFunction(p, name, args)
+}
+
+func (p *parser) parseArguments() (rv []node, err error) {
+	if p.peek().typ == tokenRightParen {
+		p.next()
+		return nil, nil
+	}
+	for {
+		var arg node
+		tok := p.peek()
+		if tok.typ == tokenString && (p.peekAt(1).typ == tokenComma || p.peekAt(1).typ == tokenRightParen) {
+			p.next()
+			arg = stringNode(tok.val)
+		} else {
+			arg, err = p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+		}
+		rv = append(rv, arg)
+
+		tok = p.next()
+		switch tok.typ {
+		case tokenComma:
+			continue
+		case tokenRightParen:
+			return rv, nil
+		}
+		return nil, fmt.Errorf("expected ',' or ')' at offset %d, got %s", tok.pos, tok)
+	}
+}
@@ -0,0 +1,227 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/blugelabs/bluge/numeric"
+	"github.com/blugelabs/bluge/numeric/geo"
+)
+
+// JSONFloat is a float encoded as null when it is NaN or
+// infinite, as these have no JSON form, such as the value
+// of a metric calculated without any values
+type JSONFloat float64
+
+func (f JSONFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+// BucketJSON is the JSON form of a bucket, calculators may embed
+// it in the JSON form of their buckets to add details of them
+type BucketJSON struct {
+	Key          string                 `json:"key"`
+	Count        uint64                 `json:"count"`
+	Aggregations map[string]interface{} `json:"aggregations,omitempty"`
+}
+
+// JSON returns the JSON form of the bucket, the count,
+// and the JSON form of the other aggregations
+func (b *Bucket) JSON() *BucketJSON {
+	rv := &BucketJSON{
+		Key:   b.name,
+		Count: b.Count(),
+	}
+	for name, calc := range b.aggregations {
+		if name == "count" {
+			continue
+		}
+		if rv.Aggregations == nil {
+			rv.Aggregations = make(map[string]interface{}, len(b.aggregations))
+		}
+		rv.Aggregations[name] = CalculatorJSON(calc)
+	}
+	return rv
+}
+
+func (b *Bucket) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.JSON())
+}
+
+// CalculatorJSON returns the JSON form of the results of the
+// calculator, calculators implementing json.Marshaler encode
+// themselves, otherwise metrics are encoded as {"value": 1.5},
+// durations as {"value": nanoseconds, "value_as_string": "1.5ms"}
+// and buckets as {"buckets": [...]}
+func CalculatorJSON(calc Calculator) interface{} {
+	switch calc := calc.(type) {
+	case json.Marshaler:
+		return calc
+	case MetricCalculator:
+		return map[string]interface{}{
+			"value": JSONFloat(calc.Value()),
+		}
+	case DurationCalculator:
+		return map[string]interface{}{
+			"value":           calc.Duration().Nanoseconds(),
+			"value_as_string": calc.Duration().String(),
+		}
+	case BucketCalculator:
+		buckets := calc.Buckets()
+		if buckets == nil {
+			buckets = []*Bucket{}
+		}
+		return map[string]interface{}{
+			"buckets": buckets,
+		}
+	}
+	return nil
+}
+
+// ValueDecoder decodes a stored value, or a sort value, into
+// its JSON form, only the bytes of values are kept in the index,
+// so their type must be known to decode them
+type ValueDecoder func(value []byte) (interface{}, error)
+
+// TextValue decodes a value as text
+func TextValue(value []byte) (interface{}, error) {
+	return string(value), nil
+}
+
+// NumericValue decodes a number
+func NumericValue(value []byte) (interface{}, error) {
+	i64, err := numeric.PrefixCoded(value).Int64()
+	if err != nil {
+		return nil, err
+	}
+	return JSONFloat(numeric.Int64ToFloat64(i64)), nil
+}
+
+// DateTimeValue decodes a date, encoded in RFC 3339
+func DateTimeValue(value []byte) (interface{}, error) {
+	i64, err := numeric.PrefixCoded(value).Int64()
+	if err != nil {
+		return nil, err
+	}
+	return time.Unix(0, i64).UTC(), nil
+}
+
+// GeoPointValue decodes a geo point, encoded as {"lon": 1.5, "lat": 2.5}
+func GeoPointValue(value []byte) (interface{}, error) {
+	i64, err := numeric.PrefixCoded(value).Int64()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]JSONFloat{
+		"lon": JSONFloat(geo.MortonUnhashLon(uint64(i64))),
+		"lat": JSONFloat(geo.MortonUnhashLat(uint64(i64))),
+	}, nil
+}
+
+// HitJSONFormat chooses the values in the JSON form
+// of matches, and how each of them is decoded
+type HitJSONFormat struct {
+	// IDField is the stored field of the identifier of documents
+	IDField string
+	// Fields are the stored fields included, by the decoder of their values
+	Fields map[string]ValueDecoder
+	// Sort decodes the sort values, by their position in the sort
+	// order, values without a decoder are encoded in base64
+	Sort []ValueDecoder
+}
+
+// HitJSON is the JSON form of a match, the sort values are
+// encoded in base64, unless decoded, as they may be binary
+type HitJSON struct {
+	ID          string                   `json:"id"`
+	Score       JSONFloat                `json:"score"`
+	Sort        []interface{}            `json:"sort,omitempty"`
+	SourceIndex int                      `json:"source_index,omitempty"`
+	Explanation *Explanation             `json:"explanation,omitempty"`
+	Locations   FieldTermLocationMap     `json:"locations,omitempty"`
+	Fields      map[string][]interface{} `json:"fields,omitempty"`
+	Features    []JSONFloat              `json:"features,omitempty"`
+	InnerHits   []*HitJSON               `json:"inner_hits,omitempty"`
+}
+
+// NewHitJSON returns the JSON form of the match, loading its
+// identifier, and the values of the stored fields of the format
+func NewHitJSON(dm *DocumentMatch, format *HitJSONFormat) (*HitJSON, error) {
+	rv := &HitJSON{
+		Score:       JSONFloat(dm.Score),
+		SourceIndex: dm.SourceIndex,
+		Explanation: dm.Explanation,
+		Locations:   dm.Locations,
+	}
+	for i, value := range dm.SortValue {
+		if i >= len(format.Sort) || format.Sort[i] == nil || len(value) == 0 {
+			rv.Sort = append(rv.Sort, value)
+			continue
+		}
+		decoded, err := format.Sort[i](value)
+		if err != nil {
+			return nil, err
+		}
+		rv.Sort = append(rv.Sort, decoded)
+	}
+	for _, feature := range dm.Features {
+		rv.Features = append(rv.Features, JSONFloat(feature))
+	}
+	if dm.reader != nil {
+		var err error
+		visitErr := dm.VisitStoredFields(func(field string, value []byte) bool {
+			if field == format.IDField {
+				rv.ID = string(value)
+			}
+			decode, ok := format.Fields[field]
+			if !ok {
+				return true
+			}
+			var decoded interface{}
+			decoded, err = decode(value)
+			if err != nil {
+				return false
+			}
+			if rv.Fields == nil {
+				rv.Fields = make(map[string][]interface{}, len(format.Fields))
+			}
+			rv.Fields[field] = append(rv.Fields[field], decoded)
+			return true
+		})
+		if visitErr != nil {
+			return nil, visitErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// inner hits have a sort order of their own
+	innerFormat := *format
+	innerFormat.Sort = nil
+	for _, inner := range dm.InnerHits {
+		hit, err := NewHitJSON(inner, &innerFormat)
+		if err != nil {
+			return nil, err
+		}
+		rv.InnerHits = append(rv.InnerHits, hit)
+	}
+	return rv, nil
+}
@@ -0,0 +1,52 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ranking evaluates models trained offline to rank
+// matches, over vectors of features of each match
+package ranking
+
+import (
+	"math"
+)
+
+// Model scores a match from the values of its features,
+// missing values are NaN
+type Model interface {
+	Score(features []float64) float64
+}
+
+// LinearModel scores matches by the weighted sum of their features
+type LinearModel struct {
+	intercept float64
+	weights   []float64
+}
+
+// NewLinearModel weights each feature, in the order of the
+// feature vector, missing values count as 0
+func NewLinearModel(intercept float64, weights ...float64) *LinearModel {
+	return &LinearModel{
+		intercept: intercept,
+		weights:   weights,
+	}
+}
+
+func (m *LinearModel) Score(features []float64) float64 {
+	rv := m.intercept
+	for i, weight := range m.weights {
+		if i < len(features) && !math.IsNaN(features[i]) {
+			rv += weight * features[i]
+		}
+	}
+	return rv
+}
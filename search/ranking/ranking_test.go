@@ -0,0 +1,184 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ranking
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLinearModel(t *testing.T) {
+	model := NewLinearModel(0.5, 2, -1, 3)
+	tests := []struct {
+		features []float64
+		expected float64
+	}{
+		{
+			features: []float64{1, 2, 3},
+			expected: 0.5 + 2 - 2 + 9,
+		},
+		{
+			features: []float64{1, math.NaN(), 3},
+			expected: 0.5 + 2 + 9,
+		},
+		{
+			features: []float64{1},
+			expected: 2.5,
+		},
+	}
+	for _, test := range tests {
+		if got := model.Score(test.features); got != test.expected {
+			t.Errorf("expected %f for %v, got %f", test.expected, test.features, got)
+		}
+	}
+}
+
+const testXGBoostModel = `[
+  { "nodeid": 0, "depth": 0, "split": "title", "split_condition": 1.5, "yes": 1, "no": 2, "missing": 2, "children": [
+    { "nodeid": 1, "leaf": 0.25 },
+    { "nodeid": 2, "depth": 1, "split": "f1", "split_condition": 10, "yes": 3, "no": 4, "missing": 3, "children": [
+      { "nodeid": 4, "leaf": 1.5 },
+      { "nodeid": 3, "leaf": -0.5 }
+    ]}
+  ]},
+  { "nodeid": 0, "leaf": 0.1 }
+]`
+
+func TestXGBoostModel(t *testing.T) {
+	model, err := ParseXGBoostJSON([]byte(testXGBoostModel), []string{"title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.SetBaseScore(0.5)
+	tests := []struct {
+		features []float64
+		expected float64
+	}{
+		{
+			features: []float64{1, 20},
+			expected: 0.5 + 0.25 + 0.1,
+		},
+		{
+			features: []float64{2, 5},
+			expected: 0.5 - 0.5 + 0.1,
+		},
+		{
+			features: []float64{2, 10},
+			expected: 0.5 + 1.5 + 0.1,
+		},
+		{
+			// missing title goes to node 2, missing f1 to node 3
+			features: []float64{math.NaN(), math.NaN()},
+			expected: 0.5 - 0.5 + 0.1,
+		},
+		{
+			features: []float64{2},
+			expected: 0.5 - 0.5 + 0.1,
+		},
+	}
+	for _, test := range tests {
+		if got := model.Score(test.features); math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("expected %f for %v, got %f", test.expected, test.features, got)
+		}
+	}
+
+	_, err = ParseXGBoostJSON([]byte(`[{"nodeid": 0, "split": "unknown", "yes": 1, "no": 2}]`), nil)
+	if err == nil {
+		t.Errorf("expected error for unknown feature")
+	}
+}
+
+const testLightGBMModel = `{
+  "name": "tree",
+  "feature_names": ["age", "title", "genre"],
+  "tree_info": [
+    { "tree_index": 0, "tree_structure": {
+      "split_index": 0, "split_feature": 1, "threshold": 1.5, "decision_type": "<=",
+      "default_left": false, "missing_type": "NaN",
+      "left_child": { "leaf_index": 0, "leaf_value": 1 },
+      "right_child": {
+        "split_index": 1, "split_feature": 0, "threshold": 30, "decision_type": "<=",
+        "default_left": true, "missing_type": "Zero",
+        "left_child": { "leaf_index": 1, "leaf_value": 2 },
+        "right_child": { "leaf_index": 2, "leaf_value": 3 }
+      }
+    }},
+    { "tree_index": 1, "tree_structure": {
+      "split_index": 0, "split_feature": 2, "threshold": "1||3", "decision_type": "==",
+      "default_left": true, "missing_type": "None",
+      "left_child": { "leaf_index": 0, "leaf_value": 10 },
+      "right_child": {
+        "split_index": 1, "split_feature": 0, "threshold": -1, "decision_type": "<=",
+        "default_left": true, "missing_type": "None",
+        "left_child": { "leaf_index": 1, "leaf_value": 20 },
+        "right_child": { "leaf_index": 2, "leaf_value": 30 }
+      }
+    }}
+  ]
+}`
+
+func TestLightGBMModel(t *testing.T) {
+	// the features are in a different order than in the model
+	model, err := ParseLightGBMJSON([]byte(testLightGBMModel), []string{"title", "age", "genre"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nan := math.NaN()
+	tests := []struct {
+		features []float64
+		expected float64
+	}{
+		{
+			// title <= 1.5, genre 3 listed
+			features: []float64{1.5, 50, 3},
+			expected: 1 + 10,
+		},
+		{
+			// missing title goes right, age <= 30, genre 2 not listed
+			features: []float64{nan, 30, 2},
+			expected: 2 + 30,
+		},
+		{
+			// zero age is missing and goes left, missing genre goes
+			// right, and age 0 is greater than -1
+			features: []float64{5, 0, nan},
+			expected: 2 + 30,
+		},
+		{
+			// missing age is treated as 0, which for type Zero is
+			// missing, and goes left
+			features: []float64{5, nan, 1},
+			expected: 2 + 10,
+		},
+		{
+			features: []float64{5, 31, 1.5},
+			expected: 3 + 30,
+		},
+		{
+			features: []float64{5, -2, 4},
+			expected: 2 + 20,
+		},
+	}
+	for _, test := range tests {
+		if got := model.Score(test.features); got != test.expected {
+			t.Errorf("expected %f for %v, got %f", test.expected, test.features, got)
+		}
+	}
+
+	_, err = ParseLightGBMJSON([]byte(`{"tree_info": [{"tree_structure": {"decision_type": ">"}}]}`), nil)
+	if err == nil {
+		t.Errorf("expected error for unknown decision type")
+	}
+}
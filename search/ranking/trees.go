@@ -0,0 +1,319 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ranking
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TreeEnsemble scores matches by the sum of the leaves
+// of its decision trees, such as gradient boosted trees
+type TreeEnsemble struct {
+	base  float64
+	trees []*tree
+}
+
+// SetBaseScore sets the score added to the sum of the leaves,
+// such as the base_score XGBoost models were trained with,
+// which the dumps of their trees do not include
+func (e *TreeEnsemble) SetBaseScore(base float64) *TreeEnsemble {
+	e.base = base
+	return e
+}
+
+func (e *TreeEnsemble) Score(features []float64) float64 {
+	rv := e.base
+	for _, t := range e.trees {
+		rv += t.leaf(features)
+	}
+	return rv
+}
+
+// tree holds the nodes of a decision tree, the root first
+type tree struct {
+	nodes []*treeNode
+}
+
+type treeNode struct {
+	leaf  bool
+	value float64
+
+	feature   int
+	threshold float64
+	// inclusive splits go left when the value equals the threshold
+	inclusive bool
+	// categorical splits go left for the categories listed
+	categories map[int]struct{}
+	// missing values are replaced by 0, rather than going to missing
+	nanIsZero bool
+	// zero values go to missing
+	zeroIsMissing bool
+
+	left, right, missing int
+}
+
+// zeroThreshold is how close to 0 LightGBM considers values zero
+const zeroThreshold = 1e-35
+
+func (t *tree) leaf(features []float64) float64 {
+	node := t.nodes[0]
+	for !node.leaf {
+		value := math.NaN()
+		if node.feature < len(features) {
+			value = features[node.feature]
+		}
+		if math.IsNaN(value) && node.nanIsZero {
+			value = 0
+		}
+		next := node.right
+		switch {
+		case math.IsNaN(value) || node.zeroIsMissing && math.Abs(value) <= zeroThreshold:
+			next = node.missing
+		case node.categories != nil:
+			if _, ok := node.categories[int(value)]; ok && value >= 0 && value == math.Trunc(value) {
+				next = node.left
+			}
+		case value < node.threshold || node.inclusive && value == node.threshold:
+			next = node.left
+		}
+		node = t.nodes[next]
+	}
+	return node.value
+}
+
+// featureIndex finds the position of the feature in the feature
+// vector, by name, or for unnamed features, such as "f2" or
+// "Column_2", by number
+func featureIndex(name string, featureNames []string) (int, error) {
+	for i, featureName := range featureNames {
+		if featureName == name {
+			return i, nil
+		}
+	}
+	for _, prefix := range []string{"f", "Column_"} {
+		if strings.HasPrefix(name, prefix) {
+			if i, err := strconv.Atoi(name[len(prefix):]); err == nil && i >= 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown feature %s", name)
+}
+
+type xgboostNode struct {
+	NodeID         int            `json:"nodeid"`
+	Leaf           *float64       `json:"leaf"`
+	Split          string         `json:"split"`
+	SplitCondition float64        `json:"split_condition"`
+	Yes            int            `json:"yes"`
+	No             int            `json:"no"`
+	Missing        *int           `json:"missing"`
+	Children       []*xgboostNode `json:"children"`
+}
+
+// ParseXGBoostJSON parses the trees of an XGBoost model dumped in
+// the JSON format, splits on features named in featureNames use
+// their position in it, other features must be named "f0", "f1"...
+func ParseXGBoostJSON(data []byte, featureNames []string) (*TreeEnsemble, error) {
+	var trees []*xgboostNode
+	if err := json.Unmarshal(data, &trees); err != nil {
+		return nil, fmt.Errorf("error parsing XGBoost model: %w", err)
+	}
+	rv := &TreeEnsemble{}
+	for i, root := range trees {
+		t, err := xgboostTree(root, featureNames)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing XGBoost tree %d: %w", i, err)
+		}
+		rv.trees = append(rv.trees, t)
+	}
+	return rv, nil
+}
+
+func xgboostTree(root *xgboostNode, featureNames []string) (*tree, error) {
+	if root == nil {
+		return nil, fmt.Errorf("empty tree")
+	}
+	// the nodes are numbered, but may be listed in any order
+	positions := make(map[int]int)
+	var listed []*xgboostNode
+	queue := []*xgboostNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == nil {
+			return nil, fmt.Errorf("empty node")
+		}
+		if _, ok := positions[node.NodeID]; ok {
+			return nil, fmt.Errorf("duplicate node %d", node.NodeID)
+		}
+		positions[node.NodeID] = len(listed)
+		listed = append(listed, node)
+		queue = append(queue, node.Children...)
+	}
+
+	rv := &tree{
+		nodes: make([]*treeNode, len(listed)),
+	}
+	for i, node := range listed {
+		if node.Leaf != nil {
+			rv.nodes[i] = &treeNode{
+				leaf:  true,
+				value: *node.Leaf,
+			}
+			continue
+		}
+		feature, err := featureIndex(node.Split, featureNames)
+		if err != nil {
+			return nil, err
+		}
+		missing := node.Yes
+		if node.Missing != nil {
+			missing = *node.Missing
+		}
+		left, okLeft := positions[node.Yes]
+		right, okRight := positions[node.No]
+		missingPosition, okMissing := positions[missing]
+		if !okLeft || !okRight || !okMissing {
+			return nil, fmt.Errorf("node %d has missing children", node.NodeID)
+		}
+		rv.nodes[i] = &treeNode{
+			feature:   feature,
+			threshold: node.SplitCondition,
+			left:      left,
+			right:     right,
+			missing:   missingPosition,
+		}
+	}
+	return rv, nil
+}
+
+type lightGBMModel struct {
+	FeatureNames []string `json:"feature_names"`
+	TreeInfo     []struct {
+		TreeStructure *lightGBMNode `json:"tree_structure"`
+	} `json:"tree_info"`
+}
+
+type lightGBMNode struct {
+	LeafValue    *float64        `json:"leaf_value"`
+	SplitFeature int             `json:"split_feature"`
+	Threshold    json.RawMessage `json:"threshold"`
+	DecisionType string          `json:"decision_type"`
+	DefaultLeft  bool            `json:"default_left"`
+	MissingType  string          `json:"missing_type"`
+	LeftChild    *lightGBMNode   `json:"left_child"`
+	RightChild   *lightGBMNode   `json:"right_child"`
+}
+
+// ParseLightGBMJSON parses the trees of a LightGBM model dumped in
+// the JSON format, splits on features named in featureNames use
+// their position in it, other features use their position in the
+// model, multiclass models are not supported
+func ParseLightGBMJSON(data []byte, featureNames []string) (*TreeEnsemble, error) {
+	var model lightGBMModel
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("error parsing LightGBM model: %w", err)
+	}
+	positions := make([]int, len(model.FeatureNames))
+	for i, name := range model.FeatureNames {
+		positions[i] = i
+		for j, featureName := range featureNames {
+			if featureName == name {
+				positions[i] = j
+			}
+		}
+	}
+
+	rv := &TreeEnsemble{}
+	for i, info := range model.TreeInfo {
+		t := &tree{}
+		if _, err := t.addLightGBMNode(info.TreeStructure, positions); err != nil {
+			return nil, fmt.Errorf("error parsing LightGBM tree %d: %w", i, err)
+		}
+		rv.trees = append(rv.trees, t)
+	}
+	return rv, nil
+}
+
+// addLightGBMNode adds the node, and then its children, returning its position
+func (t *tree) addLightGBMNode(node *lightGBMNode, positions []int) (int, error) {
+	if node == nil {
+		return 0, fmt.Errorf("empty node")
+	}
+	rv := len(t.nodes)
+	if node.LeafValue != nil {
+		t.nodes = append(t.nodes, &treeNode{
+			leaf:  true,
+			value: *node.LeafValue,
+		})
+		return rv, nil
+	}
+
+	feature := node.SplitFeature
+	if feature >= 0 && feature < len(positions) {
+		feature = positions[feature]
+	}
+	split := &treeNode{
+		feature:       feature,
+		inclusive:     true,
+		nanIsZero:     node.MissingType != "NaN",
+		zeroIsMissing: node.MissingType == "Zero",
+	}
+	t.nodes = append(t.nodes, split)
+	switch node.DecisionType {
+	case "<=", "":
+		if err := json.Unmarshal(node.Threshold, &split.threshold); err != nil {
+			return 0, fmt.Errorf("invalid threshold %s", node.Threshold)
+		}
+	case "==":
+		var categories string
+		if err := json.Unmarshal(node.Threshold, &categories); err != nil {
+			return 0, fmt.Errorf("invalid categories %s", node.Threshold)
+		}
+		// missing and negative categories are never listed
+		split.nanIsZero = false
+		split.zeroIsMissing = false
+		split.categories = make(map[int]struct{})
+		for _, category := range strings.Split(categories, "||") {
+			c, err := strconv.Atoi(category)
+			if err != nil {
+				return 0, fmt.Errorf("invalid category %s", category)
+			}
+			split.categories[c] = struct{}{}
+		}
+	default:
+		return 0, fmt.Errorf("unknown decision type %s", node.DecisionType)
+	}
+
+	var err error
+	split.left, err = t.addLightGBMNode(node.LeftChild, positions)
+	if err != nil {
+		return 0, err
+	}
+	split.right, err = t.addLightGBMNode(node.RightChild, positions)
+	if err != nil {
+		return 0, err
+	}
+	split.missing = split.right
+	if node.DefaultLeft && split.categories == nil {
+		split.missing = split.left
+	}
+	return rv, nil
+}
@@ -15,6 +15,7 @@
 package search
 
 import (
+	"context"
 	"fmt"
 	"sort"
 
@@ -24,9 +25,9 @@ import (
 )
 
 type Location struct {
-	Pos   int
-	Start int
-	End   int
+	Pos   int `json:"pos"`
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
 func (l *Location) Size() int {
@@ -96,6 +97,18 @@ type DocumentMatch struct {
 	// used to maintain natural index order
 	HitNumber int
 
+	// SourceIndex identifies the reader which found the match,
+	// when searching several readers, see bluge.MultiSearch
+	SourceIndex int
+
+	// InnerHits holds the top matches of the group this match
+	// represents, when matches are collapsed into groups
+	InnerHits []*DocumentMatch
+
+	// Features holds the values of the features of the match,
+	// when they are logged, missing values are NaN
+	Features []float64
+
 	// used to temporarily hold field term location information during
 	// search processing in an efficient, recycle-friendly manner, to
 	// be later incorporated into the Locations map when search
@@ -107,6 +120,11 @@ func (dm *DocumentMatch) SetReader(r MatchReader) {
 	dm.reader = r
 }
 
+// Reader returns the reader in which the match was found
+func (dm *DocumentMatch) Reader() MatchReader {
+	return dm.reader
+}
+
 func (dm *DocumentMatch) addDocValue(name string, value []byte) {
 	if dm.docValues == nil {
 		dm.docValues = make(map[string][][]byte)
@@ -134,6 +152,36 @@ func (dm *DocumentMatch) VisitStoredFields(visitor segment.StoredFieldVisitor) e
 	return dm.reader.VisitStoredFields(dm.Number, visitor)
 }
 
+// Copy returns a copy of the match, which remains valid
+// after the match is returned to its DocumentMatchPool
+func (dm *DocumentMatch) Copy() *DocumentMatch {
+	rv := &DocumentMatch{
+		reader:      dm.reader,
+		Number:      dm.Number,
+		Score:       dm.Score,
+		Explanation: dm.Explanation,
+		Locations:   dm.Locations,
+		HitNumber:   dm.HitNumber,
+		SourceIndex: dm.SourceIndex,
+		InnerHits:   dm.InnerHits,
+	}
+	if dm.Features != nil {
+		rv.Features = append([]float64(nil), dm.Features...)
+	}
+	for _, sortVal := range dm.SortValue {
+		rv.SortValue = append(rv.SortValue, append([]byte(nil), sortVal...))
+	}
+	for name, values := range dm.docValues {
+		for _, value := range values {
+			rv.addDocValue(name, append([]byte(nil), value...))
+		}
+	}
+	if len(dm.FieldTermLocations) > 0 {
+		rv.FieldTermLocations = append([]FieldTermLocation(nil), dm.FieldTermLocations...)
+	}
+	return rv
+}
+
 // Reset allows an already allocated DocumentMatch to be reused
 func (dm *DocumentMatch) Reset() *DocumentMatch {
 	// remember the [][]byte used for sort
@@ -268,6 +316,11 @@ type SearcherOptions struct {
 	Explain            bool
 	IncludeTermVectors bool
 	Score              string
+
+	// Context of the search, used to stop expensive
+	// searcher construction once the search is cancelled,
+	// may be nil
+	Context context.Context
 }
 
 // Context represents the context around a single search
@@ -356,6 +409,37 @@ type Reader interface {
 	Close() error
 }
 
+// DocumentFrequencyReader is implemented by Readers over part of
+// an index, which score matches using the document frequencies
+// of the whole index rather than those of the part
+type DocumentFrequencyReader interface {
+	DocumentFrequency(term []byte, field string) (uint64, error)
+}
+
+// Neighbor is a document found by a nearest neighbor search, and
+// the similarity of its vector to the vector searched for
+type Neighbor struct {
+	Number uint64
+	Score  float64
+}
+
+// TotalTermFrequencyReader is implemented by Readers which count the
+// occurrences of terms, those over part of an index count them in the
+// whole index.  Counting reads every posting of the term, costing as
+// much as searching for it, so it is only done for the Similarities
+// which use it, once per term of a query.
+type TotalTermFrequencyReader interface {
+	TotalTermFrequency(term []byte, field string) (uint64, error)
+}
+
+// TotalTermFrequencyStats is implemented by the TermStats passed to
+// Similarities, which count the occurrences of the term in the whole
+// collection, when they are first asked, as counting them reads
+// every posting of the term
+type TotalTermFrequencyStats interface {
+	TotalTermFrequency() uint64
+}
+
 type Similarity interface {
 	ComputeNorm(numTerms int) float32
 	Scorer(boost float64, collectionStats segment.CollectionStats, termStats segment.TermStats) Scorer
@@ -366,6 +450,28 @@ type Scorer interface {
 	Explain(freq int, norm float64) *Explanation
 }
 
+// DocumentRange is a range of document numbers,
+// from Start inclusive to End exclusive
+type DocumentRange struct {
+	Start uint64
+	End   uint64
+}
+
+// MaxScorer is implemented by Scorers and Searchers which can
+// compute an upper bound of the scores they produce
+type MaxScorer interface {
+	MaxScore() float64
+}
+
+// CompetitiveScoreSearcher is implemented by Searchers which can
+// skip over matches which could never be collected
+type CompetitiveScoreSearcher interface {
+	// SetMinCompetitiveScore informs the searcher that matches scoring
+	// less than score will not be collected, so they may be skipped.
+	// It returns false if the searcher is unable to skip matches.
+	SetMinCompetitiveScore(score float64) bool
+}
+
 type CompositeScorer interface {
 	ScoreComposite(constituents []*DocumentMatch) float64
 	ExplainComposite(constituents []*DocumentMatch) *Explanation
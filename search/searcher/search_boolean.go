@@ -16,6 +16,7 @@ package searcher
 
 import (
 	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/similarity"
 )
 
 type BooleanSearcher struct {
@@ -354,6 +355,24 @@ func (s *BooleanSearcher) advanceIfTrailing(ctx *search.Context, number uint64)
 	return nil
 }
 
+// SetMinCompetitiveScore is passed on to the should searcher when
+// there is no must searcher, as the score is then determined by the
+// should searcher alone
+func (s *BooleanSearcher) SetMinCompetitiveScore(score float64) bool {
+	if s.mustSearcher != nil || s.shouldSearcher == nil {
+		return false
+	}
+	sumScorer, ok := s.scorer.(*similarity.CompositeSumScorer)
+	if !ok || sumScorer.Boost() <= 0 {
+		return false
+	}
+	cs, ok := s.shouldSearcher.(search.CompetitiveScoreSearcher)
+	if !ok {
+		return false
+	}
+	return cs.SetMinCompetitiveScore(score / sumScorer.Boost())
+}
+
 func (s *BooleanSearcher) Count() uint64 {
 	// for now return a worst case
 	var sum uint64
@@ -60,6 +60,11 @@ func newDisjunctionSearcher(indexReader search.Reader,
 		}
 	}
 
+	if maxScores := wandEligible(qsearchers, min, scorer, options); maxScores != nil {
+		return newDisjunctionWANDSearcher(qsearchers, maxScores, min,
+			scorer.(*similarity.CompositeSumScorer), options, limit)
+	}
+
 	if len(qsearchers) > DisjunctionHeapTakeover {
 		return newDisjunctionHeapSearcher(qsearchers, min, scorer, options,
 			limit)
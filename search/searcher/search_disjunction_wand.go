@@ -0,0 +1,321 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package searcher
+
+import (
+	"math"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/similarity"
+)
+
+// wandScoreSlack inflates the score upper bounds slightly, so that
+// floating point rounding never causes a competitive match to be skipped
+const wandScoreSlack = 1e-9
+
+type wandCursor struct {
+	searcher search.Searcher
+	curr     *search.DocumentMatch
+	maxScore float64
+}
+
+// DisjunctionWANDSearcher is a disjunction searcher which uses the
+// WAND algorithm to skip over documents which cannot score highly
+// enough to be collected, once the collector sets a minimum competitive
+// score.  Every constituent must have a bounded score, and the
+// constituent scores must be summed.  Until a minimum competitive
+// score is set it matches the same documents as the other
+// disjunction searchers.
+type DisjunctionWANDSearcher struct {
+	searchers   []search.Searcher
+	scorer      *similarity.CompositeSumScorer
+	min         int
+	maxScore    float64
+	initialized bool
+
+	// cursors are ordered by the number of their current match,
+	// exhausted cursors are removed
+	cursors  []*wandCursor
+	matching []*search.DocumentMatch
+
+	minCompetitiveScore float64
+	options             search.SearcherOptions
+}
+
+// wandEligible returns the upper bound of the score of each searcher,
+// or nil if the WAND algorithm cannot be used with these searchers
+func wandEligible(searchers []search.Searcher, min int, scorer search.CompositeScorer,
+	options search.SearcherOptions) []float64 {
+	sumScorer, ok := scorer.(*similarity.CompositeSumScorer)
+	if !ok || sumScorer.Boost() <= 0 || min > 1 || len(searchers) < 2 ||
+		options.Score == optionScoringNone {
+		return nil
+	}
+	rv := make([]float64, len(searchers))
+	for i, searcher := range searchers {
+		ms, ok := searcher.(search.MaxScorer)
+		if !ok {
+			return nil
+		}
+		rv[i] = ms.MaxScore()
+		if math.IsInf(rv[i], 0) || math.IsNaN(rv[i]) || rv[i] < 0 {
+			return nil
+		}
+	}
+	return rv
+}
+
+func newDisjunctionWANDSearcher(searchers []search.Searcher, maxScores []float64, min int,
+	scorer *similarity.CompositeSumScorer, options search.SearcherOptions,
+	limit bool) (*DisjunctionWANDSearcher, error) {
+	if limit && tooManyClauses(len(searchers)) {
+		return nil, tooManyClausesErr("", len(searchers))
+	}
+
+	rv := &DisjunctionWANDSearcher{
+		searchers: searchers,
+		scorer:    scorer,
+		min:       min,
+		cursors:   make([]*wandCursor, 0, len(searchers)),
+		matching:  make([]*search.DocumentMatch, 0, len(searchers)),
+		options:   options,
+	}
+	for _, maxScore := range maxScores {
+		rv.maxScore += maxScore
+	}
+	rv.maxScore *= scorer.Boost()
+	// cursors are allocated as a single block
+	block := make([]wandCursor, len(searchers))
+	for i, searcher := range searchers {
+		block[i].searcher = searcher
+		block[i].maxScore = maxScores[i] * scorer.Boost() * (1 + wandScoreSlack)
+	}
+	for i := range block {
+		rv.cursors = append(rv.cursors, &block[i])
+	}
+	return rv, nil
+}
+
+func (s *DisjunctionWANDSearcher) Size() int {
+	sizeInBytes := reflectStaticSizeDisjunctionWANDSearcher + sizeOfPtr
+
+	for _, entry := range s.searchers {
+		sizeInBytes += entry.Size()
+	}
+
+	sizeInBytes += len(s.cursors) * reflectStaticSizeWANDCursor
+
+	return sizeInBytes
+}
+
+// MaxScore returns an upper bound of the scores of the matches
+func (s *DisjunctionWANDSearcher) MaxScore() float64 {
+	return s.maxScore
+}
+
+func (s *DisjunctionWANDSearcher) SetMinCompetitiveScore(score float64) bool {
+	s.minCompetitiveScore = score
+	return true
+}
+
+func (s *DisjunctionWANDSearcher) initSearchers(ctx *search.Context) error {
+	for _, cursor := range s.cursors {
+		var err error
+		cursor.curr, err = cursor.searcher.Next(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	s.sortCursors()
+	s.initialized = true
+	return nil
+}
+
+// sortCursors removes exhausted cursors, and restores the order
+// of the remaining cursors, which are expected to be nearly sorted
+func (s *DisjunctionWANDSearcher) sortCursors() {
+	cursors := s.cursors[:0]
+	for _, cursor := range s.cursors {
+		if cursor.curr != nil {
+			cursors = append(cursors, cursor)
+		}
+	}
+	for i := 1; i < len(cursors); i++ {
+		for j := i; j > 0 && cursors[j].curr.Number < cursors[j-1].curr.Number; j-- {
+			cursors[j], cursors[j-1] = cursors[j-1], cursors[j]
+		}
+	}
+	s.cursors = cursors
+}
+
+// pivot returns the index of the first cursor at which the sum of the
+// upper bounds of the cursors up to and including it is competitive,
+// no document before the number of the pivot can be competitive.
+// It returns -1 if no remaining document can be competitive.
+func (s *DisjunctionWANDSearcher) pivot() int {
+	var sum float64
+	for i, cursor := range s.cursors {
+		sum += cursor.maxScore
+		if sum >= s.minCompetitiveScore {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *DisjunctionWANDSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	if !s.initialized {
+		err := s.initSearchers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for len(s.cursors) > 0 {
+		pivot := s.pivot()
+		if pivot < 0 {
+			s.exhaust(ctx)
+			return nil, nil
+		}
+
+		pivotNumber := s.cursors[pivot].curr.Number
+		if s.cursors[0].curr.Number == pivotNumber {
+			return s.nextMatch(ctx, pivotNumber)
+		}
+
+		// skip the cursors before the pivot ahead to it
+		err := s.advanceCursors(ctx, pivotNumber)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// nextMatch builds the match for all the cursors positioned on
+// number, and then moves those cursors to their next match
+func (s *DisjunctionWANDSearcher) nextMatch(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	s.matching = s.matching[:0]
+	var n int
+	for n < len(s.cursors) && s.cursors[n].curr.Number == number {
+		s.matching = append(s.matching, s.cursors[n].curr)
+		n++
+	}
+
+	rv := s.buildDocumentMatch(s.matching)
+
+	for _, cursor := range s.cursors[:n] {
+		if cursor.curr != rv {
+			ctx.DocumentMatchPool.Put(cursor.curr)
+		}
+		var err error
+		cursor.curr, err = cursor.searcher.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.sortCursors()
+
+	return rv, nil
+}
+
+// advanceCursors advances all cursors positioned before number
+func (s *DisjunctionWANDSearcher) advanceCursors(ctx *search.Context, number uint64) error {
+	for _, cursor := range s.cursors {
+		if cursor.curr.Number >= number {
+			break
+		}
+		ctx.DocumentMatchPool.Put(cursor.curr)
+		var err error
+		cursor.curr, err = cursor.searcher.Advance(ctx, number)
+		if err != nil {
+			return err
+		}
+	}
+	s.sortCursors()
+	return nil
+}
+
+// exhaust releases all cursors, once no remaining match can be competitive
+func (s *DisjunctionWANDSearcher) exhaust(ctx *search.Context) {
+	for _, cursor := range s.cursors {
+		ctx.DocumentMatchPool.Put(cursor.curr)
+		cursor.curr = nil
+	}
+	s.cursors = s.cursors[:0]
+}
+
+func (s *DisjunctionWANDSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	if !s.initialized {
+		err := s.initSearchers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.advanceCursors(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Next(ctx)
+}
+
+func (s *DisjunctionWANDSearcher) Count() uint64 {
+	// for now return a worst case
+	var sum uint64
+	for _, searcher := range s.searchers {
+		sum += searcher.Count()
+	}
+	return sum
+}
+
+func (s *DisjunctionWANDSearcher) Close() (rv error) {
+	for _, searcher := range s.searchers {
+		err := searcher.Close()
+		if err != nil && rv == nil {
+			rv = err
+		}
+	}
+	return rv
+}
+
+func (s *DisjunctionWANDSearcher) Min() int {
+	return s.min
+}
+
+func (s *DisjunctionWANDSearcher) DocumentMatchPoolSize() int {
+	rv := len(s.searchers)
+	for _, s := range s.searchers {
+		rv += s.DocumentMatchPoolSize()
+	}
+	return rv
+}
+
+func (s *DisjunctionWANDSearcher) buildDocumentMatch(constituents []*search.DocumentMatch) *search.DocumentMatch {
+	rv := constituents[0]
+	if s.options.Explain {
+		rv.Explanation = s.scorer.ExplainComposite(constituents)
+		rv.Score = rv.Explanation.Value
+	} else {
+		rv.Score = s.scorer.ScoreComposite(constituents)
+	}
+
+	rv.FieldTermLocations = search.MergeFieldTermLocations(
+		rv.FieldTermLocations, constituents[1:])
+
+	return rv
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package searcher
+
+import (
+	"testing"
+
+	"github.com/blugelabs/bluge/search"
+	"github.com/blugelabs/bluge/search/similarity"
+)
+
+func wandTestTermSearchers(t *testing.T, terms ...string) []search.Searcher {
+	rv := make([]search.Searcher, 0, len(terms))
+	for _, term := range terms {
+		ts, err := NewTermSearcher(baseTestIndexReader, term, "desc", 1.0, nil, testSearchOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv = append(rv, ts)
+	}
+	return rv
+}
+
+func collectAllMatches(t *testing.T, s search.Searcher) map[uint64]float64 {
+	ctx := &search.Context{
+		DocumentMatchPool: search.NewDocumentMatchPool(s.DocumentMatchPoolSize(), 0),
+	}
+	rv := make(map[uint64]float64)
+	next, err := s.Next(ctx)
+	for err == nil && next != nil {
+		rv[next.Number] = next.Score
+		ctx.DocumentMatchPool.Put(next)
+		next, err = s.Next(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rv
+}
+
+func TestDisjunctionWANDSearch(t *testing.T) {
+	terms := []string{"beer", "couch", "apple", "water"}
+
+	wandSearcher, err := NewDisjunctionSearcher(baseTestIndexReader, wandTestTermSearchers(t, terms...),
+		0, similarity.NewCompositeSumScorer(), testSearchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := wandSearcher.(*DisjunctionWANDSearcher); !ok {
+		t.Fatalf("expected disjunction of term searchers to use WAND, got %T", wandSearcher)
+	}
+	heapSearcher, err := newDisjunctionHeapSearcher(wandTestTermSearchers(t, terms...),
+		0, similarity.NewCompositeSumScorer(), testSearchOptions, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a minimum competitive score, WAND matches everything
+	expected := collectAllMatches(t, heapSearcher)
+	actual := collectAllMatches(t, wandSearcher)
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d matches, got %d", len(expected), len(actual))
+	}
+	var threshold float64
+	for number, score := range expected {
+		if !scoresCloseEnough(score, actual[number]) {
+			t.Errorf("expected doc %d to score %f, got %f", number, score, actual[number])
+		}
+		if score > threshold && score < wandSearcher.(*DisjunctionWANDSearcher).MaxScore() {
+			threshold = score
+		}
+	}
+
+	// with a minimum competitive score, every competitive match is
+	// still returned with the same score
+	threshold /= 2
+	wandSearcher, err = NewDisjunctionSearcher(baseTestIndexReader, wandTestTermSearchers(t, terms...),
+		0, similarity.NewCompositeSumScorer(), testSearchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wandSearcher.(search.CompetitiveScoreSearcher).SetMinCompetitiveScore(threshold) {
+		t.Fatalf("expected WAND searcher to accept minimum competitive score")
+	}
+	actual = collectAllMatches(t, wandSearcher)
+	for number, score := range expected {
+		if score >= threshold {
+			if _, ok := actual[number]; !ok {
+				t.Errorf("expected competitive doc %d to match", number)
+			}
+		}
+	}
+	for number, score := range actual {
+		if !scoresCloseEnough(score, expected[number]) {
+			t.Errorf("expected doc %d to score %f, got %f", number, expected[number], score)
+		}
+	}
+
+	// a threshold above the maximum possible score matches nothing
+	wandSearcher, err = NewDisjunctionSearcher(baseTestIndexReader, wandTestTermSearchers(t, terms...),
+		0, similarity.NewCompositeSumScorer(), testSearchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wandSearcher.(search.CompetitiveScoreSearcher).SetMinCompetitiveScore(
+		wandSearcher.(*DisjunctionWANDSearcher).MaxScore() * 2)
+	actual = collectAllMatches(t, wandSearcher)
+	if len(actual) != 0 {
+		t.Errorf("expected no matches above maximum score, got %d", len(actual))
+	}
+}
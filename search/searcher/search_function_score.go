@@ -0,0 +1,119 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package searcher
+
+import (
+	"math"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// FunctionScoreSearcher wraps any other searcher, replacing the score
+// of each match with the value computed by a NumericValueSource
+type FunctionScoreSearcher struct {
+	indexReader search.Reader
+	child       search.Searcher
+	source      search.NumericValueSource
+	boost       float64
+	fields      []string
+	options     search.SearcherOptions
+
+	// doc values needed by the source are loaded into a scratch
+	// match, using a private context, so that they do not interfere
+	// with the doc values later loaded by the collector
+	valuesContext *search.Context
+	scratch       search.DocumentMatch
+}
+
+func NewFunctionScoreSearcher(indexReader search.Reader, s search.Searcher, source search.NumericValueSource,
+	boost float64, options search.SearcherOptions) *FunctionScoreSearcher {
+	return &FunctionScoreSearcher{
+		indexReader:   indexReader,
+		child:         s,
+		source:        source,
+		boost:         boost,
+		fields:        source.Fields(),
+		options:       options,
+		valuesContext: search.NewSearchContext(0, 0),
+	}
+}
+
+func (s *FunctionScoreSearcher) Size() int {
+	return reflectStaticSizeFunctionScoreSearcher + sizeOfPtr +
+		s.child.Size()
+}
+
+func (s *FunctionScoreSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	next, err := s.child.Next(ctx)
+	if err != nil || next == nil {
+		return nil, err
+	}
+	return next, s.score(next)
+}
+
+func (s *FunctionScoreSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	adv, err := s.child.Advance(ctx, number)
+	if err != nil || adv == nil {
+		return nil, err
+	}
+	return adv, s.score(adv)
+}
+
+func (s *FunctionScoreSearcher) score(d *search.DocumentMatch) error {
+	s.scratch.Reset()
+	s.scratch.SetReader(s.indexReader)
+	s.scratch.Number = d.Number
+	s.scratch.Score = d.Score
+	if len(s.fields) > 0 {
+		err := s.scratch.LoadDocumentValues(s.valuesContext, s.fields)
+		if err != nil {
+			return err
+		}
+	}
+
+	score := s.source.Number(&s.scratch)
+	if math.IsNaN(score) {
+		// missing values do not contribute to the score
+		score = 0
+	}
+	score *= s.boost
+
+	if s.options.Explain {
+		var children []*search.Explanation
+		if d.Explanation != nil {
+			children = append(children, d.Explanation)
+		}
+		d.Explanation = search.NewExplanation(score,
+			"function score, computed from:", children...)
+	}
+	d.Score = score
+	return nil
+}
+
+func (s *FunctionScoreSearcher) Close() error {
+	return s.child.Close()
+}
+
+func (s *FunctionScoreSearcher) Count() uint64 {
+	return s.child.Count()
+}
+
+func (s *FunctionScoreSearcher) Min() int {
+	return s.child.Min()
+}
+
+func (s *FunctionScoreSearcher) DocumentMatchPoolSize() int {
+	return s.child.DocumentMatchPoolSize()
+}
@@ -63,7 +63,7 @@ func NewFuzzySearcher(indexReader search.Reader, term string,
 		}
 	}
 	candidateTerms, termBoosts, err := findFuzzyCandidateTerms(indexReader, term, fuzziness,
-		field, prefixTerm)
+		field, prefixTerm, options)
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +73,7 @@ func NewFuzzySearcher(indexReader search.Reader, term string,
 }
 
 func findFuzzyCandidateTerms(indexReader search.Reader, term string,
-	fuzziness int, field, prefixTerm string) (terms []string, boosts []float64, err error) {
+	fuzziness int, field, prefixTerm string, options search.SearcherOptions) (terms []string, boosts []float64, err error) {
 	automatons, err := getLevAutomatons(term, fuzziness)
 	if err != nil {
 		return nil, nil, err
@@ -103,6 +103,11 @@ func findFuzzyCandidateTerms(indexReader search.Reader, term string,
 		if tooManyClauses(len(terms)) {
 			return nil, nil, tooManyClausesErr(field, len(terms))
 		}
+		if len(terms)%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				return nil, nil, err
+			}
+		}
 		// compute actual edit distance for this term
 		boost := 1.0
 		if tfd.Term() != term {
@@ -15,6 +15,7 @@
 package searcher
 
 import (
+	"context"
 	"testing"
 
 	"github.com/blugelabs/bluge/search/similarity"
@@ -145,3 +146,16 @@ func TestFuzzySearchLimitErrors(t *testing.T) {
 		t.Fatal("`invalid fuzziness, negative` error expected")
 	}
 }
+
+func TestFuzzySearchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	options := testSearchOptions
+	options.Context = ctx
+
+	_, err := NewFuzzySearcher(baseTestIndexReader, "beet", 0, 1, "desc",
+		1.0, nil, similarity.NewCompositeSumScorer(), options)
+	if err != context.Canceled {
+		t.Errorf("expected context canceled error, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package searcher
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// KNNSearcher matches the k documents with vectors in a field
+// nearest to a vector, scored by their similarity to it
+type KNNSearcher struct {
+	indexReader search.Reader
+	field       string
+	neighbors   []search.Neighbor
+	next        int
+	boost       float64
+	maxScore    float64
+	options     search.SearcherOptions
+}
+
+// NewKNNSearcher matches the neighbors found by a nearest
+// neighbor search of the vectors of the field, best first,
+// which are then ordered by document number in place
+func NewKNNSearcher(indexReader search.Reader, field string, neighbors []search.Neighbor,
+	boost float64, options search.SearcherOptions) (search.Searcher, error) {
+	rv := &KNNSearcher{
+		indexReader: indexReader,
+		field:       field,
+		neighbors:   neighbors,
+		boost:       boost,
+		options:     options,
+	}
+	if len(neighbors) > 0 {
+		// the neighbors are found best first
+		rv.maxScore = neighbors[0].Score * boost
+	}
+	sort.Slice(neighbors, func(i, j int) bool {
+		return neighbors[i].Number < neighbors[j].Number
+	})
+	return rv, nil
+}
+
+func (s *KNNSearcher) Size() int {
+	return reflectStaticSizeKNNSearcher + sizeOfPtr +
+		len(s.field) + len(s.neighbors)*reflectStaticSizeNeighbor
+}
+
+func (s *KNNSearcher) Count() uint64 {
+	return uint64(len(s.neighbors))
+}
+
+func (s *KNNSearcher) MaxScore() float64 {
+	return s.maxScore
+}
+
+func (s *KNNSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	if s.next >= len(s.neighbors) {
+		return nil, nil
+	}
+	rv := s.buildDocumentMatch(ctx, s.neighbors[s.next])
+	s.next++
+	return rv, nil
+}
+
+func (s *KNNSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	for s.next < len(s.neighbors) && s.neighbors[s.next].Number < number {
+		s.next++
+	}
+	return s.Next(ctx)
+}
+
+func (s *KNNSearcher) buildDocumentMatch(ctx *search.Context, neighbor search.Neighbor) *search.DocumentMatch {
+	rv := ctx.DocumentMatchPool.Get()
+	rv.SetReader(s.indexReader)
+	rv.Number = neighbor.Number
+	rv.Score = neighbor.Score * s.boost
+	if s.options.Explain {
+		rv.Explanation = search.NewExplanation(rv.Score,
+			fmt.Sprintf("product of boost %f and vector similarity in field %s", s.boost, s.field),
+			search.NewExplanation(neighbor.Score, "vector similarity"))
+	}
+	return rv
+}
+
+func (s *KNNSearcher) Close() error {
+	return nil
+}
+
+func (s *KNNSearcher) Min() int {
+	return 0
+}
+
+func (s *KNNSearcher) DocumentMatchPoolSize() int {
+	return 1
+}
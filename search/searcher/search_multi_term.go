@@ -20,6 +20,24 @@ import (
 	"github.com/blugelabs/bluge/search"
 )
 
+// CheckCancelledEvery controls how frequently term expansion
+// checks if the search has been cancelled
+const CheckCancelledEvery = 1024
+
+// checkCancelled returns the error of the search context,
+// if the search has been cancelled or its deadline has passed
+func checkCancelled(options search.SearcherOptions) error {
+	if options.Context == nil {
+		return nil
+	}
+	select {
+	case <-options.Context.Done():
+		return options.Context.Err()
+	default:
+		return nil
+	}
+}
+
 func NewMultiTermSearcher(indexReader search.Reader, terms []string,
 	field string, boost float64, scorer search.Scorer, compScorer search.CompositeScorer,
 	options search.SearcherOptions, limit bool) (
@@ -163,6 +181,12 @@ func makeBatchSearchers(indexReader search.Reader, terms []string, termBoosts []
 	}
 	for i, term := range terms {
 		var err error
+		if i%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				qsearchersClose()
+				return nil, err
+			}
+		}
 		if termBoosts != nil {
 			qsearchers[i], err = NewTermSearcher(indexReader, term, field, boost*termBoosts[i], scorer, options)
 		} else {
@@ -230,6 +254,12 @@ func makeBatchSearchersBytes(indexReader search.Reader, terms [][]byte, field st
 	}
 	for i, term := range terms {
 		var err error
+		if i%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				qsearchersClose()
+				return nil, err
+			}
+		}
 		qsearchers[i], err = NewTermSearcherBytes(indexReader, term, field, boost, scorer, options)
 		if err != nil {
 			qsearchersClose()
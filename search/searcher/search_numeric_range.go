@@ -58,7 +58,18 @@ func NewNumericRangeSearcher(indexReader search.Reader,
 		return nil, err
 	}
 
+	var lookups int
 	isIndexed = func(term []byte) bool {
+		if err != nil {
+			// search cancelled, skip remaining lookups
+			return false
+		}
+		lookups++
+		if lookups%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				return false
+			}
+		}
 		found, err2 := fieldDict.Contains(term)
 		return err2 == nil && found
 	}
@@ -67,11 +78,14 @@ func NewNumericRangeSearcher(indexReader search.Reader,
 	termRanges := splitInt64Range(minInt64, maxInt64, 4)
 	terms := termRanges.Enumerate(isIndexed)
 	if fieldDict != nil {
-		err = fieldDict.Close()
-		if err != nil {
-			return nil, err
+		cerr := fieldDict.Close()
+		if cerr != nil {
+			return nil, cerr
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	if len(terms) < 1 {
 		// cannot return MatchNoneSearcher because of interaction with
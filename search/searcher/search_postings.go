@@ -0,0 +1,91 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package searcher
+
+import (
+	"github.com/blugelabs/bluge/search"
+	segment "github.com/blugelabs/bluge_segment_api"
+)
+
+// PostingsSearcher matches the documents of an arbitrary
+// postings iterator, such as one served from the query cache
+type PostingsSearcher struct {
+	reader      segment.PostingsIterator
+	scorer      search.Scorer
+	indexReader search.Reader
+	options     search.SearcherOptions
+}
+
+func NewPostingsSearcher(indexReader search.Reader, postings segment.PostingsIterator, scorer search.Scorer,
+	options search.SearcherOptions) *PostingsSearcher {
+	return &PostingsSearcher{
+		indexReader: indexReader,
+		reader:      postings,
+		scorer:      scorer,
+		options:     options,
+	}
+}
+
+func (s *PostingsSearcher) Size() int {
+	return reflectStaticSizePostingsSearcher + sizeOfPtr +
+		s.reader.Size()
+}
+
+func (s *PostingsSearcher) Count() uint64 {
+	return s.reader.Count()
+}
+
+func (s *PostingsSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	posting, err := s.reader.Next()
+	if err != nil || posting == nil {
+		return nil, err
+	}
+	return s.buildDocumentMatch(ctx, posting), nil
+}
+
+func (s *PostingsSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	posting, err := s.reader.Advance(number)
+	if err != nil || posting == nil {
+		return nil, err
+	}
+	return s.buildDocumentMatch(ctx, posting), nil
+}
+
+func (s *PostingsSearcher) Close() error {
+	return s.reader.Close()
+}
+
+func (s *PostingsSearcher) Min() int {
+	return 0
+}
+
+func (s *PostingsSearcher) DocumentMatchPoolSize() int {
+	return 1
+}
+
+func (s *PostingsSearcher) buildDocumentMatch(ctx *search.Context, posting segment.Posting) *search.DocumentMatch {
+	rv := ctx.DocumentMatchPool.Get()
+	rv.SetReader(s.indexReader)
+	rv.Number = posting.Number()
+
+	if s.options.Explain {
+		rv.Explanation = s.scorer.Explain(posting.Frequency(), posting.Norm())
+		rv.Score = rv.Explanation.Value
+	} else {
+		rv.Score = s.scorer.Score(posting.Frequency(), posting.Norm())
+	}
+
+	return rv
+}
@@ -46,6 +46,11 @@ func NewRegexpStringSearcher(indexReader search.Reader, pattern, field string,
 	tfd, err := fieldDict.Next()
 	for err == nil && tfd != nil {
 		candidateTerms = append(candidateTerms, tfd.Term())
+		if len(candidateTerms)%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				return nil, err
+			}
+		}
 		tfd, err = fieldDict.Next()
 	}
 	if err != nil {
@@ -15,6 +15,8 @@
 package searcher
 
 import (
+	"math"
+
 	"github.com/blugelabs/bluge/search"
 	segment "github.com/blugelabs/bluge_segment_api"
 )
@@ -25,6 +27,9 @@ type TermSearcher struct {
 	options     search.SearcherOptions
 	scorer      search.Scorer
 	queryTerm   string
+
+	maxScore            float64
+	minCompetitiveScore float64
 }
 
 func NewTermSearcher(indexReader search.Reader, term, field string, boost float64, scorer search.Scorer,
@@ -44,12 +49,34 @@ func NewTermSearcherBytes(indexReader search.Reader, term []byte, field string,
 
 type termStatsWrapper struct {
 	docFreq uint64
+
+	indexReader   search.Reader
+	term          []byte
+	field         string
+	totalTermFreq uint64
+	counted       bool
+	err           error
 }
 
 func (t *termStatsWrapper) DocumentFrequency() uint64 {
 	return t.docFreq
 }
 
+// TotalTermFrequency counts the occurrences of the term, the first
+// time it is called, errors are reported once the scorer is built.
+// Readers which cannot count them are assumed to have each document
+// using the term use it once.
+func (t *termStatsWrapper) TotalTermFrequency() uint64 {
+	if !t.counted {
+		t.counted = true
+		t.totalTermFreq = t.docFreq
+		if ttfr, ok := t.indexReader.(search.TotalTermFrequencyReader); ok {
+			t.totalTermFreq, t.err = ttfr.TotalTermFrequency(t.term, t.field)
+		}
+	}
+	return t.totalTermFreq
+}
+
 func newTermSearcherFromReader(indexReader search.Reader, reader segment.PostingsIterator,
 	term []byte, field string, boost float64, scorer search.Scorer, options search.SearcherOptions) (*TermSearcher, error) {
 	if scorer == nil {
@@ -57,7 +84,27 @@ func newTermSearcherFromReader(indexReader search.Reader, reader segment.Posting
 		if err != nil {
 			return nil, err
 		}
-		scorer = options.SimilarityForField(field).Scorer(boost, collStats, &termStatsWrapper{docFreq: reader.Count()})
+		docFreq := reader.Count()
+		if dfr, ok := indexReader.(search.DocumentFrequencyReader); ok {
+			docFreq, err = dfr.DocumentFrequency(term, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		termStats := &termStatsWrapper{
+			docFreq:     docFreq,
+			indexReader: indexReader,
+			term:        term,
+			field:       field,
+		}
+		scorer = options.SimilarityForField(field).Scorer(boost, collStats, termStats)
+		if termStats.err != nil {
+			return nil, termStats.err
+		}
+	}
+	maxScore := math.Inf(1)
+	if ms, ok := scorer.(search.MaxScorer); ok {
+		maxScore = ms.MaxScore()
 	}
 	return &TermSearcher{
 		indexReader: indexReader,
@@ -65,6 +112,7 @@ func newTermSearcherFromReader(indexReader search.Reader, reader segment.Posting
 		scorer:      scorer,
 		options:     options,
 		queryTerm:   string(term),
+		maxScore:    maxScore,
 	}, nil
 }
 
@@ -76,7 +124,25 @@ func (s *TermSearcher) Count() uint64 {
 	return s.reader.Count()
 }
 
+// MaxScore returns an upper bound of the scores of the matches,
+// or +Inf if the scorer cannot compute one
+func (s *TermSearcher) MaxScore() float64 {
+	return s.maxScore
+}
+
+func (s *TermSearcher) SetMinCompetitiveScore(score float64) bool {
+	if math.IsInf(s.maxScore, 1) {
+		return false
+	}
+	s.minCompetitiveScore = score
+	return true
+}
+
 func (s *TermSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error) {
+	if s.minCompetitiveScore > s.maxScore {
+		// no remaining match can be competitive
+		return nil, nil
+	}
 	termMatch, err := s.reader.Next()
 	if err != nil {
 		return nil, err
@@ -94,6 +160,9 @@ func (s *TermSearcher) Next(ctx *search.Context) (*search.DocumentMatch, error)
 }
 
 func (s *TermSearcher) Advance(ctx *search.Context, number uint64) (*search.DocumentMatch, error) {
+	if s.minCompetitiveScore > s.maxScore {
+		return nil, nil
+	}
 	termMatch, err := s.reader.Advance(number)
 	if err != nil {
 		return nil, err
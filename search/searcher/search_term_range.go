@@ -45,6 +45,11 @@ func NewTermRangeSearcher(indexReader search.Reader,
 	tfd, err := fieldDict.Next()
 	for err == nil && tfd != nil {
 		terms = append(terms, tfd.Term())
+		if len(terms)%CheckCancelledEvery == 0 {
+			if err = checkCancelled(options); err != nil {
+				return nil, err
+			}
+		}
 		tfd, err = fieldDict.Next()
 	}
 	if err != nil {
@@ -16,6 +16,8 @@ package searcher
 
 import (
 	"reflect"
+
+	"github.com/blugelabs/bluge/search"
 )
 
 func init() {
@@ -40,6 +42,16 @@ func init() {
 	reflectStaticSizeDisjunctionSliceSearcher = int(reflect.TypeOf(ds).Size())
 	var fs FilteringSearcher
 	reflectStaticSizeFilteringSearcher = int(reflect.TypeOf(fs).Size())
+	var fss FunctionScoreSearcher
+	reflectStaticSizeFunctionScoreSearcher = int(reflect.TypeOf(fss).Size())
+	var css ConstantScoreSearcher
+	reflectStaticSizeConstantScoreSearcher = int(reflect.TypeOf(css).Size())
+	var dws DisjunctionWANDSearcher
+	reflectStaticSizeDisjunctionWANDSearcher = int(reflect.TypeOf(dws).Size())
+	var wc wandCursor
+	reflectStaticSizeWANDCursor = int(reflect.TypeOf(wc).Size())
+	var pss PostingsSearcher
+	reflectStaticSizePostingsSearcher = int(reflect.TypeOf(pss).Size())
 	var mas MatchAllSearcher
 	reflectStaticSizeMatchAllSearcher = int(reflect.TypeOf(mas).Size())
 	var mns MatchNoneSearcher
@@ -48,6 +60,10 @@ func init() {
 	reflectStaticSizePhraseSearcher = int(reflect.TypeOf(ps).Size())
 	var ts TermSearcher
 	reflectStaticSizeTermSearcher = int(reflect.TypeOf(ts).Size())
+	var knn KNNSearcher
+	reflectStaticSizeKNNSearcher = int(reflect.TypeOf(knn).Size())
+	var n search.Neighbor
+	reflectStaticSizeNeighbor = int(reflect.TypeOf(n).Size())
 }
 
 var sizeOfInt int
@@ -61,7 +77,14 @@ var reflectStaticSizeDisjunctionHeapSearcher int
 var reflectStaticSizeSearcherCurr int
 var reflectStaticSizeDisjunctionSliceSearcher int
 var reflectStaticSizeFilteringSearcher int
+var reflectStaticSizeFunctionScoreSearcher int
+var reflectStaticSizeConstantScoreSearcher int
+var reflectStaticSizePostingsSearcher int
+var reflectStaticSizeDisjunctionWANDSearcher int
+var reflectStaticSizeWANDCursor int
 var reflectStaticSizeMatchAllSearcher int
 var reflectStaticSizeMatchNoneSearcher int
 var reflectStaticSizePhraseSearcher int
 var reflectStaticSizeTermSearcher int
+var reflectStaticSizeKNNSearcher int
+var reflectStaticSizeNeighbor int
@@ -102,6 +102,12 @@ func (b *BM25Scorer) Score(freq int, norm float64) float64 {
 	return b.weight - b.weight/(1+float64(freq)*normInverse)
 }
 
+// MaxScore returns an upper bound of the scores, the term
+// frequency component of the score is always less than one
+func (b *BM25Scorer) MaxScore() float64 {
+	return math.Max(b.weight, 0)
+}
+
 func (b *BM25Scorer) explainTf(freq int, norm float64) *search.Explanation {
 	docLen := math.Float32bits(float32(norm))
 	normInverse := 1 / (b.k1 * ((1 - b.b) + b.b*float64(docLen)/b.avgDocLen))
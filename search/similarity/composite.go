@@ -34,6 +34,10 @@ func NewCompositeSumScorerWithBoost(boost float64) *CompositeSumScorer {
 	}
 }
 
+func (c *CompositeSumScorer) Boost() float64 {
+	return c.boost
+}
+
 func (c *CompositeSumScorer) ScoreComposite(constituents []*search.DocumentMatch) float64 {
 	var rv float64
 	for _, constituent := range constituents {
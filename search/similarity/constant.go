@@ -26,6 +26,10 @@ func (c ConstantScorer) Explain(_ int, _ float64) *search.Explanation {
 	return search.NewExplanation(float64(c), "constant")
 }
 
+func (c ConstantScorer) MaxScore() float64 {
+	return float64(c)
+}
+
 func (c ConstantScorer) ScoreComposite(_ []*search.DocumentMatch) float64 {
 	return float64(c)
 }
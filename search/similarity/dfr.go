@@ -0,0 +1,219 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"fmt"
+	"math"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// BasicModel is the model of the distribution of a term among the
+// documents, of the divergence from randomness similarity, the less
+// likely the normalized frequency of the term is under the model, the
+// more informative the term is
+type BasicModel int
+
+const (
+	// BasicModelG is the geometric approximation of Bose-Einstein
+	BasicModelG BasicModel = iota
+	// BasicModelIF is the inverse term frequency
+	BasicModelIF
+	// BasicModelIn is the inverse document frequency
+	BasicModelIn
+	// BasicModelIne is the inverse expected document frequency,
+	// the number of documents expected to use the term, given
+	// its number of occurrences
+	BasicModelIne
+)
+
+func (m BasicModel) String() string {
+	switch m {
+	case BasicModelG:
+		return "G"
+	case BasicModelIF:
+		return "I(F)"
+	case BasicModelIn:
+		return "I(n)"
+	case BasicModelIne:
+		return "I(ne)"
+	}
+	return "unknown"
+}
+
+func (m BasicModel) score(stats *basicStats, tfn float64) float64 {
+	n := stats.docCount
+	switch m {
+	case BasicModelG:
+		lambda := (stats.totalTermFreq + 1) / (n + stats.totalTermFreq + 1)
+		return math.Log2(lambda+1) + tfn*math.Log2((1+lambda)/lambda)
+	case BasicModelIF:
+		return tfn * math.Log2(1+(n+1)/(stats.totalTermFreq+0.5))
+	case BasicModelIn:
+		return tfn * math.Log2((n+1)/(stats.docFreq+0.5))
+	case BasicModelIne:
+		return tfn * math.Log2((n+1)/(stats.expectedDocFreq()+0.5))
+	}
+	return 0
+}
+
+// expectedDocFreq is the number of documents expected to
+// use the term, were its occurrences spread at random
+func (s *basicStats) expectedDocFreq() float64 {
+	if s.docCount <= 1 {
+		return s.docCount
+	}
+	return s.docCount * (1 - math.Pow((s.docCount-1)/s.docCount, s.totalTermFreq))
+}
+
+func (m BasicModel) explain(stats *basicStats, tfn float64) *search.Explanation {
+	score := m.score(stats, tfn)
+	tfnExplanation := search.NewExplanation(tfn, "tfn, normalized term frequency")
+	switch m {
+	case BasicModelG:
+		return search.NewExplanation(score,
+			"basic model G, computed as log2(lambda + 1) + tfn * log2((1 + lambda) / lambda), "+
+				"with lambda = (F + 1) / (N + F + 1) from:",
+			tfnExplanation, stats.explainTotalTermFreq(), stats.explainDocCount())
+	case BasicModelIF:
+		return search.NewExplanation(score,
+			"basic model I(F), computed as tfn * log2(1 + (N + 1) / (F + 0.5)) from:",
+			tfnExplanation, stats.explainTotalTermFreq(), stats.explainDocCount())
+	case BasicModelIn:
+		return search.NewExplanation(score,
+			"basic model I(n), computed as tfn * log2((N + 1) / (n + 0.5)) from:",
+			tfnExplanation, stats.explainDocFreq(), stats.explainDocCount())
+	}
+	return search.NewExplanation(score,
+		"basic model I(ne), computed as tfn * log2((N + 1) / (ne + 0.5)) from:",
+		tfnExplanation,
+		search.NewExplanation(stats.expectedDocFreq(),
+			"ne, expected number of documents containing term, computed as N * (1 - ((N - 1) / N) ^ F) from:",
+			stats.explainDocCount(), stats.explainTotalTermFreq()))
+}
+
+// AfterEffect normalizes the score of the basic model by the risk
+// of accepting the term as informative, terms occurring often in
+// a document are less informative for each further occurrence
+type AfterEffect int
+
+const (
+	// AfterEffectL is Laplace's law of succession, 1 / (tfn + 1)
+	AfterEffectL AfterEffect = iota
+	// AfterEffectB is the ratio of two Bernoulli
+	// processes, (F + 1) / ((n + 1) * (tfn + 1))
+	AfterEffectB
+	// NoAfterEffect does not normalize the score of the basic model
+	NoAfterEffect
+)
+
+func (a AfterEffect) String() string {
+	switch a {
+	case AfterEffectL:
+		return "L"
+	case AfterEffectB:
+		return "B"
+	}
+	return "none"
+}
+
+func (a AfterEffect) score(stats *basicStats, tfn float64) float64 {
+	switch a {
+	case AfterEffectL:
+		return 1 / (tfn + 1)
+	case AfterEffectB:
+		return (stats.totalTermFreq + 1) / ((stats.docFreq + 1) * (tfn + 1))
+	}
+	return 1
+}
+
+func (a AfterEffect) explain(stats *basicStats, tfn float64) *search.Explanation {
+	score := a.score(stats, tfn)
+	tfnExplanation := search.NewExplanation(tfn, "tfn, normalized term frequency")
+	switch a {
+	case AfterEffectL:
+		return search.NewExplanation(score, "after effect L, computed as 1 / (tfn + 1) from:",
+			tfnExplanation)
+	case AfterEffectB:
+		return search.NewExplanation(score, "after effect B, computed as (F + 1) / ((n + 1) * (tfn + 1)) from:",
+			tfnExplanation, stats.explainTotalTermFreq(), stats.explainDocFreq())
+	}
+	return search.NewExplanation(score, "no after effect")
+}
+
+// DFRSimilarity scores matches by the divergence from randomness
+// framework of Amati and Van Rijsbergen, the score of a match is the
+// product of the information content of the term under the basic
+// model and the after effect, over the normalized frequency of
+// the term.  It scores terms with their total number of
+// occurrences, see search.TotalTermFrequencyStats.  Without an
+// after effect the scores have no upper bound, so searches
+// cannot skip the matches which could not be competitive.
+type DFRSimilarity struct {
+	lengthNorm
+	basicModel    BasicModel
+	afterEffect   AfterEffect
+	normalization Normalization
+}
+
+// NewDFRSimilarity uses the basic model I(ne), after
+// effect B and normalization H2, known as InB2
+func NewDFRSimilarity() *DFRSimilarity {
+	return NewDFRSimilarityModel(BasicModelIne, AfterEffectB, NormalizationH2)
+}
+
+func NewDFRSimilarityModel(basicModel BasicModel, afterEffect AfterEffect,
+	normalization Normalization) *DFRSimilarity {
+	return &DFRSimilarity{
+		basicModel:    basicModel,
+		afterEffect:   afterEffect,
+		normalization: normalization,
+	}
+}
+
+func (d *DFRSimilarity) Scorer(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) search.Scorer {
+	return newModelScorer(d, boost, collectionStats, termStats)
+}
+
+func (d *DFRSimilarity) score(stats *basicStats, freq, docLen float64) float64 {
+	tfn := d.normalization.tfn(stats, freq, docLen)
+	return d.basicModel.score(stats, tfn) * d.afterEffect.score(stats, tfn)
+}
+
+// maxScore bounds the scores with an after effect, the basic models
+// are a + b * tfn, and the after effects k / (tfn + 1), so the score
+// never exceeds the greater of a and b, times k, whatever tfn
+func (d *DFRSimilarity) maxScore(stats *basicStats) float64 {
+	if d.afterEffect == NoAfterEffect {
+		return math.Inf(1)
+	}
+	a := d.basicModel.score(stats, 0)
+	b := d.basicModel.score(stats, 1) - a
+	return math.Max(a, b) * d.afterEffect.score(stats, 0)
+}
+
+func (d *DFRSimilarity) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	tfn := d.normalization.tfn(stats, freq, docLen)
+	return search.NewExplanation(d.score(stats, freq, docLen),
+		fmt.Sprintf("DFR %s%s%s, computed as basic model * after effect from:",
+			d.basicModel, d.afterEffect, d.normalization),
+		d.normalization.explain(stats, freq, docLen),
+		d.basicModel.explain(stats, tfn),
+		d.afterEffect.explain(stats, tfn))
+}
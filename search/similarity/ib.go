@@ -0,0 +1,137 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"fmt"
+	"math"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// Distribution is the probability distribution of the normalized
+// frequency of terms, of the information based similarity
+type Distribution int
+
+const (
+	// DistributionLL is the log-logistic distribution,
+	// -log(lambda / (tfn + lambda))
+	DistributionLL Distribution = iota
+	// DistributionSPL is the smoothed power law distribution,
+	// -log((lambda ^ (tfn / (tfn + 1)) - lambda) / (1 - lambda))
+	DistributionSPL
+)
+
+func (d Distribution) String() string {
+	if d == DistributionSPL {
+		return "SPL"
+	}
+	return "LL"
+}
+
+func (d Distribution) score(tfn, lambda float64) float64 {
+	if d == DistributionSPL {
+		if lambda == 1 {
+			lambda = 0.99
+		}
+		return -math.Log((math.Pow(lambda, tfn/(tfn+1)) - lambda) / (1 - lambda))
+	}
+	return -math.Log(lambda / (tfn + lambda))
+}
+
+// Lambda is the parameter of the distribution of a term
+type Lambda int
+
+const (
+	// LambdaDF is the ratio of documents using
+	// the term, (n + 1) / (N + 1)
+	LambdaDF Lambda = iota
+	// LambdaTTF is the average number of occurrences
+	// of the term per document, (F + 1) / (N + 1)
+	LambdaTTF
+)
+
+func (l Lambda) String() string {
+	if l == LambdaTTF {
+		return "TTF"
+	}
+	return "DF"
+}
+
+func (l Lambda) lambda(stats *basicStats) float64 {
+	if l == LambdaTTF {
+		return (stats.totalTermFreq + 1) / (stats.docCount + 1)
+	}
+	return (stats.docFreq + 1) / (stats.docCount + 1)
+}
+
+func (l Lambda) explain(stats *basicStats) *search.Explanation {
+	if l == LambdaTTF {
+		return search.NewExplanation(l.lambda(stats), "lambda, computed as (F + 1) / (N + 1) from:",
+			stats.explainTotalTermFreq(), stats.explainDocCount())
+	}
+	return search.NewExplanation(l.lambda(stats), "lambda, computed as (n + 1) / (N + 1) from:",
+		stats.explainDocFreq(), stats.explainDocCount())
+}
+
+// IBSimilarity scores matches by the information based framework of
+// Clinchant and Gaussier, the score of a match is the information
+// content of the normalized frequency of the term, under a
+// distribution whose parameter lambda depends on the term.  Lambda
+// TTF scores terms with their total number of occurrences, see
+// search.TotalTermFrequencyStats.  The information content grows
+// without bound with the normalized frequency, so the scores have
+// no upper bound, and searches cannot skip the matches which
+// could not be competitive.
+type IBSimilarity struct {
+	lengthNorm
+	distribution  Distribution
+	lambda        Lambda
+	normalization Normalization
+}
+
+// NewIBSimilarity uses the log-logistic distribution, lambda
+// DF and normalization H2
+func NewIBSimilarity() *IBSimilarity {
+	return NewIBSimilarityModel(DistributionLL, LambdaDF, NormalizationH2)
+}
+
+func NewIBSimilarityModel(distribution Distribution, lambda Lambda,
+	normalization Normalization) *IBSimilarity {
+	return &IBSimilarity{
+		distribution:  distribution,
+		lambda:        lambda,
+		normalization: normalization,
+	}
+}
+
+func (i *IBSimilarity) Scorer(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) search.Scorer {
+	return newModelScorer(i, boost, collectionStats, termStats)
+}
+
+func (i *IBSimilarity) score(stats *basicStats, freq, docLen float64) float64 {
+	return i.distribution.score(i.normalization.tfn(stats, freq, docLen), i.lambda.lambda(stats))
+}
+
+func (i *IBSimilarity) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	return search.NewExplanation(i.score(stats, freq, docLen),
+		fmt.Sprintf("IB %s-%s-%s, information content of tfn in distribution %s from:",
+			i.distribution, i.lambda, i.normalization, i.distribution),
+		i.normalization.explain(stats, freq, docLen),
+		i.lambda.explain(stats))
+}
@@ -0,0 +1,123 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"math"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+const defaultMu = 2000
+const defaultLambda = 0.7
+
+// LMDirichletSimilarity scores matches by the likelihood of the
+// language model of the document generating the term, smoothed by
+// the language model of the collection with Bayesian Dirichlet
+// priors, as described by Zhai and Lafferty.  Matches which are
+// less likely than in the collection score 0.  It scores terms
+// with their total number of occurrences, see
+// search.TotalTermFrequencyStats.
+type LMDirichletSimilarity struct {
+	lengthNorm
+	mu float64
+}
+
+func NewLMDirichletSimilarity() *LMDirichletSimilarity {
+	return NewLMDirichletSimilarityMu(defaultMu)
+}
+
+// NewLMDirichletSimilarityMu smoothes with the prior mu, the
+// greater it is, the more the collection model weighs
+func NewLMDirichletSimilarityMu(mu float64) *LMDirichletSimilarity {
+	return &LMDirichletSimilarity{
+		mu: mu,
+	}
+}
+
+func (l *LMDirichletSimilarity) Scorer(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) search.Scorer {
+	return newModelScorer(l, boost, collectionStats, termStats)
+}
+
+func (l *LMDirichletSimilarity) score(stats *basicStats, freq, docLen float64) float64 {
+	score := math.Log(1+freq/(l.mu*stats.collectionProbability())) + math.Log(l.mu/(docLen+l.mu))
+	return math.Max(score, 0)
+}
+
+// maxScore is the limit of the score of a field made
+// of the term only, as the field grows longer
+func (l *LMDirichletSimilarity) maxScore(stats *basicStats) float64 {
+	return -math.Log(stats.collectionProbability())
+}
+
+func (l *LMDirichletSimilarity) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	return search.NewExplanation(l.score(stats, freq, docLen),
+		"language model with Dirichlet smoothing, computed as "+
+			"max(0, log(1 + freq / (mu * p)) + log(mu / (dl + mu))) from:",
+		explainFreq(freq),
+		search.NewExplanation(l.mu, "mu, smoothing parameter"),
+		stats.explainCollectionProbability(),
+		explainFieldLength(docLen))
+}
+
+// LMJelinekMercerSimilarity scores matches by the likelihood of the
+// language model of the document generating the term, interpolated
+// with the language model of the collection by lambda, as described
+// by Zhai and Lafferty.  It scores terms with their total number of
+// occurrences, see search.TotalTermFrequencyStats.
+type LMJelinekMercerSimilarity struct {
+	lengthNorm
+	lambda float64
+}
+
+func NewLMJelinekMercerSimilarity() *LMJelinekMercerSimilarity {
+	return NewLMJelinekMercerSimilarityLambda(defaultLambda)
+}
+
+// NewLMJelinekMercerSimilarityLambda interpolates with the weight
+// lambda of the collection model, between 0 and 1, around 0.1
+// suits short fields, such as titles, and 0.7 long fields
+func NewLMJelinekMercerSimilarityLambda(lambda float64) *LMJelinekMercerSimilarity {
+	return &LMJelinekMercerSimilarity{
+		lambda: lambda,
+	}
+}
+
+func (l *LMJelinekMercerSimilarity) Scorer(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) search.Scorer {
+	return newModelScorer(l, boost, collectionStats, termStats)
+}
+
+func (l *LMJelinekMercerSimilarity) score(stats *basicStats, freq, docLen float64) float64 {
+	return math.Log(1 + (1-l.lambda)*freq/docLen/(l.lambda*stats.collectionProbability()))
+}
+
+// maxScore is the score of a field made of the term only
+func (l *LMJelinekMercerSimilarity) maxScore(stats *basicStats) float64 {
+	return math.Log(1 + (1-l.lambda)/(l.lambda*stats.collectionProbability()))
+}
+
+func (l *LMJelinekMercerSimilarity) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	return search.NewExplanation(l.score(stats, freq, docLen),
+		"language model with Jelinek-Mercer smoothing, computed as "+
+			"log(1 + ((1 - lambda) * freq / dl) / (lambda * p)) from:",
+		explainFreq(freq),
+		search.NewExplanation(l.lambda, "lambda, weight of collection model"),
+		stats.explainCollectionProbability(),
+		explainFieldLength(docLen))
+}
@@ -0,0 +1,98 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"math"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// Normalization adjusts the frequency of a term in a field to the
+// length of the field, for the DFR and IB similarities, into the
+// normalized frequency tfn
+type Normalization int
+
+const (
+	// NormalizationH1 assumes a uniform distribution of the
+	// term frequency, tfn = freq * avgdl / dl
+	NormalizationH1 Normalization = iota
+	// NormalizationH2 assumes the density of the term frequency is
+	// decreasing with the length, tfn = freq * log2(1 + avgdl / dl),
+	// this is the default
+	NormalizationH2
+	// NormalizationH3 is Dirichlet smoothing, with mu 800,
+	// tfn = (freq + mu * p) / (dl + mu) * mu
+	NormalizationH3
+	// NormalizationZ is Pareto-Zipf normalization, with z 0.3,
+	// tfn = freq * (avgdl / dl) ^ z
+	NormalizationZ
+	// NoNormalization does not normalize the frequency, tfn = freq
+	NoNormalization
+)
+
+const normalizationH3Mu = 800
+const normalizationZ = 0.3
+
+func (n Normalization) String() string {
+	switch n {
+	case NormalizationH1:
+		return "H1"
+	case NormalizationH2:
+		return "H2"
+	case NormalizationH3:
+		return "H3"
+	case NormalizationZ:
+		return "Z"
+	}
+	return "none"
+}
+
+func (n Normalization) tfn(stats *basicStats, freq, docLen float64) float64 {
+	switch n {
+	case NormalizationH1:
+		return freq * stats.avgFieldLength / docLen
+	case NormalizationH2:
+		return freq * math.Log2(1+stats.avgFieldLength/docLen)
+	case NormalizationH3:
+		return (freq + normalizationH3Mu*stats.collectionProbability()) /
+			(docLen + normalizationH3Mu) * normalizationH3Mu
+	case NormalizationZ:
+		return freq * math.Pow(stats.avgFieldLength/docLen, normalizationZ)
+	}
+	return freq
+}
+
+func (n Normalization) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	tfn := n.tfn(stats, freq, docLen)
+	switch n {
+	case NormalizationH1:
+		return search.NewExplanation(tfn, "tfn, normalization H1, computed as freq * avgdl / dl from:",
+			explainFreq(freq), stats.explainAverageFieldLength(), explainFieldLength(docLen))
+	case NormalizationH2:
+		return search.NewExplanation(tfn, "tfn, normalization H2, computed as freq * log2(1 + avgdl / dl) from:",
+			explainFreq(freq), stats.explainAverageFieldLength(), explainFieldLength(docLen))
+	case NormalizationH3:
+		return search.NewExplanation(tfn, "tfn, normalization H3, computed as (freq + mu * p) / (dl + mu) * mu from:",
+			explainFreq(freq), search.NewExplanation(normalizationH3Mu, "mu, smoothing parameter"),
+			stats.explainCollectionProbability(), explainFieldLength(docLen))
+	case NormalizationZ:
+		return search.NewExplanation(tfn, "tfn, normalization Z, computed as freq * (avgdl / dl) ^ z from:",
+			explainFreq(freq), search.NewExplanation(normalizationZ, "z, normalization parameter"),
+			stats.explainAverageFieldLength(), explainFieldLength(docLen))
+	}
+	return search.NewExplanation(tfn, "tfn, without normalization, computed as freq from:",
+		explainFreq(freq))
+}
@@ -0,0 +1,163 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"fmt"
+	"math"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// lengthNorm stores the length of the field as its norm, as
+// BM25Similarity does, so that the similarity of a field can
+// be changed without indexing its documents again
+type lengthNorm struct{}
+
+func (lengthNorm) ComputeNorm(numTerms int) float32 {
+	return math.Float32frombits(uint32(numTerms))
+}
+
+func fieldLength(norm float64) float64 {
+	return float64(math.Float32bits(float32(norm)))
+}
+
+// basicStats holds the statistics of the collection and
+// of the term, which the models of similarities use
+type basicStats struct {
+	boost float64
+	// docCount is the number of documents with the field
+	docCount float64
+	// docFreq is the number of documents using the term
+	docFreq float64
+	// totalTermFreq is the number of occurrences of the term,
+	// when the term stats do not count them, each document
+	// using the term is assumed to use it once
+	totalTermFreq float64
+	// sumTotalTermFreq is the number of terms of the field
+	sumTotalTermFreq float64
+	avgFieldLength   float64
+}
+
+func newBasicStats(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) *basicStats {
+	rv := &basicStats{
+		boost:   boost,
+		docFreq: float64(termStats.DocumentFrequency()),
+	}
+	rv.totalTermFreq = rv.docFreq
+	if ttf, ok := termStats.(search.TotalTermFrequencyStats); ok {
+		rv.totalTermFreq = float64(ttf.TotalTermFrequency())
+	}
+	if collectionStats != nil {
+		rv.docCount = float64(collectionStats.DocumentCount())
+		rv.sumTotalTermFreq = float64(collectionStats.SumTotalTermFrequency())
+	}
+	if rv.docCount > 0 {
+		rv.avgFieldLength = rv.sumTotalTermFreq / rv.docCount
+	}
+	return rv
+}
+
+func (s *basicStats) explainDocCount() *search.Explanation {
+	return search.NewExplanation(s.docCount, "N, total number of documents with field")
+}
+
+func (s *basicStats) explainDocFreq() *search.Explanation {
+	return search.NewExplanation(s.docFreq, "n, number of documents containing term")
+}
+
+func (s *basicStats) explainTotalTermFreq() *search.Explanation {
+	return search.NewExplanation(s.totalTermFreq, "F, total number of occurrences of term")
+}
+
+func (s *basicStats) explainAverageFieldLength() *search.Explanation {
+	return search.NewExplanation(s.avgFieldLength, "avgdl, average length of field")
+}
+
+// collectionProbability is the probability of a term of the
+// field being the term, smoothed so that it is never 0
+func (s *basicStats) collectionProbability() float64 {
+	return (s.totalTermFreq + 1) / (s.sumTotalTermFreq + 1)
+}
+
+func (s *basicStats) explainCollectionProbability() *search.Explanation {
+	return search.NewExplanation(s.collectionProbability(),
+		"p, probability of term in collection, computed as (F + 1) / (T + 1) from:",
+		s.explainTotalTermFreq(),
+		search.NewExplanation(s.sumTotalTermFreq, "T, total number of terms of field"))
+}
+
+func explainFreq(freq float64) *search.Explanation {
+	return search.NewExplanation(freq, "freq, occurrences of term within document")
+}
+
+func explainFieldLength(docLen float64) *search.Explanation {
+	return search.NewExplanation(docLen, "dl, length of field")
+}
+
+// model is the way a similarity scores the occurrences of a term
+// in a field of a document, before the score is boosted
+type model interface {
+	score(stats *basicStats, freq, docLen float64) float64
+	explain(stats *basicStats, freq, docLen float64) *search.Explanation
+}
+
+// boundedModel is implemented by models which can
+// compute an upper bound of the scores they produce
+type boundedModel interface {
+	maxScore(stats *basicStats) float64
+}
+
+// modelScorer scores the occurrences of a term by a model
+type modelScorer struct {
+	stats *basicStats
+	model model
+}
+
+func newModelScorer(m model, boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) *modelScorer {
+	return &modelScorer{
+		stats: newBasicStats(boost, collectionStats, termStats),
+		model: m,
+	}
+}
+
+func (m *modelScorer) Score(freq int, norm float64) float64 {
+	return m.stats.boost * m.model.score(m.stats, float64(freq), fieldLength(norm))
+}
+
+// MaxScore returns an upper bound of the scores,
+// or +Inf when the model cannot compute one
+func (m *modelScorer) MaxScore() float64 {
+	if bm, ok := m.model.(boundedModel); ok {
+		return math.Max(m.stats.boost*bm.maxScore(m.stats), 0)
+	}
+	return math.Inf(1)
+}
+
+func (m *modelScorer) Explain(freq int, norm float64) *search.Explanation {
+	children := []*search.Explanation{
+		m.model.explain(m.stats, float64(freq), fieldLength(norm)),
+	}
+	if m.stats.boost != noBoost {
+		children = append(children, search.NewExplanation(m.stats.boost, "boost"))
+	}
+	return search.NewExplanation(m.Score(freq, norm),
+		fmt.Sprintf("score(freq=%d), computed as boost * model score from:", freq),
+		children...)
+}
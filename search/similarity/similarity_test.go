@@ -0,0 +1,191 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"math"
+	"testing"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+type testCollectionStats struct {
+	docCount         uint64
+	sumTotalTermFreq uint64
+}
+
+func (c *testCollectionStats) TotalDocumentCount() uint64 {
+	return c.docCount
+}
+
+func (c *testCollectionStats) DocumentCount() uint64 {
+	return c.docCount
+}
+
+func (c *testCollectionStats) SumTotalTermFrequency() uint64 {
+	return c.sumTotalTermFreq
+}
+
+func (c *testCollectionStats) Merge(other segment.CollectionStats) {
+	c.docCount += other.DocumentCount()
+	c.sumTotalTermFreq += other.SumTotalTermFrequency()
+}
+
+type testTermStats struct {
+	docFreq       uint64
+	totalTermFreq uint64
+}
+
+func (t *testTermStats) DocumentFrequency() uint64 {
+	return t.docFreq
+}
+
+func (t *testTermStats) TotalTermFrequency() uint64 {
+	return t.totalTermFreq
+}
+
+func testSimilarities() map[string]search.Similarity {
+	rv := map[string]search.Similarity{
+		"bm25":         NewBM25Similarity(),
+		"tfidf":        NewTFIDFSimilarity(),
+		"dfr":          NewDFRSimilarity(),
+		"ib":           NewIBSimilarity(),
+		"ib spl ttf":   NewIBSimilarityModel(DistributionSPL, LambdaTTF, NormalizationH1),
+		"lm dirichlet": NewLMDirichletSimilarity(),
+		"lm jelinek":   NewLMJelinekMercerSimilarity(),
+	}
+	for _, basicModel := range []BasicModel{BasicModelG, BasicModelIF, BasicModelIn, BasicModelIne} {
+		for _, afterEffect := range []AfterEffect{AfterEffectL, AfterEffectB, NoAfterEffect} {
+			for _, normalization := range []Normalization{NormalizationH1, NormalizationH2,
+				NormalizationH3, NormalizationZ, NoNormalization} {
+				rv["dfr "+basicModel.String()+afterEffect.String()+normalization.String()] =
+					NewDFRSimilarityModel(basicModel, afterEffect, normalization)
+			}
+		}
+	}
+	return rv
+}
+
+func TestSimilarities(t *testing.T) {
+	collectionStats := &testCollectionStats{
+		docCount:         1000,
+		sumTotalTermFreq: 100000,
+	}
+	termStats := &testTermStats{
+		docFreq:       50,
+		totalTermFreq: 120,
+	}
+	norm := func(sim search.Similarity, length int) float64 {
+		return float64(sim.ComputeNorm(length))
+	}
+	for name, sim := range testSimilarities() {
+		for _, boost := range []float64{1, 2.5} {
+			scorer := sim.Scorer(boost, collectionStats, termStats)
+			maxScore := math.Inf(1)
+			if ms, ok := scorer.(search.MaxScorer); ok {
+				maxScore = ms.MaxScore()
+			}
+			if dfr, ok := sim.(*DFRSimilarity); ok && dfr.afterEffect != NoAfterEffect {
+				if math.IsInf(maxScore, 1) {
+					t.Errorf("%s: expected an upper bound of the scores", name)
+				}
+				if score := scorer.Score(1000000, norm(sim, 100)); score > maxScore+1e-9 {
+					t.Errorf("%s: expected the score %f to be at most %f", name, score, maxScore)
+				}
+			}
+			var previous float64
+			for freq := 1; freq <= 10; freq++ {
+				score := scorer.Score(freq, norm(sim, 100))
+				if math.IsNaN(score) || score < 0 {
+					t.Errorf("%s: expected a positive score of %d occurrences, got %f", name, freq, score)
+				}
+				if freq > 1 && score < previous {
+					t.Errorf("%s: expected the score of %d occurrences %f to be at least %f",
+						name, freq, score, previous)
+				}
+				if score > maxScore+1e-9 {
+					t.Errorf("%s: expected the score %f to be at most %f", name, score, maxScore)
+				}
+				if explanation := scorer.Explain(freq, norm(sim, 100)); math.Abs(explanation.Value-score) > 1e-9 {
+					t.Errorf("%s: expected explanation of score %f, got %f", name, score, explanation.Value)
+				}
+				previous = score
+			}
+			// the same occurrences in a field made of the term only
+			if score := scorer.Score(10, norm(sim, 10)); score > maxScore+1e-9 {
+				t.Errorf("%s: expected the score %f to be at most %f", name, score, maxScore)
+			}
+		}
+	}
+}
+
+func TestSimilarityScores(t *testing.T) {
+	collectionStats := &testCollectionStats{
+		docCount:         10,
+		sumTotalTermFreq: 99,
+	}
+	termStats := &testTermStats{
+		docFreq:       4,
+		totalTermFreq: 9,
+	}
+	tests := []struct {
+		name     string
+		sim      search.Similarity
+		expected float64
+	}{
+		{
+			name:     "tfidf",
+			sim:      NewTFIDFSimilarity(),
+			expected: (1 + math.Log(11.0/5)) * math.Sqrt(2) / math.Sqrt(4),
+		},
+		{
+			// tfn = 2 * log2(1 + 9.9 / 4), ne = 10 * (1 - 0.9^9)
+			name: "dfr",
+			sim:  NewDFRSimilarity(),
+			expected: 2 * math.Log2(1+9.9/4) * math.Log2(11/(10*(1-math.Pow(0.9, 9))+0.5)) *
+				10 / (5 * (2*math.Log2(1+9.9/4) + 1)),
+		},
+		{
+			name:     "ib",
+			sim:      NewIBSimilarity(),
+			expected: -math.Log((5.0 / 11) / (2*math.Log2(1+9.9/4) + 5.0/11)),
+		},
+		{
+			name:     "lm dirichlet",
+			sim:      NewLMDirichletSimilarityMu(10),
+			expected: math.Log(1+2/(10*0.1)) + math.Log(10.0/14),
+		},
+		{
+			name:     "lm jelinek mercer",
+			sim:      NewLMJelinekMercerSimilarityLambda(0.5),
+			expected: math.Log(1 + 0.5*2/4/(0.5*0.1)),
+		},
+	}
+	for _, test := range tests {
+		score := test.sim.Scorer(1, collectionStats, termStats).Score(2, float64(test.sim.ComputeNorm(4)))
+		if math.Abs(score-test.expected) > 1e-9 {
+			t.Errorf("%s: expected score %f, got %f", test.name, test.expected, score)
+		}
+	}
+
+	// documents less likely than the collection score 0
+	dirichlet := NewLMDirichletSimilarity()
+	score := dirichlet.Scorer(1, collectionStats, termStats).Score(1, float64(dirichlet.ComputeNorm(1000)))
+	if score != 0 {
+		t.Errorf("expected score 0, got %f", score)
+	}
+}
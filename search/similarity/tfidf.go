@@ -0,0 +1,66 @@
+//  Copyright (c) 2020 The Bluge Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package similarity
+
+import (
+	"math"
+
+	segment "github.com/blugelabs/bluge_segment_api"
+
+	"github.com/blugelabs/bluge/search"
+)
+
+// TFIDFSimilarity is the classic vector space model, scoring
+// matches by the square root of the term frequency, the inverse
+// document frequency of the term, and the inverse square root
+// of the length of the field
+type TFIDFSimilarity struct {
+	lengthNorm
+}
+
+func NewTFIDFSimilarity() *TFIDFSimilarity {
+	return &TFIDFSimilarity{}
+}
+
+func (t *TFIDFSimilarity) Scorer(boost float64, collectionStats segment.CollectionStats,
+	termStats segment.TermStats) search.Scorer {
+	return newModelScorer(t, boost, collectionStats, termStats)
+}
+
+func (t *TFIDFSimilarity) idf(stats *basicStats) float64 {
+	return 1 + math.Log((stats.docCount+1)/(stats.docFreq+1))
+}
+
+func (t *TFIDFSimilarity) score(stats *basicStats, freq, docLen float64) float64 {
+	return t.idf(stats) * math.Sqrt(freq) / math.Sqrt(docLen)
+}
+
+// maxScore is the idf, as a term occurs at most as
+// many times as there are terms in the field
+func (t *TFIDFSimilarity) maxScore(stats *basicStats) float64 {
+	return t.idf(stats)
+}
+
+func (t *TFIDFSimilarity) explain(stats *basicStats, freq, docLen float64) *search.Explanation {
+	return search.NewExplanation(t.score(stats, freq, docLen),
+		"computed as idf * tf * lengthNorm from:",
+		search.NewExplanation(t.idf(stats), "idf, computed as 1 + log((N + 1) / (n + 1)) from:",
+			stats.explainDocFreq(),
+			stats.explainDocCount()),
+		search.NewExplanation(math.Sqrt(freq), "tf, computed as sqrt(freq) from:",
+			explainFreq(freq)),
+		search.NewExplanation(1/math.Sqrt(docLen), "lengthNorm, computed as 1 / sqrt(dl) from:",
+			explainFieldLength(docLen)))
+}
@@ -16,7 +16,11 @@ package search
 
 import (
 	"bytes"
+	"sort"
+	"strconv"
 	"strings"
+
+	segment "github.com/blugelabs/bluge_segment_api"
 )
 
 type SortOrder []*Sort
@@ -41,6 +45,89 @@ func (o SortOrder) Reverse() {
 	}
 }
 
+// ScoreDescending returns true if matches are
+// sorted primarily by descending score
+func (o SortOrder) ScoreDescending() bool {
+	if len(o) == 0 || !o[0].desc {
+		return false
+	}
+	if mtv, ok := o[0].source.(*MissingTextValueSource); ok {
+		_, ok = mtv.primary.(*ScoreSource)
+		return ok
+	}
+	return false
+}
+
+// Key uniquely identifies the order, it is empty unless every
+// sort is by the document values of a field, so that the order
+// can be used to sort an index
+func (o SortOrder) Key() string {
+	keys := make([]string, 0, len(o))
+	for _, s := range o {
+		mtv, ok := s.source.(*MissingTextValueSource)
+		if !ok {
+			return ""
+		}
+		field, ok := mtv.primary.(FieldSource)
+		if !ok {
+			return ""
+		}
+		key := strconv.Quote(string(field))
+		if s.desc {
+			key = "-" + key
+		}
+		if s.missingFirst {
+			key += " missing first"
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, ",")
+}
+
+// SortDocuments orders analyzed documents by the document values
+// of their fields, as they would be sorted once indexed
+func (o SortOrder) SortDocuments(docs []segment.Document) {
+	matches := make([]*DocumentMatch, len(docs))
+	for i, doc := range docs {
+		matches[i] = &DocumentMatch{HitNumber: i}
+		if doc != nil {
+			doc.EachField(func(field segment.Field) {
+				if field.IndexDocValues() {
+					field.EachTerm(func(term segment.FieldTerm) {
+						matches[i].addDocValue(field.Name(), term.Term())
+					})
+				}
+			})
+		}
+		// document values are read back sorted and without duplicates
+		for name, values := range matches[i].docValues {
+			sort.Slice(values, func(x, y int) bool {
+				return bytes.Compare(values[x], values[y]) < 0
+			})
+			matches[i].docValues[name] = dedupeSortedTerms(values)
+		}
+		o.Compute(matches[i])
+	}
+	sort.SliceStable(matches, func(x, y int) bool {
+		return o.Compare(matches[x], matches[y]) < 0
+	})
+	sorted := make([]segment.Document, len(docs))
+	for i, match := range matches {
+		sorted[i] = docs[match.HitNumber]
+	}
+	copy(docs, sorted)
+}
+
+func dedupeSortedTerms(terms [][]byte) [][]byte {
+	rv := terms[:0]
+	for _, term := range terms {
+		if len(rv) == 0 || !bytes.Equal(term, rv[len(rv)-1]) {
+			rv = append(rv, term)
+		}
+	}
+	return rv
+}
+
 func (o SortOrder) Compute(match *DocumentMatch) {
 	for _, sort := range o {
 		sortVal := sort.Value(match)
@@ -51,6 +138,21 @@ func (o SortOrder) Compute(match *DocumentMatch) {
 }
 
 func (o SortOrder) Compare(i, j *DocumentMatch) int {
+	c := o.CompareSortValues(i, j)
+	if c != 0 {
+		return c
+	}
+	// if they are the same at this point, impose order based on index natural sort order
+	if i.HitNumber == j.HitNumber {
+		return 0
+	} else if i.HitNumber > j.HitNumber {
+		return 1
+	}
+	return -1
+}
+
+// CompareSortValues compares the documents on their sort values only
+func (o SortOrder) CompareSortValues(i, j *DocumentMatch) int {
 	// compare the documents on all search sorts until a differences is found
 	for x := range o {
 		c := 0
@@ -66,13 +168,7 @@ func (o SortOrder) Compare(i, j *DocumentMatch) int {
 		}
 		return c
 	}
-	// if they are the same at this point, impose order based on index natural sort order
-	if i.HitNumber == j.HitNumber {
-		return 0
-	} else if i.HitNumber > j.HitNumber {
-		return 1
-	}
-	return -1
+	return 0
 }
 
 type SortValue [][]byte
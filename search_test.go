@@ -16,16 +16,28 @@ package bluge
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/blugelabs/bluge/index/hnsw"
 	"github.com/blugelabs/bluge/search/aggregations"
+	"github.com/blugelabs/bluge/search/expression"
 	"github.com/blugelabs/bluge/search/highlight"
+	"github.com/blugelabs/bluge/search/ranking"
+	"github.com/blugelabs/bluge/search/similarity"
 
 	"github.com/blugelabs/bluge/analysis/char"
 
+	"github.com/blugelabs/bluge/numeric"
 	"github.com/blugelabs/bluge/numeric/geo"
 
 	"github.com/blugelabs/bluge/search"
@@ -1121,6 +1133,39 @@ func TestBooleanSearchBug1185(t *testing.T) {
 	}
 }
 
+// openTestReader opens a reader of an index of the documents built
+// for the numbers from start to end, indexed in batches of batchSize
+// documents, a segment per batch, or in a single batch when it is 0
+func openTestReader(t *testing.T, config Config, start, end, batchSize int,
+	buildDoc func(i int) *Document) *Reader {
+	indexWriter, err := OpenWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batchSize <= 0 {
+		batchSize = end - start
+	}
+	batch := NewBatch()
+	for i := start; i < end; i++ {
+		doc := buildDoc(i)
+		batch.Update(doc.ID(), doc)
+		if (i-start+1)%batchSize == 0 || i == end-1 {
+			if err = indexWriter.Batch(batch); err != nil {
+				t.Fatal(err)
+			}
+			batch.Reset()
+		}
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = indexWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return indexReader
+}
+
 func countHits(dmi search.DocumentMatchIterator) (n int, err error) {
 	var next *search.DocumentMatch
 	next, err = dmi.Next()
@@ -1380,3 +1425,1641 @@ func TestBooleanSearchBoost(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFunctionScoreAndExpressionSort(t *testing.T) {
+	tmpIndexPath := createTmpIndexPath(t)
+	defer cleanupTmpIndexPath(t, tmpIndexPath)
+
+	config := DefaultConfig(tmpIndexPath)
+	indexWriter, err := OpenWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatch()
+	for i, popularity := range []float64{5, 20, 1} {
+		doc := NewDocument(strconv.Itoa(i)).
+			AddField(NewNumericField("popularity", popularity).Aggregatable())
+		batch.Update(doc.ID(), doc)
+	}
+	if err = indexWriter.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatalf("error getting index reader: %v", err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+		_ = indexWriter.Close()
+	}()
+
+	collectIDs := func(dmi search.DocumentMatchIterator) (ids []string, scores []float64) {
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores = append(scores, next.Score)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, scores
+	}
+
+	// score documents by the expression
+	q := NewFunctionScoreQuery(NewMatchAllQuery(), expression.MustCompile("_score * log10(popularity * 10)"))
+	req := NewTopNSearch(10, q)
+	req.AddAggregation("total", aggregations.Sum(expression.MustCompile("popularity * 2")))
+	dmi, err := indexReader.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, scores := collectIDs(dmi)
+	if !reflect.DeepEqual(ids, []string{"1", "0", "2"}) {
+		t.Errorf("expected function score order [1 0 2], got %v", ids)
+	}
+	if math.Abs(scores[2]-1) > 1e-9 {
+		t.Errorf("expected lowest score 1, got %f", scores[2])
+	}
+	if total := dmi.Aggregations().Metric("total"); total != 52 {
+		t.Errorf("expected aggregated total 52, got %f", total)
+	}
+
+	// sort documents by the expression
+	req = NewTopNSearch(10, NewMatchAllQuery()).
+		SortByCustom(search.SortOrder{search.SortBy(expression.MustCompile("abs(popularity - 6)"))})
+	dmi, err = indexReader.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _ = collectIDs(dmi)
+	if !reflect.DeepEqual(ids, []string{"0", "2", "1"}) {
+		t.Errorf("expected expression sort order [0 2 1], got %v", ids)
+	}
+}
+
+func TestFilterQueryCache(t *testing.T) {
+	tmpIndexPath := createTmpIndexPath(t)
+	defer cleanupTmpIndexPath(t, tmpIndexPath)
+
+	config := DefaultConfig(tmpIndexPath).WithQueryCache(1024 * 1024)
+	indexWriter, err := OpenWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// separate batches, so that the documents span several segments
+	for i, color := range []string{"red", "blue", "red", "green", "red"} {
+		batch := NewBatch()
+		doc := NewDocument(strconv.Itoa(i)).
+			AddField(NewKeywordField("color", color)).
+			AddField(NewTextField("desc", "some text"))
+		batch.Update(doc.ID(), doc)
+		if err = indexWriter.Batch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatalf("error getting index reader: %v", err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+		_ = indexWriter.Close()
+	}()
+
+	q := NewBooleanQuery().
+		AddMust(NewMatchQuery("text").SetField("desc")).
+		AddMust(NewFilterQuery(NewTermQuery("red").SetField("color")))
+	for i := 0; i < 3; i++ {
+		req := NewTopNSearch(10, q).SortBy([]string{"_id"})
+		dmi, err := indexReader.Search(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, []string{"0", "2", "4"}) {
+			t.Errorf("run %d: expected filtered ids [0 2 4], got %v", i, ids)
+		}
+	}
+}
+
+func TestQueryCacheKey(t *testing.T) {
+	options := search.SearcherOptions{DefaultSearchField: "_all"}
+	tests := []struct {
+		query Query
+		key   string
+	}{
+		{query: NewTermQuery("a"), key: `term("_all","a")`},
+		{query: NewTermQuery("a").SetField("f"), key: `term("f","a")`},
+		{query: NewNumericRangeQuery(1, 2.5).SetField("n"), key: `numeric_range("n",1,true,2.5,false)`},
+		{query: NewFilterQuery(NewMatchAllQuery()).SetCacheKey("custom"), key: "custom"},
+		{query: NewBooleanQuery().AddMust(NewPrefixQuery("p")).AddMustNot(NewMatchAllQuery()),
+			key: `bool(0,must[prefix("_all","p")],should[],must_not[match_all])`},
+		{query: NewBooleanQuery().AddMust(NewMatchQuery("not cacheable")), key: ""},
+	}
+	for _, test := range tests {
+		actual := queryCacheKey(test.query, options)
+		if actual != test.key {
+			t.Errorf("expected key %s, got %s", test.key, actual)
+		}
+	}
+}
+
+func TestTopNSearchTrackTotalHits(t *testing.T) {
+	tmpIndexPath := createTmpIndexPath(t)
+	defer cleanupTmpIndexPath(t, tmpIndexPath)
+
+	config := DefaultConfig(tmpIndexPath)
+	indexWriter, err := OpenWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewBatch()
+	for i := 0; i < 200; i++ {
+		text := "common"
+		if i%20 == 0 {
+			text = "rare common"
+		}
+		doc := NewDocument(strconv.Itoa(i)).
+			AddField(NewTextField("desc", text))
+		batch.Update(doc.ID(), doc)
+	}
+	if err = indexWriter.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatalf("error getting index reader: %v", err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+		_ = indexWriter.Close()
+	}()
+
+	topIDs := func(req *TopNSearch) (ids []string, count uint64, lowerBound bool) {
+		dmi, err := indexReader.Search(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, dmi.Aggregations().Count(), dmi.(search.PartialResultsIterator).CountIsLowerBound()
+	}
+
+	q := NewMatchQuery("rare common").SetField("desc")
+	exactIDs, exactCount, lowerBound := topIDs(NewTopNSearch(4, q).WithStandardAggregations())
+	if exactCount != 200 || lowerBound {
+		t.Errorf("expected exact count of 200, got %d (lower bound %t)", exactCount, lowerBound)
+	}
+
+	ids, count, lowerBound := topIDs(NewTopNSearch(4, q).WithStandardAggregations().DisableTrackTotalHits())
+	if !reflect.DeepEqual(exactIDs, ids) {
+		t.Errorf("expected same top hits %v, got %v", exactIDs, ids)
+	}
+	if !lowerBound || count >= exactCount {
+		t.Errorf("expected count to be a lower bound below %d, got %d (lower bound %t)",
+			exactCount, count, lowerBound)
+	}
+
+	_, count, _ = topIDs(NewTopNSearch(4, q).WithStandardAggregations().TrackTotalHitsUpTo(100))
+	if count < 100 {
+		t.Errorf("expected at least 100 matches counted, got %d", count)
+	}
+}
+
+func TestConcurrentSearch(t *testing.T) {
+	tmpIndexPath := createTmpIndexPath(t)
+	defer cleanupTmpIndexPath(t, tmpIndexPath)
+
+	config := DefaultConfig(tmpIndexPath)
+	indexWriter, err := OpenWriter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each batch builds a separate segment
+	for b := 0; b < 6; b++ {
+		batch := NewBatch()
+		for i := b * 40; i < (b+1)*40; i++ {
+			text := "common"
+			if i%3 == 0 {
+				text = "rare common common"
+			}
+			doc := NewDocument(fmt.Sprintf("%03d", i)).
+				AddField(NewTextField("desc", text)).
+				AddField(NewKeywordField("color", []string{"red", "green", "blue"}[i%3]).Aggregatable()).
+				AddField(NewNumericField("price", float64(i%17)).Aggregatable())
+			batch.Update(doc.ID(), doc)
+		}
+		if err = indexWriter.Batch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = indexWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sequential, err := OpenReader(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sequential.Close()
+	}()
+	concurrent, err := OpenReader(config.WithConcurrentSearch(3, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = concurrent.Close()
+	}()
+	if len(concurrent.reader.Slices(3)) < 2 {
+		t.Fatalf("expected the index to be partitioned into several slices")
+	}
+
+	type result struct {
+		hits  []string
+		count uint64
+		sum   float64
+		terms map[string]uint64
+	}
+	run := func(reader *Reader, req *TopNSearch) result {
+		req.AddAggregation("sum", aggregations.Sum(search.Field("price")))
+		req.AddAggregation("colors", aggregations.NewTermsAggregation(search.Field("color"), 10))
+		dmi, err := reader.Search(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rv result
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			var id string
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					id = string(value)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rv.hits = append(rv.hits, fmt.Sprintf("%s-%.6f", id, next.Score))
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rv.count = dmi.Aggregations().Count()
+		rv.sum = dmi.Aggregations().Metric("sum")
+		rv.terms = map[string]uint64{}
+		for _, bucket := range dmi.Aggregations().Buckets("colors") {
+			rv.terms[bucket.Name()] = bucket.Count()
+		}
+		return rv
+	}
+
+	q := NewMatchQuery("rare common").SetField("desc")
+	dmi, err := sequential.Search(context.Background(), NewTopNSearch(12, q).SortBy([]string{"price", "_id"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after [][]byte
+	for next, err := dmi.Next(); next != nil; next, err = dmi.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		after = next.SortValue
+	}
+
+	tests := []func() *TopNSearch{
+		func() *TopNSearch {
+			return NewTopNSearch(10, q).WithStandardAggregations()
+		},
+		func() *TopNSearch {
+			return NewTopNSearch(7, q).SetFrom(5).WithStandardAggregations()
+		},
+		func() *TopNSearch {
+			return NewTopNSearch(10, q).SortBy([]string{"-price", "_id"}).WithStandardAggregations()
+		},
+		func() *TopNSearch {
+			return NewTopNSearch(5, q).SortBy([]string{"price", "_id"}).
+				After(after).WithStandardAggregations()
+		},
+	}
+	for i, test := range tests {
+		expected := run(sequential, test())
+		actual := run(concurrent, test())
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("test %d: expected %v, got %v", i, expected, actual)
+		}
+		if len(expected.hits) == 0 {
+			t.Errorf("test %d: expected some hits", i)
+		}
+	}
+}
+
+func TestConcurrentSearchAfter(t *testing.T) {
+	// many segments, sharing a handful of prices
+	indexReader := openTestReader(t, InMemoryOnlyConfig().WithConcurrentSearch(4, nil), 0, 120, 10,
+		func(i int) *Document {
+			return NewDocument(fmt.Sprintf("%03d", i)).
+				AddField(NewNumericField("price", float64(i%5)).Sortable())
+		})
+	defer func() {
+		_ = indexReader.Close()
+	}()
+	if len(indexReader.reader.Slices(4)) < 2 {
+		t.Fatalf("expected the index to be partitioned into several slices")
+	}
+
+	page := func(size int, sortBy []string, after [][]byte) (ids []string, last [][]byte) {
+		req := NewTopNSearch(size, NewMatchAllQuery()).SortBy(sortBy)
+		if after != nil {
+			req.After(after)
+		}
+		dmi, err := indexReader.Search(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			last = next.SortValue
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, last
+	}
+
+	// matches tied with the search after key are skipped in every slice
+	for price := 0; price < 5; price++ {
+		first, after := page(1, []string{"price"}, nil)
+		for p := 0; p < price; p++ {
+			first, after = page(1, []string{"price"}, after)
+		}
+		if len(first) != 1 {
+			t.Fatalf("expected a match priced %d, got %v", price, first)
+		}
+		var expected []string
+		for i := 0; i < 120; i++ {
+			if i%5 > price {
+				expected = append(expected, fmt.Sprintf("%03d", i))
+			}
+		}
+		ids, _ := page(120, []string{"price"}, after)
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected matches priced above %d %v, got %v", price, expected, ids)
+		}
+	}
+
+	// paging through ties visits every match once
+	all, _ := page(120, []string{"price", "_id"}, nil)
+	var paged []string
+	ids, after := page(7, []string{"price", "_id"}, nil)
+	for len(ids) > 0 {
+		paged = append(paged, ids...)
+		ids, after = page(7, []string{"price", "_id"}, after)
+	}
+	if !reflect.DeepEqual(paged, all) {
+		t.Errorf("expected pages of %v, got %v", all, paged)
+	}
+}
+
+func TestAggregationOnSortField(t *testing.T) {
+	indexWriter, err := OpenWriter(InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexWriter.Close()
+	}()
+
+	batch := NewBatch()
+	for i, price := range []float64{10, 25, 5} {
+		doc := NewDocument(strconv.Itoa(i)).
+			AddField(NewNumericField("price", price).Aggregatable())
+		batch.Update(doc.ID(), doc)
+	}
+	if err = indexWriter.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+	}()
+
+	// the price field is needed by the sort and both aggregations
+	req := NewTopNSearch(1, NewMatchAllQuery()).SortBy([]string{"-price"})
+	req.AddAggregation("sum", aggregations.Sum(search.Field("price")))
+	req.AddAggregation("ranges", aggregations.Ranges(search.Field("price")).
+		AddRange(aggregations.Range(0, 20)))
+	dmi, err := indexReader.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := dmi.Aggregations().Metric("sum"); sum != 40 {
+		t.Errorf("expected sum 40, got %f", sum)
+	}
+	if buckets := dmi.Aggregations().Buckets("ranges"); len(buckets) != 1 || buckets[0].Count() != 2 {
+		t.Errorf("expected one range bucket with count 2, got %v", buckets)
+	}
+}
+
+func TestTopNSearchCollapse(t *testing.T) {
+	indexWriter, err := OpenWriter(InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexWriter.Close()
+	}()
+
+	products := []struct {
+		id     string
+		title  string
+		family string
+		price  float64
+	}{
+		{id: "a1", title: "phone phone phone", family: "alpha", price: 100},
+		{id: "a2", title: "phone case", family: "alpha", price: 300},
+		{id: "a3", title: "phone", family: "alpha", price: 200},
+		{id: "b1", title: "phone phone", family: "beta", price: 50},
+		{id: "b2", title: "phone charger cable", family: "beta", price: 20},
+		{id: "c1", title: "phone stand", family: "gamma", price: 10},
+		{id: "n1", title: "phone", price: 5},
+		{id: "x1", title: "tablet", family: "alpha", price: 1000},
+	}
+	batch := NewBatch()
+	for _, product := range products {
+		doc := NewDocument(product.id).
+			AddField(NewTextField("title", product.title)).
+			AddField(NewNumericField("price", product.price).Aggregatable())
+		if product.family != "" {
+			doc.AddField(NewKeywordField("family", product.family).Aggregatable())
+		}
+		batch.Update(doc.ID(), doc)
+	}
+	if err = indexWriter.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+	indexReader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = indexReader.Close()
+	}()
+
+	matchID := func(match *search.DocumentMatch) (id string) {
+		err := match.VisitStoredFields(func(field string, value []byte) bool {
+			if field == "_id" {
+				id = string(value)
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	q := NewMatchQuery("phone").SetField("title")
+	req := NewTopNSearch(10, q).WithStandardAggregations().
+		CollapseBy("family").
+		CollapseInnerHits(2, []string{"-price"})
+	dmi, err := indexReader.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// groups are ranked by their best scoring match
+	var groups [][]string
+	next, err := dmi.Next()
+	for err == nil && next != nil {
+		group := []string{matchID(next)}
+		for _, inner := range next.InnerHits {
+			group = append(group, matchID(inner))
+		}
+		groups = append(groups, group)
+		next, err = dmi.Next()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the group of matches without a family has its own inner hits
+	expected := [][]string{
+		{"a1", "a2", "a3"},
+		{"b1", "b1", "b2"},
+		{"n1", "n1"},
+		{"c1", "c1"},
+	}
+	if !reflect.DeepEqual(expected, groups) {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+	if groupCount := dmi.(search.CollapsedResultsIterator).GroupCount(); groupCount != 4 {
+		t.Errorf("expected 4 groups, got %d", groupCount)
+	}
+	if count := dmi.Aggregations().Count(); count != 7 {
+		t.Errorf("expected 7 matches, got %d", count)
+	}
+
+	// page through the groups
+	dmi, err = indexReader.Search(context.Background(), NewTopNSearch(1, q).SetFrom(1).CollapseBy("family"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err = dmi.Next()
+	if err != nil || next == nil || matchID(next) != "b1" {
+		t.Errorf("expected second group to be b1, got %v (%v)", next, err)
+	}
+
+	// a group could reappear through its other matches paging after a match
+	_, err = indexReader.Search(context.Background(),
+		NewTopNSearch(1, q).After(next.SortValue).CollapseBy("family"))
+	if err == nil {
+		t.Errorf("expected error paging collapsed search after a match")
+	}
+
+	// the collapsing collector cannot stop early or skip matches
+	for _, req := range []*TopNSearch{
+		NewTopNSearch(1, q).AllowPartialResults().CollapseBy("family"),
+		NewTopNSearch(1, q).TerminateAfter(2).CollapseBy("family"),
+		NewTopNSearch(1, q).TrackTotalHitsUpTo(2).CollapseBy("family"),
+		NewTopNSearch(1, q).DisableTrackTotalHits().CollapseBy("family"),
+	} {
+		_, err = indexReader.Search(context.Background(), req)
+		if err == nil {
+			t.Errorf("expected error combining collapse with partial results or early termination")
+		}
+	}
+}
+
+func TestSignificantTerms(t *testing.T) {
+	// failing builds mostly report E42, which is rare otherwise,
+	// E1 is reported by most builds
+	buildDoc := func(i int) *Document {
+		status, code := "pass", "E1"
+		if i%5 == 0 {
+			status = "fail"
+			if i%20 != 0 {
+				code = "E42"
+			}
+		} else if i%40 == 1 {
+			code = "E42"
+		}
+		return NewDocument(fmt.Sprintf("%03d", i)).
+			AddField(NewKeywordField("status", status)).
+			AddField(NewKeywordField("code", code).Aggregatable())
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, 200, 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 80, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 80, 200, 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	significant := func(agg *aggregations.SignificantTermsAggregation, readers ...*Reader) (
+		terms []string, scores []float64) {
+		req := NewTopNSearch(0, NewTermQuery("fail").SetField("status"))
+		req.AddAggregation("codes", agg)
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		calc := dmi.Aggregations().Aggregation("codes").(*aggregations.SignificantTermsCalculator)
+		if calc.Err() != nil {
+			t.Fatal(calc.Err())
+		}
+		for _, bucket := range calc.Buckets() {
+			terms = append(terms, bucket.Name())
+			scores = append(scores, calc.Score(bucket))
+		}
+		return terms, scores
+	}
+
+	for _, heuristic := range []aggregations.SignificanceHeuristic{
+		aggregations.JLH(),
+		aggregations.PercentageScore(),
+		aggregations.ChiSquare(false, true),
+		aggregations.MutualInformation(false, true),
+	} {
+		terms, scores := significant(aggregations.SignificantTerms("code", 10).Heuristic(heuristic), combined)
+		if len(terms) == 0 || terms[0] != "E42" {
+			t.Errorf("%T: expected E42 to be most significant, got %v", heuristic, terms)
+		}
+		// the background is the same when the index is split
+		_, multiScores := significant(aggregations.SignificantTerms("code", 10).Heuristic(heuristic), first, second)
+		if !reflect.DeepEqual(scores, multiScores) {
+			t.Errorf("%T: expected scores %v, got %v", heuristic, scores, multiScores)
+		}
+	}
+
+	// 30 of 40 failing builds report E42, as do 5 of 200 builds
+	_, scores := significant(aggregations.SignificantTerms("code", 10), combined)
+	expectedJLH := (30.0/40 - 35.0/200) * (30.0 / 40) / (35.0 / 200)
+	if len(scores) != 1 || math.Abs(scores[0]-expectedJLH) > 1e-9 {
+		t.Errorf("expected JLH score %f, got %v", expectedJLH, scores)
+	}
+
+	// compared to the passing builds only, 5 of 160 report E42
+	terms, scores := significant(aggregations.SignificantTerms("code", 10).
+		Heuristic(aggregations.PercentageScore()).
+		BackgroundFilter(NewTermQuery("pass").SetField("status")), combined)
+	if !reflect.DeepEqual(terms, []string{"E42", "E1"}) || math.Abs(scores[0]-30.0/5) > 1e-9 {
+		t.Errorf("expected E42 scoring 6 and E1, got %v %v", terms, scores)
+	}
+
+	// the search fails when the background cannot be searched
+	req := NewTopNSearch(0, NewTermQuery("fail").SetField("status"))
+	req.AddAggregation("codes", aggregations.SignificantTerms("code", 10).
+		BackgroundFilter(failingFilterQuery{}))
+	if _, err := combined.Search(context.Background(), req); err == nil {
+		t.Errorf("expected the failing background filter to fail the search")
+	}
+	if _, err := MultiSearch(context.Background(), req, first, second); err == nil {
+		t.Errorf("expected the failing background filter to fail the multi search")
+	}
+}
+
+func TestCompositeAggregation(t *testing.T) {
+	day := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	buildDoc := func(i int) *Document {
+		doc := NewDocument(fmt.Sprintf("%03d", i)).
+			AddField(NewKeywordField("country", []string{"fr", "nl", "us"}[i%3]).Aggregatable()).
+			AddField(NewNumericField("price", float64(i%7)*10).Aggregatable()).
+			AddField(NewDateTimeField("sold", day.AddDate(0, 0, i%4)).Aggregatable())
+		if i%10 == 0 {
+			// sold in two countries
+			doc.AddField(NewKeywordField("country", "de").Aggregatable())
+		}
+		return doc
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, 100, 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 30, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 30, 100, 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	type compositeBucket struct {
+		key   map[string]string
+		count uint64
+		total float64
+	}
+	composite := func(size int, after map[string]string, readers ...*Reader) (
+		buckets []compositeBucket, afterKey map[string]string) {
+		agg := aggregations.Composite(size,
+			aggregations.TermsSource("country", search.Field("country")),
+			aggregations.HistogramSource("price", aggregations.Histogram(search.Field("price"), 25)),
+			aggregations.DateHistogramSource("sold",
+				aggregations.CalendarDateHistogram(search.Field("sold"), aggregations.Day))).
+			AddAggregation("total", aggregations.Sum(search.Field("price")))
+		if after != nil {
+			if err := agg.After(after); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req := NewTopNSearch(0, NewMatchAllQuery())
+		req.AddAggregation("composite", agg)
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		calc := dmi.Aggregations().Aggregation("composite").(*aggregations.CompositeCalculator)
+		for _, bucket := range calc.Buckets() {
+			buckets = append(buckets, compositeBucket{
+				key:   calc.Key(bucket),
+				count: bucket.Count(),
+				total: bucket.Metric("total"),
+			})
+		}
+		return buckets, calc.AfterKey()
+	}
+
+	all, _ := composite(1000, nil, combined)
+	// every combination of 3 countries, 3 price ranges and 4 days,
+	// germany sold only on 2 days, but at every price
+	if len(all) != 42 {
+		t.Fatalf("expected 42 buckets, got %d", len(all))
+	}
+	if !reflect.DeepEqual(all[0].key, map[string]string{
+		"country": "de", "price": "0", "sold": "2020-03-01T00:00:00Z"}) {
+		t.Errorf("unexpected first bucket %v", all[0].key)
+	}
+	var count uint64
+	for _, bucket := range all {
+		count += bucket.count
+	}
+	if count != 110 {
+		t.Errorf("expected 110 matches counted, got %d", count)
+	}
+
+	// page through the same buckets, when the index is split
+	var paged []compositeBucket
+	var after map[string]string
+	for {
+		page, afterKey := composite(7, after, first, second)
+		if len(page) == 0 {
+			if afterKey != nil {
+				t.Errorf("expected no after key on the last page")
+			}
+			break
+		}
+		paged = append(paged, page...)
+		after = afterKey
+	}
+	if !reflect.DeepEqual(all, paged) {
+		t.Errorf("expected paging to find the buckets %v, got %v", all, paged)
+	}
+}
+
+func TestFiltersAggregation(t *testing.T) {
+	buildDoc := func(i int) *Document {
+		level := "info"
+		if i%10 == 0 {
+			level = "error"
+		} else if i%5 == 0 {
+			level = "warning"
+		}
+		doc := NewDocument(fmt.Sprintf("%03d", i)).
+			AddField(NewKeywordField("level", level)).
+			AddField(NewNumericField("size", float64(i)).Aggregatable())
+		if i%3 == 0 {
+			doc.AddField(NewKeywordField("attachment", "yes"))
+		}
+		doc.AddField(NewCompositeFieldExcluding("_all", []string{"_id"}))
+		return doc
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, 100, 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 40, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 40, 100, 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		req := NewTopNSearch(0, NewMatchAllQuery())
+		req.AddAggregation("levels", aggregations.Filters().
+			AddFilter("errors", NewTermQuery("error").SetField("level")).
+			AddFilter("warnings", NewTermQuery("warning").SetField("level")).
+			AddFilter("has attachment", NewTermQuery("yes").SetField("attachment")).
+			OtherBucket("other").
+			AddAggregation("size", aggregations.Sum(search.Field("size"))))
+		req.AddAggregation("errors", aggregations.Filter(NewTermQuery("error").SetField("level")).
+			AddAggregation("max_size", aggregations.Max(search.Field("size"))))
+		// searches the default field of the search
+		req.AddAggregation("default field", aggregations.Filter(NewMatchQuery("error")))
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		levels := dmi.Aggregations().Aggregation("levels").(*aggregations.FiltersCalculator)
+		if levels.Err() != nil {
+			t.Fatal(levels.Err())
+		}
+		// the other documents are divisible by neither 3 nor 5
+		expected := []struct {
+			name  string
+			count uint64
+			size  float64
+		}{
+			{"errors", 10, 450},
+			{"warnings", 10, 500},
+			{"has attachment", 34, 1683},
+			{"other", 53, 2632},
+		}
+		buckets := levels.Buckets()
+		if len(buckets) != len(expected) {
+			t.Fatalf("expected %d buckets, got %d", len(expected), len(buckets))
+		}
+		for i, bucket := range buckets {
+			if bucket.Name() != expected[i].name || bucket.Count() != expected[i].count ||
+				bucket.Metric("size") != expected[i].size {
+				t.Errorf("%d readers: expected bucket %s with %d matches of size %f, got %s with %d of size %f",
+					len(readers), expected[i].name, expected[i].count, expected[i].size,
+					bucket.Name(), bucket.Count(), bucket.Metric("size"))
+			}
+		}
+
+		errors := dmi.Aggregations().Aggregation("errors").(*aggregations.FilterCalculator)
+		if errors.Err() != nil {
+			t.Fatal(errors.Err())
+		}
+		if errors.Bucket().Count() != 10 || errors.Bucket().Metric("max_size") != 90 {
+			t.Errorf("%d readers: expected 10 errors of max size 90, got %d of %f", len(readers),
+				errors.Bucket().Count(), errors.Bucket().Metric("max_size"))
+		}
+
+		defaultField := dmi.Aggregations().Aggregation("default field").(*aggregations.FilterCalculator)
+		if defaultField.Bucket().Count() != 10 {
+			t.Errorf("%d readers: expected 10 errors in the default field, got %d", len(readers),
+				defaultField.Bucket().Count())
+		}
+
+		// the search fails when a filter cannot be searched
+		req = NewTopNSearch(0, NewMatchAllQuery())
+		req.AddAggregation("levels", aggregations.Filters().
+			AddFilter("failing", failingFilterQuery{}))
+		if len(readers) == 1 {
+			_, err = readers[0].Search(context.Background(), req)
+		} else {
+			_, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err == nil {
+			t.Errorf("%d readers: expected the failing filter to fail the search", len(readers))
+		}
+	}
+}
+
+type failingFilterQuery struct{}
+
+func (failingFilterQuery) Searcher(search.Reader, search.SearcherOptions) (search.Searcher, error) {
+	return nil, fmt.Errorf("failing filter")
+}
+
+func TestSearchResponseJSON(t *testing.T) {
+	indexWriter, err := OpenWriter(InMemoryOnlyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewBatch()
+	for i, color := range []string{"red", "blue", "red"} {
+		doc := NewDocument(fmt.Sprintf("%d", i)).
+			AddField(NewKeywordField("color", color).StoreValue().Aggregatable()).
+			AddField(NewNumericField("price", float64(10*(i+1))).StoreValue().Aggregatable())
+		batch.Update(doc.ID(), doc)
+	}
+	if err = indexWriter.Batch(batch); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := indexWriter.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+		_ = indexWriter.Close()
+	}()
+
+	req := NewTopNSearch(2, NewMatchAllQuery()).
+		SortBy([]string{"-price"}).
+		WithStandardAggregations()
+	colors := aggregations.NewTermsAggregation(search.Field("color"), 10)
+	colors.AddAggregation("top", aggregations.TopHits(1).SortBy(search.SortOrder{
+		search.SortBy(search.Field("price")),
+	}))
+	colors.AddAggregation("cheapest", aggregations.TopHits(1).SortBy(search.SortOrder{
+		search.SortBy(search.Field("price")),
+	}).JSONFormat(search.HitJSONFormat{
+		IDField: "_id",
+		Sort:    []search.ValueDecoder{search.NumericValue},
+	}))
+	req.AddAggregation("colors", colors)
+	req.AddAggregation("prices", aggregations.Stats(search.Field("price")))
+	req.AddAggregation("quantiles", aggregations.Quantiles(search.Field("price")))
+	median := aggregations.Quantiles(search.Field("price"))
+	if err = median.SetPercents(50); err != nil {
+		t.Fatal(err)
+	}
+	req.AddAggregation("median", median)
+	req.AddAggregation("distinct", aggregations.Cardinality(search.Field("color")))
+	req.AddAggregation("none", aggregations.Filter(NewTermQuery("green").SetField("color")).
+		AddAggregation("avg", aggregations.Avg(search.Field("price"))))
+	dmi, err := reader.Search(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewSearchResponse(dmi, search.HitJSONFormat{
+		Fields: map[string]search.ValueDecoder{
+			"color": search.TextValue,
+			"price": search.NumericValue,
+		},
+		Sort: []search.ValueDecoder{search.NumericValue},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual map[string]interface{}
+	if err = json.Unmarshal(data, &actual); err != nil {
+		t.Fatal(err)
+	}
+	// the duration varies
+	aggs := actual["aggregations"].(map[string]interface{})
+	if _, ok := aggs["duration"].(map[string]interface{})["value_as_string"].(string); !ok {
+		t.Errorf("expected duration as a string, got %v", aggs["duration"])
+	}
+	delete(aggs, "duration")
+
+	// sort values are base64 encoded, unless decoded
+	sortValue := func(price float64) string {
+		return base64.StdEncoding.EncodeToString(numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(price), 0))
+	}
+	var expected map[string]interface{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{
+		"total": 3,
+		"hits": [
+			{"id": "2", "score": 1, "sort": [30], "fields": {"color": ["red"], "price": [30]}},
+			{"id": "1", "score": 1, "sort": [20], "fields": {"color": ["blue"], "price": [20]}}
+		],
+		"aggregations": {
+			"max_score": {"value": 1},
+			"colors": {"other": 0, "buckets": [
+				{"key": "red", "count": 2, "aggregations": {
+					"top": {"hits": [{"id": "0", "score": 1, "sort": [%q]}]},
+					"cheapest": {"hits": [{"id": "0", "score": 1, "sort": [10]}]}}},
+				{"key": "blue", "count": 1, "aggregations": {
+					"top": {"hits": [{"id": "1", "score": 1, "sort": [%q]}]},
+					"cheapest": {"hits": [{"id": "1", "score": 1, "sort": [20]}]}}}
+			]},
+			"prices": {"count": 3, "min": 10, "max": 30, "sum": 60, "avg": 20,
+				"sum_of_squares": 1400, "variance": 66.66666666666667, "variance_sampling": 100,
+				"std_deviation": 8.16496580927726, "std_deviation_sampling": 10,
+				"std_deviation_bounds": {"upper": 36.329931618554525, "lower": 3.6700683814454784}},
+			"quantiles": {"values": {"1": 10.200000000000001, "5": 11, "25": 15, "50": 20, "75": 25, "95": 29, "99": 29.799999999999997}},
+			"median": {"values": {"50": 20}},
+			"distinct": {"value": 2},
+			"none": {"key": "filter", "count": 0, "aggregations": {"avg": {"value": null}}}
+		}
+	}`, sortValue(10), sortValue(20))), &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		got, _ := json.MarshalIndent(actual, "", "  ")
+		t.Errorf("unexpected response:\n%s", got)
+	}
+}
+
+func TestRescore(t *testing.T) {
+	texts := []string{
+		"quick quick quick brown fox",
+		"the fox was quick",
+		"a quick fox",
+		"fox fox fox quick",
+		"quick fox jumps over the lazy dog",
+	}
+	buildDoc := func(i int) *Document {
+		return NewDocument(texts[i]).
+			AddField(NewTextField("text", texts[i]).SearchTermPositions())
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, len(texts), 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 2, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 2, len(texts), 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	ids := func(req *TopNSearch, readers ...*Reader) (rv []string, scores []float64) {
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			var id string
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == _idField {
+					id = string(value)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.Explanation != nil && next.Explanation.Value != next.Score {
+				t.Errorf("expected explanation of %s to be %f, got %f", id, next.Score, next.Explanation.Value)
+			}
+			rv = append(rv, id)
+			scores = append(scores, next.Score)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rv, scores
+	}
+
+	query := NewMatchQuery("quick fox").SetField("text")
+	phrase := NewMatchPhraseQuery("quick fox").SetField("text")
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		plain, plainScores := ids(NewTopNSearch(5, query), readers...)
+		if plain[0] == texts[2] || plain[0] == texts[4] {
+			t.Fatalf("expected a phrase match not to score best without rescoring, got %v", plain)
+		}
+
+		// the phrase matches move to the top, the others keep their score
+		rescored, scores := ids(NewTopNSearch(2, query).SetFrom(1).ExplainScores().
+			Rescore(NewRescorer(phrase, 5).SetRescoreQueryWeight(10)), readers...)
+		if len(rescored) != 2 || rescored[1] != plain[0] {
+			t.Errorf("expected the best match without the phrase second, got %v", rescored)
+		}
+		if len(scores) == 2 && scores[1] != plainScores[0] {
+			t.Errorf("expected the score of %s to remain %f, got %f", rescored[1], plainScores[0], scores[1])
+		}
+
+		// whatever the mode, matches not matching the phrase keep their weighted score
+		plainScore := make(map[string]float64, len(plain))
+		for i, id := range plain {
+			plainScore[id] = plainScores[i]
+		}
+		for _, mode := range []RescoreMode{RescoreModeMultiply, RescoreModeAvg, RescoreModeMax, RescoreModeMin} {
+			rescored, scores = ids(NewTopNSearch(5, query).ExplainScores().
+				Rescore(NewRescorer(phrase, 5).SetQueryWeight(2).SetScoreMode(mode)), readers...)
+			for i, id := range rescored {
+				if id != texts[2] && id != texts[4] && scores[i] != 2*plainScore[id] {
+					t.Errorf("mode %d: expected %s to score %f, got %f", mode, id, 2*plainScore[id], scores[i])
+				}
+			}
+		}
+
+		// the window only includes the top 3
+		multiplied, _ := ids(NewTopNSearch(5, query).
+			Rescore(NewRescorer(phrase, 3).SetScoreMode(RescoreModeMultiply)), readers...)
+		if !reflect.DeepEqual(multiplied[3:], plain[3:]) {
+			t.Errorf("expected matches out of the window to remain %v, got %v", plain[3:], multiplied[3:])
+		}
+	}
+
+	_, err := combined.Search(context.Background(), NewTopNSearch(5, query).SortBy([]string{"_id"}).
+		Rescore(NewRescorer(phrase, 5)))
+	if err == nil {
+		t.Errorf("expected an error rescoring without sorting by score")
+	}
+}
+
+func TestLearningToRank(t *testing.T) {
+	texts := []string{
+		"quick quick quick brown fox",
+		"the fox was quick",
+		"a quick fox",
+		"fox fox fox quick",
+		"quick fox jumps over the lazy dog",
+	}
+	popularity := map[string]float64{
+		texts[0]: 3,
+		texts[1]: 5,
+		texts[2]: 1,
+		texts[4]: 4,
+	}
+	buildDoc := func(i int) *Document {
+		doc := NewDocument(texts[i]).
+			AddField(NewTextField("text", texts[i]).SearchTermPositions())
+		if p, ok := popularity[texts[i]]; ok {
+			doc.AddField(NewNumericField("popularity", p).Aggregatable())
+		}
+		return doc
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, len(texts), 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 2, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 2, len(texts), 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	matches := func(req *TopNSearch, readers ...*Reader) (ids []string, rv []*search.DocumentMatch) {
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			var id string
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == _idField {
+					id = string(value)
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.Explanation != nil && next.Explanation.Value != next.Score {
+				t.Errorf("expected explanation of %s to be %f, got %f", id, next.Score, next.Explanation.Value)
+			}
+			ids = append(ids, id)
+			rv = append(rv, next)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, rv
+	}
+
+	query := NewMatchQuery("quick fox").SetField("text")
+	features := NewFeatureSet().
+		AddQueryFeature("phrase", NewMatchPhraseQuery("quick fox").SetField("text")).
+		AddValueFeature("popularity", search.Field("popularity"))
+	if !reflect.DeepEqual(features.Names(), []string{"phrase", "popularity"}) {
+		t.Errorf("expected feature names phrase and popularity, got %v", features.Names())
+	}
+	trees, err := ranking.ParseXGBoostJSON([]byte(`[
+		{"nodeid": 0, "split": "phrase", "split_condition": 0.0001, "yes": 1, "no": 2, "missing": 1,
+			"children": [{"nodeid": 1, "leaf": 0}, {"nodeid": 2, "leaf": 100}]}
+	]`), features.Names())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		ids, logged := matches(NewTopNSearch(5, query).LogFeatures(features), readers...)
+		if len(logged) != len(texts) {
+			t.Fatalf("expected %d matches, got %d", len(texts), len(logged))
+		}
+		for i, match := range logged {
+			if len(match.Features) != 2 {
+				t.Fatalf("expected 2 features for %s, got %v", ids[i], match.Features)
+			}
+			if isPhrase := ids[i] == texts[2] || ids[i] == texts[4]; isPhrase != (match.Features[0] > 0) {
+				t.Errorf("expected phrase feature of %s to be positive only for phrase matches, got %f",
+					ids[i], match.Features[0])
+			}
+			if p, ok := popularity[ids[i]]; ok && match.Features[1] != p {
+				t.Errorf("expected popularity feature of %s to be %f, got %f", ids[i], p, match.Features[1])
+			} else if !ok && !math.IsNaN(match.Features[1]) {
+				t.Errorf("expected popularity feature of %s to be missing, got %f", ids[i], match.Features[1])
+			}
+		}
+
+		// ignoring the query score, the linear model orders by popularity
+		ids, _ = matches(NewTopNSearch(5, query).ExplainScores().
+			Rescore(NewModelRescorer(features, ranking.NewLinearModel(0, 0, 1), 5).SetQueryWeight(0)), readers...)
+		expected := []string{texts[1], texts[4], texts[0], texts[2], texts[3]}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected matches ordered by popularity %v, got %v", expected, ids)
+		}
+
+		// the tree moves the phrase matches to the top
+		ids, _ = matches(NewTopNSearch(2, query).ExplainScores().
+			Rescore(NewModelRescorer(features, trees, 5)), readers...)
+		if len(ids) != 2 || (ids[0] != texts[2] && ids[0] != texts[4]) ||
+			(ids[1] != texts[2] && ids[1] != texts[4]) {
+			t.Errorf("expected the phrase matches first, got %v", ids)
+		}
+	}
+}
+
+func TestKNNQuery(t *testing.T) {
+	type vectorDoc struct {
+		id     string
+		color  string
+		vector []float32
+	}
+	docs := []vectorDoc{
+		{"a", "red", []float32{1, 0, 0}},
+		{"b", "red", []float32{0.9, 0.1, 0}},
+		{"c", "blue", []float32{0.7, 0.7, 0}},
+		{"d", "blue", []float32{0, 1, 0}},
+		{"e", "red", []float32{0, 0.6, 0.8}},
+		{"f", "blue", []float32{0, 0, 1}},
+		{"g", "red", []float32{-1, 0, 0}},
+		{"h", "blue", []float32{0.8, 0, 0.6}},
+	}
+	config := InMemoryOnlyConfig().
+		WithDenseVectorField("embedding", 3, hnsw.Cosine).
+		WithConcurrentSearch(3, nil)
+	buildDoc := func(i int) *Document {
+		return NewDocument(docs[i].id).
+			AddField(NewKeywordField("color", docs[i].color)).
+			AddField(NewDenseVectorField("embedding", docs[i].vector))
+	}
+	// a segment per document, so that searches are partitioned
+	combined := openTestReader(t, config, 0, len(docs), 1, buildDoc)
+	first := openTestReader(t, config, 0, 3, 1, buildDoc)
+	second := openTestReader(t, config, 3, len(docs), 1, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	// nearest finds the k nearest documents of the color, or any color
+	vector := []float32{1, 0.2, 0.1}
+	nearest := func(k int, color string) (ids []string, scores []float64) {
+		var candidates []vectorDoc
+		for _, d := range docs {
+			if color == "" || d.color == color {
+				candidates = append(candidates, d)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return hnsw.Cosine.Score(vector, candidates[i].vector) > hnsw.Cosine.Score(vector, candidates[j].vector)
+		})
+		for _, d := range candidates[:k] {
+			ids = append(ids, d.id)
+			scores = append(scores, hnsw.Cosine.Score(vector, d.vector))
+		}
+		return ids, scores
+	}
+	search := func(req SearchRequest, readers ...*Reader) (ids []string, scores []float64) {
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == _idField {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores = append(scores, next.Score)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, scores
+	}
+	scoresEqual := func(a, b []float64) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if math.Abs(a[i]-b[i]) > 1e-6 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		expectedIDs, expectedScores := nearest(3, "")
+		// each reader of MultiSearch finds k neighbors, of which the top k are kept
+		ids, scores := search(NewTopNSearch(3, NewKNNQuery("embedding", vector, 3).SetNumCandidates(5)), readers...)
+		if !reflect.DeepEqual(ids, expectedIDs) || !scoresEqual(scores, expectedScores) {
+			t.Errorf("expected neighbors %v with scores %v, got %v with scores %v",
+				expectedIDs, expectedScores, ids, scores)
+		}
+
+		expectedIDs, expectedScores = nearest(2, "blue")
+		ids, scores = search(NewTopNSearch(2, NewKNNQuery("embedding", vector, 2).
+			SetFilter(NewTermQuery("blue").SetField("color"))), readers...)
+		if !reflect.DeepEqual(ids, expectedIDs) || !scoresEqual(scores, expectedScores) {
+			t.Errorf("expected filtered neighbors %v with scores %v, got %v with scores %v",
+				expectedIDs, expectedScores, ids, scores)
+		}
+
+		// the filter is searched once per reader, rather than once per slice
+		filter := &countingQuery{Query: NewTermQuery("blue").SetField("color")}
+		ids, _ = search(NewTopNSearch(2, NewKNNQuery("embedding", vector, 2).SetFilter(filter)), readers...)
+		if !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("expected filtered neighbors %v, got %v", expectedIDs, ids)
+		}
+		if searches := atomic.LoadInt32(&filter.searches); int(searches) != len(readers) {
+			t.Errorf("expected the filter searched %d times, got %d", len(readers), searches)
+		}
+
+		// the nearest red document matches both queries
+		expectedIDs, _ = nearest(1, "red")
+		hybrid := NewBooleanQuery().
+			AddShould(NewTermQuery("red").SetField("color")).
+			AddShould(NewKNNQuery("embedding", vector, 1).SetBoost(10))
+		ids, _ = search(NewTopNSearch(10, hybrid), readers...)
+		var red int
+		for _, id := range ids {
+			for _, d := range docs {
+				if d.id == id && d.color == "red" {
+					red++
+				}
+			}
+		}
+		if red != 4 || len(ids) == 0 || ids[0] != expectedIDs[0] {
+			t.Errorf("expected the red documents with %s first, got %v", expectedIDs[0], ids)
+		}
+	}
+
+	_, err := combined.Search(context.Background(),
+		NewTopNSearch(10, NewKNNQuery("embedding", []float32{1, 0}, 3)))
+	if err == nil {
+		t.Errorf("expected error searching a vector of the wrong number of dimensions")
+	}
+}
+
+// countingQuery counts the searches of the query
+type countingQuery struct {
+	Query
+	searches int32
+}
+
+func (q *countingQuery) Searcher(i search.Reader, options search.SearcherOptions) (search.Searcher, error) {
+	atomic.AddInt32(&q.searches, 1)
+	return q.Query.Searcher(i, options)
+}
+
+func TestRankFusion(t *testing.T) {
+	type fusionDoc struct {
+		id       string
+		lexical  float64
+		semantic float64
+	}
+	// zero values are missing, each document is found by either search or both
+	docs := []fusionDoc{
+		{"a", 5, 0},
+		{"b", 4, 0.9},
+		{"c", 3, 0.5},
+		{"d", 0, 0.95},
+		{"e", 2, 0},
+		{"f", 0, 0.1},
+	}
+	buildDoc := func(i int) *Document {
+		doc := NewDocument(docs[i].id)
+		if docs[i].lexical > 0 {
+			doc.AddField(NewKeywordField("kind", "lexical")).
+				AddField(NewNumericField("lexical", docs[i].lexical).Aggregatable())
+		}
+		if docs[i].semantic > 0 {
+			doc.AddField(NewKeywordField("kind", "semantic")).
+				AddField(NewNumericField("semantic", docs[i].semantic).Aggregatable())
+		}
+		return doc
+	}
+	combined := openTestReader(t, InMemoryOnlyConfig(), 0, len(docs), 0, buildDoc)
+	first := openTestReader(t, InMemoryOnlyConfig(), 0, 3, 0, buildDoc)
+	second := openTestReader(t, InMemoryOnlyConfig(), 3, len(docs), 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	// the lexical search ranks a b c e, the semantic search d b c f
+	searchOf := func(kind string) *TopNSearch {
+		return NewTopNSearch(10, NewFunctionScoreQuery(NewTermQuery(kind).SetField("kind"),
+			expression.MustCompile(kind))).ExplainScores()
+	}
+	search := func(req SearchRequest, readers ...*Reader) (ids []string, dmi search.DocumentMatchIterator) {
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == _idField {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.Explanation != nil && math.Abs(next.Explanation.Value-next.Score) > 1e-9 {
+				t.Errorf("expected explanation of score %f, got %f", next.Score, next.Explanation.Value)
+			}
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids, dmi
+	}
+
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		// b and c are found by both searches, ties keep the order they were found in
+		req := NewRankFusionSearch(10).
+			AddSearch(searchOf("lexical")).
+			AddSearch(searchOf("semantic")).
+			ExplainScores()
+		req.AddAggregation("count", aggregations.CountMatches())
+		ids, dmi := search(req, readers...)
+		if expected := []string{"b", "c", "a", "d", "e", "f"}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected reciprocal rank fusion %v, got %v", expected, ids)
+		}
+		if count := dmi.Aggregations().Count(); count != 6 {
+			t.Errorf("expected union count 6, got %d", count)
+		}
+
+		// the normalized semantic scores are worth twice the lexical
+		req = NewRankFusionSearch(10).
+			AddSearch(searchOf("lexical")).
+			AddWeightedSearch(searchOf("semantic"), 2).
+			SetMethod(FusionNormalizedScores).
+			ExplainScores()
+		ids, _ = search(req, readers...)
+		if expected := []string{"b", "d", "c", "a", "e", "f"}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected normalized score fusion %v, got %v", expected, ids)
+		}
+
+		// the second page of two matches, with the aggregations of the semantic search
+		semantic := searchOf("semantic")
+		semantic.AddAggregation("count", aggregations.CountMatches())
+		req = NewRankFusionSearch(2).
+			AddSearch(searchOf("lexical")).
+			AddSearch(semantic).
+			SetFrom(2).
+			AggregationsFrom(1)
+		ids, dmi = search(req, readers...)
+		if expected := []string{"a", "d"}; !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected second page %v, got %v", expected, ids)
+		}
+		if count := dmi.Aggregations().Count(); count != 4 {
+			t.Errorf("expected semantic count 4, got %d", count)
+		}
+
+		_, err := readers[0].Search(context.Background(),
+			NewRankFusionSearch(2).AddSearch(searchOf("lexical")).AggregationsFrom(1))
+		if err == nil {
+			t.Errorf("expected error taking aggregations from a missing search")
+		}
+	}
+}
+
+func TestPerFieldSimilarity(t *testing.T) {
+	titles := []string{
+		"quick fox",
+		"quick quick dog",
+		"lazy dog",
+		"the quick brown fox jumps",
+	}
+	config := InMemoryOnlyConfig().
+		WithDefaultSimilarity(similarity.NewTFIDFSimilarity()).
+		WithSimilarity("title", similarity.NewLMJelinekMercerSimilarityLambda(0.5))
+	if _, ok := config.SimilarityForField("body").(*similarity.TFIDFSimilarity); !ok {
+		t.Errorf("expected the default similarity for other fields")
+	}
+	buildDoc := func(i int) *Document {
+		return NewDocument(strconv.Itoa(i)).
+			AddField(NewTextField("title", titles[i]))
+	}
+	combined := openTestReader(t, config, 0, len(titles), 0, buildDoc)
+	first := openTestReader(t, config, 0, 2, 0, buildDoc)
+	second := openTestReader(t, config, 2, len(titles), 0, buildDoc)
+	defer func() {
+		_ = combined.Close()
+		_ = first.Close()
+		_ = second.Close()
+	}()
+
+	// quick occurs 4 times among the 12 terms of the titles
+	p := 5.0 / 13
+	expectedIDs := []string{"1", "0", "3"}
+	expectedScores := []float64{
+		math.Log(1 + 0.5*2/3/(0.5*p)),
+		math.Log(1 + 0.5*1/2/(0.5*p)),
+		math.Log(1 + 0.5*1/5/(0.5*p)),
+	}
+	for _, readers := range [][]*Reader{{combined}, {first, second}} {
+		req := NewTopNSearch(10, NewMatchQuery("quick").SetField("title")).ExplainScores()
+		var dmi search.DocumentMatchIterator
+		var err error
+		if len(readers) == 1 {
+			dmi, err = readers[0].Search(context.Background(), req)
+		} else {
+			dmi, err = MultiSearch(context.Background(), req, readers...)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		var scores []float64
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == _idField {
+					ids = append(ids, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(next.Explanation.Value-next.Score) > 1e-9 {
+				t.Errorf("expected explanation of score %f, got %f", next.Score, next.Explanation.Value)
+			}
+			scores = append(scores, next.Score)
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("expected matches %v, got %v", expectedIDs, ids)
+		}
+		for i := range scores {
+			if i < len(expectedScores) && math.Abs(scores[i]-expectedScores[i]) > 1e-6 {
+				t.Errorf("expected scores %v, got %v", expectedScores, scores)
+				break
+			}
+		}
+	}
+}
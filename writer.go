@@ -63,6 +63,11 @@ func (w *Writer) Batch(batch *index.Batch) error {
 	return w.chill.Batch(batch)
 }
 
+// Stats returns the current statistics of the index
+func (w *Writer) Stats() index.Stats {
+	return w.chill.Stats()
+}
+
 func (w *Writer) Close() error {
 	return w.chill.Close()
 }
@@ -17,7 +17,10 @@ package bluge
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
+
+	"github.com/blugelabs/bluge/search"
 )
 
 func TestOfflineWriter(t *testing.T) {
@@ -74,3 +77,85 @@ func TestOfflineWriter(t *testing.T) {
 		t.Errorf("expected 10 search hits, got %d", res.Aggregations().Count())
 	}
 }
+
+func TestOfflineWriterIndexSort(t *testing.T) {
+	tmpIndexPath := createTmpIndexPath(t)
+	defer cleanupTmpIndexPath(t, tmpIndexPath)
+
+	config := DefaultConfig(tmpIndexPath).
+		WithIndexSort(search.SortOrder{search.SortBy(search.Field("n")).Desc()})
+	// a single batch, merged segments are not sorted
+	b, err := OpenOfflineWriter(config, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		doc := NewDocument(fmt.Sprintf("%d", i)).
+			AddField(NewKeywordField("name", "hello")).
+			AddField(NewNumericField("n", float64((i*37)%100)).Sortable())
+		err = b.Insert(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = b.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexReader, err := OpenReader(config)
+	if err != nil {
+		t.Fatalf("error opening index: %v", err)
+	}
+	defer func() {
+		err = indexReader.Close()
+		if err != nil {
+			t.Errorf("error closing index: %v", err)
+		}
+	}()
+
+	ids := func(req *TopNSearch) (rv []string, lowerBound bool) {
+		dmi, err := indexReader.Search(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := dmi.Next()
+		for err == nil && next != nil {
+			err = next.VisitStoredFields(func(field string, value []byte) bool {
+				if field == "_id" {
+					rv = append(rv, string(value))
+				}
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err = dmi.Next()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rv, dmi.(search.PartialResultsIterator).CountIsLowerBound()
+	}
+
+	q := NewTermQuery("hello").SetField("name")
+	expected, lowerBound := ids(NewTopNSearch(5, q).SortBy([]string{"-n"}))
+	if lowerBound {
+		t.Errorf("expected exact count by default")
+	}
+	actual, lowerBound := ids(NewTopNSearch(5, q).SortBy([]string{"-n"}).DisableTrackTotalHits())
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if !lowerBound {
+		t.Errorf("expected sorted ranges to be skipped")
+	}
+
+	// a different order cannot skip
+	_, lowerBound = ids(NewTopNSearch(5, q).SortBy([]string{"n"}).DisableTrackTotalHits())
+	if lowerBound {
+		t.Errorf("expected no skipping when sorting by a different order")
+	}
+}